@@ -0,0 +1,35 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestACMEChallenge(c *check.C) {
+	err := s.conn.ACMEChallenges().Insert(bson.M{"_id": "mytoken", "cname": "app.io", "response": "mytoken.thumbprint"})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/.well-known/acme-challenge/mytoken", nil)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "text/plain")
+	c.Assert(recorder.Body.String(), check.Equals, "mytoken.thumbprint")
+}
+
+func (s *S) TestACMEChallengeNotFound(c *check.C) {
+	request, err := http.NewRequest("GET", "/.well-known/acme-challenge/unknown", nil)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
@@ -11,11 +11,13 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ajg/form"
 	"github.com/tsuru/tsuru/api/context"
 	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/app/acme"
 	"github.com/tsuru/tsuru/app/bind"
 	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/db"
@@ -328,10 +330,14 @@ func createApp(w http.ResponseWriter, r *http.Request, t auth.Token) (err error)
 			if e.Err == app.ErrAppAlreadyExists {
 				return &errors.HTTP{Code: http.StatusConflict, Message: e.Error()}
 			}
-			if _, ok := e.Err.(*quota.QuotaExceededError); ok {
+			if quotaErr, ok := e.Err.(*quota.QuotaExceededError); ok {
+				msg := "Quota exceeded"
+				if quotaErr.Resource != "" {
+					msg = quotaErr.Error()
+				}
 				return &errors.HTTP{
 					Code:    http.StatusForbidden,
-					Message: "Quota exceeded",
+					Message: msg,
 				}
 			}
 		}
@@ -367,6 +373,7 @@ func createApp(w http.ResponseWriter, r *http.Request, t auth.Token) (err error)
 // responses:
 //   200: App updated
 //   401: Unauthorized
+//   403: Quota exceeded
 //   404: Not found
 func updateApp(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	updateData := app.App{
@@ -431,6 +438,9 @@ func updateApp(w http.ResponseWriter, r *http.Request, t auth.Token) (err error)
 	if _, ok := err.(*router.ErrRouterNotFound); ok {
 		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
 	}
+	if _, ok := err.(*quota.QuotaExceededError); ok {
+		return &errors.HTTP{Code: http.StatusForbidden, Message: err.Error()}
+	}
 	return err
 }
 
@@ -820,6 +830,11 @@ func writeEnvVars(w http.ResponseWriter, a *app.App, variables ...string) error
 			result = append(result, v)
 		}
 	}
+	for i := range result {
+		if result[i].Secret {
+			result[i].Value = ""
+		}
+	}
 	return json.NewEncoder(w).Encode(result)
 }
 
@@ -829,6 +844,24 @@ type Envs struct {
 	Envs      []struct{ Name, Value string }
 	NoRestart bool
 	Private   bool
+	Secret    bool
+}
+
+// envsFormWithoutSecrets returns a copy of the set envs form with the values
+// of the variables replaced when they are secret, so they're not stored in
+// the event.
+func envsFormWithoutSecrets(values url.Values, secret bool) url.Values {
+	if !secret {
+		return values
+	}
+	filtered := url.Values{}
+	for k, v := range values {
+		if strings.HasPrefix(k, "Envs.") && strings.HasSuffix(k, ".Value") {
+			v = []string{"*****"}
+		}
+		filtered[k] = v
+	}
+	return filtered
 }
 
 // title: set envs
@@ -872,7 +905,7 @@ func setEnv(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 		Target:     appTarget(appName),
 		Kind:       permission.PermAppUpdateEnvSet,
 		Owner:      t,
-		CustomData: event.FormToCustomData(r.Form),
+		CustomData: event.FormToCustomData(envsFormWithoutSecrets(r.Form, e.Secret)),
 		Allowed:    event.Allowed(permission.PermAppReadEvents, contextsForApp(&a)...),
 	})
 	if err != nil {
@@ -883,7 +916,7 @@ func setEnv(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	variables := []bind.EnvVar{}
 	for _, v := range e.Envs {
 		envs[v.Name] = v.Value
-		variables = append(variables, bind.EnvVar{Name: v.Name, Value: v.Value, Public: !e.Private})
+		variables = append(variables, bind.EnvVar{Name: v.Name, Value: v.Value, Public: !e.Private && !e.Secret, Secret: e.Secret})
 	}
 	w.Header().Set("Content-Type", "application/x-json-stream")
 	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
@@ -1050,6 +1083,36 @@ func unsetCName(w http.ResponseWriter, r *http.Request, t auth.Token) (err error
 	return err
 }
 
+// logFilterFromQuery builds a log filter from the source, unit, message,
+// since and until parameters. The unit parameter may be repeated and dates
+// must be in the RFC 3339 format.
+func logFilterFromQuery(query url.Values) (app.LogFilter, error) {
+	filter := app.LogFilter{
+		Source:  query.Get("source"),
+		Message: query.Get("message"),
+	}
+	for _, unit := range query["unit"] {
+		if unit != "" {
+			filter.Units = append(filter.Units, unit)
+		}
+	}
+	for _, param := range []struct {
+		name  string
+		value *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		date, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, fmt.Errorf(`Parameter %q must be a date in the RFC 3339 format.`, param.name)
+		}
+		*param.value = date
+	}
+	return filter, filter.Validate()
+}
+
 // title: app log
 // path: /apps/{app}/log
 // method: GET
@@ -1071,12 +1134,14 @@ func appLog(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	} else {
 		return &errors.HTTP{Code: http.StatusBadRequest, Message: `Parameter "lines" is mandatory.`}
 	}
+	filter, err := logFilterFromQuery(r.URL.Query())
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	jsonLines := r.URL.Query().Get("format") == "jsonl"
 	w.Header().Set("Content-Type", "application/x-json-stream")
-	source := r.URL.Query().Get("source")
-	unit := r.URL.Query().Get("unit")
 	follow := r.URL.Query().Get("follow")
 	appName := r.URL.Query().Get(":app")
-	filterLog := app.Applog{Source: source, Unit: unit}
 	a, err := getAppFromContext(appName, r)
 	if err != nil {
 		return err
@@ -1087,14 +1152,23 @@ func appLog(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	if !allowed {
 		return permission.ErrUnauthorized
 	}
-	logs, err := a.LastLogs(lines, filterLog)
+	logs, err := a.LastLogs(lines, filter)
 	if err != nil {
 		return err
 	}
 	encoder := json.NewEncoder(w)
-	err = encoder.Encode(logs)
-	if err != nil {
-		return err
+	if jsonLines {
+		for _, l := range logs {
+			err = encoder.Encode(l)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		err = encoder.Encode(logs)
+		if err != nil {
+			return err
+		}
 	}
 	if follow != "1" {
 		return nil
@@ -1105,7 +1179,7 @@ func appLog(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	} else {
 		closeChan = make(chan bool)
 	}
-	l, err := app.NewLogListener(&a, filterLog)
+	l, err := app.NewLogListener(&a, filter)
 	if err != nil {
 		return err
 	}
@@ -1125,7 +1199,11 @@ func appLog(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 		if logMsg == (app.Applog{}) {
 			break
 		}
-		err := encoder.Encode([]app.Applog{logMsg})
+		if jsonLines {
+			err = encoder.Encode(logMsg)
+		} else {
+			err = encoder.Encode([]app.Applog{logMsg})
+		}
 		if err != nil {
 			break
 		}
@@ -1270,6 +1348,79 @@ func unbindServiceInstance(w http.ResponseWriter, r *http.Request, t auth.Token)
 	return nil
 }
 
+// title: app migrate pool
+// path: /apps/{app}/migrate
+// method: POST
+// consume: application/x-www-form-urlencoded
+// produce: application/x-json-stream
+// responses:
+//   200: Ok
+//   400: Invalid data
+//   401: Unauthorized
+//   404: App not found
+//   409: App locked
+func appMigratePool(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	poolName := r.FormValue("pool")
+	if poolName == "" {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "You must provide the pool name."}
+	}
+	appName := r.URL.Query().Get(":app")
+	a, err := getAppFromContext(appName, r)
+	if err != nil {
+		return err
+	}
+	allowed := permission.Check(t, permission.PermAppUpdatePoolMigrate,
+		contextsForApp(&a)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	_, err = provision.GetPoolByName(poolName)
+	if err != nil {
+		if err == provision.ErrPoolNotFound {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+		}
+		return err
+	}
+	// The lock is held until the old units are destroyed, so no other
+	// operation runs against either provisioner during the migration.
+	locked, err := app.AcquireApplicationLockWait(appName, t.GetUserName(), "POST /apps/migrate", lockWaitDuration)
+	if err != nil {
+		return err
+	}
+	if !locked {
+		lockedApp, errGet := getApp(appName)
+		if errGet != nil {
+			return errGet
+		}
+		return &errors.HTTP{Code: http.StatusConflict, Message: fmt.Sprintf("%s: %s", lockedApp.Name, &lockedApp.Lock)}
+	}
+	defer app.ReleaseApplicationLock(appName)
+	evt, err := event.New(&event.Opts{
+		Target:        appTarget(appName),
+		Kind:          permission.PermAppUpdatePoolMigrate,
+		Owner:         t,
+		CustomData:    event.FormToCustomData(r.Form),
+		Allowed:       event.Allowed(permission.PermAppReadEvents, contextsForApp(&a)...),
+		AllowedCancel: event.Allowed(permission.PermAppUpdateEvents, contextsForApp(&a)...),
+		Cancelable:    true,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	w.Header().Set("Content-Type", "application/x-json-stream")
+	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
+	defer keepAliveWriter.Stop()
+	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
+	err = a.MigratePool(poolName, evt, writer)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(writer, "\nApp %q migrated to pool %q.\n", appName, poolName)
+	return nil
+}
+
 // title: app restart
 // path: /apps/{app}/restart
 // method: POST
@@ -1826,6 +1977,11 @@ func unsetCertificate(w http.ResponseWriter, r *http.Request, t auth.Token) (err
 	return nil
 }
 
+type certificateStatus struct {
+	Certificate string            `json:"certificate"`
+	ACME        *acme.Certificate `json:"acme,omitempty"`
+}
+
 // title: list app certificates
 // path: /apps/{app}/certificate
 // method: GET
@@ -1850,7 +2006,23 @@ func listCertificates(w http.ResponseWriter, r *http.Request, t auth.Token) erro
 	if err != nil {
 		return err
 	}
-	return json.NewEncoder(w).Encode(&result)
+	if withStatus, _ := strconv.ParseBool(r.URL.Query().Get("status")); !withStatus {
+		return json.NewEncoder(w).Encode(&result)
+	}
+	acmeCerts, err := acme.Certificates(a.Name)
+	if err != nil {
+		return err
+	}
+	statusResult := make(map[string]certificateStatus, len(result))
+	for name, cert := range result {
+		statusResult[name] = certificateStatus{Certificate: cert}
+	}
+	for i := range acmeCerts {
+		status := statusResult[acmeCerts[i].CName]
+		status.ACME = &acmeCerts[i]
+		statusResult[acmeCerts[i].CName] = status
+	}
+	return json.NewEncoder(w).Encode(statusResult)
 }
 
 func contextsForApp(a *app.App) []permission.PermissionContext {
@@ -22,13 +22,17 @@ import (
 	"github.com/ajg/form"
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/app/acme"
 	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/app/image"
 	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/db"
 	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
 	"github.com/tsuru/tsuru/event/eventtest"
 	"github.com/tsuru/tsuru/permission"
 	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/provisiontest"
 	"github.com/tsuru/tsuru/queue"
 	"github.com/tsuru/tsuru/quota"
 	"github.com/tsuru/tsuru/repository"
@@ -1094,6 +1098,21 @@ func (s *S) TestCreateAppQuotaExceeded(c *check.C) {
 	}, eventtest.HasEvent)
 }
 
+func (s *S) TestCreateAppTeamQuotaExceeded(c *check.C) {
+	err := auth.ChangeTeamQuota(s.team, auth.TeamQuota{Apps: 0, Units: -1, Memory: -1, ServiceInstances: -1})
+	c.Assert(err, check.IsNil)
+	b := strings.NewReader("name=someapp&platform=zend&teamOwner=" + s.team.Name)
+	request, err := http.NewRequest("POST", "/apps", b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	c.Assert(recorder.Body.String(), check.Equals, `Quota exceeded for apps of team "tsuruteam". Available: 0. Requested: 1.`+"\n")
+}
+
 func (s *S) TestCreateAppInvalidName(c *check.C) {
 	b := strings.NewReader("name=123myapp&platform=zend")
 	request, err := http.NewRequest("POST", "/apps", b)
@@ -2714,6 +2733,75 @@ func (s *S) TestSetEnvPublicEnvironmentVariableInTheApp(c *check.C) {
 	}, eventtest.HasEvent)
 }
 
+func (s *S) TestSetEnvHandlerShouldSetASecretEnvironmentVariableInTheApp(c *check.C) {
+	config.Set("secret-envs:key", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	defer config.Unset("secret-envs")
+	a := app.App{Name: "black-dog", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/apps/%s/env", a.Name)
+	d := Envs{
+		Envs: []struct{ Name, Value string }{
+			{"DATABASE_PASSWORD", "secret123"},
+		},
+		Secret: true,
+	}
+	v, err := form.EncodeToValues(&d)
+	c.Assert(err, check.IsNil)
+	b := strings.NewReader(v.Encode())
+	request, err := http.NewRequest("POST", url, b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	dbApp, err := app.GetByName("black-dog")
+	c.Assert(err, check.IsNil)
+	env := dbApp.Env["DATABASE_PASSWORD"]
+	c.Assert(env.Secret, check.Equals, true)
+	c.Assert(env.Public, check.Equals, false)
+	c.Assert(env.Value, check.Not(check.Equals), "secret123")
+	c.Assert(dbApp.Envs()["DATABASE_PASSWORD"].Value, check.Equals, "secret123")
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget(a.Name),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.env.set",
+		StartCustomData: []map[string]interface{}{
+			{"name": "Envs.0.Name", "value": "DATABASE_PASSWORD"},
+			{"name": "Envs.0.Value", "value": "*****"},
+			{"name": "Secret", "value": "true"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestGetEnvHidesSecretValues(c *check.C) {
+	a := app.App{
+		Name:      "everything-i-touch",
+		Platform:  "zend",
+		TeamOwner: s.team.Name,
+		Env: map[string]bind.EnvVar{
+			"DATABASE_PASSWORD": {Name: "DATABASE_PASSWORD", Value: "tsuru-secret:abcd:encrypted", Secret: true},
+		},
+	}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/apps/everything-i-touch/env?env=DATABASE_PASSWORD", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var got []map[string]interface{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &got)
+	c.Assert(err, check.IsNil)
+	c.Assert(got, check.DeepEquals, []map[string]interface{}{
+		{"name": "DATABASE_PASSWORD", "value": "", "public": false, "secret": true},
+	})
+}
+
 func (s *S) TestSetEnvHandlerShouldSetAPrivateEnvironmentVariableInTheApp(c *check.C) {
 	a := app.App{Name: "black-dog", Platform: "zend", TeamOwner: s.team.Name}
 	err := app.CreateApp(&a, s.user)
@@ -3776,6 +3864,98 @@ func (s *S) TestAppLogSelectByUnit(c *check.C) {
 	c.Assert(logs[0].Unit, check.Equals, "caliban")
 }
 
+func (s *S) TestAppLogSearch(c *check.C) {
+	a := app.App{Name: "lost", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	baseTime := time.Date(2017, 6, 16, 15, 0, 0, 0, time.UTC)
+	coll := s.logConn.Logs(a.Name)
+	defer coll.DropCollection()
+	for i, unit := range []string{"prospero", "caliban", "ariel", "caliban", "prospero"} {
+		coll.Insert(app.Applog{
+			Date:    baseTime.Add(time.Duration(i) * time.Minute),
+			Message: fmt.Sprintf("error %d", i),
+			Source:  "web",
+			AppName: a.Name,
+			Unit:    unit,
+		})
+	}
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppReadLog,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	query := url.Values{
+		"lines":   {"10"},
+		"unit":    {"caliban", "ariel"},
+		"message": {"error [1-3]"},
+		"since":   {"2017-06-16T15:02:00Z"},
+		"until":   {"2017-06-16T15:04:00Z"},
+	}
+	request, err := http.NewRequest("GET", fmt.Sprintf("/apps/%s/log/?:app=%s&%s", a.Name, a.Name, query.Encode()), nil)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	err = appLog(recorder, request, token)
+	c.Assert(err, check.IsNil)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var logs []app.Applog
+	err = json.Unmarshal(recorder.Body.Bytes(), &logs)
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.HasLen, 2)
+	c.Assert(logs[0].Message, check.Equals, "error 2")
+	c.Assert(logs[1].Message, check.Equals, "error 3")
+}
+
+func (s *S) TestAppLogJSONLines(c *check.C) {
+	a := app.App{Name: "lost", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	a.Log("mars log", "mars", "prospero")
+	a.Log("earth log", "earth", "caliban")
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppReadLog,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	url := fmt.Sprintf("/apps/%s/log/?:app=%s&lines=10&format=jsonl", a.Name, a.Name)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	err = appLog(recorder, request, token)
+	c.Assert(err, check.IsNil)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	lines := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")
+	c.Assert(lines, check.HasLen, 2)
+	var l app.Applog
+	err = json.Unmarshal([]byte(lines[0]), &l)
+	c.Assert(err, check.IsNil)
+	c.Assert(l.Message, check.Equals, "mars log")
+	err = json.Unmarshal([]byte(lines[1]), &l)
+	c.Assert(err, check.IsNil)
+	c.Assert(l.Message, check.Equals, "earth log")
+}
+
+func (s *S) TestAppLogInvalidFilter(c *check.C) {
+	tests := []struct {
+		query string
+		msg   string
+	}{
+		{"since=yesterday", `Parameter "since" must be a date in the RFC 3339 format.`},
+		{"until=2017-06-16", `Parameter "until" must be a date in the RFC 3339 format.`},
+		{"since=2017-06-16T15:00:00Z&until=2017-06-16T14:00:00Z", "since must be before until"},
+		{"message=%28", "invalid message expression: .*"},
+	}
+	for _, tt := range tests {
+		request, err := http.NewRequest("GET", "/apps/something/log/?:app=doesntmatter&lines=10&"+tt.query, nil)
+		c.Assert(err, check.IsNil)
+		recorder := httptest.NewRecorder()
+		err = appLog(recorder, request, s.token)
+		c.Assert(err, check.NotNil)
+		e, ok := err.(*errors.HTTP)
+		c.Assert(ok, check.Equals, true)
+		c.Check(e.Code, check.Equals, http.StatusBadRequest)
+		c.Check(e.Message, check.Matches, tt.msg)
+	}
+}
+
 func (s *S) TestAppLogSelectByLinesShouldReturnTheLastestEntries(c *check.C) {
 	a := app.App{Name: "lost", Platform: "zend", TeamOwner: s.team.Name}
 	err := app.CreateApp(&a, s.user)
@@ -4663,6 +4843,140 @@ func (s *S) TestRestartHandlerReturns403IfTheUserDoesNotHaveAccessToTheApp(c *ch
 	c.Assert(e.Code, check.Equals, http.StatusForbidden)
 }
 
+type migrationTargetProvisioner struct {
+	*provisiontest.FakeProvisioner
+	lockedOnDeploy bool
+}
+
+func (p *migrationTargetProvisioner) GetName() string {
+	return "fake-target"
+}
+
+func (p *migrationTargetProvisioner) Rollback(a provision.App, img string, evt *event.Event) (string, error) {
+	dbApp, err := app.GetByName(a.GetName())
+	if err != nil {
+		return "", err
+	}
+	p.lockedOnDeploy = dbApp.Lock.Locked
+	return p.FakeProvisioner.Rollback(a, img, evt)
+}
+
+func (s *S) TestAppMigratePoolHandler(c *check.C) {
+	target := &migrationTargetProvisioner{FakeProvisioner: provisiontest.NewFakeProvisioner()}
+	provision.Register("fake-target", func() (provision.Provisioner, error) {
+		return target, nil
+	})
+	defer provision.Unregister("fake-target")
+	err := provision.AddPool(provision.AddPoolOptions{Name: "target-pool", Public: true, Provisioner: "fake-target"})
+	c.Assert(err, check.IsNil)
+	a := app.App{Name: "stress", Platform: "zend", TeamOwner: s.team.Name}
+	err = app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = s.conn.Apps().Update(bson.M{"name": a.Name}, bson.M{"$set": bson.M{"deploys": 1}})
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName(a.Name, "tsuru/app-stress:v1")
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.AddUnits(&a, 2, "web", nil)
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("pool=target-pool")
+	request, err := http.NewRequest("POST", "/apps/stress/migrate", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK, check.Commentf("body: %s", recorder.Body.String()))
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/x-json-stream")
+	c.Assert(recorder.Body.String(), check.Matches, `(?s).*App \\"stress\\" migrated to pool \\"target-pool\\".*`)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Pool, check.Equals, "target-pool")
+	c.Assert(target.GetUnits(dbApp), check.HasLen, 2)
+	c.Assert(s.provisioner.Provisioned(&a), check.Equals, false)
+	c.Assert(target.lockedOnDeploy, check.Equals, true)
+	c.Assert(dbApp.Lock.Locked, check.Equals, false)
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget(a.Name),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.pool.migrate",
+		StartCustomData: []map[string]interface{}{
+			{"name": "pool", "value": "target-pool"},
+			{"name": ":app", "value": a.Name},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestAppMigratePoolHandlerAppLocked(c *check.C) {
+	oldDuration := lockWaitDuration
+	lockWaitDuration = 0
+	defer func() { lockWaitDuration = oldDuration }()
+	a := app.App{Name: "stress", Platform: "zend", TeamOwner: s.team.Name, Lock: app.AppLock{
+		Locked: true, Reason: "/test", Owner: "x",
+	}}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("pool=test1")
+	request, err := http.NewRequest("POST", "/apps/stress/migrate", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+	c.Assert(recorder.Body.String(), check.Matches, "stress: App locked by x, running /test. Acquired in .*\n")
+}
+
+func (s *S) TestAppMigratePoolHandlerNoPool(c *check.C) {
+	a := app.App{Name: "stress", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("POST", "/apps/stress/migrate", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "You must provide the pool name.\n")
+}
+
+func (s *S) TestAppMigratePoolHandlerPoolNotFound(c *check.C) {
+	a := app.App{Name: "stress", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("pool=unknown")
+	request, err := http.NewRequest("POST", "/apps/stress/migrate", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, provision.ErrPoolNotFound.Error()+"\n")
+}
+
+func (s *S) TestAppMigratePoolHandlerNoPermission(c *check.C) {
+	a := app.App{Name: "stress", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppUpdatePool,
+		Context: permission.Context(permission.CtxApp, "-invalid-"),
+	})
+	body := strings.NewReader("pool=test1")
+	request, err := http.NewRequest("POST", "/apps/stress/migrate", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
 func (s *S) TestSleepHandler(c *check.C) {
 	config.Set("docker:router", "fake")
 	defer config.Unset("docker:router")
@@ -4800,7 +5114,7 @@ func (s *S) TestAddLog(c *check.C) {
 		"mysource",
 		"mysource",
 	}
-	logs, err := a.LastLogs(5, app.Applog{})
+	logs, err := a.LastLogs(5, app.LogFilter{})
 	c.Assert(err, check.IsNil)
 	got := make([]string, len(logs))
 	gotSource := make([]string, len(logs))
@@ -5501,3 +5815,30 @@ func (s *S) TestListCertificates(c *check.C) {
 		"myapp.fakerouter.com": "",
 	})
 }
+
+func (s *S) TestListCertificatesWithStatus(c *check.C) {
+	a := app.App{Name: "myapp", TeamOwner: s.team.Name, CName: []string{"app.io", "www.app.io"}, Router: "fake-tls"}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.SetCertificate("app.io", testCert, testKey)
+	c.Assert(err, check.IsNil)
+	err = s.conn.ACMECertificates().Insert(acme.Certificate{CName: "www.app.io", App: "myapp", Status: acme.StatusFailed, Error: "challenge failed"})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/apps/myapp/certificate?status=true", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var certs map[string]certificateStatus
+	err = json.Unmarshal(recorder.Body.Bytes(), &certs)
+	c.Assert(err, check.IsNil)
+	c.Assert(certs, check.HasLen, 3)
+	c.Assert(certs["app.io"], check.DeepEquals, certificateStatus{Certificate: testCert})
+	c.Assert(certs["myapp.fakerouter.com"], check.DeepEquals, certificateStatus{})
+	c.Assert(certs["www.app.io"].Certificate, check.Equals, "")
+	c.Assert(certs["www.app.io"].ACME, check.NotNil)
+	c.Assert(certs["www.app.io"].ACME.Status, check.Equals, acme.StatusFailed)
+	c.Assert(certs["www.app.io"].ACME.Error, check.Equals, "challenge failed")
+}
@@ -22,9 +22,13 @@ import (
 const (
 	nonManagedSchemeMsg = "Authentication scheme does not allow this operation."
 	createDisabledMsg   = "User registration is disabled for non-admin users."
+	personalTokenMsg    = "Personal access tokens can't be used to manage the API token."
 )
 
-var createDisabledErr = &errors.HTTP{Code: http.StatusUnauthorized, Message: createDisabledMsg}
+var (
+	createDisabledErr = &errors.HTTP{Code: http.StatusUnauthorized, Message: createDisabledMsg}
+	personalTokenErr  = &errors.HTTP{Code: http.StatusForbidden, Message: personalTokenMsg}
+)
 
 func handleAuthError(err error) error {
 	if err == auth.ErrUserNotFound {
@@ -123,10 +127,17 @@ func login(w http.ResponseWriter, r *http.Request) (err error) {
 		params[key] = r.FormValue(key)
 	}
 	token, err := app.AuthScheme.Login(params)
+	if challenge, ok := err.(*auth.TwoFactorRequired); ok {
+		return json.NewEncoder(w).Encode(challenge)
+	}
 	if err != nil {
 		return handleAuthError(err)
 	}
-	return json.NewEncoder(w).Encode(map[string]string{"token": token.GetValue()})
+	result := map[string]interface{}{"token": token.GetValue()}
+	if t, ok := token.(auth.RecoveryCodesToken); ok && len(t.RecoveryCodes()) > 0 {
+		result["recovery_codes"] = t.RecoveryCodes()
+	}
+	return json.NewEncoder(w).Encode(result)
 }
 
 // title: logout
@@ -562,8 +573,12 @@ func authScheme(w http.ResponseWriter, r *http.Request) error {
 // responses:
 //   200: OK
 //   401: Unauthorized
+//   403: Forbidden
 //   404: User not found
 func regenerateAPIToken(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	if _, ok := t.(*auth.PersonalToken); ok {
+		return personalTokenErr
+	}
 	r.ParseForm()
 	email := r.URL.Query().Get("user")
 	if email == "" {
@@ -605,8 +620,12 @@ func regenerateAPIToken(w http.ResponseWriter, r *http.Request, t auth.Token) (e
 // responses:
 //   200: OK
 //   401: Unauthorized
+//   403: Forbidden
 //   404: User not found
 func showAPIToken(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	if _, ok := t.(*auth.PersonalToken); ok {
+		return personalTokenErr
+	}
 	u, err := t.User()
 	if err != nil {
 		return err
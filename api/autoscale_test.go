@@ -9,6 +9,7 @@ import (
 
 	"github.com/ajg/form"
 	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/autoscale"
 	"github.com/tsuru/tsuru/event"
 	"github.com/tsuru/tsuru/event/eventtest"
@@ -121,7 +122,10 @@ func (s *S) TestAutoScaleConfigHandler(c *check.C) {
 	c.Assert(err, check.IsNil)
 	base, err := autoscale.CurrentConfig()
 	c.Assert(err, check.IsNil)
-	expected, err := json.Marshal(base)
+	expected, err := json.Marshal(struct {
+		*autoscale.Config
+		Schedules []autoscale.ScheduleStatus
+	}{Config: base})
 	c.Assert(err, check.IsNil)
 	recorder := httptest.NewRecorder()
 	request, err := http.NewRequest("GET", "/node/autoscale/config", nil)
@@ -352,3 +356,218 @@ func (s *S) TestAutoScaleDeleteRuleNotFound(c *check.C) {
 		ErrorMatches: `rule not found`,
 	}, eventtest.HasEvent)
 }
+
+func (s *S) TestAutoScaleConfigHandlerWithSchedules(c *check.C) {
+	schedule := autoscale.Schedule{
+		Name:            "always",
+		MetadataFilter:  "pool1",
+		Cron:            "* * * * *",
+		DurationMinutes: 10,
+		MinNodes:        2,
+		Enabled:         true,
+	}
+	err := schedule.Update()
+	c.Assert(err, check.IsNil)
+	err = autoscale.Initialize()
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/node/autoscale/config", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var result struct {
+		RunInterval time.Duration
+		Schedules   []autoscale.ScheduleStatus
+	}
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.RunInterval, check.Equals, time.Hour)
+	c.Assert(result.Schedules, check.HasLen, 1)
+	c.Assert(result.Schedules[0].Schedule, check.DeepEquals, schedule)
+	c.Assert(result.Schedules[0].Active, check.Equals, true)
+}
+
+func (s *S) TestAutoScaleSetSchedule(c *check.C) {
+	schedule := autoscale.Schedule{
+		Name:            "peak",
+		MetadataFilter:  "pool1",
+		Cron:            "0 18 * * 1-5",
+		DurationMinutes: 180,
+		Location:        "America/Sao_Paulo",
+		MinNodes:        5,
+		MaxNodes:        10,
+		Enabled:         true,
+	}
+	v, err := form.EncodeToValues(&schedule)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("POST", "/1.4/node/autoscale/schedules", strings.NewReader(v.Encode()))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	schedules, err := autoscale.ListSchedules()
+	c.Assert(err, check.IsNil)
+	c.Assert(schedules, check.DeepEquals, []autoscale.Schedule{schedule})
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypePool, Value: "pool1"},
+		Owner:  s.token.GetUserName(),
+		Kind:   "node.autoscale.update",
+		StartCustomData: []map[string]interface{}{
+			{"name": "Name", "value": "peak"},
+			{"name": "Cron", "value": "0 18 * * 1-5"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestAutoScaleSetScheduleInvalid(c *check.C) {
+	body := strings.NewReader("Name=peak&Cron=0+25+*+*+*&DurationMinutes=60")
+	request, err := http.NewRequest("POST", "/1.4/node/autoscale/schedules", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Matches, `invalid cron expression "0 25 \* \* \*": hour "25" out of range 0-23\n`)
+}
+
+func (s *S) TestAutoScaleListSchedules(c *check.C) {
+	schedule := autoscale.Schedule{Name: "peak", Cron: "0 18 * * *", DurationMinutes: 60, MinNodes: 3}
+	err := schedule.Update()
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/1.4/node/autoscale/schedules", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var schedules []autoscale.ScheduleStatus
+	err = json.Unmarshal(recorder.Body.Bytes(), &schedules)
+	c.Assert(err, check.IsNil)
+	c.Assert(schedules, check.HasLen, 1)
+	c.Assert(schedules[0].Schedule, check.DeepEquals, schedule)
+	c.Assert(schedules[0].NextStart.IsZero(), check.Equals, false)
+}
+
+func (s *S) TestAutoScaleDeleteSchedule(c *check.C) {
+	schedule := autoscale.Schedule{Name: "peak", Cron: "0 18 * * *", DurationMinutes: 60, MinNodes: 3}
+	err := schedule.Update()
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/1.4/node/autoscale/schedules/peak", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	schedules, err := autoscale.ListSchedules()
+	c.Assert(err, check.IsNil)
+	c.Assert(schedules, check.HasLen, 0)
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("DELETE", "/1.4/node/autoscale/schedules/peak", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestAppAutoScaleSetRule(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	rule := app.AutoScaleRule{Process: "web", MinUnits: 1, MaxUnits: 4, Metric: "cpu", Target: 60, Enabled: true}
+	v, err := form.EncodeToValues(&rule)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("POST", "/1.4/apps/myapp/autoscale", strings.NewReader(v.Encode()))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.AutoScale, check.DeepEquals, []app.AutoScaleRule{rule})
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget(a.Name),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.unit.autoscale",
+		StartCustomData: []map[string]interface{}{
+			{"name": "Process", "value": "web"},
+			{"name": "MinUnits", "value": "1"},
+			{"name": "MaxUnits", "value": "4"},
+			{"name": "Metric", "value": "cpu"},
+			{"name": "Target", "value": "60"},
+			{"name": "Enabled", "value": "true"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestAppAutoScaleSetRuleInvalid(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("Process=web&MinUnits=1&MaxUnits=4&Metric=memory&Target=10")
+	request, err := http.NewRequest("POST", "/1.4/apps/myapp/autoscale", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "invalid metric \"memory\", must be one of: cpu, requests\n")
+}
+
+func (s *S) TestAppAutoScaleListRules(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	rule := app.AutoScaleRule{Process: "web", MinUnits: 1, MaxUnits: 4, Metric: "requests", Target: 60}
+	err = a.SetAutoScaleRule(rule)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/1.4/apps/myapp/autoscale", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var rules []app.AutoScaleRule
+	err = json.Unmarshal(recorder.Body.Bytes(), &rules)
+	c.Assert(err, check.IsNil)
+	c.Assert(rules, check.DeepEquals, []app.AutoScaleRule{rule})
+}
+
+func (s *S) TestAppAutoScaleDeleteRule(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.SetAutoScaleRule(app.AutoScaleRule{Process: "web", MinUnits: 1, MaxUnits: 4, Metric: "requests", Target: 60})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/1.4/apps/myapp/autoscale/web", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.AutoScale, check.HasLen, 0)
+	request, err = http.NewRequest("DELETE", "/1.4/apps/myapp/autoscale/web", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
@@ -113,6 +113,13 @@ func deploy(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	if origin == "" && commit != "" {
 		origin = "git"
 	}
+	strategy, err := deployStrategyFromForm(r)
+	if err != nil {
+		return &tsuruErrors.HTTP{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		}
+	}
 	opts := app.DeployOptions{
 		App:        instance,
 		Commit:     commit,
@@ -124,6 +131,7 @@ func deploy(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 		Origin:     origin,
 		Build:      build,
 		Message:    message,
+		Strategy:   strategy,
 	}
 	opts.GetKind()
 	if t.GetAppName() != app.InternalAppName {
@@ -157,6 +165,27 @@ func deploy(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	return err
 }
 
+func deployStrategyFromForm(r *http.Request) (app.DeployStrategy, error) {
+	strategy := app.DeployStrategy{
+		Kind: app.DeployStrategyKind(r.FormValue("strategy")),
+	}
+	if percent := r.FormValue("strategy-percent"); percent != "" {
+		var err error
+		strategy.Percent, err = strconv.Atoi(percent)
+		if err != nil {
+			return strategy, errors.Errorf("invalid strategy-percent: %q", percent)
+		}
+	}
+	if interval := r.FormValue("strategy-interval"); interval != "" {
+		var err error
+		strategy.Interval, err = time.ParseDuration(interval)
+		if err != nil {
+			return strategy, errors.Errorf("invalid strategy-interval: %q", interval)
+		}
+	}
+	return strategy, strategy.Validate()
+}
+
 func permSchemeForDeploy(opts app.DeployOptions) *permission.PermissionScheme {
 	switch opts.GetKind() {
 	case app.DeployGit:
@@ -242,6 +271,13 @@ func deployRollback(w http.ResponseWriter, r *http.Request, t auth.Token) error
 			}
 		}
 	}
+	strategy, err := deployStrategyFromForm(r)
+	if err != nil {
+		return &tsuruErrors.HTTP{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		}
+	}
 	w.Header().Set("Content-Type", "application/x-json-stream")
 	keepAliveWriter := io.NewKeepAliveWriter(w, 30*time.Second, "")
 	defer keepAliveWriter.Stop()
@@ -253,6 +289,7 @@ func deployRollback(w http.ResponseWriter, r *http.Request, t auth.Token) error
 		User:         t.GetUserName(),
 		Origin:       origin,
 		Rollback:     true,
+		Strategy:     strategy,
 	}
 	opts.GetKind()
 	canRollback := permission.Check(t, permSchemeForDeploy(opts), contextsForApp(instance)...)
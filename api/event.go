@@ -8,15 +8,22 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/ajg/form"
 	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/errors"
 	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
 	"github.com/tsuru/tsuru/permission"
 	"gopkg.in/mgo.v2/bson"
 )
 
+var (
+	eventStreamInterval  = time.Second
+	eventStreamKeepAlive = 30 * time.Second
+)
+
 // title: event list
 // path: /events
 // method: GET
@@ -25,6 +32,23 @@ import (
 //   200: OK
 //   204: No content
 func eventList(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	filter, err := eventFilterFromRequest(r, t)
+	if err != nil {
+		return err
+	}
+	events, err := event.List(filter)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Add("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(events)
+}
+
+func eventFilterFromRequest(r *http.Request, t auth.Token) (*event.Filter, error) {
 	r.ParseForm()
 	filter := &event.Filter{}
 	dec := form.NewDecoder(nil)
@@ -32,23 +56,73 @@ func eventList(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	dec.IgnoreCase(true)
 	err := dec.DecodeValues(&filter, r.Form)
 	if err != nil {
-		return &errors.HTTP{Code: http.StatusBadRequest, Message: fmt.Sprintf("unable to parse event filters: %s", err)}
+		return nil, &errors.HTTP{Code: http.StatusBadRequest, Message: fmt.Sprintf("unable to parse event filters: %s", err)}
 	}
 	filter.PruneUserValues()
 	filter.Permissions, err = t.Permissions()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	events, err := event.List(filter)
+	return filter, nil
+}
+
+// title: event stream
+// path: /events/stream
+// method: GET
+// produce: text/event-stream
+// responses:
+//   200: OK
+//   400: Invalid filter
+func eventStream(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	filter, err := eventFilterFromRequest(r, t)
 	if err != nil {
 		return err
 	}
-	if len(events) == 0 {
-		w.WriteHeader(http.StatusNoContent)
-		return nil
+	var closeChan <-chan bool
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		closeChan = notifier.CloseNotify()
+	} else {
+		closeChan = make(chan bool)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	feed := event.NewFeed(filter)
+	ticker := time.NewTicker(eventStreamInterval)
+	defer ticker.Stop()
+	lastWrite := time.Now()
+	for {
+		msgs, err := feed.Next()
+		if err != nil {
+			log.Errorf("[events] unable to stream events: %s", err)
+			data, _ := json.Marshal(errMsg{Error: err.Error()})
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+			return nil
+		}
+		for i := range msgs {
+			data, err := json.Marshal(msgs[i])
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msgs[i].Type, data)
+			if err != nil {
+				return nil
+			}
+			lastWrite = time.Now()
+		}
+		if time.Since(lastWrite) >= eventStreamKeepAlive {
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+			if err != nil {
+				return nil
+			}
+			lastWrite = time.Now()
+		}
+		select {
+		case <-closeChan:
+			return nil
+		case <-ticker.C:
+		}
 	}
-	w.Header().Add("Content-Type", "application/json")
-	return json.NewEncoder(w).Encode(events)
 }
 
 // title: kind list
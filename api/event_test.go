@@ -5,11 +5,13 @@
 package api
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"time"
 
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/app"
@@ -443,3 +445,73 @@ func (s *EventSuite) TestEventCancelWithoutPermission(c *check.C) {
 	server.ServeHTTP(recorder, request)
 	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
 }
+
+func (s *EventSuite) TestEventStream(c *check.C) {
+	oldInterval := eventStreamInterval
+	eventStreamInterval = 10 * time.Millisecond
+	defer func() { eventStreamInterval = oldInterval }()
+	token := customUserWithPermission(c, "myuser", permission.Permission{
+		Scheme:  permission.PermAppReadEvents,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	notAllowed, err := event.New(&event.Opts{
+		Target:  event.Target{Type: event.TargetTypeApp, Value: "other"},
+		Owner:   s.token,
+		Kind:    permission.PermAppDeploy,
+		Allowed: event.Allowed(permission.PermAppReadEvents, permission.Context(permission.CtxTeam, "other-team")),
+	})
+	c.Assert(err, check.IsNil)
+	defer notAllowed.Abort()
+	srv := httptest.NewServer(RunServer(true))
+	defer srv.Close()
+	request, err := http.NewRequest("GET", srv.URL+"/1.4/events/stream?target.type=app", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	rsp, err := http.DefaultClient.Do(request)
+	c.Assert(err, check.IsNil)
+	defer rsp.Body.Close()
+	c.Assert(rsp.StatusCode, check.Equals, http.StatusOK)
+	c.Assert(rsp.Header.Get("Content-Type"), check.Equals, "text/event-stream")
+	evt, err := event.New(&event.Opts{
+		Target:  event.Target{Type: event.TargetTypeApp, Value: "aha"},
+		Owner:   s.token,
+		Kind:    permission.PermAppDeploy,
+		Allowed: event.Allowed(permission.PermAppReadEvents, permission.Context(permission.CtxTeam, s.team.Name)),
+	})
+	c.Assert(err, check.IsNil)
+	evt.Logf("deploying")
+	err = evt.Done(nil)
+	c.Assert(err, check.IsNil)
+	scanner := bufio.NewScanner(rsp.Body)
+	var msgs []event.FeedMessage
+	var eventTypes []string
+	for len(msgs) < 3 && scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "event: ") {
+			eventTypes = append(eventTypes, strings.TrimPrefix(line, "event: "))
+		}
+		if strings.HasPrefix(line, "data: ") {
+			var msg event.FeedMessage
+			err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &msg)
+			c.Assert(err, check.IsNil)
+			msgs = append(msgs, msg)
+		}
+	}
+	c.Assert(scanner.Err(), check.IsNil)
+	c.Assert(eventTypes, check.DeepEquals, []string{event.FeedMessageStart, event.FeedMessageLog, event.FeedMessageEnd})
+	for _, msg := range msgs {
+		c.Assert(msg.EventID, check.Equals, evt.UniqueID)
+	}
+	c.Assert(msgs[1].Log, check.Equals, "deploying\n")
+	c.Assert(msgs[2].Event.Target, check.DeepEquals, evt.Target)
+}
+
+func (s *EventSuite) TestEventStreamInvalidFilter(c *check.C) {
+	request, err := http.NewRequest("GET", "/1.4/events/stream?running=notbool", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
@@ -0,0 +1,272 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/provision"
+)
+
+// maskLogDrainURL returns the drain url without its password.
+func maskLogDrainURL(drainURL string) string {
+	u, err := url.Parse(drainURL)
+	if err != nil || u.User == nil {
+		return drainURL
+	}
+	if _, hasPassword := u.User.Password(); !hasPassword {
+		return drainURL
+	}
+	u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	return u.String()
+}
+
+// logDrainCustomData returns the event custom data for the request form,
+// without the password in the drain url.
+func logDrainCustomData(values url.Values) []map[string]interface{} {
+	filtered := url.Values{}
+	for k, v := range values {
+		filtered[k] = v
+	}
+	if values.Get("url") != "" {
+		filtered.Set("url", maskLogDrainURL(values.Get("url")))
+	}
+	return event.FormToCustomData(filtered)
+}
+
+func logDrainError(err error) error {
+	switch err {
+	case app.ErrLogDrainNotFound:
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	case app.ErrLogDrainAlreadyExists:
+		return &errors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+	}
+	if _, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return err
+}
+
+func writeLogDrains(w http.ResponseWriter, drains []app.LogDrain) error {
+	if len(drains) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(drains)
+}
+
+// title: app log drain list
+// path: /apps/{app}/log-drains
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   204: No content
+//   401: Unauthorized
+//   404: App not found
+func appLogDrainList(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	a, err := getAppFromContext(r.URL.Query().Get(":app"), r)
+	if err != nil {
+		return err
+	}
+	if !permission.Check(t, permission.PermAppReadLogDrain, contextsForApp(&a)...) {
+		return permission.ErrUnauthorized
+	}
+	drains, err := a.LogDrains()
+	if err != nil {
+		return err
+	}
+	for i := range drains {
+		if drains[i].App == "" {
+			drains[i].URL = maskLogDrainURL(drains[i].URL)
+		}
+	}
+	return writeLogDrains(w, drains)
+}
+
+// title: app log drain add
+// path: /apps/{app}/log-drains
+// method: POST
+// consume: application/x-www-form-urlencoded
+// responses:
+//   201: Log drain created
+//   400: Invalid data
+//   401: Unauthorized
+//   404: App not found
+//   409: Log drain already exists
+func appLogDrainAdd(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	a, err := getAppFromContext(r.URL.Query().Get(":app"), r)
+	if err != nil {
+		return err
+	}
+	if !permission.Check(t, permission.PermAppUpdateLogDrainAdd, contextsForApp(&a)...) {
+		return permission.ErrUnauthorized
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     appTarget(a.Name),
+		Kind:       permission.PermAppUpdateLogDrainAdd,
+		Owner:      t,
+		CustomData: logDrainCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermAppReadEvents, contextsForApp(&a)...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = app.AddLogDrain(&app.LogDrain{
+		Name: r.FormValue("name"),
+		URL:  r.FormValue("url"),
+		App:  a.Name,
+	})
+	if err != nil {
+		return logDrainError(err)
+	}
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// title: app log drain remove
+// path: /apps/{app}/log-drains/{drain}
+// method: DELETE
+// responses:
+//   200: Log drain removed
+//   401: Unauthorized
+//   404: Not found
+func appLogDrainRemove(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	a, err := getAppFromContext(r.URL.Query().Get(":app"), r)
+	if err != nil {
+		return err
+	}
+	if !permission.Check(t, permission.PermAppUpdateLogDrainRemove, contextsForApp(&a)...) {
+		return permission.ErrUnauthorized
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     appTarget(a.Name),
+		Kind:       permission.PermAppUpdateLogDrainRemove,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermAppReadEvents, contextsForApp(&a)...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	return logDrainError(app.RemoveLogDrain(a.Name, "", r.URL.Query().Get(":drain")))
+}
+
+func poolForLogDrains(r *http.Request) (string, error) {
+	poolName := r.URL.Query().Get(":name")
+	_, err := provision.GetPoolByName(poolName)
+	if err == provision.ErrPoolNotFound {
+		return "", &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return poolName, err
+}
+
+// title: pool log drain list
+// path: /pools/{name}/log-drains
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   204: No content
+//   401: Unauthorized
+//   404: Pool not found
+func poolLogDrainList(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	poolName, err := poolForLogDrains(r)
+	if err != nil {
+		return err
+	}
+	if !permission.Check(t, permission.PermPoolReadLogDrain, permission.Context(permission.CtxPool, poolName)) {
+		return permission.ErrUnauthorized
+	}
+	drains, err := app.ListLogDrains("", poolName)
+	if err != nil {
+		return err
+	}
+	return writeLogDrains(w, drains)
+}
+
+// title: pool log drain add
+// path: /pools/{name}/log-drains
+// method: POST
+// consume: application/x-www-form-urlencoded
+// responses:
+//   201: Log drain created
+//   400: Invalid data
+//   401: Unauthorized
+//   404: Pool not found
+//   409: Log drain already exists
+func poolLogDrainAdd(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	poolName, err := poolForLogDrains(r)
+	if err != nil {
+		return err
+	}
+	if !permission.Check(t, permission.PermPoolUpdateLogDrainAdd, permission.Context(permission.CtxPool, poolName)) {
+		return permission.ErrUnauthorized
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     event.Target{Type: event.TargetTypePool, Value: poolName},
+		Kind:       permission.PermPoolUpdateLogDrainAdd,
+		Owner:      t,
+		CustomData: logDrainCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermPoolReadEvents, permission.Context(permission.CtxPool, poolName)),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = app.AddLogDrain(&app.LogDrain{
+		Name: r.FormValue("name"),
+		URL:  r.FormValue("url"),
+		Pool: poolName,
+	})
+	if err != nil {
+		return logDrainError(err)
+	}
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// title: pool log drain remove
+// path: /pools/{name}/log-drains/{drain}
+// method: DELETE
+// responses:
+//   200: Log drain removed
+//   401: Unauthorized
+//   404: Not found
+func poolLogDrainRemove(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	poolName, err := poolForLogDrains(r)
+	if err != nil {
+		return err
+	}
+	if !permission.Check(t, permission.PermPoolUpdateLogDrainRemove, permission.Context(permission.CtxPool, poolName)) {
+		return permission.ErrUnauthorized
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     event.Target{Type: event.TargetTypePool, Value: poolName},
+		Kind:       permission.PermPoolUpdateLogDrainRemove,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermPoolReadEvents, permission.Context(permission.CtxPool, poolName)),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	return logDrainError(app.RemoveLogDrain("", poolName, r.URL.Query().Get(":drain")))
+}
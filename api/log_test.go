@@ -118,9 +118,9 @@ loop:
 			logs1 []app.Applog
 			logs2 []app.Applog
 		)
-		logs1, err = a1.LastLogs(3, app.Applog{})
+		logs1, err = a1.LastLogs(3, app.LogFilter{})
 		c.Assert(err, check.IsNil)
-		logs2, err = a2.LastLogs(2, app.Applog{})
+		logs2, err = a2.LastLogs(2, app.LogFilter{})
 		c.Assert(err, check.IsNil)
 		if len(logs1) == 3 && len(logs2) == 2 {
 			break
@@ -132,7 +132,7 @@ loop:
 		default:
 		}
 	}
-	logs, err := a1.LastLogs(3, app.Applog{})
+	logs, err := a1.LastLogs(3, app.LogFilter{})
 	c.Assert(err, check.IsNil)
 	sort.Sort(LogList(logs))
 	c.Assert(logs, check.DeepEquals, []app.Applog{
@@ -140,7 +140,7 @@ loop:
 		{Date: baseTime.Add(2 * time.Second), Message: "msg3", Source: "web", AppName: "myapp1", Unit: "unit3"},
 		{Date: baseTime.Add(4 * time.Second), Message: "msg5", Source: "worker", AppName: "myapp1", Unit: "unit3"},
 	})
-	logs, err = a2.LastLogs(2, app.Applog{})
+	logs, err = a2.LastLogs(2, app.LogFilter{})
 	c.Assert(err, check.IsNil)
 	sort.Sort(LogList(logs))
 	c.Assert(logs, check.DeepEquals, []app.Applog{
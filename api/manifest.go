@@ -0,0 +1,197 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	tsuruIo "github.com/tsuru/tsuru/io"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/yaml.v2"
+)
+
+// title: export app manifest
+// path: /apps/{app}/manifest
+// method: GET
+// produce: application/x-yaml
+// responses:
+//   200: OK
+//   401: Unauthorized
+//   404: App not found
+func exportAppManifest(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	a, err := getAppFromContext(r.URL.Query().Get(":app"), r)
+	if err != nil {
+		return err
+	}
+	canRead := permission.Check(t, permission.PermAppRead,
+		contextsForApp(&a)...,
+	)
+	if !canRead {
+		return permission.ErrUnauthorized
+	}
+	manifest, err := a.Manifest()
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/x-yaml")
+	_, err = w.Write(data)
+	return err
+}
+
+// title: apply app manifest
+// path: /apps/manifest
+// method: POST
+// consume: application/x-www-form-urlencoded
+// produce: application/json, application/x-json-stream
+// responses:
+//   200: Changes planned or applied
+//   400: Invalid manifest
+//   401: Unauthorized
+//   409: App locked
+func applyAppManifest(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	dryRun, _ := strconv.ParseBool(r.FormValue("dry"))
+	manifest, err := app.ParseManifest([]byte(r.FormValue("manifest")))
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	if manifest.Name == "" {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "invalid manifest: the app name is required"}
+	}
+	existing, err := app.GetByName(manifest.Name)
+	if err != nil && err != app.ErrAppNotFound {
+		return err
+	}
+	if existing == nil && manifest.TeamOwner == "" {
+		manifest.TeamOwner, err = permission.TeamForPermission(t, permission.PermAppCreate)
+		if err != nil {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: fmt.Sprintf("unable to define the team owner of the new app: %s", err)}
+		}
+	}
+	if existing != nil && !dryRun {
+		var locked bool
+		locked, err = app.AcquireApplicationLockWait(manifest.Name, t.GetUserName(), "POST /apps/manifest", lockWaitDuration)
+		if err != nil {
+			return err
+		}
+		if !locked {
+			existing, err = getApp(manifest.Name)
+			if err != nil {
+				return err
+			}
+			return &errors.HTTP{Code: http.StatusConflict, Message: fmt.Sprintf("%s: %s", existing.Name, &existing.Lock)}
+		}
+		defer app.ReleaseApplicationLock(manifest.Name)
+	}
+	diff, err := app.NewManifestDiff(manifest)
+	if err != nil {
+		if _, ok := err.(*errors.ValidationError); ok {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+		}
+		return err
+	}
+	a := diff.App
+	if a == nil {
+		a = &app.App{Name: manifest.Name, Pool: manifest.Pool, TeamOwner: manifest.TeamOwner, Teams: []string{manifest.TeamOwner}}
+	}
+	for _, change := range diff.Changes {
+		err = checkManifestChangePermission(t, a, change)
+		if err != nil {
+			return err
+		}
+	}
+	if diff.App == nil && manifest.Platform != "" {
+		platform, errPlat := app.GetPlatform(manifest.Platform)
+		if errPlat != nil {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: errPlat.Error()}
+		}
+		if platform.Disabled && !permission.Check(t, permission.PermPlatformUpdate) && !permission.Check(t, permission.PermPlatformCreate) {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: app.InvalidPlatformError.Error()}
+		}
+	}
+	changes := diff.Changes
+	if changes == nil {
+		changes = []app.ManifestChange{}
+	}
+	if dryRun {
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(changes)
+	}
+	kind := permission.PermAppUpdate
+	if diff.App == nil {
+		kind = permission.PermAppCreate
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     appTarget(manifest.Name),
+		Kind:       kind,
+		Owner:      t,
+		CustomData: changes,
+		Allowed:    event.Allowed(permission.PermAppReadEvents, contextsForApp(a)...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
+	defer keepAliveWriter.Stop()
+	w.Header().Set("Content-Type", "application/x-json-stream")
+	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
+	err = diff.Apply(u, writer)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(writer, "%d changes applied to app %q\n", len(changes), manifest.Name)
+	return nil
+}
+
+func checkManifestChangePermission(t auth.Token, a *app.App, change app.ManifestChange) error {
+	var ctxs []permission.PermissionContext
+	if change.Action == app.ManifestCreateApp {
+		ctxs = []permission.PermissionContext{permission.Context(permission.CtxTeam, a.TeamOwner)}
+	} else {
+		ctxs = contextsForApp(a)
+	}
+	if !permission.Check(t, change.Permission(), ctxs...) {
+		return permission.ErrUnauthorized
+	}
+	if change.Action != app.ManifestBind && change.Action != app.ManifestUnbind {
+		return nil
+	}
+	instance, err := getServiceInstanceOrError(change.Service, change.Instance)
+	if err != nil {
+		if _, ok := err.(*errors.HTTP); ok && change.Action == app.ManifestBind {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+		}
+		return err
+	}
+	scheme := permission.PermServiceInstanceUpdateBind
+	if change.Action == app.ManifestUnbind {
+		scheme = permission.PermServiceInstanceUpdateUnbind
+	}
+	allowed := permission.Check(t, scheme,
+		append(permission.Contexts(permission.CtxTeam, instance.Teams),
+			permission.Context(permission.CtxServiceInstance, instance.Name),
+		)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/quota"
+	"gopkg.in/check.v1"
+	"gopkg.in/yaml.v2"
+)
+
+func (s *S) applyManifestRequest(c *check.C, token string, manifest string, dryRun bool) *httptest.ResponseRecorder {
+	v := url.Values{"manifest": []string{manifest}}
+	if dryRun {
+		v.Set("dry", "true")
+	}
+	request, err := http.NewRequest("POST", "/1.4/apps/manifest", strings.NewReader(v.Encode()))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+token)
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	return recorder
+}
+
+func (s *S) TestExportAppManifest(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name, Quota: quota.Unlimited}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.SetEnvs(bind.SetEnvApp{Envs: []bind.EnvVar{{Name: "DATABASE_HOST", Value: "localhost", Public: true}}}, nil)
+	c.Assert(err, check.IsNil)
+	err = a.AddUnits(2, "web", nil)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/1.4/apps/myapp/manifest", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/x-yaml")
+	var manifest app.Manifest
+	err = yaml.Unmarshal(recorder.Body.Bytes(), &manifest)
+	c.Assert(err, check.IsNil)
+	c.Assert(manifest.Name, check.Equals, "myapp")
+	c.Assert(manifest.Platform, check.Equals, "zend")
+	c.Assert(manifest.TeamOwner, check.Equals, s.team.Name)
+	c.Assert(manifest.Teams, check.DeepEquals, []string{s.team.Name})
+	c.Assert(manifest.Envs, check.DeepEquals, []app.ManifestEnv{{Name: "DATABASE_HOST", Value: "localhost"}})
+	c.Assert(manifest.Units, check.DeepEquals, map[string]uint{"web": 2})
+}
+
+func (s *S) TestExportAppManifestUnauthorized(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	token := customUserWithPermission(c, "someuser", permission.Permission{
+		Scheme:  permission.PermAppRead,
+		Context: permission.Context(permission.CtxApp, "otherapp"),
+	})
+	request, err := http.NewRequest("GET", "/1.4/apps/myapp/manifest", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestApplyAppManifestDryRun(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	manifest := "name: myapp\nenvs:\n  - name: DATABASE_HOST\n    value: localhost\ncnames: [myapp.example.com]\n"
+	recorder := s.applyManifestRequest(c, s.token.GetValue(), manifest, true)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var changes []app.ManifestChange
+	err = json.Unmarshal(recorder.Body.Bytes(), &changes)
+	c.Assert(err, check.IsNil)
+	c.Assert(changes, check.DeepEquals, []app.ManifestChange{
+		{Action: app.ManifestSetEnv, Name: "DATABASE_HOST", To: "localhost"},
+		{Action: app.ManifestAddCName, Name: "myapp.example.com"},
+	})
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.CName, check.HasLen, 0)
+	_, ok := dbApp.Env["DATABASE_HOST"]
+	c.Assert(ok, check.Equals, false)
+}
+
+func (s *S) TestApplyAppManifest(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name, Quota: quota.Unlimited}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	manifest := "name: myapp\ndescription: my app\nenvs:\n  - name: DATABASE_HOST\n    value: localhost\ncnames: [myapp.example.com]\nunits:\n  web: 1\n"
+	recorder := s.applyManifestRequest(c, s.token.GetValue(), manifest, false)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/x-json-stream")
+	c.Assert(recorder.Body.String(), check.Matches, `(?s).*4 changes applied to app \\"myapp\\".*`)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Description, check.Equals, "my app")
+	c.Assert(dbApp.CName, check.DeepEquals, []string{"myapp.example.com"})
+	c.Assert(dbApp.Env["DATABASE_HOST"].Value, check.Equals, "localhost")
+	c.Assert(dbApp.Lock.Locked, check.Equals, false)
+	units, err := dbApp.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 1)
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget("myapp"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update",
+		StartCustomData: []map[string]interface{}{
+			{"action": "update", "name": "description", "to": "my app"},
+			{"action": "add-cname", "name": "myapp.example.com"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestApplyAppManifestCreatesApp(c *check.C) {
+	manifest := "name: newapp\nplatform: zend\nteam-owner: " + s.team.Name + "\nenvs:\n  - name: A\n    value: \"1\"\n"
+	recorder := s.applyManifestRequest(c, s.token.GetValue(), manifest, false)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	dbApp, err := app.GetByName("newapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Platform, check.Equals, "zend")
+	c.Assert(dbApp.TeamOwner, check.Equals, s.team.Name)
+	c.Assert(dbApp.Env["A"].Value, check.Equals, "1")
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget("newapp"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.create",
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestApplyAppManifestUnauthorized(c *check.C) {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	token := customUserWithPermission(c, "someuser", permission.Permission{
+		Scheme:  permission.PermAppUpdateEnvSet,
+		Context: permission.Context(permission.CtxApp, "myapp"),
+	})
+	manifest := "name: myapp\nenvs:\n  - name: A\n    value: \"1\"\n"
+	recorder := s.applyManifestRequest(c, token.GetValue(), manifest, true)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	manifest += "cnames: [myapp.example.com]\n"
+	recorder = s.applyManifestRequest(c, token.GetValue(), manifest, false)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	_, ok := dbApp.Env["A"]
+	c.Assert(ok, check.Equals, false)
+}
+
+func (s *S) TestApplyAppManifestInvalid(c *check.C) {
+	recorder := s.applyManifestRequest(c, s.token.GetValue(), "envs: [{value: x}]", false)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "invalid manifest: envs must have a name\n")
+	recorder = s.applyManifestRequest(c, s.token.GetValue(), "platform: zend", false)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "invalid manifest: the app name is required\n")
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	recorder = s.applyManifestRequest(c, s.token.GetValue(), "name: myapp\nplatform: python", true)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, `changing the platform of an existing app is not supported, app "myapp" uses "zend"`+"\n")
+}
@@ -36,7 +36,10 @@ func validate(token string, r *http.Request) (auth.Token, error) {
 	if err != nil {
 		t, err = auth.APIAuth(token)
 		if err != nil {
-			return nil, err
+			t, err = auth.PersonalTokenAuth(token)
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 	if t.IsAppToken() {
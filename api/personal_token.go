@@ -0,0 +1,154 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+)
+
+// parseTokenPermission parses permissions in the format
+// name[:context-type[:context-value]], e.g. app.deploy:app:myapp.
+func parseTokenPermission(value string) auth.TokenPermission {
+	parts := strings.SplitN(value, ":", 3)
+	perm := auth.TokenPermission{Name: parts[0]}
+	if len(parts) > 1 {
+		perm.ContextType = parts[1]
+	}
+	if len(parts) > 2 {
+		perm.ContextValue = parts[2]
+	}
+	return perm
+}
+
+// parseTokenExpiration parses durations like 720h, also accepting a number
+// of days, like 30d.
+func parseTokenExpiration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// title: personal token list
+// path: /users/tokens
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   204: No content
+//   401: Unauthorized
+func listPersonalTokens(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	email := t.GetUserName()
+	if !permission.Check(t, permission.PermUserReadTokens, permission.Context(permission.CtxUser, email)) {
+		return permission.ErrUnauthorized
+	}
+	tokens, err := auth.ListPersonalTokens(email)
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(tokens)
+}
+
+// title: personal token create
+// path: /users/tokens
+// method: POST
+// consume: application/x-www-form-urlencoded
+// produce: application/json
+// responses:
+//   201: Token created
+//   400: Invalid data
+//   401: Unauthorized
+//   403: Forbidden
+//   409: Token already exists
+func createPersonalToken(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	email := t.GetUserName()
+	if !permission.Check(t, permission.PermUserUpdateToken, permission.Context(permission.CtxUser, email)) {
+		return permission.ErrUnauthorized
+	}
+	expiresIn, err := parseTokenExpiration(r.FormValue("expires"))
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: fmt.Sprintf("invalid expiration: %s", err)}
+	}
+	opts := auth.PersonalTokenOpts{
+		Name:      r.FormValue("name"),
+		ExpiresIn: expiresIn,
+	}
+	for _, p := range r.Form["permission"] {
+		opts.Permissions = append(opts.Permissions, parseTokenPermission(p))
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     userTarget(email),
+		Kind:       permission.PermUserUpdateToken,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermUserReadEvents, permission.Context(permission.CtxUser, email)),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	token, err := auth.CreatePersonalToken(t, opts)
+	if err != nil {
+		return handleAuthError(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(w).Encode(token)
+}
+
+// title: personal token revoke
+// path: /users/tokens/{name}
+// method: DELETE
+// responses:
+//   200: Token revoked
+//   401: Unauthorized
+//   404: Token not found
+func revokePersonalToken(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	email := t.GetUserName()
+	if !permission.Check(t, permission.PermUserUpdateToken, permission.Context(permission.CtxUser, email)) {
+		return permission.ErrUnauthorized
+	}
+	name := r.URL.Query().Get(":name")
+	evt, err := event.New(&event.Opts{
+		Target:     userTarget(email),
+		Kind:       permission.PermUserUpdateToken,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermUserReadEvents, permission.Context(permission.CtxUser, email)),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = auth.RevokePersonalToken(email, name)
+	if err == auth.ErrPersonalTokenNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return err
+}
@@ -0,0 +1,161 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+func (s *AuthSuite) TestCreatePersonalToken(c *check.C) {
+	body := strings.NewReader("name=ci&expires=30d&permission=app.deploy:team:tsuruteam")
+	request, err := http.NewRequest("POST", "/users/tokens", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var token auth.PersonalToken
+	err = json.Unmarshal(recorder.Body.Bytes(), &token)
+	c.Assert(err, check.IsNil)
+	c.Assert(token.Token, check.Not(check.Equals), "")
+	c.Assert(token.Name, check.Equals, "ci")
+	c.Assert(token.ExpiresAt.Sub(token.CreatedAt), check.Equals, 30*24*time.Hour)
+	c.Assert(token.AllowedPermissions, check.DeepEquals, []auth.TokenPermission{
+		{Name: "app.deploy", ContextType: "team", ContextValue: "tsuruteam"},
+	})
+	c.Assert(eventtest.EventDesc{
+		Target: userTarget(s.user.Email),
+		Owner:  s.token.GetUserName(),
+		Kind:   "user.update.token",
+		StartCustomData: []map[string]interface{}{
+			{"name": "name", "value": "ci"},
+			{"name": "expires", "value": "30d"},
+			{"name": "permission", "value": "app.deploy:team:tsuruteam"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *AuthSuite) TestCreatePersonalTokenInvalidExpiration(c *check.C) {
+	body := strings.NewReader("name=ci&expires=tomorrow")
+	request, err := http.NewRequest("POST", "/users/tokens", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *AuthSuite) TestCreatePersonalTokenPermissionNotGranted(c *check.C) {
+	token := customUserWithPermission(c, "deployer", permission.Permission{
+		Scheme:  permission.PermAppDeploy,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	body := strings.NewReader("name=ci&permission=app.update:team:" + s.team.Name)
+	request, err := http.NewRequest("POST", "/users/tokens", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *AuthSuite) TestPersonalTokenAuthorizesRequests(c *check.C) {
+	token, err := auth.CreatePersonalToken(s.token, auth.PersonalTokenOpts{
+		Name:        "ci",
+		Permissions: []auth.TokenPermission{{Name: "user.read.tokens", ContextType: "user", ContextValue: s.user.Email}},
+	})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/users/tokens", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.Token)
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var tokens []auth.PersonalToken
+	err = json.Unmarshal(recorder.Body.Bytes(), &tokens)
+	c.Assert(err, check.IsNil)
+	c.Assert(tokens, check.HasLen, 1)
+	c.Assert(tokens[0].Name, check.Equals, "ci")
+	c.Assert(tokens[0].Token, check.Equals, "")
+	request, err = http.NewRequest("POST", "/teams", strings.NewReader("name=newteam"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+token.Token)
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *AuthSuite) TestListPersonalTokensEmpty(c *check.C) {
+	request, err := http.NewRequest("GET", "/users/tokens", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+}
+
+func (s *AuthSuite) TestRevokePersonalToken(c *check.C) {
+	token, err := auth.CreatePersonalToken(s.token, auth.PersonalTokenOpts{Name: "ci"})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/users/tokens/ci", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	_, err = auth.PersonalTokenAuth("bearer " + token.Token)
+	c.Assert(err, check.Equals, auth.ErrInvalidToken)
+	c.Assert(eventtest.EventDesc{
+		Target: userTarget(s.user.Email),
+		Owner:  s.token.GetUserName(),
+		Kind:   "user.update.token",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":name", "value": "ci"},
+		},
+	}, eventtest.HasEvent)
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *AuthSuite) TestPersonalTokenCantManageAPIToken(c *check.C) {
+	token, err := auth.CreatePersonalToken(s.token, auth.PersonalTokenOpts{
+		Name:        "ci",
+		Permissions: []auth.TokenPermission{{Name: "user.update.token", ContextType: "user", ContextValue: s.user.Email}},
+	})
+	c.Assert(err, check.IsNil)
+	m := RunServer(true)
+	for _, method := range []string{"GET", "POST"} {
+		request, err := http.NewRequest(method, "/users/api-key", nil)
+		c.Assert(err, check.IsNil)
+		request.Header.Set("Authorization", "bearer "+token.Token)
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, request)
+		c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	}
+	user, err := auth.GetUserByEmail(s.user.Email)
+	c.Assert(err, check.IsNil)
+	c.Assert(user.APIKey, check.Equals, s.user.APIKey)
+}
@@ -6,6 +6,7 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -151,3 +152,117 @@ func changeAppQuota(w http.ResponseWriter, r *http.Request, t auth.Token) (err e
 	}
 	return app.ChangeQuota(&a, limit)
 }
+
+type teamQuota struct {
+	Limits auth.TeamQuota `json:"limits"`
+	InUse  auth.TeamQuota `json:"inuse"`
+}
+
+func getTeamForQuota(name string) (*auth.Team, error) {
+	team, err := auth.GetTeam(name)
+	if err == auth.ErrTeamNotFound {
+		return nil, &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return team, err
+}
+
+// title: team quota
+// path: /teams/{name}/quota
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   401: Unauthorized
+//   404: Team not found
+func getTeamQuota(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	name := r.URL.Query().Get(":name")
+	allowed := permission.Check(t, permission.PermTeamReadQuota, permission.Context(permission.CtxTeam, name))
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	team, err := getTeamForQuota(name)
+	if err != nil {
+		return err
+	}
+	usage, err := team.QuotaUsage()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(teamQuota{Limits: team.QuotaLimits(), InUse: usage})
+}
+
+// title: update team quota
+// path: /teams/{name}/quota
+// method: PUT
+// consume: application/x-www-form-urlencoded
+// responses:
+//   200: Quota updated
+//   400: Invalid data
+//   401: Unauthorized
+//   404: Team not found
+func changeTeamQuota(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	name := r.URL.Query().Get(":name")
+	allowed := permission.Check(t, permission.PermTeamUpdateQuota, permission.Context(permission.CtxTeam, name))
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	team, err := getTeamForQuota(name)
+	if err != nil {
+		return err
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     teamTarget(name),
+		Kind:       permission.PermTeamUpdateQuota,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermTeamReadEvents, permission.Context(permission.CtxTeam, name)),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	limits := team.QuotaLimits()
+	changed := false
+	parseLimit := func(name string, current int64) (int64, error) {
+		raw := r.FormValue(name)
+		if raw == "" {
+			return current, nil
+		}
+		changed = true
+		limit, parseErr := strconv.ParseInt(raw, 10, 64)
+		if parseErr != nil {
+			return 0, &errors.HTTP{Code: http.StatusBadRequest, Message: fmt.Sprintf("Invalid %s limit", name)}
+		}
+		return limit, nil
+	}
+	apps, err := parseLimit("apps", int64(limits.Apps))
+	if err != nil {
+		return err
+	}
+	units, err := parseLimit("units", int64(limits.Units))
+	if err != nil {
+		return err
+	}
+	limits.Memory, err = parseLimit("memory", limits.Memory)
+	if err != nil {
+		return err
+	}
+	instances, err := parseLimit("serviceInstances", int64(limits.ServiceInstances))
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return &errors.HTTP{
+			Code:    http.StatusBadRequest,
+			Message: "You must define at least one of the apps, units, memory or serviceInstances limits.",
+		}
+	}
+	limits.Apps, limits.Units, limits.ServiceInstances = int(apps), int(units), int(instances)
+	err = auth.ChangeTeamQuota(team, limits)
+	if err == auth.ErrTeamQuotaLesserThanUsage {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return err
+}
@@ -53,6 +53,12 @@ func (s *QuotaSuite) SetUpTest(c *check.C) {
 	}, permission.Permission{
 		Scheme:  permission.PermUserUpdateQuota,
 		Context: permission.Context(permission.CtxGlobal, ""),
+	}, permission.Permission{
+		Scheme:  permission.PermTeamReadQuota,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	}, permission.Permission{
+		Scheme:  permission.PermTeamUpdateQuota,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
 	})
 	s.user, err = s.token.User()
 	c.Assert(err, check.IsNil)
@@ -394,3 +400,112 @@ func (s *QuotaSuite) TestChangeAppQuotaAppNotFound(c *check.C) {
 	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
 	c.Assert(recorder.Body.String(), check.Equals, app.ErrAppNotFound.Error()+"\n")
 }
+
+func (s *QuotaSuite) TestGetTeamQuota(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Apps().Insert(app.App{
+		Name:      "shangrila",
+		TeamOwner: s.team.Name,
+		Plan:      app.Plan{Memory: 1024},
+		Quota:     quota.Quota{Limit: -1, InUse: 3},
+	})
+	c.Assert(err, check.IsNil)
+	err = auth.ChangeTeamQuota(s.team, auth.TeamQuota{Apps: 5, Units: 10, Memory: -1, ServiceInstances: 2})
+	c.Assert(err, check.IsNil)
+	request, _ := http.NewRequest("GET", "/1.4/teams/superteam/quota", nil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	handler := RunServer(true)
+	handler.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var result teamQuota
+	err = json.NewDecoder(recorder.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, teamQuota{
+		Limits: auth.TeamQuota{Apps: 5, Units: 10, Memory: -1, ServiceInstances: 2},
+		InUse:  auth.TeamQuota{Apps: 1, Units: 3, Memory: 3072},
+	})
+}
+
+func (s *QuotaSuite) TestGetTeamQuotaTeamNotFound(c *check.C) {
+	token := customUserWithPermission(c, "teamquotauser", permission.Permission{
+		Scheme:  permission.PermTeamReadQuota,
+		Context: permission.Context(permission.CtxGlobal, ""),
+	})
+	request, _ := http.NewRequest("GET", "/1.4/teams/unknown/quota", nil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	handler := RunServer(true)
+	handler.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *QuotaSuite) TestChangeTeamQuota(c *check.C) {
+	body := bytes.NewBufferString("apps=5&memory=2048")
+	request, _ := http.NewRequest("PUT", "/1.4/teams/superteam/quota", body)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	handler := RunServer(true)
+	handler.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	team, err := auth.GetTeam(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(team.Quota, check.DeepEquals, &auth.TeamQuota{Apps: 5, Units: -1, Memory: 2048, ServiceInstances: -1})
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeTeam, Value: s.team.Name},
+		Owner:  s.token.GetUserName(),
+		Kind:   "team.update.quota",
+		StartCustomData: []map[string]interface{}{
+			{"name": "apps", "value": "5"},
+			{"name": "memory", "value": "2048"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *QuotaSuite) TestChangeTeamQuotaInvalid(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Apps().Insert(app.App{Name: "shangrila", TeamOwner: s.team.Name})
+	c.Assert(err, check.IsNil)
+	tests := []struct {
+		body string
+		msg  string
+	}{
+		{"", "You must define at least one of the apps, units, memory or serviceInstances limits.\n"},
+		{"units=many", "Invalid units limit\n"},
+		{"apps=0", "new limit is lesser than the current allocated value\n"},
+	}
+	for _, t := range tests {
+		request, _ := http.NewRequest("PUT", "/1.4/teams/superteam/quota", bytes.NewBufferString(t.body))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+		recorder := httptest.NewRecorder()
+		handler := RunServer(true)
+		handler.ServeHTTP(recorder, request)
+		c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+		c.Assert(recorder.Body.String(), check.Equals, t.msg)
+	}
+}
+
+func (s *QuotaSuite) TestChangeTeamQuotaRequiresPermission(c *check.C) {
+	token := customUserWithPermission(c, "teamquotauser", permission.Permission{
+		Scheme:  permission.PermTeamReadQuota,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	body := bytes.NewBufferString("apps=5")
+	request, _ := http.NewRequest("PUT", "/1.4/teams/superteam/quota", body)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	handler := RunServer(true)
+	handler.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	team, err := auth.GetTeam(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(team.Quota, check.IsNil)
+}
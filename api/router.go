@@ -8,7 +8,10 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/tsuru/tsuru/app/routercheck"
 	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
 	"github.com/tsuru/tsuru/permission"
 	"github.com/tsuru/tsuru/router"
 )
@@ -36,3 +39,96 @@ func listRouters(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	w.Header().Set("Content-Type", "application/json")
 	return json.NewEncoder(w).Encode(routers)
 }
+
+// title: router check
+// path: /routers/check
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   401: Unauthorized
+//   404: Router not found
+func checkRouters(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	allowed := permission.Check(t, permission.PermRouterReadCheck)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	r.ParseForm()
+	reports, err := routercheck.Check(r.Form["router"], false)
+	if err != nil {
+		if _, ok := err.(*router.ErrRouterNotFound); ok {
+			return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+		}
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(reports)
+}
+
+// title: router repair
+// path: /routers/check
+// method: POST
+// consume: application/x-www-form-urlencoded
+// produce: application/json
+// responses:
+//   200: OK
+//   401: Unauthorized
+//   404: Router not found
+func repairRouters(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	allowed := permission.Check(t, permission.PermRouterUpdateRepair)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	err = r.ParseForm()
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	plans, err := router.List()
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(plans))
+	for _, p := range plans {
+		known[p.Name] = true
+	}
+	names := r.Form["router"]
+	for _, name := range names {
+		if !known[name] {
+			return &errors.HTTP{Code: http.StatusNotFound, Message: (&router.ErrRouterNotFound{Name: name}).Error()}
+		}
+	}
+	if len(names) == 0 {
+		for _, p := range plans {
+			names = append(names, p.Name)
+		}
+	}
+	reports := make([]routercheck.Report, 0, len(names))
+	for _, name := range names {
+		report, err := repairRouter(name, r, t)
+		if err != nil {
+			return err
+		}
+		reports = append(reports, *report)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(reports)
+}
+
+func repairRouter(name string, r *http.Request, t auth.Token) (report *routercheck.Report, err error) {
+	evt, err := event.New(&event.Opts{
+		Target:     event.Target{Type: event.TargetTypeRouter, Value: name},
+		Kind:       permission.PermRouterUpdateRepair,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermRouterReadEvents),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { evt.DoneCustomData(err, report) }()
+	reports, err := routercheck.Check([]string{name}, true)
+	if err != nil {
+		return nil, err
+	}
+	return &reports[0], nil
+}
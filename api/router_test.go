@@ -8,9 +8,15 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 
 	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app/routercheck"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
 	"github.com/tsuru/tsuru/router"
+	"github.com/tsuru/tsuru/router/routertest"
 	check "gopkg.in/check.v1"
 )
 
@@ -66,3 +72,104 @@ func (s *S) TestRoutersListNoAppCreatePermission(c *check.C) {
 	m.ServeHTTP(recorder, request)
 	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
 }
+
+func (s *S) TestRoutersCheck(c *check.C) {
+	err := routertest.FakeRouter.AddBackend("removed-app")
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/routers/check?router=fake", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var reports []routercheck.Report
+	err = json.Unmarshal(recorder.Body.Bytes(), &reports)
+	c.Assert(err, check.IsNil)
+	c.Assert(reports, check.DeepEquals, []routercheck.Report{
+		{Router: "fake", Issues: []routercheck.Issue{
+			{Backend: "removed-app", Kind: routercheck.KindOrphanBackend},
+		}},
+	})
+	c.Assert(routertest.FakeRouter.HasBackend("removed-app"), check.Equals, true)
+}
+
+func (s *S) TestRoutersCheckRouterNotFound(c *check.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/routers/check?router=unknown", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestRoutersCheckNoPermission(c *check.C) {
+	token := userWithPermission(c)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/routers/check", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestRoutersRepair(c *check.C) {
+	err := routertest.FakeRouter.AddBackend("removed-app")
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	body := strings.NewReader("router=fake")
+	request, err := http.NewRequest("POST", "/routers/check", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var reports []routercheck.Report
+	err = json.Unmarshal(recorder.Body.Bytes(), &reports)
+	c.Assert(err, check.IsNil)
+	c.Assert(reports, check.DeepEquals, []routercheck.Report{
+		{Router: "fake", Issues: []routercheck.Issue{
+			{Backend: "removed-app", Kind: routercheck.KindOrphanBackend, Fixed: true},
+		}},
+	})
+	c.Assert(routertest.FakeRouter.HasBackend("removed-app"), check.Equals, false)
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeRouter, Value: "fake"},
+		Owner:  s.token.GetUserName(),
+		Kind:   "router.update.repair",
+		StartCustomData: []map[string]interface{}{
+			{"name": "router", "value": "fake"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestRoutersRepairRouterNotFound(c *check.C) {
+	recorder := httptest.NewRecorder()
+	body := strings.NewReader("router=unknown")
+	request, err := http.NewRequest("POST", "/routers/check", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+	c.Assert(eventtest.EventDesc{IsEmpty: true}, eventtest.HasEvent)
+}
+
+func (s *S) TestRoutersRepairNoPermission(c *check.C) {
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermRouterReadCheck,
+		Context: permission.Context(permission.CtxGlobal, ""),
+	})
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/routers/check", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
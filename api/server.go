@@ -20,12 +20,15 @@ import (
 	apiRouter "github.com/tsuru/tsuru/api/router"
 	"github.com/tsuru/tsuru/api/shutdown"
 	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/app/acme"
+	"github.com/tsuru/tsuru/app/routercheck"
 	"github.com/tsuru/tsuru/auth"
 	_ "github.com/tsuru/tsuru/auth/native"
 	_ "github.com/tsuru/tsuru/auth/oauth"
 	_ "github.com/tsuru/tsuru/auth/saml"
 	"github.com/tsuru/tsuru/autoscale"
 	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/event"
 	"github.com/tsuru/tsuru/hc"
 	"github.com/tsuru/tsuru/healer"
 	"github.com/tsuru/tsuru/log"
@@ -123,11 +126,15 @@ func RunServer(dry bool) http.Handler {
 
 	m.Add("1.0", "Delete", "/apps/{app}", AuthorizationRequiredHandler(appDelete))
 	m.Add("1.0", "Get", "/apps/{app}", AuthorizationRequiredHandler(appInfo))
+	m.Add("1.4", "Get", "/apps/{app}/manifest", AuthorizationRequiredHandler(exportAppManifest))
+	m.Add("1.4", "Post", "/apps/manifest", AuthorizationRequiredHandler(applyAppManifest))
 	m.Add("1.0", "Post", "/apps/{app}/cname", AuthorizationRequiredHandler(setCName))
 	m.Add("1.0", "Delete", "/apps/{app}/cname", AuthorizationRequiredHandler(unsetCName))
 	runHandler := AuthorizationRequiredHandler(runCommand)
 	m.Add("1.0", "Post", "/apps/{app}/run", runHandler)
 	m.Add("1.0", "Post", "/apps/{app}/restart", AuthorizationRequiredHandler(restart))
+	migratePoolHandler := AuthorizationRequiredHandler(appMigratePool)
+	m.Add("1.4", "Post", "/apps/{app}/migrate", migratePoolHandler)
 	m.Add("1.0", "Post", "/apps/{app}/start", AuthorizationRequiredHandler(start))
 	m.Add("1.0", "Post", "/apps/{app}/stop", AuthorizationRequiredHandler(stop))
 	m.Add("1.0", "Post", "/apps/{app}/sleep", AuthorizationRequiredHandler(sleep))
@@ -143,6 +150,9 @@ func RunServer(dry bool) http.Handler {
 	m.Add("1.0", "Delete", "/apps/{app}/lock", forceDeleteLockHandler)
 	m.Add("1.0", "Put", "/apps/{app}/units", AuthorizationRequiredHandler(addUnits))
 	m.Add("1.0", "Delete", "/apps/{app}/units", AuthorizationRequiredHandler(removeUnits))
+	m.Add("1.4", "Get", "/apps/{app}/autoscale", AuthorizationRequiredHandler(appAutoScaleListRules))
+	m.Add("1.4", "Post", "/apps/{app}/autoscale", AuthorizationRequiredHandler(appAutoScaleSetRule))
+	m.Add("1.4", "Delete", "/apps/{app}/autoscale/{process}", AuthorizationRequiredHandler(appAutoScaleDeleteRule))
 	registerUnitHandler := AuthorizationRequiredHandler(registerUnit)
 	m.Add("1.0", "Post", "/apps/{app}/units/register", registerUnitHandler)
 	setUnitStatusHandler := AuthorizationRequiredHandler(setUnitStatus)
@@ -159,6 +169,10 @@ func RunServer(dry bool) http.Handler {
 	m.Add("1.2", "Get", "/apps/{app}/certificate", AuthorizationRequiredHandler(listCertificates))
 	m.Add("1.2", "Put", "/apps/{app}/certificate", AuthorizationRequiredHandler(setCertificate))
 	m.Add("1.2", "Delete", "/apps/{app}/certificate", AuthorizationRequiredHandler(unsetCertificate))
+	m.Add("1.4", "Get", "/apps/{app}/log-drains", AuthorizationRequiredHandler(appLogDrainList))
+	m.Add("1.4", "Post", "/apps/{app}/log-drains", AuthorizationRequiredHandler(appLogDrainAdd))
+	m.Add("1.4", "Delete", "/apps/{app}/log-drains/{drain}", AuthorizationRequiredHandler(appLogDrainRemove))
+	m.Add("1.4", "Get", "/.well-known/acme-challenge/{token}", Handler(acmeChallenge))
 
 	m.Add("1.0", "Post", "/node/status", AuthorizationRequiredHandler(setNodeStatus))
 
@@ -167,6 +181,13 @@ func RunServer(dry bool) http.Handler {
 
 	m.Add("1.1", "Get", "/events", AuthorizationRequiredHandler(eventList))
 	m.Add("1.1", "Get", "/events/kinds", AuthorizationRequiredHandler(kindList))
+	m.Add("1.4", "Get", "/events/stream", AuthorizationRequiredHandler(eventStream))
+	m.Add("1.4", "GET", "/events/webhooks", AuthorizationRequiredHandler(webhookList))
+	m.Add("1.4", "POST", "/events/webhooks", AuthorizationRequiredHandler(webhookCreate))
+	m.Add("1.4", "GET", "/events/webhooks/{name}", AuthorizationRequiredHandler(webhookInfo))
+	m.Add("1.4", "PUT", "/events/webhooks/{name}", AuthorizationRequiredHandler(webhookUpdate))
+	m.Add("1.4", "DELETE", "/events/webhooks/{name}", AuthorizationRequiredHandler(webhookDelete))
+	m.Add("1.4", "GET", "/events/webhooks/{name}/deliveries", AuthorizationRequiredHandler(webhookDeliveries))
 	m.Add("1.1", "Get", "/events/{uuid}", AuthorizationRequiredHandler(eventInfo))
 	m.Add("1.1", "Post", "/events/{uuid}/cancel", AuthorizationRequiredHandler(eventCancel))
 
@@ -201,7 +222,15 @@ func RunServer(dry bool) http.Handler {
 	m.Add("1.0", "Get", "/users/{email}/quota", AuthorizationRequiredHandler(getUserQuota))
 	m.Add("1.0", "Put", "/users/{email}/quota", AuthorizationRequiredHandler(changeUserQuota))
 	m.Add("1.0", "Delete", "/users/tokens", AuthorizationRequiredHandler(logout))
+	m.Add("1.4", "Get", "/users/tokens", AuthorizationRequiredHandler(listPersonalTokens))
+	m.Add("1.4", "Post", "/users/tokens", AuthorizationRequiredHandler(createPersonalToken))
+	m.Add("1.4", "Delete", "/users/tokens/{name}", AuthorizationRequiredHandler(revokePersonalToken))
 	m.Add("1.0", "Put", "/users/password", AuthorizationRequiredHandler(changePassword))
+	m.Add("1.4", "Get", "/users/two-factor", AuthorizationRequiredHandler(twoFactorStatus))
+	m.Add("1.4", "Post", "/users/two-factor", AuthorizationRequiredHandler(startTwoFactorEnrollment))
+	m.Add("1.4", "Delete", "/users/two-factor", AuthorizationRequiredHandler(disableTwoFactor))
+	m.Add("1.4", "Post", "/users/two-factor/confirm", AuthorizationRequiredHandler(confirmTwoFactorEnrollment))
+	m.Add("1.4", "Post", "/users/two-factor/recovery-codes", AuthorizationRequiredHandler(regenerateRecoveryCodes))
 	m.Add("1.0", "Delete", "/users", AuthorizationRequiredHandler(removeUser))
 	m.Add("1.0", "Get", "/users/keys", AuthorizationRequiredHandler(listKeys))
 	m.Add("1.0", "Post", "/users/keys", AuthorizationRequiredHandler(addKeyToUser))
@@ -214,6 +243,8 @@ func RunServer(dry bool) http.Handler {
 	m.Add("1.0", "Get", "/teams", AuthorizationRequiredHandler(teamList))
 	m.Add("1.0", "Post", "/teams", AuthorizationRequiredHandler(createTeam))
 	m.Add("1.0", "Delete", "/teams/{name}", AuthorizationRequiredHandler(removeTeam))
+	m.Add("1.4", "Get", "/teams/{name}/quota", AuthorizationRequiredHandler(getTeamQuota))
+	m.Add("1.4", "Put", "/teams/{name}/quota", AuthorizationRequiredHandler(changeTeamQuota))
 
 	m.Add("1.0", "Post", "/swap", AuthorizationRequiredHandler(swap))
 
@@ -237,6 +268,9 @@ func RunServer(dry bool) http.Handler {
 	m.Add("1.0", "Put", "/pools/{name}", AuthorizationRequiredHandler(poolUpdateHandler))
 	m.Add("1.0", "Post", "/pools/{name}/team", AuthorizationRequiredHandler(addTeamToPoolHandler))
 	m.Add("1.0", "Delete", "/pools/{name}/team", AuthorizationRequiredHandler(removeTeamToPoolHandler))
+	m.Add("1.4", "Get", "/pools/{name}/log-drains", AuthorizationRequiredHandler(poolLogDrainList))
+	m.Add("1.4", "Post", "/pools/{name}/log-drains", AuthorizationRequiredHandler(poolLogDrainAdd))
+	m.Add("1.4", "Delete", "/pools/{name}/log-drains/{drain}", AuthorizationRequiredHandler(poolLogDrainRemove))
 
 	m.Add("1.3", "Get", "/constraints", AuthorizationRequiredHandler(poolConstraintList))
 	m.Add("1.3", "Put", "/constraints", AuthorizationRequiredHandler(poolConstraintSet))
@@ -272,6 +306,9 @@ func RunServer(dry bool) http.Handler {
 	m.Add("1.3", "POST", "/node/autoscale/rules", AuthorizationRequiredHandler(autoScaleSetRule))
 	m.Add("1.3", "DELETE", "/node/autoscale/rules", AuthorizationRequiredHandler(autoScaleDeleteRule))
 	m.Add("1.3", "DELETE", "/node/autoscale/rules/{id}", AuthorizationRequiredHandler(autoScaleDeleteRule))
+	m.Add("1.4", "GET", "/node/autoscale/schedules", AuthorizationRequiredHandler(autoScaleListSchedules))
+	m.Add("1.4", "POST", "/node/autoscale/schedules", AuthorizationRequiredHandler(autoScaleSetSchedule))
+	m.Add("1.4", "DELETE", "/node/autoscale/schedules/{name}", AuthorizationRequiredHandler(autoScaleDeleteSchedule))
 
 	m.Add("1.2", "GET", "/node", AuthorizationRequiredHandler(listNodesHandler))
 	m.Add("1.2", "GET", "/node/apps/{appname}/containers", AuthorizationRequiredHandler(listUnitsByApp))
@@ -297,6 +334,8 @@ func RunServer(dry bool) http.Handler {
 	m.Add("1.2", "DELETE", "/healing/node", AuthorizationRequiredHandler(nodeHealingDelete))
 
 	m.Add("1.3", "Get", "/routers", AuthorizationRequiredHandler(listRouters))
+	m.Add("1.4", "Get", "/routers/check", AuthorizationRequiredHandler(checkRouters))
+	m.Add("1.4", "Post", "/routers/check", AuthorizationRequiredHandler(repairRouters))
 	m.Add("1.2", "GET", "/metrics", promhttp.Handler())
 
 	// Handlers for compatibility reasons, should be removed on tsuru 2.0.
@@ -348,6 +387,7 @@ func RunServer(dry bool) http.Handler {
 		registerUnitHandler,
 		setUnitStatusHandler,
 		diffDeployHandler,
+		migratePoolHandler,
 	}})
 	n.UseHandler(http.HandlerFunc(runDelayedHandler))
 
@@ -472,6 +512,22 @@ func startServer(handler http.Handler) {
 	if err != nil {
 		fatal(err)
 	}
+	err = autoscale.InitializeUnits()
+	if err != nil {
+		fatal(err)
+	}
+	err = event.InitializePruner()
+	if err != nil {
+		fatal(err)
+	}
+	err = acme.Initialize()
+	if err != nil {
+		fatal(err)
+	}
+	err = routercheck.Initialize()
+	if err != nil {
+		fatal(err)
+	}
 	fmt.Println("Checking components status:")
 	results := hc.Check()
 	for _, result := range results {
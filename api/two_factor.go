@@ -0,0 +1,180 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+)
+
+func twoFactorScheme() (auth.TwoFactorScheme, error) {
+	scheme, ok := app.AuthScheme.(auth.TwoFactorScheme)
+	if !ok {
+		return nil, &errors.HTTP{Code: http.StatusBadRequest, Message: nonManagedSchemeMsg}
+	}
+	return scheme, nil
+}
+
+func twoFactorEvent(t auth.Token) (*event.Event, error) {
+	return event.New(&event.Opts{
+		Target:  userTarget(t.GetUserName()),
+		Kind:    permission.PermUserUpdateTwoFactor,
+		Owner:   t,
+		Allowed: event.Allowed(permission.PermUserReadEvents, permission.Context(permission.CtxUser, t.GetUserName())),
+	})
+}
+
+// title: two-factor status
+// path: /users/two-factor
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   400: Not supported by the auth scheme
+//   401: Unauthorized
+func twoFactorStatus(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	scheme, err := twoFactorScheme()
+	if err != nil {
+		return err
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	status, err := scheme.TwoFactorStatus(u)
+	if err != nil {
+		return handleAuthError(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(status)
+}
+
+// title: two-factor enroll
+// path: /users/two-factor
+// method: POST
+// produce: application/json
+// responses:
+//   200: OK
+//   400: Not supported by the auth scheme
+//   401: Unauthorized
+//   409: Already enabled
+func startTwoFactorEnrollment(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	scheme, err := twoFactorScheme()
+	if err != nil {
+		return err
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	evt, err := twoFactorEvent(t)
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	enrollment, err := scheme.StartTwoFactorEnrollment(u)
+	if err != nil {
+		return handleAuthError(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(enrollment)
+}
+
+// title: two-factor confirm
+// path: /users/two-factor/confirm
+// method: POST
+// consume: application/x-www-form-urlencoded
+// produce: application/json
+// responses:
+//   200: OK
+//   400: Invalid data
+//   401: Unauthorized
+//   409: Already enabled
+func confirmTwoFactorEnrollment(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	scheme, err := twoFactorScheme()
+	if err != nil {
+		return err
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	evt, err := twoFactorEvent(t)
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	codes, err := scheme.ConfirmTwoFactorEnrollment(u, r.FormValue("otp"))
+	if err != nil {
+		return handleAuthError(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// title: two-factor disable
+// path: /users/two-factor
+// method: DELETE
+// responses:
+//   200: OK
+//   400: Invalid data
+//   401: Unauthorized
+//   403: Required by role
+func disableTwoFactor(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	scheme, err := twoFactorScheme()
+	if err != nil {
+		return err
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	evt, err := twoFactorEvent(t)
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = scheme.DisableTwoFactor(u, r.URL.Query().Get("otp"))
+	if err != nil {
+		return handleAuthError(err)
+	}
+	return nil
+}
+
+// title: regenerate recovery codes
+// path: /users/two-factor/recovery-codes
+// method: POST
+// produce: application/json
+// responses:
+//   200: OK
+//   400: Not enabled
+//   401: Unauthorized
+func regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	scheme, err := twoFactorScheme()
+	if err != nil {
+		return err
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	evt, err := twoFactorEvent(t)
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	codes, err := scheme.RegenerateRecoveryCodes(u)
+	if err != nil {
+		return handleAuthError(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
@@ -0,0 +1,161 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"gopkg.in/check.v1"
+)
+
+func totpCode(c *check.C, secret string) string {
+	key, err := base32.StdEncoding.DecodeString(secret)
+	c.Assert(err, check.IsNil)
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+func (s *AuthSuite) twoFactorRequest(c *check.C, method, url, body string, token auth.Token) *httptest.ResponseRecorder {
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if token != nil {
+		request.Header.Set("Authorization", "bearer "+token.GetValue())
+	}
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func (s *AuthSuite) enrollTwoFactor(c *check.C, token auth.Token) (string, []string) {
+	recorder := s.twoFactorRequest(c, "POST", "/users/two-factor", "", token)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var enrollment auth.TwoFactorEnrollment
+	err := json.Unmarshal(recorder.Body.Bytes(), &enrollment)
+	c.Assert(err, check.IsNil)
+	c.Assert(enrollment.URI, check.Matches, "^otpauth://totp/.*")
+	recorder = s.twoFactorRequest(c, "POST", "/users/two-factor/confirm", "otp="+totpCode(c, enrollment.Secret), token)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var result map[string][]string
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	return enrollment.Secret, result["recovery_codes"]
+}
+
+func (s *AuthSuite) TestTwoFactorEnrollment(c *check.C) {
+	_, codes := s.enrollTwoFactor(c, s.token)
+	c.Assert(codes, check.HasLen, 10)
+	c.Assert(eventtest.EventDesc{
+		Target: userTarget(s.user.Email),
+		Owner:  s.token.GetUserName(),
+		Kind:   "user.update.two-factor",
+	}, eventtest.HasEvent)
+	recorder := s.twoFactorRequest(c, "GET", "/users/two-factor", "", s.token)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var status auth.TwoFactorStatus
+	err := json.Unmarshal(recorder.Body.Bytes(), &status)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.DeepEquals, auth.TwoFactorStatus{Enabled: true, RecoveryCodes: 10})
+	recorder = s.twoFactorRequest(c, "POST", "/users/two-factor", "", s.token)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+}
+
+func (s *AuthSuite) TestTwoFactorConfirmInvalidCode(c *check.C) {
+	recorder := s.twoFactorRequest(c, "POST", "/users/two-factor", "", s.token)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	recorder = s.twoFactorRequest(c, "POST", "/users/two-factor/confirm", "otp=000000x", s.token)
+	c.Assert(recorder.Code, check.Equals, http.StatusUnauthorized)
+	recorder = s.twoFactorRequest(c, "POST", "/users/two-factor/confirm", "", s.token)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *AuthSuite) TestLoginTwoFactor(c *check.C) {
+	_, codes := s.enrollTwoFactor(c, s.token)
+	recorder := s.twoFactorRequest(c, "POST", "/auth/login", "email="+s.user.Email+"&password=123456", nil)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var challenge auth.TwoFactorRequired
+	err := json.Unmarshal(recorder.Body.Bytes(), &challenge)
+	c.Assert(err, check.IsNil)
+	c.Assert(challenge.Challenge, check.Not(check.Equals), "")
+	recorder = s.twoFactorRequest(c, "POST", "/auth/login", "challenge="+challenge.Challenge+"&otp="+codes[0], nil)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var result map[string]string
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	token, err := nativeScheme.Auth("bearer " + result["token"])
+	c.Assert(err, check.IsNil)
+	c.Assert(token.GetUserName(), check.Equals, s.user.Email)
+}
+
+func (s *AuthSuite) TestLoginTwoFactorRequiredReturnsRecoveryCodes(c *check.C) {
+	config.Set("auth:two-factor:required-roles", []interface{}{s.user.Roles[0].Name})
+	defer config.Unset("auth:two-factor")
+	recorder := s.twoFactorRequest(c, "POST", "/auth/login", "email="+s.user.Email+"&password=123456", nil)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var challenge auth.TwoFactorRequired
+	err := json.Unmarshal(recorder.Body.Bytes(), &challenge)
+	c.Assert(err, check.IsNil)
+	uri, err := url.Parse(challenge.EnrollURI)
+	c.Assert(err, check.IsNil)
+	otp := totpCode(c, uri.Query().Get("secret"))
+	recorder = s.twoFactorRequest(c, "POST", "/auth/login", "challenge="+challenge.Challenge+"&otp="+otp, nil)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var result struct {
+		Token         string
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Token, check.Not(check.Equals), "")
+	c.Assert(result.RecoveryCodes, check.HasLen, 10)
+}
+
+func (s *AuthSuite) TestLoginTwoFactorInvalidChallenge(c *check.C) {
+	recorder := s.twoFactorRequest(c, "POST", "/auth/login", "challenge=abc&otp=123456", nil)
+	c.Assert(recorder.Code, check.Equals, http.StatusUnauthorized)
+}
+
+func (s *AuthSuite) TestDisableTwoFactor(c *check.C) {
+	_, codes := s.enrollTwoFactor(c, s.token)
+	recorder := s.twoFactorRequest(c, "DELETE", "/users/two-factor?otp=000000x", "", s.token)
+	c.Assert(recorder.Code, check.Equals, http.StatusUnauthorized)
+	recorder = s.twoFactorRequest(c, "DELETE", "/users/two-factor?otp="+codes[0], "", s.token)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	recorder = s.twoFactorRequest(c, "DELETE", "/users/two-factor?otp="+codes[1], "", s.token)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *AuthSuite) TestRegenerateRecoveryCodes(c *check.C) {
+	recorder := s.twoFactorRequest(c, "POST", "/users/two-factor/recovery-codes", "", s.token)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	s.enrollTwoFactor(c, s.token)
+	recorder = s.twoFactorRequest(c, "POST", "/users/two-factor/recovery-codes", "", s.token)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var result map[string][]string
+	err := json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result["recovery_codes"], check.HasLen, 10)
+}
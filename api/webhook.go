@@ -0,0 +1,263 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ajg/form"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+)
+
+func webhookFromForm(r *http.Request) (*event.Webhook, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return nil, &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	var webhook event.Webhook
+	dec := form.NewDecoder(nil)
+	dec.IgnoreUnknownKeys(true)
+	dec.IgnoreCase(true)
+	err = dec.DecodeValues(&webhook, r.Form)
+	if err != nil {
+		return nil, &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return &webhook, nil
+}
+
+// webhookCustomData returns the event custom data for the request form,
+// without the webhook secret.
+func webhookCustomData(values url.Values) []map[string]interface{} {
+	filtered := url.Values{}
+	for k, v := range values {
+		if !strings.EqualFold(k, "secret") {
+			filtered[k] = v
+		}
+	}
+	return event.FormToCustomData(filtered)
+}
+
+func webhookError(err error) error {
+	switch err {
+	case event.ErrWebhookNotFound:
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	case event.ErrWebhookAlreadyExists:
+		return &errors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+	}
+	if _, ok := err.(event.ErrValidation); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return err
+}
+
+// title: webhook list
+// path: /events/webhooks
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   204: No content
+func webhookList(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	teams := []string{}
+	contexts := permission.ContextsForPermission(t, permission.PermWebhookRead)
+	for _, c := range contexts {
+		if c.CtxType == permission.CtxGlobal {
+			teams = nil
+			break
+		}
+		if c.CtxType == permission.CtxTeam {
+			teams = append(teams, c.Value)
+		}
+	}
+	if teams != nil && len(teams) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	webhooks, err := event.ListWebhooks(teams)
+	if err != nil {
+		return err
+	}
+	if len(webhooks) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(webhooks)
+}
+
+// title: webhook info
+// path: /events/webhooks/{name}
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   401: Unauthorized
+//   404: Not found
+func webhookInfo(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	webhook, err := event.GetWebhook(r.URL.Query().Get(":name"))
+	if err != nil {
+		return webhookError(err)
+	}
+	if !permission.Check(t, permission.PermWebhookRead, permission.Context(permission.CtxTeam, webhook.TeamOwner)) {
+		return permission.ErrUnauthorized
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(webhook)
+}
+
+// title: webhook create
+// path: /events/webhooks
+// method: POST
+// consume: application/x-www-form-urlencoded
+// responses:
+//   200: Webhook created
+//   400: Invalid data
+//   401: Unauthorized
+//   409: Webhook already exists
+func webhookCreate(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	webhook, err := webhookFromForm(r)
+	if err != nil {
+		return err
+	}
+	if webhook.TeamOwner == "" {
+		webhook.TeamOwner, err = permission.TeamForPermission(t, permission.PermWebhookCreate)
+		if err != nil {
+			return err
+		}
+	}
+	ctx := permission.Context(permission.CtxTeam, webhook.TeamOwner)
+	if !permission.Check(t, permission.PermWebhookCreate, ctx) {
+		return permission.ErrUnauthorized
+	}
+	webhook.Owner = t.GetUserName()
+	evt, err := event.New(&event.Opts{
+		Target:     event.Target{Type: event.TargetTypeWebhook, Value: webhook.Name},
+		Kind:       permission.PermWebhookCreate,
+		Owner:      t,
+		CustomData: webhookCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermWebhookReadEvents, ctx),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	return webhookError(event.CreateWebhook(webhook))
+}
+
+// title: webhook update
+// path: /events/webhooks/{name}
+// method: PUT
+// consume: application/x-www-form-urlencoded
+// responses:
+//   200: Webhook updated
+//   400: Invalid data
+//   401: Unauthorized
+//   404: Not found
+func webhookUpdate(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	webhook, err := webhookFromForm(r)
+	if err != nil {
+		return err
+	}
+	webhook.Name = r.URL.Query().Get(":name")
+	existing, err := event.GetWebhook(webhook.Name)
+	if err != nil {
+		return webhookError(err)
+	}
+	ctx := permission.Context(permission.CtxTeam, existing.TeamOwner)
+	if !permission.Check(t, permission.PermWebhookUpdate, ctx) {
+		return permission.ErrUnauthorized
+	}
+	if webhook.TeamOwner == "" {
+		webhook.TeamOwner = existing.TeamOwner
+	} else if webhook.TeamOwner != existing.TeamOwner &&
+		!permission.Check(t, permission.PermWebhookUpdate, permission.Context(permission.CtxTeam, webhook.TeamOwner)) {
+		return permission.ErrUnauthorized
+	}
+	if _, ok := r.Form["Secret"]; !ok {
+		webhook.Secret = existing.Secret
+	}
+	webhook.Owner = t.GetUserName()
+	evt, err := event.New(&event.Opts{
+		Target:     event.Target{Type: event.TargetTypeWebhook, Value: webhook.Name},
+		Kind:       permission.PermWebhookUpdate,
+		Owner:      t,
+		CustomData: webhookCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermWebhookReadEvents, ctx),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	return webhookError(event.UpdateWebhook(webhook))
+}
+
+// title: webhook delete
+// path: /events/webhooks/{name}
+// method: DELETE
+// responses:
+//   200: Webhook deleted
+//   401: Unauthorized
+//   404: Not found
+func webhookDelete(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	name := r.URL.Query().Get(":name")
+	webhook, err := event.GetWebhook(name)
+	if err != nil {
+		return webhookError(err)
+	}
+	ctx := permission.Context(permission.CtxTeam, webhook.TeamOwner)
+	if !permission.Check(t, permission.PermWebhookDelete, ctx) {
+		return permission.ErrUnauthorized
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     event.Target{Type: event.TargetTypeWebhook, Value: name},
+		Kind:       permission.PermWebhookDelete,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermWebhookReadEvents, ctx),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	return webhookError(event.DeleteWebhook(name))
+}
+
+// title: webhook deliveries
+// path: /events/webhooks/{name}/deliveries
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   204: No content
+//   401: Unauthorized
+//   404: Not found
+func webhookDeliveries(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	webhook, err := event.GetWebhook(r.URL.Query().Get(":name"))
+	if err != nil {
+		return webhookError(err)
+	}
+	if !permission.Check(t, permission.PermWebhookRead, permission.Context(permission.CtxTeam, webhook.TeamOwner)) {
+		return permission.ErrUnauthorized
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	deliveries, err := event.ListWebhookDeliveries(webhook.Name, limit)
+	if err != nil {
+		return err
+	}
+	if len(deliveries) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(deliveries)
+}
@@ -0,0 +1,281 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/ajg/form"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestWebhookCreate(c *check.C) {
+	webhook := event.Webhook{
+		Name:      "hook1",
+		TeamOwner: s.team.Name,
+		Owner:     "someone@example.com",
+		URL:       "http://example.com/hook",
+		Secret:    "s3cr3t",
+		Filter:    event.WebhookFilter{KindNames: []string{"app.deploy"}, ErrorOnly: true},
+	}
+	v, err := form.EncodeToValues(&webhook)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("POST", "/1.4/events/webhooks", strings.NewReader(v.Encode()))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	dbWebhook, err := event.GetWebhook("hook1")
+	c.Assert(err, check.IsNil)
+	webhook.Owner = s.token.GetUserName()
+	c.Assert(dbWebhook, check.DeepEquals, &webhook)
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeWebhook, Value: "hook1"},
+		Owner:  s.token.GetUserName(),
+		Kind:   "webhook.create",
+		StartCustomData: []map[string]interface{}{
+			{"name": "Name", "value": "hook1"},
+			{"name": "URL", "value": "http://example.com/hook"},
+		},
+	}, eventtest.HasEvent)
+	var evt event.Event
+	err = s.conn.Events().Find(bson.M{"target.value": "hook1"}).One(&evt)
+	c.Assert(err, check.IsNil)
+	var customData []map[string]interface{}
+	err = evt.StartData(&customData)
+	c.Assert(err, check.IsNil)
+	for _, data := range customData {
+		c.Assert(data["name"], check.Not(check.Equals), "Secret")
+	}
+}
+
+func (s *S) TestWebhookCreateDefaultTeamOwner(c *check.C) {
+	token := customUserWithPermission(c, "webhookuser", permission.Permission{
+		Scheme:  permission.PermWebhookCreate,
+		Context: permission.Context(permission.CtxTeam, "myteam"),
+	})
+	body := strings.NewReader("Name=hook1&URL=http://example.com/hook")
+	request, err := http.NewRequest("POST", "/1.4/events/webhooks", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	webhook, err := event.GetWebhook("hook1")
+	c.Assert(err, check.IsNil)
+	c.Assert(webhook.TeamOwner, check.Equals, "myteam")
+}
+
+func (s *S) TestWebhookCreateUnauthorized(c *check.C) {
+	token := customUserWithPermission(c, "webhookuser", permission.Permission{
+		Scheme:  permission.PermWebhookCreate,
+		Context: permission.Context(permission.CtxTeam, "myteam"),
+	})
+	body := strings.NewReader("Name=hook1&URL=http://example.com/hook&TeamOwner=otherteam")
+	request, err := http.NewRequest("POST", "/1.4/events/webhooks", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestWebhookCreateInvalid(c *check.C) {
+	body := strings.NewReader("Name=hook1&URL=ftp://example.com&TeamOwner=" + s.team.Name)
+	request, err := http.NewRequest("POST", "/1.4/events/webhooks", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "webhook url must be a valid http or https url\n")
+}
+
+func (s *S) TestWebhookCreateAlreadyExists(c *check.C) {
+	err := event.CreateWebhook(&event.Webhook{Name: "hook1", TeamOwner: s.team.Name, URL: "http://example.com"})
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("Name=hook1&URL=http://example.com&TeamOwner=" + s.team.Name)
+	request, err := http.NewRequest("POST", "/1.4/events/webhooks", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+}
+
+func (s *S) TestWebhookList(c *check.C) {
+	err := event.CreateWebhook(&event.Webhook{Name: "hook1", TeamOwner: "myteam", URL: "http://example.com", Secret: "abc"})
+	c.Assert(err, check.IsNil)
+	err = event.CreateWebhook(&event.Webhook{Name: "hook2", TeamOwner: "otherteam", URL: "http://example.com"})
+	c.Assert(err, check.IsNil)
+	token := customUserWithPermission(c, "webhookuser", permission.Permission{
+		Scheme:  permission.PermWebhookRead,
+		Context: permission.Context(permission.CtxTeam, "myteam"),
+	})
+	request, err := http.NewRequest("GET", "/1.4/events/webhooks", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	c.Assert(recorder.Body.String(), check.Not(check.Matches), `(?s).*abc.*`)
+	var webhooks []event.Webhook
+	err = json.Unmarshal(recorder.Body.Bytes(), &webhooks)
+	c.Assert(err, check.IsNil)
+	c.Assert(webhooks, check.DeepEquals, []event.Webhook{
+		{Name: "hook1", TeamOwner: "myteam", URL: "http://example.com"},
+	})
+}
+
+func (s *S) TestWebhookListEmpty(c *check.C) {
+	request, err := http.NewRequest("GET", "/1.4/events/webhooks", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+}
+
+func (s *S) TestWebhookInfo(c *check.C) {
+	err := event.CreateWebhook(&event.Webhook{Name: "hook1", TeamOwner: s.team.Name, URL: "http://example.com"})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/1.4/events/webhooks/hook1", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var webhook event.Webhook
+	err = json.Unmarshal(recorder.Body.Bytes(), &webhook)
+	c.Assert(err, check.IsNil)
+	c.Assert(webhook, check.DeepEquals, event.Webhook{Name: "hook1", TeamOwner: s.team.Name, URL: "http://example.com"})
+}
+
+func (s *S) TestWebhookInfoNotFound(c *check.C) {
+	request, err := http.NewRequest("GET", "/1.4/events/webhooks/hook1", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestWebhookUpdate(c *check.C) {
+	err := event.CreateWebhook(&event.Webhook{Name: "hook1", TeamOwner: s.team.Name, URL: "http://example.com", Secret: "abc"})
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("URL=https://example.com/new&Filter.TargetTypes.0=app")
+	request, err := http.NewRequest("PUT", "/1.4/events/webhooks/hook1", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	webhook, err := event.GetWebhook("hook1")
+	c.Assert(err, check.IsNil)
+	c.Assert(webhook, check.DeepEquals, &event.Webhook{
+		Name:      "hook1",
+		TeamOwner: s.team.Name,
+		Owner:     s.token.GetUserName(),
+		URL:       "https://example.com/new",
+		Secret:    "abc",
+		Filter:    event.WebhookFilter{TargetTypes: []string{"app"}},
+	})
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeWebhook, Value: "hook1"},
+		Owner:  s.token.GetUserName(),
+		Kind:   "webhook.update",
+		StartCustomData: []map[string]interface{}{
+			{"name": "URL", "value": "https://example.com/new"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestWebhookUpdateNotFound(c *check.C) {
+	body := strings.NewReader("URL=https://example.com/new")
+	request, err := http.NewRequest("PUT", "/1.4/events/webhooks/hook1", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestWebhookDelete(c *check.C) {
+	err := event.CreateWebhook(&event.Webhook{Name: "hook1", TeamOwner: s.team.Name, URL: "http://example.com"})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/1.4/events/webhooks/hook1", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	_, err = event.GetWebhook("hook1")
+	c.Assert(err, check.Equals, event.ErrWebhookNotFound)
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeWebhook, Value: "hook1"},
+		Owner:  s.token.GetUserName(),
+		Kind:   "webhook.delete",
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestWebhookDeliveries(c *check.C) {
+	err := event.CreateWebhook(&event.Webhook{Name: "hook1", TeamOwner: s.team.Name, URL: "http://example.com"})
+	c.Assert(err, check.IsNil)
+	delivery := event.WebhookDelivery{
+		ID:         bson.NewObjectId(),
+		Webhook:    "hook1",
+		EventID:    bson.NewObjectId(),
+		Phase:      event.WebhookPhaseEnd,
+		URL:        "http://example.com",
+		Time:       time.Now().UTC().Truncate(time.Millisecond),
+		Attempts:   1,
+		StatusCode: http.StatusOK,
+		Successful: true,
+	}
+	err = s.conn.WebhookDeliveries().Insert(delivery)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/1.4/events/webhooks/hook1/deliveries?limit=10", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var deliveries []event.WebhookDelivery
+	err = json.Unmarshal(recorder.Body.Bytes(), &deliveries)
+	c.Assert(err, check.IsNil)
+	c.Assert(deliveries, check.HasLen, 1)
+	c.Assert(deliveries[0].ID, check.Equals, delivery.ID)
+	c.Assert(deliveries[0].Successful, check.Equals, true)
+}
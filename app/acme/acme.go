@@ -0,0 +1,525 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package acme obtains and renews certificates for the cnames of apps using
+// the ACME protocol. HTTP-01 challenges are answered by the tsuru API, with
+// the router of the app forwarding challenge requests in the cname to it.
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/api/shutdown"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/lock"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/router"
+	"golang.org/x/crypto/acme"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	StatusPending = "pending"
+	StatusIssued  = "issued"
+	StatusFailed  = "failed"
+
+	issueTimeout       = 5 * time.Minute
+	defaultRenewBefore = 30 * 24 * time.Hour
+	defaultRunInterval = time.Hour
+	failureBackoff     = time.Hour
+	maxFailureBackoff  = 24 * time.Hour
+	lockName           = "acme"
+)
+
+var (
+	ErrChallengeNotFound    = errors.New("acme challenge not found")
+	ErrRouterNotSupported   = errors.New("app router does not support acme certificates")
+	errNoHTTP01Challenge    = errors.New("certificate authority did not offer an http-01 challenge")
+	errManualCertificateSet = errors.New("cname has a certificate not managed by acme")
+)
+
+// Certificate holds the state of the certificate obtained through ACME for
+// a cname of an app. Failures counts the consecutive failed attempts, which
+// are only retried after RetryAt.
+type Certificate struct {
+	CName     string    `json:"cname" bson:"_id"`
+	App       string    `json:"app"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Failures  int       `json:"failures,omitempty"`
+	RetryAt   time.Time `json:"retryAt,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type account struct {
+	DirectoryURL string `bson:"_id"`
+	Key          []byte
+	URI          string
+}
+
+type challenge struct {
+	Token    string `bson:"_id"`
+	CName    string
+	Response string
+}
+
+// Manager periodically obtains certificates for the cnames of apps whose
+// router supports ACME challenges, renewing them before they expire. Cnames
+// with certificates added manually are left untouched. Only the tsuru API
+// instance holding the acme lock runs the task.
+type Manager struct {
+	DirectoryURL     string
+	Email            string
+	ChallengeAddress *url.URL
+	RenewBefore      time.Duration
+	RunInterval      time.Duration
+	HTTPClient       *http.Client
+	Enabled          bool
+	done             chan bool
+}
+
+// Initialize starts the ACME certificate manager, if enabled in the
+// config file.
+func Initialize() error {
+	m, err := newManager()
+	if err != nil {
+		return err
+	}
+	if !m.Enabled {
+		return nil
+	}
+	shutdown.Register(m)
+	go m.run()
+	return nil
+}
+
+// Enabled returns whether tsuru is configured to obtain certificates using
+// ACME.
+func Enabled() bool {
+	enabled, _ := config.GetBool("acme:enabled")
+	return enabled
+}
+
+func newManager() (*Manager, error) {
+	directoryURL, _ := config.GetString("acme:directory-url")
+	email, _ := config.GetString("acme:email")
+	renewBefore, _ := config.GetInt("acme:renew-before")
+	runInterval, _ := config.GetInt("acme:run-interval")
+	m := &Manager{
+		DirectoryURL: directoryURL,
+		Email:        email,
+		RenewBefore:  time.Duration(renewBefore) * 24 * time.Hour,
+		RunInterval:  time.Duration(runInterval) * time.Second,
+		Enabled:      Enabled(),
+		done:         make(chan bool),
+	}
+	if m.DirectoryURL == "" {
+		m.DirectoryURL = acme.LetsEncryptURL
+	}
+	if m.RenewBefore == 0 {
+		m.RenewBefore = defaultRenewBefore
+	}
+	if m.RunInterval == 0 {
+		m.RunInterval = defaultRunInterval
+	}
+	if !m.Enabled {
+		return m, nil
+	}
+	challengeAddress, err := config.GetString("acme:challenge-address")
+	if err != nil {
+		return nil, errors.New("acme:challenge-address is required when acme is enabled")
+	}
+	m.ChallengeAddress, err = url.Parse(challengeAddress)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid acme:challenge-address")
+	}
+	return m, nil
+}
+
+func (m *Manager) run() {
+	lock.RunPeriodic(lockName, m.RunInterval, func() error {
+		return m.runOnce(time.Now().UTC())
+	}, m.done)
+}
+
+func (m *Manager) Shutdown() {
+	if m.Enabled {
+		m.done <- true
+		m.Enabled = false
+	}
+}
+
+func (m *Manager) String() string {
+	return "acme certificate manager"
+}
+
+// runOnce obtains certificates for every cname without one, and renews the
+// certificates expiring in less than RenewBefore.
+func (m *Manager) runOnce(now time.Time) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var apps []app.App
+	err = conn.Apps().Find(bson.M{"cname.0": bson.M{"$exists": true}}).All(&apps)
+	if err != nil {
+		return err
+	}
+	for i := range apps {
+		a := &apps[i]
+		err = m.removeStale(a)
+		if err != nil {
+			log.Errorf("[acme] unable to remove stale certificates for app %q: %s", a.Name, err)
+		}
+		for _, cname := range a.CName {
+			err = m.ensureCertificate(a, cname, now)
+			if err != nil && err != ErrRouterNotSupported && err != errManualCertificateSet {
+				log.Errorf("[acme] unable to obtain certificate for %q in app %q: %s", cname, a.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// removeStale removes the state of certificates for cnames no longer
+// assigned to the app.
+func (m *Manager) removeStale(a *app.App) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.ACMECertificates().RemoveAll(bson.M{"app": a.Name, "_id": bson.M{"$nin": a.CName}})
+	return err
+}
+
+func (m *Manager) ensureCertificate(a *app.App, cname string, now time.Time) error {
+	cert, err := getCertificate(cname)
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	if cert != nil && cert.App == a.Name {
+		if cert.Status == StatusIssued && cert.ExpiresAt.Sub(now) > m.RenewBefore {
+			return nil
+		}
+		if cert.Status == StatusFailed && now.Before(cert.RetryAt) {
+			return nil
+		}
+	}
+	r, err := a.GetRouter()
+	if err != nil {
+		return err
+	}
+	tlsRouter, ok := r.(router.TLSRouter)
+	if !ok {
+		return ErrRouterNotSupported
+	}
+	if _, ok = r.(router.ChallengeRouter); !ok {
+		return ErrRouterNotSupported
+	}
+	if cert == nil {
+		_, err = tlsRouter.GetCertificate(cname)
+		if err == nil {
+			return errManualCertificateSet
+		}
+		if err != router.ErrCertificateNotFound {
+			return err
+		}
+	}
+	return m.Issue(a, cname)
+}
+
+// Issue obtains a new certificate for the given cname of the app, adding it
+// to the app router. The resulting state of the certificate is stored and
+// may be retrieved with Certificates. Failed attempts are retried by the
+// manager with an exponential backoff.
+func (m *Manager) Issue(a *app.App, cname string) error {
+	previous, err := getCertificate(cname)
+	if err != nil && err != mgo.ErrNotFound {
+		return err
+	}
+	cert := Certificate{CName: cname, App: a.Name, Status: StatusPending}
+	if previous != nil && previous.App == a.Name {
+		cert.Failures = previous.Failures
+	}
+	err = saveCertificate(&cert)
+	if err != nil {
+		return err
+	}
+	expiresAt, err := m.issue(a, cname)
+	if err != nil {
+		cert.Status = StatusFailed
+		cert.Error = err.Error()
+		cert.Failures++
+		cert.RetryAt = time.Now().UTC().Add(retryDelay(cert.Failures))
+	} else {
+		cert.Status = StatusIssued
+		cert.Failures = 0
+		cert.ExpiresAt = expiresAt
+	}
+	saveErr := saveCertificate(&cert)
+	if err != nil {
+		return err
+	}
+	return saveErr
+}
+
+// retryDelay returns the time to wait before retrying to obtain a certificate
+// after the given number of consecutive failures.
+func retryDelay(failures int) time.Duration {
+	delay := failureBackoff
+	for i := 1; i < failures && delay < maxFailureBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxFailureBackoff {
+		delay = maxFailureBackoff
+	}
+	return delay
+}
+
+func (m *Manager) issue(a *app.App, cname string) (time.Time, error) {
+	r, err := a.GetRouter()
+	if err != nil {
+		return time.Time{}, err
+	}
+	tlsRouter, ok := r.(router.TLSRouter)
+	if !ok {
+		return time.Time{}, ErrRouterNotSupported
+	}
+	challengeRouter, ok := r.(router.ChallengeRouter)
+	if !ok {
+		return time.Time{}, ErrRouterNotSupported
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), issueTimeout)
+	defer cancel()
+	client, err := m.client(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(cname))
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, authzURL := range order.AuthzURLs {
+		var authz *acme.Authorization
+		authz, err = client.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if authz.Status != acme.StatusValid {
+			err = m.fulfill(ctx, client, challengeRouter, authz, cname)
+			if err != nil {
+				return time.Time{}, err
+			}
+		}
+	}
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return time.Time{}, err
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return time.Time{}, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: cname},
+		DNSNames: []string{cname},
+	}, key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return time.Time{}, err
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return time.Time{}, err
+	}
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	err = tlsRouter.AddCertificate(cname, string(certPEM), string(keyPEM))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return leaf.NotAfter, nil
+}
+
+// fulfill answers the http-01 challenge of the authorization, forwarding
+// challenge requests in the cname to the tsuru API while the certificate
+// authority validates it.
+func (m *Manager) fulfill(ctx context.Context, client *acme.Client, r router.ChallengeRouter, authz *acme.Authorization, cname string) error {
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "http-01" {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return errNoHTTP01Challenge
+	}
+	response, err := client.HTTP01ChallengeResponse(chal.Token)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.ACMEChallenges().Insert(challenge{Token: chal.Token, CName: cname, Response: response})
+	if err != nil {
+		return err
+	}
+	defer conn.ACMEChallenges().RemoveId(chal.Token)
+	err = r.AddChallengeRoute(cname, m.ChallengeAddress)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if routeErr := r.RemoveChallengeRoute(cname); routeErr != nil {
+			log.Errorf("[acme] unable to remove challenge route for %q: %s", cname, routeErr)
+		}
+	}()
+	_, err = client.Accept(ctx, chal)
+	if err != nil {
+		return err
+	}
+	_, err = client.WaitAuthorization(ctx, authz.URI)
+	return err
+}
+
+// client returns an ACME client using the account registered for the
+// directory, registering a new one in the first call.
+func (m *Manager) client(ctx context.Context) (*acme.Client, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	client := &acme.Client{DirectoryURL: m.DirectoryURL, HTTPClient: m.HTTPClient}
+	var acc account
+	err = conn.ACMEAccounts().FindId(m.DirectoryURL).One(&acc)
+	if err == nil {
+		block, _ := pem.Decode(acc.Key)
+		if block == nil {
+			return nil, errors.New("invalid acme account key")
+		}
+		client.Key, err = x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+	if err != mgo.ErrNotFound {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	client.Key = key
+	var contact []string
+	if m.Email != "" {
+		contact = []string{"mailto:" + m.Email}
+	}
+	registered, err := client.Register(ctx, &acme.Account{Contact: contact}, acme.AcceptTOS)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to register acme account")
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	acc = account{
+		DirectoryURL: m.DirectoryURL,
+		Key:          pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		URI:          registered.URI,
+	}
+	err = conn.ACMEAccounts().Insert(acc)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func getCertificate(cname string) (*Certificate, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var cert Certificate
+	err = conn.ACMECertificates().FindId(cname).One(&cert)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+func saveCertificate(cert *Certificate) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	cert.UpdatedAt = time.Now().UTC()
+	_, err = conn.ACMECertificates().UpsertId(cert.CName, cert)
+	return err
+}
+
+// Certificates returns the state of the certificates obtained through ACME
+// for the cnames of the given app.
+func Certificates(appName string) ([]Certificate, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var certs []Certificate
+	err = conn.ACMECertificates().Find(bson.M{"app": appName}).Sort("_id").All(&certs)
+	if err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+// ChallengeResponse returns the response to the pending http-01 challenge
+// with the given token.
+func ChallengeResponse(token string) (string, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	var chal challenge
+	err = conn.ACMEChallenges().FindId(token).One(&chal)
+	if err == mgo.ErrNotFound {
+		return "", ErrChallengeNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return chal.Response, nil
+}
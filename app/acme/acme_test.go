@@ -0,0 +1,227 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acme
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/router/routertest"
+	"golang.org/x/crypto/acme"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) addApp(c *check.C, name, routerName string, cnames ...string) {
+	err := s.conn.Apps().Insert(app.App{Name: name, Router: routerName, CName: cnames})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) validateThroughRouter(c *check.C) {
+	s.ca.Validate = func(domain, token, keyAuth string) bool {
+		addr, ok := routertest.TLSRouter.ChallengeRoute(domain)
+		if !ok || addr != s.manager.ChallengeAddress.String() {
+			return false
+		}
+		response, err := ChallengeResponse(token)
+		return err == nil && response == keyAuth
+	}
+}
+
+func (s *S) TestNewManager(c *check.C) {
+	config.Set("acme:enabled", true)
+	config.Set("acme:directory-url", "https://acme.example.com/directory")
+	config.Set("acme:email", "admin@example.com")
+	config.Set("acme:challenge-address", "http://tsuru.example.com:8080")
+	config.Set("acme:renew-before", 10)
+	defer config.Unset("acme")
+	m, err := newManager()
+	c.Assert(err, check.IsNil)
+	c.Assert(m.Enabled, check.Equals, true)
+	c.Assert(m.DirectoryURL, check.Equals, "https://acme.example.com/directory")
+	c.Assert(m.Email, check.Equals, "admin@example.com")
+	c.Assert(m.ChallengeAddress.String(), check.Equals, "http://tsuru.example.com:8080")
+	c.Assert(m.RenewBefore, check.Equals, 10*24*time.Hour)
+	c.Assert(m.RunInterval, check.Equals, time.Hour)
+}
+
+func (s *S) TestNewManagerDefaults(c *check.C) {
+	m, err := newManager()
+	c.Assert(err, check.IsNil)
+	c.Assert(m.Enabled, check.Equals, false)
+	c.Assert(m.DirectoryURL, check.Equals, acme.LetsEncryptURL)
+	c.Assert(m.RenewBefore, check.Equals, defaultRenewBefore)
+}
+
+func (s *S) TestNewManagerRequiresChallengeAddress(c *check.C) {
+	config.Set("acme:enabled", true)
+	defer config.Unset("acme")
+	_, err := newManager()
+	c.Assert(err, check.ErrorMatches, "acme:challenge-address is required when acme is enabled")
+}
+
+func (s *S) TestRunOnceIssuesCertificate(c *check.C) {
+	s.validateThroughRouter(c)
+	s.addApp(c, "myapp", "fake-tls", "myapp.example.com")
+	err := s.manager.runOnce(time.Now().UTC())
+	c.Assert(err, check.IsNil)
+	c.Assert(s.ca.Issued(), check.DeepEquals, []string{"myapp.example.com"})
+	certPEM, err := routertest.TLSRouter.GetCertificate("myapp.example.com")
+	c.Assert(err, check.IsNil)
+	block, _ := pem.Decode([]byte(certPEM))
+	c.Assert(block, check.NotNil)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	c.Assert(err, check.IsNil)
+	c.Assert(cert.DNSNames, check.DeepEquals, []string{"myapp.example.com"})
+	certs, err := Certificates("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(certs, check.HasLen, 1)
+	c.Assert(certs[0].CName, check.Equals, "myapp.example.com")
+	c.Assert(certs[0].Status, check.Equals, StatusIssued)
+	c.Assert(certs[0].ExpiresAt.Unix(), check.Equals, cert.NotAfter.Unix())
+	_, ok := routertest.TLSRouter.ChallengeRoute("myapp.example.com")
+	c.Assert(ok, check.Equals, false)
+	n, err := s.conn.ACMEChallenges().Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 0)
+}
+
+func (s *S) TestRunOnceReusesAccount(c *check.C) {
+	s.addApp(c, "myapp", "fake-tls", "myapp.example.com", "www.example.com")
+	err := s.manager.runOnce(time.Now().UTC())
+	c.Assert(err, check.IsNil)
+	c.Assert(s.ca.Issued(), check.DeepEquals, []string{"myapp.example.com", "www.example.com"})
+	n, err := s.conn.ACMEAccounts().Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 1)
+}
+
+func (s *S) TestRunOnceKeepsValidCertificate(c *check.C) {
+	s.addApp(c, "myapp", "fake-tls", "myapp.example.com")
+	err := s.manager.runOnce(time.Now().UTC())
+	c.Assert(err, check.IsNil)
+	err = s.manager.runOnce(time.Now().UTC())
+	c.Assert(err, check.IsNil)
+	c.Assert(s.ca.Issued(), check.HasLen, 1)
+}
+
+func (s *S) TestRunOnceRenewsExpiringCertificate(c *check.C) {
+	s.ca.CertDuration = 10 * 24 * time.Hour
+	s.addApp(c, "myapp", "fake-tls", "myapp.example.com")
+	err := s.manager.runOnce(time.Now().UTC())
+	c.Assert(err, check.IsNil)
+	err = s.manager.runOnce(time.Now().UTC())
+	c.Assert(err, check.IsNil)
+	c.Assert(s.ca.Issued(), check.DeepEquals, []string{"myapp.example.com", "myapp.example.com"})
+	certs, err := Certificates("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(certs, check.HasLen, 1)
+	c.Assert(certs[0].Status, check.Equals, StatusIssued)
+}
+
+func (s *S) TestRunOnceSkipsManualCertificate(c *check.C) {
+	s.addApp(c, "myapp", "fake-tls", "myapp.example.com")
+	err := routertest.TLSRouter.AddCertificate("myapp.example.com", "manual-cert", "manual-key")
+	c.Assert(err, check.IsNil)
+	err = s.manager.runOnce(time.Now().UTC())
+	c.Assert(err, check.IsNil)
+	c.Assert(s.ca.Issued(), check.HasLen, 0)
+	cert, err := routertest.TLSRouter.GetCertificate("myapp.example.com")
+	c.Assert(err, check.IsNil)
+	c.Assert(cert, check.Equals, "manual-cert")
+	certs, err := Certificates("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(certs, check.HasLen, 0)
+}
+
+func (s *S) TestRunOnceRouterWithoutTLS(c *check.C) {
+	s.addApp(c, "myapp", "fake", "myapp.example.com")
+	err := s.manager.runOnce(time.Now().UTC())
+	c.Assert(err, check.IsNil)
+	c.Assert(s.ca.Issued(), check.HasLen, 0)
+	certs, err := Certificates("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(certs, check.HasLen, 0)
+}
+
+func (s *S) TestRunOnceChallengeFailure(c *check.C) {
+	s.ca.Validate = func(domain, token, keyAuth string) bool {
+		return false
+	}
+	s.addApp(c, "myapp", "fake-tls", "myapp.example.com")
+	err := s.manager.runOnce(time.Now().UTC())
+	c.Assert(err, check.IsNil)
+	c.Assert(s.ca.Issued(), check.HasLen, 0)
+	_, err = routertest.TLSRouter.GetCertificate("myapp.example.com")
+	c.Assert(err, check.NotNil)
+	certs, err := Certificates("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(certs, check.HasLen, 1)
+	c.Assert(certs[0].Status, check.Equals, StatusFailed)
+	c.Assert(certs[0].Error, check.Not(check.Equals), "")
+	_, ok := routertest.TLSRouter.ChallengeRoute("myapp.example.com")
+	c.Assert(ok, check.Equals, false)
+}
+
+func (s *S) TestRunOnceBacksOffAfterFailures(c *check.C) {
+	attempts := 0
+	s.ca.Validate = func(domain, token, keyAuth string) bool {
+		attempts++
+		return false
+	}
+	s.addApp(c, "myapp", "fake-tls", "myapp.example.com")
+	now := time.Now().UTC()
+	err := s.manager.runOnce(now)
+	c.Assert(err, check.IsNil)
+	c.Assert(attempts, check.Equals, 1)
+	certs, err := Certificates("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(certs, check.HasLen, 1)
+	c.Assert(certs[0].Failures, check.Equals, 1)
+	c.Assert(certs[0].RetryAt.After(now.Add(failureBackoff-time.Minute)), check.Equals, true)
+	err = s.manager.runOnce(now.Add(time.Minute))
+	c.Assert(err, check.IsNil)
+	c.Assert(attempts, check.Equals, 1)
+	err = s.manager.runOnce(certs[0].RetryAt.Add(time.Second))
+	c.Assert(err, check.IsNil)
+	c.Assert(attempts, check.Equals, 2)
+	certs, err = Certificates("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(certs[0].Failures, check.Equals, 2)
+	c.Assert(certs[0].RetryAt.After(now.Add(2*failureBackoff-time.Minute)), check.Equals, true)
+	s.ca.Validate = nil
+	err = s.manager.runOnce(certs[0].RetryAt.Add(time.Second))
+	c.Assert(err, check.IsNil)
+	certs, err = Certificates("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(certs[0].Status, check.Equals, StatusIssued)
+	c.Assert(certs[0].Failures, check.Equals, 0)
+}
+
+func (s *S) TestRetryDelay(c *check.C) {
+	c.Assert(retryDelay(1), check.Equals, failureBackoff)
+	c.Assert(retryDelay(2), check.Equals, 2*failureBackoff)
+	c.Assert(retryDelay(3), check.Equals, 4*failureBackoff)
+	c.Assert(retryDelay(100), check.Equals, maxFailureBackoff)
+}
+
+func (s *S) TestRunOnceRemovesStaleCertificates(c *check.C) {
+	s.addApp(c, "myapp", "fake-tls", "myapp.example.com")
+	err := saveCertificate(&Certificate{CName: "old.example.com", App: "myapp", Status: StatusIssued})
+	c.Assert(err, check.IsNil)
+	err = s.manager.runOnce(time.Now().UTC())
+	c.Assert(err, check.IsNil)
+	certs, err := Certificates("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(certs, check.HasLen, 1)
+	c.Assert(certs[0].CName, check.Equals, "myapp.example.com")
+}
+
+func (s *S) TestChallengeResponseNotFound(c *check.C) {
+	_, err := ChallengeResponse("unknown-token")
+	c.Assert(err, check.Equals, ErrChallengeNotFound)
+}
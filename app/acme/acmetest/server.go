@@ -0,0 +1,439 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package acmetest provides a fake ACME (RFC 8555) certificate authority, to
+// be used in tests of components that obtain certificates using the ACME
+// protocol.
+package acmetest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ValidateFunc is called by the server when a client accepts an http-01
+// challenge. It must return whether the challenge was fulfilled for the
+// given domain, token and key authorization.
+type ValidateFunc func(domain, token, keyAuth string) bool
+
+// Server is a fake ACME certificate authority. It does not check the
+// signature of requests, and validates challenges by calling Validate
+// instead of connecting to the domain being authorized.
+type Server struct {
+	// Validate decides whether accepted challenges are valid. When nil, every
+	// challenge is considered valid.
+	Validate ValidateFunc
+	// CertDuration is the validity of issued certificates, defaulting to 90
+	// days.
+	CertDuration time.Duration
+
+	server   *httptest.Server
+	mu       sync.Mutex
+	caKey    *ecdsa.PrivateKey
+	caCert   *x509.Certificate
+	caDER    []byte
+	counter  int
+	accounts map[string]string
+	authzs   map[string]*authz
+	orders   map[string]*order
+	certs    map[string][]byte
+	issued   []string
+}
+
+type authz struct {
+	Status     string      `json:"status"`
+	Identifier identifier  `json:"identifier"`
+	Challenges []challenge `json:"challenges"`
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type challenge struct {
+	URL    string `json:"url"`
+	Type   string `json:"type"`
+	Token  string `json:"token"`
+	Status string `json:"status"`
+}
+
+type order struct {
+	Status         string       `json:"status"`
+	Identifiers    []identifier `json:"identifiers"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate,omitempty"`
+	authzIDs       []string
+}
+
+type jwsRequest struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+}
+
+type jwsHeader struct {
+	JWK map[string]string `json:"jwk"`
+	KID string            `json:"kid"`
+}
+
+// NewServer starts a new fake certificate authority. It must be stopped
+// with Stop.
+func NewServer() (*Server, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tsuru fake acme ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * 365 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		caKey:    key,
+		caCert:   caCert,
+		caDER:    der,
+		accounts: make(map[string]string),
+		authzs:   make(map[string]*authz),
+		orders:   make(map[string]*order),
+		certs:    make(map[string][]byte),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s, nil
+}
+
+// URL returns the directory URL of the server.
+func (s *Server) URL() string {
+	return s.server.URL + "/directory"
+}
+
+// Stop stops the server.
+func (s *Server) Stop() {
+	s.server.Close()
+}
+
+// Issued returns the domains of the certificates issued by the server, in
+// the order they were issued.
+func (s *Server) Issued() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.issued...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counter++
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", s.counter))
+	path := r.URL.Path
+	if path == "/new-nonce" {
+		return
+	}
+	if path == "/directory" {
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   s.server.URL + "/new-nonce",
+			"newAccount": s.server.URL + "/new-account",
+			"newOrder":   s.server.URL + "/new-order",
+			"revokeCert": s.server.URL + "/revoke-cert",
+			"keyChange":  s.server.URL + "/key-change",
+		})
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "malformed", "requests must use POST")
+		return
+	}
+	var req jwsRequest
+	var header jwsHeader
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err == nil {
+		err = decodeBase64JSON(req.Protected, &header)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	if path == "/new-account" {
+		s.newAccount(w, &req, &header)
+		return
+	}
+	thumbprint, ok := s.accounts[header.KID]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "accountDoesNotExist", "account not found")
+		return
+	}
+	switch {
+	case path == "/new-order":
+		s.newOrder(w, &req)
+	case strings.HasPrefix(path, "/order/"):
+		s.getOrder(w, strings.TrimPrefix(path, "/order/"))
+	case strings.HasPrefix(path, "/authz/"):
+		s.getAuthz(w, strings.TrimPrefix(path, "/authz/"))
+	case strings.HasPrefix(path, "/challenge/"):
+		s.acceptChallenge(w, strings.TrimPrefix(path, "/challenge/"), thumbprint)
+	case strings.HasPrefix(path, "/finalize/"):
+		s.finalize(w, &req, strings.TrimPrefix(path, "/finalize/"))
+	case strings.HasPrefix(path, "/cert/"):
+		s.getCert(w, strings.TrimPrefix(path, "/cert/"))
+	default:
+		writeError(w, http.StatusNotFound, "malformed", "not found")
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, problem, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":   "urn:ietf:params:acme:error:" + problem,
+		"detail": detail,
+		"status": code,
+	})
+}
+
+func decodeBase64JSON(data string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// jwkThumbprint returns the RFC 7638 thumbprint of the key, used in key
+// authorizations.
+func jwkThumbprint(jwk map[string]string) (string, error) {
+	var data string
+	switch jwk["kty"] {
+	case "EC":
+		data = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, jwk["crv"], jwk["x"], jwk["y"])
+	case "RSA":
+		data = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk["e"], jwk["n"])
+	default:
+		return "", fmt.Errorf("unsupported key type %q", jwk["kty"])
+	}
+	sum := sha256.Sum256([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func (s *Server) newAccount(w http.ResponseWriter, req *jwsRequest, header *jwsHeader) {
+	var payload struct {
+		Contact            []string `json:"contact"`
+		OnlyReturnExisting bool     `json:"onlyReturnExisting"`
+	}
+	err := decodeBase64JSON(req.Payload, &payload)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	thumbprint, err := jwkThumbprint(header.JWK)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "badPublicKey", err.Error())
+		return
+	}
+	for accountURL, t := range s.accounts {
+		if t == thumbprint {
+			w.Header().Set("Location", accountURL)
+			json.NewEncoder(w).Encode(map[string]interface{}{"status": "valid"})
+			return
+		}
+	}
+	if payload.OnlyReturnExisting {
+		writeError(w, http.StatusBadRequest, "accountDoesNotExist", "account not found")
+		return
+	}
+	accountURL := fmt.Sprintf("%s/account/%d", s.server.URL, s.counter)
+	s.accounts[accountURL] = thumbprint
+	w.Header().Set("Location", accountURL)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "valid",
+		"contact": payload.Contact,
+	})
+}
+
+func (s *Server) newOrder(w http.ResponseWriter, req *jwsRequest) {
+	var payload struct {
+		Identifiers []identifier `json:"identifiers"`
+	}
+	err := decodeBase64JSON(req.Payload, &payload)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	id := fmt.Sprintf("%d", s.counter)
+	o := &order{
+		Identifiers: payload.Identifiers,
+		Finalize:    fmt.Sprintf("%s/finalize/%s", s.server.URL, id),
+	}
+	for i, ident := range payload.Identifiers {
+		authzID := fmt.Sprintf("%s-%d", id, i)
+		s.authzs[authzID] = &authz{
+			Status:     "pending",
+			Identifier: ident,
+			Challenges: []challenge{{
+				URL:    fmt.Sprintf("%s/challenge/%s", s.server.URL, authzID),
+				Type:   "http-01",
+				Token:  fmt.Sprintf("token-%s", authzID),
+				Status: "pending",
+			}},
+		}
+		o.authzIDs = append(o.authzIDs, authzID)
+		o.Authorizations = append(o.Authorizations, fmt.Sprintf("%s/authz/%s", s.server.URL, authzID))
+	}
+	s.orders[id] = o
+	s.writeOrder(w, http.StatusCreated, id, o)
+}
+
+func (s *Server) writeOrder(w http.ResponseWriter, code int, id string, o *order) {
+	o.Status = "ready"
+	if o.Certificate != "" {
+		o.Status = "valid"
+	}
+	for _, authzID := range o.authzIDs {
+		switch s.authzs[authzID].Status {
+		case "invalid":
+			o.Status = "invalid"
+		case "pending":
+			if o.Status != "invalid" {
+				o.Status = "pending"
+			}
+		}
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s/order/%s", s.server.URL, id))
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(o)
+}
+
+func (s *Server) getOrder(w http.ResponseWriter, id string) {
+	o, ok := s.orders[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "malformed", "order not found")
+		return
+	}
+	s.writeOrder(w, http.StatusOK, id, o)
+}
+
+func (s *Server) getAuthz(w http.ResponseWriter, id string) {
+	a, ok := s.authzs[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "malformed", "authorization not found")
+		return
+	}
+	json.NewEncoder(w).Encode(a)
+}
+
+func (s *Server) acceptChallenge(w http.ResponseWriter, id, thumbprint string) {
+	a, ok := s.authzs[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "malformed", "challenge not found")
+		return
+	}
+	chal := &a.Challenges[0]
+	keyAuth := chal.Token + "." + thumbprint
+	valid := s.Validate == nil || s.Validate(a.Identifier.Value, chal.Token, keyAuth)
+	if valid {
+		chal.Status = "valid"
+		a.Status = "valid"
+	} else {
+		chal.Status = "invalid"
+		a.Status = "invalid"
+	}
+	json.NewEncoder(w).Encode(chal)
+}
+
+func (s *Server) finalize(w http.ResponseWriter, req *jwsRequest, id string) {
+	o, ok := s.orders[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "malformed", "order not found")
+		return
+	}
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+	err := decodeBase64JSON(req.Payload, &payload)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	csrDER, err := base64.RawURLEncoding.DecodeString(payload.CSR)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "badCSR", err.Error())
+		return
+	}
+	authorized := make(map[string]bool)
+	for _, authzID := range o.authzIDs {
+		a := s.authzs[authzID]
+		authorized[a.Identifier.Value] = a.Status == "valid"
+	}
+	for _, name := range csr.DNSNames {
+		if !authorized[name] {
+			writeError(w, http.StatusForbidden, "unauthorized", fmt.Sprintf("no valid authorization for %s", name))
+			return
+		}
+	}
+	duration := s.CertDuration
+	if duration == 0 {
+		duration = 90 * 24 * time.Hour
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(s.counter)),
+		Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(duration),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, s.caCert, csr.PublicKey, s.caKey)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "serverInternal", err.Error())
+		return
+	}
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.caDER})...)
+	s.certs[id] = chain
+	s.issued = append(s.issued, csr.DNSNames...)
+	o.Certificate = fmt.Sprintf("%s/cert/%s", s.server.URL, id)
+	s.writeOrder(w, http.StatusOK, id, o)
+}
+
+func (s *Server) getCert(w http.ResponseWriter, id string) {
+	chain, ok := s.certs[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "malformed", "certificate not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.Write(chain)
+}
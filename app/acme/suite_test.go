@@ -0,0 +1,66 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acme
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app/acme/acmetest"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/dbtest"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct {
+	conn    *db.Storage
+	ca      *acmetest.Server
+	manager *Manager
+}
+
+var _ = check.Suite(&S{})
+
+func (s *S) SetUpSuite(c *check.C) {
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "tsuru_app_acme_tests")
+	config.Set("routers:fake:type", "fake")
+	config.Set("routers:fake-tls:type", "fake-tls")
+	var err error
+	s.conn, err = db.Conn()
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) SetUpTest(c *check.C) {
+	err := dbtest.ClearAllCollections(s.conn.Apps().Database)
+	c.Assert(err, check.IsNil)
+	s.ca, err = acmetest.NewServer()
+	c.Assert(err, check.IsNil)
+	challengeAddress, err := url.Parse("http://tsuru.example.com:8080")
+	c.Assert(err, check.IsNil)
+	s.manager = &Manager{
+		DirectoryURL:     s.ca.URL(),
+		Email:            "admin@example.com",
+		ChallengeAddress: challengeAddress,
+		RenewBefore:      defaultRenewBefore,
+		done:             make(chan bool),
+	}
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	s.ca.Stop()
+	routertest.TLSRouter.Reset()
+	for cname := range routertest.TLSRouter.Certs {
+		routertest.TLSRouter.RemoveCertificate(cname)
+	}
+}
+
+func (s *S) TearDownSuite(c *check.C) {
+	s.conn.Apps().Database.DropDatabase()
+	s.conn.Close()
+}
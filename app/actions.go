@@ -70,6 +70,33 @@ var reserveUserApp = action.Action{
 	MinParams: 2,
 }
 
+// reserveTeamApp reserves the app in the quota of its team owner.
+//
+// The first argument in the context must be an App or a pointer to an App.
+var reserveTeamApp = action.Action{
+	Name: "reserve-team-app",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		var app *App
+		switch ctx.Params[0].(type) {
+		case *App:
+			app = ctx.Params[0].(*App)
+		default:
+			return nil, errors.New("First parameter must be *App.")
+		}
+		if err := auth.ReserveTeamQuota(app.TeamOwner, auth.TeamQuota{Apps: 1}); err != nil {
+			return nil, err
+		}
+		return app.TeamOwner, nil
+	},
+	Backward: func(ctx action.BWContext) {
+		teamName := ctx.FWResult.(string)
+		if err := auth.ReleaseTeamQuota(teamName, auth.TeamQuota{Apps: 1}); err != nil {
+			log.Errorf("Failed to rollback reserveTeamApp: %s", err)
+		}
+	},
+	MinParams: 1,
+}
+
 // insertApp is an action that inserts an app in the database in Forward and
 // removes it in the Backward.
 //
@@ -348,8 +375,16 @@ var reserveUnitsToAdd = action.Action{
 		if err != nil {
 			return nil, ErrAppNotFound
 		}
+		teamUnits := auth.TeamQuota{Units: n, Memory: int64(n) * app.Plan.Memory}
+		err = auth.ReserveTeamQuota(app.TeamOwner, teamUnits)
+		if err != nil {
+			return nil, err
+		}
 		err = reserveUnits(app, n)
 		if err != nil {
+			if releaseErr := auth.ReleaseTeamQuota(app.TeamOwner, teamUnits); releaseErr != nil {
+				log.Errorf("Failed to release team quota of app %q: %s", app.Name, releaseErr)
+			}
 			return nil, err
 		}
 		return n, nil
@@ -365,6 +400,10 @@ var reserveUnitsToAdd = action.Action{
 		if err != nil {
 			log.Errorf("Failed to rollback reserveUnitsToAdd: %s", err)
 		}
+		err = auth.ReleaseTeamQuota(app.TeamOwner, auth.TeamQuota{Units: qty, Memory: int64(qty) * app.Plan.Memory})
+		if err != nil {
+			log.Errorf("Failed to rollback team quota in reserveUnitsToAdd: %s", err)
+		}
 	},
 	MinParams: 2,
 }
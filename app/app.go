@@ -126,6 +126,7 @@ type App struct {
 	Router         string
 	RouterOpts     map[string]string
 	Deploys        uint
+	AutoScale      []AutoScaleRule
 
 	quota.Quota
 	provisioner provision.Provisioner
@@ -310,6 +311,7 @@ func CreateApp(app *App, user *auth.User) error {
 	}
 	actions := []*action.Action{
 		&reserveUserApp,
+		&reserveTeamApp,
 		&insertApp,
 		&exportEnvironmentsAction,
 		&createRepository,
@@ -344,6 +346,7 @@ func (app *App) Update(updateData App, w io.Writer) (err error) {
 	}
 	oldPlan := app.Plan
 	oldRouter := app.Router
+	oldTeamOwner := app.TeamOwner
 	if routerName != "" {
 		_, err = router.Get(routerName)
 		if err != nil {
@@ -374,6 +377,13 @@ func (app *App) Update(updateData App, w io.Writer) (err error) {
 	if err != nil {
 		return err
 	}
+	err = app.reserveTeamQuotaForUpdate(oldTeamOwner, &oldPlan)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		app.releaseTeamQuotaForUpdate(oldTeamOwner, &oldPlan, err == nil)
+	}()
 	if app.Router != oldRouter || app.Plan != oldPlan {
 		actions := []*action.Action{
 			&moveRouterUnits,
@@ -394,6 +404,51 @@ func (app *App) Update(updateData App, w io.Writer) (err error) {
 	return conn.Apps().Update(bson.M{"name": app.Name}, app)
 }
 
+// reserveTeamQuotaForUpdate reserves, in the quota of the team owning the
+// app, the resources taken by the app after changing its team owner or plan.
+func (app *App) reserveTeamQuotaForUpdate(oldTeamOwner string, oldPlan *Plan) error {
+	units := int64(app.Quota.InUse)
+	if app.TeamOwner != oldTeamOwner {
+		return auth.ReserveTeamQuota(app.TeamOwner, auth.TeamQuota{
+			Apps:   1,
+			Units:  app.Quota.InUse,
+			Memory: units * app.Plan.Memory,
+		})
+	}
+	if app.Plan.Memory > oldPlan.Memory {
+		return auth.ReserveTeamQuota(app.TeamOwner, auth.TeamQuota{
+			Memory: units * (app.Plan.Memory - oldPlan.Memory),
+		})
+	}
+	return nil
+}
+
+// releaseTeamQuotaForUpdate releases the team quota no longer used after an
+// update, or the quota reserved by reserveTeamQuotaForUpdate when the update
+// failed.
+func (app *App) releaseTeamQuotaForUpdate(oldTeamOwner string, oldPlan *Plan, updated bool) {
+	units := int64(app.Quota.InUse)
+	teamName := app.TeamOwner
+	var released auth.TeamQuota
+	switch {
+	case app.TeamOwner != oldTeamOwner && updated:
+		teamName = oldTeamOwner
+		released = auth.TeamQuota{Apps: 1, Units: app.Quota.InUse, Memory: units * oldPlan.Memory}
+	case app.TeamOwner != oldTeamOwner:
+		released = auth.TeamQuota{Apps: 1, Units: app.Quota.InUse, Memory: units * app.Plan.Memory}
+	case updated && app.Plan.Memory < oldPlan.Memory:
+		released = auth.TeamQuota{Memory: units * (oldPlan.Memory - app.Plan.Memory)}
+	case !updated && app.Plan.Memory > oldPlan.Memory:
+		released = auth.TeamQuota{Memory: units * (app.Plan.Memory - oldPlan.Memory)}
+	default:
+		return
+	}
+	err := auth.ReleaseTeamQuota(teamName, released)
+	if err != nil {
+		log.Errorf("Unable to release quota of team %q: %s", teamName, err)
+	}
+}
+
 // unbind takes all service instances that are bound to the app, and unbind
 // them. This method is used by Destroy (before destroying the app, it unbinds
 // all service instances). Refer to Destroy docs for more details.
@@ -488,13 +543,24 @@ func Delete(app *App, w io.Writer) error {
 	if err != nil {
 		logErr("Unable to release app quota", err)
 	}
-	logConn, err := db.LogConn()
+	err = auth.ReleaseTeamQuota(app.TeamOwner, auth.TeamQuota{
+		Apps:   1,
+		Units:  app.Quota.InUse,
+		Memory: int64(app.Quota.InUse) * app.Plan.Memory,
+	})
+	if err != nil {
+		logErr("Unable to release team quota", err)
+	}
+	logService, err := GetLogService()
 	if err == nil {
-		defer logConn.Close()
-		err = logConn.Logs(appName).DropCollection()
+		err = logService.Remove(appName)
+	}
+	if err != nil {
+		logErr("Unable to remove app logs", err)
 	}
+	err = removeLogDrains(bson.M{"app": appName})
 	if err != nil {
-		logErr("Unable to remove logs collection", err)
+		logErr("Unable to remove app log drains", err)
 	}
 	conn, err := db.Conn()
 	if err == nil {
@@ -604,14 +670,34 @@ func (app *App) RemoveUnits(n uint, process string, writer io.Writer) error {
 	if err != nil {
 		return err
 	}
-	return conn.Apps().Update(
-		bson.M{"name": app.Name},
-		bson.M{
-			"$set": bson.M{
-				"quota.inuse": len(units),
-			},
-		},
-	)
+	return app.setQuotaInUse(conn, len(units))
+}
+
+// setQuotaInUse stores the number of units in use by the app, accounting the
+// difference to the previously stored value in the quota of its team owner.
+func (app *App) setQuotaInUse(conn *db.Storage, inUse int) error {
+	var old App
+	_, err := conn.Apps().Find(bson.M{"name": app.Name}).Apply(mgo.Change{
+		Update: bson.M{"$set": bson.M{"quota.inuse": inUse}},
+	}, &old)
+	if err != nil {
+		return err
+	}
+	app.Quota.InUse = inUse
+	diff := inUse - old.Quota.InUse
+	if diff > 0 {
+		return auth.AddTeamQuotaInUse(old.TeamOwner, auth.TeamQuota{
+			Units:  diff,
+			Memory: int64(diff) * old.Plan.Memory,
+		})
+	}
+	if diff < 0 {
+		return auth.ReleaseTeamQuota(old.TeamOwner, auth.TeamQuota{
+			Units:  -diff,
+			Memory: int64(-diff) * old.Plan.Memory,
+		})
+	}
+	return nil
 }
 
 // SetUnitStatus changes the status of the given unit.
@@ -1151,7 +1237,7 @@ func (app *App) SetQuotaInUse(inUse int) error {
 		return err
 	}
 	defer conn.Close()
-	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"quota.inuse": inUse}})
+	err = app.setQuotaInUse(conn, inUse)
 	if err == mgo.ErrNotFound {
 		return ErrAppNotFound
 	}
@@ -1178,9 +1264,10 @@ func (app *App) GetDeploys() uint {
 	return app.Deploys
 }
 
-// Envs returns a map representing the apps environment variables.
+// Envs returns a map representing the apps environment variables, with the
+// values of secret variables decrypted.
 func (app *App) Envs() map[string]bind.EnvVar {
-	return app.Env
+	return app.decryptedEnvs()
 }
 
 // SetEnvs saves a list of environment variables in the app. The publicOnly
@@ -1215,6 +1302,14 @@ func (app *App) setEnvsToApp(setEnvs bind.SetEnvApp, w io.Writer) error {
 		fmt.Fprintf(w, "---- Setting %d new environment variables ----\n", len(setEnvs.Envs))
 	}
 	for _, env := range setEnvs.Envs {
+		if env.Secret {
+			var err error
+			env.Public = false
+			env.Value, err = encryptSecretEnv(env.Value)
+			if err != nil {
+				return err
+			}
+		}
 		set := true
 		if setEnvs.PublicOnly {
 			e, err := app.getEnv(env.Name)
@@ -1475,7 +1570,7 @@ func (app *App) RemoveInstance(instanceApp bind.InstanceApp, writer io.Writer) e
 // user can filter where the message come from.
 func (app *App) Log(message, source, unit string) error {
 	messages := strings.Split(message, "\n")
-	logs := make([]interface{}, 0, len(messages))
+	logs := make([]Applog, 0, len(messages))
 	for _, msg := range messages {
 		if msg != "" {
 			l := Applog{
@@ -1489,20 +1584,31 @@ func (app *App) Log(message, source, unit string) error {
 		}
 	}
 	if len(logs) > 0 {
-		notify(app.Name, logs)
-		conn, err := db.LogConn()
+		notifyMessages := make([]interface{}, len(logs))
+		for i := range logs {
+			notifyMessages[i] = logs[i]
+		}
+		notify(app.Name, notifyMessages)
+		logService, err := GetLogService()
 		if err != nil {
 			return err
 		}
-		defer conn.Close()
-		return conn.Logs(app.Name).Insert(logs...)
+		err = logService.Add(app.Name, logs)
+		if err != nil {
+			return err
+		}
+		logDrains.forward(app.Name, logs)
 	}
 	return nil
 }
 
 // LastLogs returns a list of the last `lines` log of the app, matching the
-// fields in the log instance received as an example.
-func (app *App) LastLogs(lines int, filterLog Applog) ([]Applog, error) {
+// received filter.
+func (app *App) LastLogs(lines int, filter LogFilter) ([]Applog, error) {
+	err := filter.Validate()
+	if err != nil {
+		return nil, err
+	}
 	prov, err := app.getProvisioner()
 	if err != nil {
 		return nil, err
@@ -1519,28 +1625,15 @@ func (app *App) LastLogs(lines int, filterLog Applog) ([]Applog, error) {
 			return nil, errors.New(doc)
 		}
 	}
-	conn, err := db.LogConn()
+	logService, err := GetLogService()
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
-	logs := []Applog{}
-	q := bson.M{}
-	if filterLog.Source != "" {
-		q["source"] = filterLog.Source
-	}
-	if filterLog.Unit != "" {
-		q["unit"] = filterLog.Unit
-	}
-	err = conn.Logs(app.Name).Find(q).Sort("-$natural").Limit(lines).All(&logs)
-	if err != nil {
-		return nil, err
-	}
-	l := len(logs)
-	for i := 0; i < l/2; i++ {
-		logs[i], logs[l-1-i] = logs[l-1-i], logs[i]
-	}
-	return logs, nil
+	return logService.List(ListLogArgs{
+		LogFilter: filter,
+		AppName:   app.Name,
+		Limit:     lines,
+	})
 }
 
 type Filter struct {
@@ -2306,7 +2306,7 @@ func (s *S) TestLogWithListeners(c *check.C) {
 	}
 	err := CreateApp(&a, s.user)
 	c.Assert(err, check.IsNil)
-	l, err := NewLogListener(&a, Applog{})
+	l, err := NewLogListener(&a, LogFilter{})
 	c.Assert(err, check.IsNil)
 	defer l.Close()
 	go func() {
@@ -2365,7 +2365,7 @@ func (s *S) TestLastLogs(c *check.C) {
 		time.Sleep(1e6) // let the time flow
 	}
 	app.Log("app3 log from circus", "circus", "rdaneel")
-	logs, err := app.LastLogs(10, Applog{Source: "tsuru"})
+	logs, err := app.LastLogs(10, LogFilter{Source: "tsuru"})
 	c.Assert(err, check.IsNil)
 	c.Assert(logs, check.HasLen, 10)
 	for i := 5; i < 15; i++ {
@@ -2388,7 +2388,7 @@ func (s *S) TestLastLogsUnitFilter(c *check.C) {
 	}
 	app.Log("app3 log from circus", "circus", "rdaneel")
 	app.Log("app3 log from tsuru", "tsuru", "seldon")
-	logs, err := app.LastLogs(10, Applog{Source: "tsuru", Unit: "rdaneel"})
+	logs, err := app.LastLogs(10, LogFilter{Source: "tsuru", Units: []string{"rdaneel"}})
 	c.Assert(err, check.IsNil)
 	c.Assert(logs, check.HasLen, 10)
 	for i := 5; i < 15; i++ {
@@ -2405,7 +2405,7 @@ func (s *S) TestLastLogsEmpty(c *check.C) {
 	}
 	err := CreateApp(&app, s.user)
 	c.Assert(err, check.IsNil)
-	logs, err := app.LastLogs(10, Applog{Source: "tsuru"})
+	logs, err := app.LastLogs(10, LogFilter{Source: "tsuru"})
 	c.Assert(err, check.IsNil)
 	c.Assert(logs, check.DeepEquals, []Applog{})
 }
@@ -2433,7 +2433,7 @@ func (s *S) TestLastLogsDisabled(c *check.C) {
 	}
 	err := s.conn.Apps().Insert(app)
 	c.Assert(err, check.IsNil)
-	_, err = app.LastLogs(10, Applog{})
+	_, err = app.LastLogs(10, LogFilter{})
 	c.Assert(err, check.ErrorMatches, "my doc msg")
 }
 
@@ -2573,7 +2573,7 @@ func (s *S) TestRun(c *check.C) {
 	var logs []Applog
 	timeout := time.After(5 * time.Second)
 	for {
-		logs, err = app.LastLogs(10, Applog{})
+		logs, err = app.LastLogs(10, LogFilter{})
 		c.Assert(err, check.IsNil)
 		if len(logs) > 1 {
 			break
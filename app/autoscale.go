@@ -0,0 +1,155 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/db"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	AutoScaleMetricCPU      = "cpu"
+	AutoScaleMetricRequests = "requests"
+)
+
+var ErrAutoScaleRuleNotFound = errors.New("auto scale rule not found")
+
+// AutoScaleRule controls the number of units of an app process. The number of
+// units is kept between MinUnits and MaxUnits, aiming the Target value for the
+// chosen Metric: the average CPU usage percentage of each unit for "cpu" and
+// the number of requests per second handled by each unit for "requests".
+type AutoScaleRule struct {
+	Process  string
+	MinUnits uint
+	MaxUnits uint
+	Metric   string
+	Target   float64
+	Enabled  bool
+}
+
+func (r *AutoScaleRule) validate() error {
+	if r.Process == "" {
+		return errors.New("process is required")
+	}
+	if r.Metric != AutoScaleMetricCPU && r.Metric != AutoScaleMetricRequests {
+		return errors.Errorf("invalid metric %q, must be one of: %s, %s", r.Metric, AutoScaleMetricCPU, AutoScaleMetricRequests)
+	}
+	if r.Target <= 0 {
+		return errors.New("target must be greater than 0")
+	}
+	if r.MinUnits == 0 {
+		return errors.New("minimum number of units must be greater than 0")
+	}
+	if r.MaxUnits < r.MinUnits {
+		return errors.New("maximum number of units must be greater than or equal to the minimum")
+	}
+	return nil
+}
+
+// DesiredUnits returns the number of units needed to reach the rule target,
+// given the current number of units and the measured metric value. For the
+// "cpu" metric the value is the average usage per unit, for "requests" it's
+// the total rate for the process.
+func (r *AutoScaleRule) DesiredUnits(current uint, value float64) uint {
+	var desired float64
+	switch r.Metric {
+	case AutoScaleMetricCPU:
+		desired = float64(current) * value / r.Target
+	case AutoScaleMetricRequests:
+		desired = value / r.Target
+	}
+	units := uint(desired)
+	if float64(units) < desired {
+		units++
+	}
+	if units < r.MinUnits {
+		units = r.MinUnits
+	}
+	if units > r.MaxUnits {
+		units = r.MaxUnits
+	}
+	return units
+}
+
+// SetAutoScaleRule adds or replaces the auto scale rule for the rule process.
+func (app *App) SetAutoScaleRule(rule AutoScaleRule) error {
+	err := rule.validate()
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(
+		bson.M{"name": app.Name, "autoscale.process": rule.Process},
+		bson.M{"$set": bson.M{"autoscale.$": rule}},
+	)
+	if err == mgo.ErrNotFound {
+		err = conn.Apps().Update(
+			bson.M{"name": app.Name},
+			bson.M{"$push": bson.M{"autoscale": rule}},
+		)
+	}
+	if err == mgo.ErrNotFound {
+		return ErrAppNotFound
+	}
+	if err != nil {
+		return err
+	}
+	for i := range app.AutoScale {
+		if app.AutoScale[i].Process == rule.Process {
+			app.AutoScale[i] = rule
+			return nil
+		}
+	}
+	app.AutoScale = append(app.AutoScale, rule)
+	return nil
+}
+
+// RemoveAutoScaleRule removes the auto scale rule for the given process.
+func (app *App) RemoveAutoScaleRule(process string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(
+		bson.M{"name": app.Name, "autoscale.process": process},
+		bson.M{"$pull": bson.M{"autoscale": bson.M{"process": process}}},
+	)
+	if err == mgo.ErrNotFound {
+		return ErrAutoScaleRuleNotFound
+	}
+	if err != nil {
+		return err
+	}
+	for i := range app.AutoScale {
+		if app.AutoScale[i].Process == process {
+			app.AutoScale = append(app.AutoScale[:i], app.AutoScale[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// ListAutoScaleApps returns all apps with at least one enabled auto scale
+// rule.
+func ListAutoScaleApps() ([]App, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var apps []App
+	err = conn.Apps().Find(bson.M{"autoscale.enabled": true}).All(&apps)
+	if err != nil {
+		return nil, err
+	}
+	return apps, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestAutoScaleRuleDesiredUnits(c *check.C) {
+	cpuRule := AutoScaleRule{Process: "web", MinUnits: 2, MaxUnits: 10, Metric: AutoScaleMetricCPU, Target: 50}
+	c.Assert(cpuRule.DesiredUnits(4, 50), check.Equals, uint(4))
+	c.Assert(cpuRule.DesiredUnits(4, 90), check.Equals, uint(8))
+	c.Assert(cpuRule.DesiredUnits(4, 10), check.Equals, uint(2))
+	c.Assert(cpuRule.DesiredUnits(8, 100), check.Equals, uint(10))
+	reqRule := AutoScaleRule{Process: "web", MinUnits: 1, MaxUnits: 5, Metric: AutoScaleMetricRequests, Target: 100}
+	c.Assert(reqRule.DesiredUnits(1, 250), check.Equals, uint(3))
+	c.Assert(reqRule.DesiredUnits(3, 0), check.Equals, uint(1))
+	c.Assert(reqRule.DesiredUnits(3, 10000), check.Equals, uint(5))
+}
+
+func (s *S) TestSetAutoScaleRule(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	rule := AutoScaleRule{Process: "web", MinUnits: 1, MaxUnits: 5, Metric: AutoScaleMetricCPU, Target: 70, Enabled: true}
+	err = a.SetAutoScaleRule(rule)
+	c.Assert(err, check.IsNil)
+	rule.MaxUnits = 8
+	err = a.SetAutoScaleRule(rule)
+	c.Assert(err, check.IsNil)
+	worker := AutoScaleRule{Process: "worker", MinUnits: 1, MaxUnits: 2, Metric: AutoScaleMetricRequests, Target: 10}
+	err = a.SetAutoScaleRule(worker)
+	c.Assert(err, check.IsNil)
+	c.Assert(a.AutoScale, check.DeepEquals, []AutoScaleRule{rule, worker})
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.AutoScale, check.DeepEquals, []AutoScaleRule{rule, worker})
+	apps, err := ListAutoScaleApps()
+	c.Assert(err, check.IsNil)
+	c.Assert(apps, check.HasLen, 1)
+	c.Assert(apps[0].Name, check.Equals, a.Name)
+}
+
+func (s *S) TestSetAutoScaleRuleInvalid(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	tests := []struct {
+		rule AutoScaleRule
+		err  string
+	}{
+		{AutoScaleRule{MinUnits: 1, MaxUnits: 2, Metric: AutoScaleMetricCPU, Target: 1}, "process is required"},
+		{AutoScaleRule{Process: "web", MinUnits: 1, MaxUnits: 2, Metric: "memory", Target: 1}, `invalid metric "memory", must be one of: cpu, requests`},
+		{AutoScaleRule{Process: "web", MinUnits: 1, MaxUnits: 2, Metric: AutoScaleMetricCPU}, "target must be greater than 0"},
+		{AutoScaleRule{Process: "web", MaxUnits: 2, Metric: AutoScaleMetricCPU, Target: 1}, "minimum number of units must be greater than 0"},
+		{AutoScaleRule{Process: "web", MinUnits: 3, MaxUnits: 2, Metric: AutoScaleMetricCPU, Target: 1}, "maximum number of units must be greater than or equal to the minimum"},
+	}
+	for _, tt := range tests {
+		err = a.SetAutoScaleRule(tt.rule)
+		c.Assert(err, check.ErrorMatches, tt.err)
+	}
+}
+
+func (s *S) TestRemoveAutoScaleRule(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	rule := AutoScaleRule{Process: "web", MinUnits: 1, MaxUnits: 5, Metric: AutoScaleMetricCPU, Target: 70, Enabled: true}
+	err = a.SetAutoScaleRule(rule)
+	c.Assert(err, check.IsNil)
+	err = a.RemoveAutoScaleRule("web")
+	c.Assert(err, check.IsNil)
+	c.Assert(a.AutoScale, check.HasLen, 0)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.AutoScale, check.HasLen, 0)
+	err = a.RemoveAutoScaleRule("web")
+	c.Assert(err, check.Equals, ErrAutoScaleRuleNotFound)
+}
@@ -8,11 +8,13 @@ package bind
 
 import "io"
 
-// EnvVar represents a environment variable for an app.
+// EnvVar represents a environment variable for an app. The value of secret
+// variables is stored encrypted.
 type EnvVar struct {
 	Name         string `json:"name"`
 	Value        string `json:"value"`
 	Public       bool   `json:"public"`
+	Secret       bool   `json:"secret,omitempty"`
 	InstanceName string `json:"-"`
 }
 
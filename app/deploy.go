@@ -169,6 +169,7 @@ type DeployOptions struct {
 	Event        *event.Event `bson:"-"`
 	Kind         DeployKind
 	Message      string
+	Strategy     DeployStrategy
 }
 
 func (o *DeployOptions) GetOrigin() string {
@@ -252,6 +253,9 @@ func deployToProvisioner(opts *DeployOptions, evt *event.Event) (string, error)
 	if opts.Kind == "" {
 		opts.GetKind()
 	}
+	if opts.Strategy.Kind != "" {
+		return deployWithStrategy(opts, evt)
+	}
 	switch opts.Kind {
 	case DeployRollback:
 		if deployer, ok := prov.(provision.RollbackableDeployer); ok {
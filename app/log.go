@@ -10,7 +10,6 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/tsuru/tsuru/db"
 	"github.com/tsuru/tsuru/log"
 	"github.com/tsuru/tsuru/queue"
 )
@@ -42,7 +41,7 @@ var (
 
 	logsWritten = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "tsuru_logs_write_total",
-		Help: "The number of log entries written to mongo.",
+		Help: "The number of log entries written to the log storage.",
 	})
 )
 
@@ -63,7 +62,11 @@ func logQueueName(appName string) string {
 	return LogPubSubQueuePrefix + appName
 }
 
-func NewLogListener(a *App, filterLog Applog) (*LogListener, error) {
+func NewLogListener(a *App, filter LogFilter) (*LogListener, error) {
+	match, err := filter.Matcher()
+	if err != nil {
+		return nil, err
+	}
 	factory, err := queue.Factory()
 	if err != nil {
 		return nil, err
@@ -86,8 +89,7 @@ func NewLogListener(a *App, filterLog Applog) (*LogListener, error) {
 				log.Errorf("Unparsable log message, ignoring: %s", string(msg))
 				continue
 			}
-			if (filterLog.Source == "" || filterLog.Source == applog.Source) &&
-				(filterLog.Unit == "" || filterLog.Unit == applog.Unit) {
+			if match(&applog) {
 				c <- applog
 			}
 		}
@@ -218,7 +220,7 @@ func (d *appLogDispatcher) runFlusher() {
 	t := time.NewTimer(bulkMaxWaitTime)
 	pos := 0
 	sz := 200
-	bulkBuffer := make([]interface{}, sz)
+	bulkBuffer := make([]Applog, sz)
 	for {
 		var flush bool
 		select {
@@ -229,7 +231,7 @@ func (d *appLogDispatcher) runFlusher() {
 				flush = true
 				break
 			}
-			bulkBuffer[pos] = msg
+			bulkBuffer[pos] = *msg
 			pos++
 			flush = sz == pos
 		case <-t.C:
@@ -237,18 +239,17 @@ func (d *appLogDispatcher) runFlusher() {
 			t.Reset(bulkMaxWaitTime)
 		}
 		if flush {
-			conn, err := db.LogConn()
+			logService, err := GetLogService()
 			if err != nil {
-				log.Errorf("[log flusher] unable to connect to mongodb: %s", err)
+				log.Errorf("[log flusher] unable to get log service: %s", err)
 				continue
 			}
-			coll := conn.Logs(d.appName)
-			err = coll.Insert(bulkBuffer[:pos]...)
-			coll.Close()
+			err = logService.Add(d.appName, bulkBuffer[:pos])
 			if err != nil {
 				log.Errorf("[log flusher] unable to insert logs: %s", err)
 				continue
 			}
+			logDrains.forward(d.appName, bulkBuffer[:pos])
 			logsWritten.Add(float64(pos))
 			pos = 0
 		}
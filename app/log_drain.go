@@ -0,0 +1,552 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/log"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	defaultLogDrainQueueSize  = 1000
+	defaultLogDrainMaxRetries = 3
+	logDrainBatchSize         = 100
+	logDrainTimeout           = 30 * time.Second
+)
+
+var (
+	ErrLogDrainNotFound      = errors.New("log drain not found")
+	ErrLogDrainAlreadyExists = errors.New("log drain already exists")
+
+	logDrainNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,40}$`)
+
+	logDrainRetryInterval = time.Second
+	logDrainCacheTTL      = 30 * time.Second
+	logDrainIdleTimeout   = 10 * time.Minute
+	logDrainHTTPClient    = &http.Client{Timeout: logDrainTimeout}
+
+	logDrains = logForwarder{
+		apps:    make(map[string]appLogDrains),
+		senders: make(map[string]*logDrainSender),
+	}
+
+	logDrainSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tsuru_logs_drain_sent_total",
+		Help: "The number of log entries sent to log drains.",
+	}, []string{"type"})
+
+	logDrainDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tsuru_logs_drain_dropped_total",
+		Help: "The number of log entries dropped by log drains, either because their queue was full or because they couldn't be sent.",
+	}, []string{"type"})
+
+	logDrainErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tsuru_logs_drain_errors_total",
+		Help: "The number of failed attempts to send log entries to log drains.",
+	}, []string{"type"})
+
+	logDrainSendersCurrent = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "tsuru_logs_drain_senders_current",
+		Help: "The current number of log drain senders running.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(logDrainSent)
+	prometheus.MustRegister(logDrainDropped)
+	prometheus.MustRegister(logDrainErrors)
+	prometheus.MustRegister(logDrainSendersCurrent)
+}
+
+// LogDrain forwards every log entry stored for an app to an external sink.
+// Drains may belong to an app or to a pool, drains of a pool are used by the
+// apps in the pool without drains of their own.
+//
+// The URL defines the kind of the sink: syslog://host:port and
+// syslog+tls://host:port send RFC 5424 messages over TCP, with octet
+// counting framing, while http:// and https:// URLs receive POST requests
+// with batches of entries encoded as a JSON array.
+type LogDrain struct {
+	ID   bson.ObjectId `bson:"_id" json:"-"`
+	Name string
+	URL  string
+	App  string `json:",omitempty"`
+	Pool string `json:",omitempty"`
+}
+
+func (d *LogDrain) validate() error {
+	if !logDrainNameRegexp.MatchString(d.Name) {
+		return &tsuruErrors.ValidationError{Message: "log drain name must contain only letters, numbers, dashes and underscores, with at most 40 characters"}
+	}
+	if (d.App == "") == (d.Pool == "") {
+		return &tsuruErrors.ValidationError{Message: "log drain must belong to either an app or a pool"}
+	}
+	u, err := url.Parse(d.URL)
+	if err != nil || u.Host == "" {
+		return &tsuruErrors.ValidationError{Message: "log drain url must be a valid url"}
+	}
+	switch u.Scheme {
+	case "syslog", "syslog+tls":
+		_, _, err = net.SplitHostPort(u.Host)
+		if err != nil {
+			return &tsuruErrors.ValidationError{Message: "syslog log drain url must include the port"}
+		}
+	case "http", "https":
+	default:
+		return &tsuruErrors.ValidationError{Message: "log drain url scheme must be one of syslog, syslog+tls, http or https"}
+	}
+	return nil
+}
+
+// key identifies the sender of the drain, a new sender is used when the URL
+// of the drain changes.
+func (d *LogDrain) key() string {
+	return d.ID.Hex() + " " + d.URL
+}
+
+func (d *LogDrain) kind() string {
+	if i := strings.Index(d.URL, "://"); i > 0 {
+		return d.URL[:i]
+	}
+	return ""
+}
+
+// AddLogDrain adds a log drain to an app or to a pool.
+func AddLogDrain(d *LogDrain) error {
+	err := d.validate()
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	d.ID = bson.NewObjectId()
+	err = conn.LogDrains().Insert(d)
+	if mgo.IsDup(err) {
+		return ErrLogDrainAlreadyExists
+	}
+	if err != nil {
+		return err
+	}
+	logDrains.invalidate()
+	return nil
+}
+
+// ListLogDrains returns the drains of the app, or of the pool when appName is
+// empty, sorted by name.
+func ListLogDrains(appName, poolName string) ([]LogDrain, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	drains := []LogDrain{}
+	err = conn.LogDrains().Find(bson.M{"app": appName, "pool": poolName}).Sort("name").All(&drains)
+	if err != nil {
+		return nil, err
+	}
+	return drains, nil
+}
+
+// RemoveLogDrain removes a drain from the app, or from the pool when appName
+// is empty.
+func RemoveLogDrain(appName, poolName, name string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.LogDrains().Remove(bson.M{"app": appName, "pool": poolName, "name": name})
+	if err == mgo.ErrNotFound {
+		return ErrLogDrainNotFound
+	}
+	if err != nil {
+		return err
+	}
+	logDrains.invalidate()
+	return nil
+}
+
+// RemovePoolLogDrains removes every drain of the pool.
+func RemovePoolLogDrains(poolName string) error {
+	return removeLogDrains(bson.M{"app": "", "pool": poolName})
+}
+
+func removeLogDrains(query bson.M) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.LogDrains().RemoveAll(query)
+	if err != nil {
+		return err
+	}
+	logDrains.invalidate()
+	return nil
+}
+
+// LogDrains returns the drains receiving the logs of the app, which are the
+// drains of the app or, when it has none, the drains of its pool.
+func (app *App) LogDrains() ([]LogDrain, error) {
+	drains, err := ListLogDrains(app.Name, "")
+	if err != nil || len(drains) > 0 || app.Pool == "" {
+		return drains, err
+	}
+	return ListLogDrains("", app.Pool)
+}
+
+type appLogDrains struct {
+	drains  []LogDrain
+	expires time.Time
+}
+
+// logForwarder sends log entries to the drains of their apps. Drains of each
+// app are cached for logDrainCacheTTL, and each drain has a sender with its
+// own queue, so slow drains don't block the storage of logs.
+type logForwarder struct {
+	sync.Mutex
+	apps    map[string]appLogDrains
+	senders map[string]*logDrainSender
+}
+
+func (f *logForwarder) invalidate() {
+	f.Lock()
+	defer f.Unlock()
+	f.apps = make(map[string]appLogDrains)
+}
+
+func (f *logForwarder) drainsFor(appName string) ([]LogDrain, error) {
+	f.Lock()
+	cached, ok := f.apps[appName]
+	f.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.drains, nil
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	var a App
+	err = conn.Apps().Find(bson.M{"name": appName}).Select(bson.M{"name": 1, "pool": 1}).One(&a)
+	conn.Close()
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, err
+	}
+	a.Name = appName
+	drains, err := a.LogDrains()
+	if err != nil {
+		return nil, err
+	}
+	f.Lock()
+	f.apps[appName] = appLogDrains{drains: drains, expires: time.Now().Add(logDrainCacheTTL)}
+	f.Unlock()
+	return drains, nil
+}
+
+// forward queues the log entries to the drains of the app, entries that
+// don't fit in the queue of a drain are dropped.
+func (f *logForwarder) forward(appName string, logs []Applog) {
+	drains, err := f.drainsFor(appName)
+	if err != nil {
+		log.Errorf("[log drains] unable to get drains for app %q: %s", appName, err)
+		return
+	}
+	if len(drains) == 0 {
+		return
+	}
+	f.Lock()
+	defer f.Unlock()
+	for _, d := range drains {
+		s, ok := f.senders[d.key()]
+		if !ok {
+			s, err = newLogDrainSender(d)
+			if err != nil {
+				log.Errorf("[log drains] unable to create sender for drain %q: %s", d.Name, err)
+				logDrainDropped.WithLabelValues(d.kind()).Add(float64(len(logs)))
+				continue
+			}
+			f.senders[d.key()] = s
+			go s.run(f)
+		}
+		s.enqueue(appName, logs)
+	}
+}
+
+// remove removes the sender if its queue is empty, returning whether it was
+// removed.
+func (f *logForwarder) remove(s *logDrainSender) bool {
+	f.Lock()
+	defer f.Unlock()
+	if len(s.ch) > 0 {
+		return false
+	}
+	delete(f.senders, s.drain.key())
+	return true
+}
+
+type logDrainEntry struct {
+	appName string
+	log     Applog
+}
+
+type logDrainSender struct {
+	drain      LogDrain
+	writer     logDrainWriter
+	ch         chan logDrainEntry
+	maxRetries int
+}
+
+func newLogDrainSender(d LogDrain) (*logDrainSender, error) {
+	writer, err := newLogDrainWriter(d.URL)
+	if err != nil {
+		return nil, err
+	}
+	queueSize, _ := config.GetInt("app-logs:drains:queue-size")
+	if queueSize <= 0 {
+		queueSize = defaultLogDrainQueueSize
+	}
+	maxRetries := defaultLogDrainMaxRetries
+	if _, err = config.Get("app-logs:drains:max-retries"); err == nil {
+		maxRetries, _ = config.GetInt("app-logs:drains:max-retries")
+	}
+	return &logDrainSender{
+		drain:      d,
+		writer:     writer,
+		ch:         make(chan logDrainEntry, queueSize),
+		maxRetries: maxRetries,
+	}, nil
+}
+
+func (s *logDrainSender) enqueue(appName string, logs []Applog) {
+	for _, l := range logs {
+		select {
+		case s.ch <- logDrainEntry{appName: appName, log: l}:
+		default:
+			logDrainDropped.WithLabelValues(s.drain.kind()).Inc()
+		}
+	}
+}
+
+// run sends batches of queued entries until the sender stays idle for
+// logDrainIdleTimeout.
+func (s *logDrainSender) run(f *logForwarder) {
+	logDrainSendersCurrent.Inc()
+	defer logDrainSendersCurrent.Dec()
+	defer s.writer.Close()
+	batch := make([]logDrainEntry, 0, logDrainBatchSize)
+	idle := time.NewTimer(logDrainIdleTimeout)
+	defer idle.Stop()
+	for {
+		select {
+		case entry := <-s.ch:
+			batch = append(batch, entry)
+		case <-idle.C:
+			if f.remove(s) {
+				return
+			}
+			idle.Reset(logDrainIdleTimeout)
+			continue
+		}
+	fill:
+		for len(batch) < logDrainBatchSize {
+			select {
+			case entry := <-s.ch:
+				batch = append(batch, entry)
+			default:
+				break fill
+			}
+		}
+		s.send(batch)
+		batch = batch[:0]
+		if !idle.Stop() {
+			<-idle.C
+		}
+		idle.Reset(logDrainIdleTimeout)
+	}
+}
+
+// send writes the batch to the drain, retrying with an exponential backoff.
+func (s *logDrainSender) send(batch []logDrainEntry) {
+	kind := s.drain.kind()
+	interval := logDrainRetryInterval
+	var err error
+	for attempt := 0; attempt <= s.maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(interval)
+			interval *= 2
+		}
+		err = s.writer.Write(batch)
+		if err == nil {
+			logDrainSent.WithLabelValues(kind).Add(float64(len(batch)))
+			return
+		}
+		logDrainErrors.WithLabelValues(kind).Inc()
+	}
+	logDrainDropped.WithLabelValues(kind).Add(float64(len(batch)))
+	log.Errorf("[log drains] unable to send %d log entries to drain %q: %s", len(batch), s.drain.Name, err)
+}
+
+type logDrainWriter interface {
+	Write(entries []logDrainEntry) error
+	Close() error
+}
+
+func newLogDrainWriter(drainURL string) (logDrainWriter, error) {
+	u, err := url.Parse(drainURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "syslog", "syslog+tls":
+		w := &syslogDrainWriter{addr: u.Host}
+		if u.Scheme == "syslog+tls" {
+			host, _, _ := net.SplitHostPort(u.Host)
+			w.tlsConfig = &tls.Config{ServerName: host}
+		}
+		return w, nil
+	case "http", "https":
+		return &httpDrainWriter{url: drainURL, client: logDrainHTTPClient}, nil
+	}
+	return nil, errors.Errorf("unsupported log drain scheme %q", u.Scheme)
+}
+
+// syslogDrainWriter keeps a connection to the syslog server, reconnecting in
+// the next write after a failure.
+type syslogDrainWriter struct {
+	addr      string
+	tlsConfig *tls.Config
+	conn      net.Conn
+}
+
+func (w *syslogDrainWriter) connect() error {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	var err error
+	if w.tlsConfig != nil {
+		w.conn, err = tls.DialWithDialer(dialer, "tcp", w.addr, w.tlsConfig)
+	} else {
+		w.conn, err = dialer.Dial("tcp", w.addr)
+	}
+	return err
+}
+
+func (w *syslogDrainWriter) Write(entries []logDrainEntry) error {
+	if w.conn == nil {
+		err := w.connect()
+		if err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	for _, e := range entries {
+		msg := formatSyslog(e.appName, &e.log)
+		fmt.Fprintf(&buf, "%d %s", len(msg), msg)
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(logDrainTimeout))
+	_, err := buf.WriteTo(w.conn)
+	if err != nil {
+		w.Close()
+	}
+	return err
+}
+
+func (w *syslogDrainWriter) Close() error {
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// formatSyslog formats the entry as a RFC 5424 message, using the app name as
+// hostname, the source as app-name and the unit as procid.
+func formatSyslog(appName string, l *Applog) string {
+	const priority = 14 // user-level facility, informational severity
+	return fmt.Sprintf("<%d>1 %s %s %s %s - - %s",
+		priority,
+		l.Date.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		syslogField(appName, 255),
+		syslogField(l.Source, 48),
+		syslogField(l.Unit, 128),
+		l.Message,
+	)
+}
+
+// syslogField replaces characters not allowed in syslog header fields and
+// truncates the value, returning the nil value for empty fields.
+func syslogField(value string, maxLen int) string {
+	if value == "" {
+		return "-"
+	}
+	field := []byte(value)
+	for i, c := range field {
+		if c <= ' ' || c > '~' {
+			field[i] = '_'
+		}
+	}
+	if len(field) > maxLen {
+		field = field[:maxLen]
+	}
+	return string(field)
+}
+
+type httpDrainWriter struct {
+	url    string
+	client *http.Client
+}
+
+func (w *httpDrainWriter) Write(entries []logDrainEntry) error {
+	logs := make([]Applog, len(entries))
+	for i, e := range entries {
+		logs[i] = e.log
+		logs[i].AppName = e.appName
+	}
+	body, err := json.Marshal(logs)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rsp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(rsp.Body, 64*1024))
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return errors.Errorf("invalid status code %d", rsp.StatusCode)
+	}
+	return nil
+}
+
+func (w *httpDrainWriter) Close() error {
+	return nil
+}
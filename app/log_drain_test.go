@@ -0,0 +1,335 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/errors"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func counterValue(c *check.C, vec *prometheus.CounterVec, label string) float64 {
+	var m dto.Metric
+	err := vec.WithLabelValues(label).Write(&m)
+	c.Assert(err, check.IsNil)
+	return m.GetCounter().GetValue()
+}
+
+func (s *S) TestAddLogDrainValidation(c *check.C) {
+	tests := []struct {
+		drain LogDrain
+		msg   string
+	}{
+		{LogDrain{Name: "my drain", URL: "https://logs.example.com", App: "myapp"}, "log drain name must contain .*"},
+		{LogDrain{Name: "papertrail", URL: "https://logs.example.com"}, "log drain must belong to either an app or a pool"},
+		{LogDrain{Name: "papertrail", URL: "https://logs.example.com", App: "myapp", Pool: "pool1"}, "log drain must belong to either an app or a pool"},
+		{LogDrain{Name: "papertrail", URL: "logs.example.com", App: "myapp"}, "log drain url must be a valid url"},
+		{LogDrain{Name: "papertrail", URL: "syslog://logs.example.com", App: "myapp"}, "syslog log drain url must include the port"},
+		{LogDrain{Name: "papertrail", URL: "udp://logs.example.com:514", App: "myapp"}, "log drain url scheme must be .*"},
+	}
+	for _, tt := range tests {
+		err := AddLogDrain(&tt.drain)
+		c.Check(err, check.FitsTypeOf, &errors.ValidationError{})
+		c.Check(err, check.ErrorMatches, tt.msg)
+	}
+}
+
+func (s *S) TestAddLogDrain(c *check.C) {
+	drain := LogDrain{Name: "papertrail", URL: "syslog+tls://logs.example.com:6514", App: "myapp"}
+	err := AddLogDrain(&drain)
+	c.Assert(err, check.IsNil)
+	c.Assert(drain.ID.Valid(), check.Equals, true)
+	err = AddLogDrain(&LogDrain{Name: "papertrail", URL: "https://logs.example.com", App: "myapp"})
+	c.Assert(err, check.Equals, ErrLogDrainAlreadyExists)
+	err = AddLogDrain(&LogDrain{Name: "papertrail", URL: "https://logs.example.com", Pool: "pool1"})
+	c.Assert(err, check.IsNil)
+	drains, err := ListLogDrains("myapp", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(drains, check.DeepEquals, []LogDrain{drain})
+	drains, err = ListLogDrains("", "pool1")
+	c.Assert(err, check.IsNil)
+	c.Assert(drains, check.HasLen, 1)
+	c.Assert(drains[0].URL, check.Equals, "https://logs.example.com")
+}
+
+func (s *S) TestRemoveLogDrain(c *check.C) {
+	err := AddLogDrain(&LogDrain{Name: "papertrail", URL: "https://logs.example.com", App: "myapp"})
+	c.Assert(err, check.IsNil)
+	err = RemoveLogDrain("otherapp", "", "papertrail")
+	c.Assert(err, check.Equals, ErrLogDrainNotFound)
+	err = RemoveLogDrain("myapp", "", "papertrail")
+	c.Assert(err, check.IsNil)
+	drains, err := ListLogDrains("myapp", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(drains, check.HasLen, 0)
+}
+
+func (s *S) TestRemovePoolLogDrains(c *check.C) {
+	err := AddLogDrain(&LogDrain{Name: "d1", URL: "https://logs.example.com", Pool: "pool1"})
+	c.Assert(err, check.IsNil)
+	err = AddLogDrain(&LogDrain{Name: "d1", URL: "https://logs.example.com", App: "myapp"})
+	c.Assert(err, check.IsNil)
+	err = RemovePoolLogDrains("pool1")
+	c.Assert(err, check.IsNil)
+	drains, err := ListLogDrains("", "pool1")
+	c.Assert(err, check.IsNil)
+	c.Assert(drains, check.HasLen, 0)
+	drains, err = ListLogDrains("myapp", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(drains, check.HasLen, 1)
+}
+
+func (s *S) TestAppLogDrainsPoolDefault(c *check.C) {
+	a := App{Name: "myapp", Pool: "pool1"}
+	err := AddLogDrain(&LogDrain{Name: "pooldrain", URL: "https://pool.example.com", Pool: "pool1"})
+	c.Assert(err, check.IsNil)
+	drains, err := a.LogDrains()
+	c.Assert(err, check.IsNil)
+	c.Assert(drains, check.HasLen, 1)
+	c.Assert(drains[0].Name, check.Equals, "pooldrain")
+	err = AddLogDrain(&LogDrain{Name: "appdrain", URL: "https://app.example.com", App: "myapp"})
+	c.Assert(err, check.IsNil)
+	drains, err = a.LogDrains()
+	c.Assert(err, check.IsNil)
+	c.Assert(drains, check.HasLen, 1)
+	c.Assert(drains[0].Name, check.Equals, "appdrain")
+}
+
+func (s *S) TestAppLogForwardsToDrains(c *check.C) {
+	received := make(chan []Applog, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var logs []Applog
+		json.NewDecoder(r.Body).Decode(&logs)
+		received <- logs
+	}))
+	defer server.Close()
+	a := App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = AddLogDrain(&LogDrain{Name: "http", URL: server.URL, Pool: a.Pool})
+	c.Assert(err, check.IsNil)
+	sent := counterValue(c, logDrainSent, "http")
+	err = a.Log("msg1\nmsg2", "tsuru", "unit1")
+	c.Assert(err, check.IsNil)
+	select {
+	case logs := <-received:
+		c.Assert(logs, check.HasLen, 2)
+		c.Assert(logs[0].Message, check.Equals, "msg1")
+		c.Assert(logs[0].AppName, check.Equals, "myapp")
+		c.Assert(logs[1].Message, check.Equals, "msg2")
+	case <-time.After(5 * time.Second):
+		c.Fatal("timeout waiting for logs")
+	}
+	timeout := time.After(5 * time.Second)
+	for counterValue(c, logDrainSent, "http")-sent != 2 {
+		select {
+		case <-timeout:
+			c.Fatal("timeout waiting for sent metric")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (s *S) TestDeleteAppRemovesLogDrains(c *check.C) {
+	a := App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = AddLogDrain(&LogDrain{Name: "appdrain", URL: "https://app.example.com", App: a.Name})
+	c.Assert(err, check.IsNil)
+	err = AddLogDrain(&LogDrain{Name: "pooldrain", URL: "https://pool.example.com", Pool: a.Pool})
+	c.Assert(err, check.IsNil)
+	err = Delete(&a, nil)
+	c.Assert(err, check.IsNil)
+	drains, err := ListLogDrains(a.Name, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(drains, check.HasLen, 0)
+	drains, err = ListLogDrains("", a.Pool)
+	c.Assert(err, check.IsNil)
+	c.Assert(drains, check.HasLen, 1)
+}
+
+func (s *S) TestLogDrainSenderRetries(c *check.C) {
+	defer func(d time.Duration) { logDrainRetryInterval = d }(logDrainRetryInterval)
+	logDrainRetryInterval = time.Millisecond
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	sender, err := newLogDrainSender(LogDrain{ID: bson.NewObjectId(), Name: "http", URL: server.URL})
+	c.Assert(err, check.IsNil)
+	failures := counterValue(c, logDrainErrors, "http")
+	sent := counterValue(c, logDrainSent, "http")
+	sender.send([]logDrainEntry{{appName: "myapp", log: Applog{Message: "msg1"}}})
+	c.Assert(requests, check.Equals, 3)
+	c.Assert(counterValue(c, logDrainErrors, "http")-failures, check.Equals, float64(2))
+	c.Assert(counterValue(c, logDrainSent, "http")-sent, check.Equals, float64(1))
+}
+
+func (s *S) TestLogDrainSenderDropsAfterRetries(c *check.C) {
+	defer func(d time.Duration) { logDrainRetryInterval = d }(logDrainRetryInterval)
+	logDrainRetryInterval = time.Millisecond
+	config.Set("app-logs:drains:max-retries", 1)
+	defer config.Unset("app-logs")
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	sender, err := newLogDrainSender(LogDrain{ID: bson.NewObjectId(), Name: "http", URL: server.URL})
+	c.Assert(err, check.IsNil)
+	dropped := counterValue(c, logDrainDropped, "http")
+	sender.send([]logDrainEntry{{log: Applog{Message: "msg1"}}, {log: Applog{Message: "msg2"}}})
+	c.Assert(requests, check.Equals, 2)
+	c.Assert(counterValue(c, logDrainDropped, "http")-dropped, check.Equals, float64(2))
+}
+
+func (s *S) TestLogDrainSenderQueueFull(c *check.C) {
+	config.Set("app-logs:drains:queue-size", 2)
+	defer config.Unset("app-logs")
+	sender, err := newLogDrainSender(LogDrain{ID: bson.NewObjectId(), Name: "http", URL: "http://localhost"})
+	c.Assert(err, check.IsNil)
+	dropped := counterValue(c, logDrainDropped, "http")
+	sender.enqueue("myapp", []Applog{{Message: "msg1"}, {Message: "msg2"}, {Message: "msg3"}})
+	c.Assert(sender.ch, check.HasLen, 2)
+	c.Assert(counterValue(c, logDrainDropped, "http")-dropped, check.Equals, float64(1))
+}
+
+func (s *S) TestSyslogDrainWriter(c *check.C) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer listener.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		var msgs []string
+		for len(msgs) < 2 {
+			length, err := reader.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(length))
+			msg := make([]byte, n)
+			_, err = io.ReadFull(reader, msg)
+			if err != nil {
+				return
+			}
+			msgs = append(msgs, string(msg))
+		}
+		received <- msgs
+	}()
+	writer, err := newLogDrainWriter("syslog://" + listener.Addr().String())
+	c.Assert(err, check.IsNil)
+	defer writer.Close()
+	date := time.Date(2017, 6, 16, 15, 0, 0, 0, time.UTC)
+	err = writer.Write([]logDrainEntry{
+		{appName: "myapp", log: Applog{Date: date, Message: "hello world", Source: "web", Unit: "abc123"}},
+		{appName: "myapp", log: Applog{Date: date, Message: "deploy done", Source: "tsuru"}},
+	})
+	c.Assert(err, check.IsNil)
+	select {
+	case msgs := <-received:
+		c.Assert(msgs, check.DeepEquals, []string{
+			"<14>1 2017-06-16T15:00:00.000000Z myapp web abc123 - - hello world",
+			"<14>1 2017-06-16T15:00:00.000000Z myapp tsuru - - - deploy done",
+		})
+	case <-time.After(5 * time.Second):
+		c.Fatal("timeout waiting for syslog messages")
+	}
+}
+
+func (s *S) TestSyslogDrainWriterReconnects(c *check.C) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	addr := listener.Addr().String()
+	listener.Close()
+	writer, err := newLogDrainWriter("syslog://" + addr)
+	c.Assert(err, check.IsNil)
+	entries := []logDrainEntry{{appName: "myapp", log: Applog{Message: "msg1"}}}
+	err = writer.Write(entries)
+	c.Assert(err, check.NotNil)
+	listener, err = net.Listen("tcp", addr)
+	c.Assert(err, check.IsNil)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			ioutil.ReadAll(conn)
+		}
+	}()
+	err = writer.Write(entries)
+	c.Assert(err, check.IsNil)
+	writer.Close()
+}
+
+func (s *S) TestSyslogField(c *check.C) {
+	c.Assert(syslogField("", 10), check.Equals, "-")
+	c.Assert(syslogField("my source", 10), check.Equals, "my_source")
+	c.Assert(syslogField("abcdefghijkl", 10), check.Equals, "abcdefghij")
+	c.Assert(syslogField(fmt.Sprintf("a%cb", 200), 10), check.Equals, "a__b")
+}
+
+func (s *S) TestLogDrainSenderRunStopsWhenIdle(c *check.C) {
+	defer func(d time.Duration) { logDrainIdleTimeout = d }(logDrainIdleTimeout)
+	logDrainIdleTimeout = 50 * time.Millisecond
+	received := make(chan []Applog, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var logs []Applog
+		json.NewDecoder(r.Body).Decode(&logs)
+		received <- logs
+	}))
+	defer server.Close()
+	f := &logForwarder{
+		apps:    make(map[string]appLogDrains),
+		senders: make(map[string]*logDrainSender),
+	}
+	drain := LogDrain{ID: bson.NewObjectId(), Name: "http", URL: server.URL}
+	sender, err := newLogDrainSender(drain)
+	c.Assert(err, check.IsNil)
+	f.senders[drain.key()] = sender
+	sender.enqueue("myapp", []Applog{{Message: "msg1"}, {Message: "msg2"}})
+	done := make(chan bool)
+	go func() {
+		sender.run(f)
+		close(done)
+	}()
+	select {
+	case logs := <-received:
+		c.Assert(logs, check.HasLen, 2)
+		c.Assert(logs[1].Message, check.Equals, "msg2")
+	case <-time.After(5 * time.Second):
+		c.Fatal("timeout waiting for logs")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.Fatal("timeout waiting for sender to stop")
+	}
+	c.Assert(f.senders, check.HasLen, 0)
+}
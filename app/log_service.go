@@ -0,0 +1,268 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	defaultLogService = "mongodb"
+
+	// maxMessageExpressionLength is the maximum length of the regular
+	// expression used to filter log messages.
+	maxMessageExpressionLength = 256
+
+	// messageQueryMaxTime bounds the time spent by MongoDB matching the
+	// message expression, as it uses a backtracking regular expression
+	// engine.
+	messageQueryMaxTime = 10 * time.Second
+)
+
+var logServices = map[string]LogServiceFactory{
+	defaultLogService: func() (LogService, error) { return &mongoLogService{}, nil },
+}
+
+// LogService is the storage of app logs. Realtime listeners are notified
+// through the pubsub queue regardless of the storage in use.
+type LogService interface {
+	// Add stores the log entries of the app.
+	Add(appName string, logs []Applog) error
+	// List returns the last log entries matching the arguments, from the
+	// oldest to the newest one.
+	List(args ListLogArgs) ([]Applog, error)
+	// Remove removes every log entry of the app.
+	Remove(appName string) error
+}
+
+// LogFilter holds the filters used to search app logs, empty fields match
+// every entry. Message is a regular expression matched against any part of
+// the message of the entries, restricted to the syntax shared by every log
+// storage. Since and Until are respectively the inclusive and the exclusive
+// bounds of the date of the entries, the date of the oldest entry received
+// can be used as Until to page backwards.
+type LogFilter struct {
+	Source  string
+	Units   []string
+	Message string
+	Since   time.Time
+	Until   time.Time
+}
+
+// ListLogArgs holds the arguments used to list app logs. A zero Limit
+// returns every matching entry.
+type ListLogArgs struct {
+	LogFilter
+	AppName string
+	Limit   int
+}
+
+// Validate checks the message expression and the time range of the filter.
+func (f *LogFilter) Validate() error {
+	if f.Message != "" {
+		err := validateMessageExpression(f.Message)
+		if err != nil {
+			return &tsuruErrors.ValidationError{Message: fmt.Sprintf("invalid message expression: %s", err)}
+		}
+	}
+	if !f.Since.IsZero() && !f.Until.IsZero() && !f.Since.Before(f.Until) {
+		return &tsuruErrors.ValidationError{Message: "since must be before until"}
+	}
+	return nil
+}
+
+// validateMessageExpression checks that expr is a valid Go regular expression
+// that is interpreted the same way by MongoDB (PCRE) and Elasticsearch
+// (Lucene). Anchors, escape sequences of letters and digits, group flags and
+// the Lucene operators are rejected, special characters must be escaped to be
+// matched literally. Quantified groups containing other quantifiers, such as
+// (a+)+, are also rejected, as they may take exponential time in backtracking
+// engines like PCRE.
+func validateMessageExpression(expr string) error {
+	if len(expr) > maxMessageExpressionLength {
+		return errors.Errorf("expression can't be longer than %d characters", maxMessageExpressionLength)
+	}
+	_, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	inClass := false
+	// quantifiedGroups holds, for each open group, whether it contains a
+	// quantifier.
+	var quantifiedGroups []bool
+	for i := 0; i < len(expr); i++ {
+		ch := expr[i]
+		switch {
+		case ch == '\\':
+			i++
+			if isAlphanumeric(expr[i]) {
+				return errors.Errorf("escape sequence %q is not supported", expr[i-1:i+1])
+			}
+		case inClass:
+			if ch == '[' {
+				return errors.New("character classes can't be nested")
+			}
+			inClass = ch != ']'
+		case ch == '[':
+			inClass = true
+			if i+1 < len(expr) && expr[i+1] == '^' {
+				i++
+			}
+		case ch == '(' && i+1 < len(expr) && expr[i+1] == '?':
+			return errors.New("group flags are not supported")
+		case ch == '(':
+			quantifiedGroups = append(quantifiedGroups, false)
+		case ch == ')':
+			last := len(quantifiedGroups) - 1
+			quantified := quantifiedGroups[last]
+			quantifiedGroups = quantifiedGroups[:last]
+			if !quantified {
+				break
+			}
+			if i+1 < len(expr) && isQuantifier(expr[i+1]) {
+				return errors.New("nested quantifiers are not supported")
+			}
+			if last > 0 {
+				quantifiedGroups[last-1] = true
+			}
+		case isQuantifier(ch):
+			if len(quantifiedGroups) > 0 {
+				quantifiedGroups[len(quantifiedGroups)-1] = true
+			}
+		case strings.IndexByte(`^$~&<>@#"`, ch) != -1:
+			return errors.Errorf("character %q must be escaped", ch)
+		}
+	}
+	return nil
+}
+
+func isQuantifier(ch byte) bool {
+	return ch == '*' || ch == '+' || ch == '?' || ch == '{'
+}
+
+func isAlphanumeric(ch byte) bool {
+	return ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z'
+}
+
+// Matcher returns a function reporting whether log entries match the filter.
+func (f *LogFilter) Matcher() (func(*Applog) bool, error) {
+	err := f.Validate()
+	if err != nil {
+		return nil, err
+	}
+	var messageRE *regexp.Regexp
+	if f.Message != "" {
+		messageRE = regexp.MustCompile(f.Message)
+	}
+	units := make(map[string]bool, len(f.Units))
+	for _, u := range f.Units {
+		units[u] = true
+	}
+	filter := *f
+	return func(l *Applog) bool {
+		return (filter.Source == "" || filter.Source == l.Source) &&
+			(len(units) == 0 || units[l.Unit]) &&
+			(messageRE == nil || messageRE.MatchString(l.Message)) &&
+			(filter.Since.IsZero() || !l.Date.Before(filter.Since)) &&
+			(filter.Until.IsZero() || l.Date.Before(filter.Until))
+	}, nil
+}
+
+type LogServiceFactory func() (LogService, error)
+
+// RegisterLogService registers a new log service, that can be later
+// configured in the app-logs:storage setting.
+func RegisterLogService(name string, factory LogServiceFactory) {
+	logServices[name] = factory
+}
+
+// GetLogService returns the log service configured in app-logs:storage,
+// defaulting to mongodb.
+func GetLogService() (LogService, error) {
+	name, err := config.GetString("app-logs:storage")
+	if err != nil {
+		name = defaultLogService
+	}
+	factory, ok := logServices[name]
+	if !ok {
+		return nil, errors.Errorf("unknown app log storage: %q", name)
+	}
+	return factory()
+}
+
+type mongoLogService struct{}
+
+func (s *mongoLogService) Add(appName string, logs []Applog) error {
+	conn, err := db.LogConn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	docs := make([]interface{}, len(logs))
+	for i := range logs {
+		docs[i] = logs[i]
+	}
+	return conn.Logs(appName).Insert(docs...)
+}
+
+func (s *mongoLogService) List(args ListLogArgs) ([]Applog, error) {
+	conn, err := db.LogConn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	logs := []Applog{}
+	q := bson.M{}
+	if args.Source != "" {
+		q["source"] = args.Source
+	}
+	if len(args.Units) > 0 {
+		q["unit"] = bson.M{"$in": args.Units}
+	}
+	if args.Message != "" {
+		q["message"] = bson.M{"$regex": args.Message}
+	}
+	dateQuery := bson.M{}
+	if !args.Since.IsZero() {
+		dateQuery["$gte"] = args.Since
+	}
+	if !args.Until.IsZero() {
+		dateQuery["$lt"] = args.Until
+	}
+	if len(dateQuery) > 0 {
+		q["date"] = dateQuery
+	}
+	query := conn.Logs(args.AppName).Find(q).Sort("-$natural").Limit(args.Limit)
+	if args.Message != "" {
+		query.SetMaxTime(messageQueryMaxTime)
+	}
+	err = query.All(&logs)
+	if err != nil {
+		return nil, err
+	}
+	l := len(logs)
+	for i := 0; i < l/2; i++ {
+		logs[i], logs[l-1-i] = logs[l-1-i], logs[i]
+	}
+	return logs, nil
+}
+
+func (s *mongoLogService) Remove(appName string) error {
+	conn, err := db.LogConn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Logs(appName).DropCollection()
+}
@@ -0,0 +1,159 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"strings"
+	"time"
+
+	"github.com/tsuru/config"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"gopkg.in/check.v1"
+)
+
+type fakeLogService struct {
+	logs []Applog
+}
+
+func (s *fakeLogService) Add(appName string, logs []Applog) error {
+	s.logs = append(s.logs, logs...)
+	return nil
+}
+
+func (s *fakeLogService) List(args ListLogArgs) ([]Applog, error) {
+	return s.logs, nil
+}
+
+func (s *fakeLogService) Remove(appName string) error {
+	s.logs = nil
+	return nil
+}
+
+func (s *S) TestGetLogServiceDefault(c *check.C) {
+	svc, err := GetLogService()
+	c.Assert(err, check.IsNil)
+	c.Assert(svc, check.FitsTypeOf, &mongoLogService{})
+}
+
+func (s *S) TestGetLogServiceUnknown(c *check.C) {
+	config.Set("app-logs:storage", "unknown")
+	defer config.Unset("app-logs")
+	_, err := GetLogService()
+	c.Assert(err, check.ErrorMatches, `unknown app log storage: "unknown"`)
+}
+
+func (s *S) TestRegisterLogService(c *check.C) {
+	fake := &fakeLogService{}
+	RegisterLogService("fake", func() (LogService, error) { return fake, nil })
+	defer delete(logServices, "fake")
+	config.Set("app-logs:storage", "fake")
+	defer config.Unset("app-logs")
+	a := App{Name: "myapp"}
+	err := a.Log("msg1\nmsg2", "tsuru", "unit1")
+	c.Assert(err, check.IsNil)
+	c.Assert(fake.logs, check.HasLen, 2)
+	c.Assert(fake.logs[0].Message, check.Equals, "msg1")
+	c.Assert(fake.logs[1].Message, check.Equals, "msg2")
+	c.Assert(fake.logs[1].AppName, check.Equals, "myapp")
+}
+
+func (s *S) TestMongoLogService(c *check.C) {
+	svc := &mongoLogService{}
+	baseTime := time.Date(2017, 6, 16, 15, 0, 0, 0, time.UTC).Local()
+	logs := []Applog{
+		{Date: baseTime, Message: "msg1", Source: "web", AppName: "myapp", Unit: "unit1"},
+		{Date: baseTime.Add(time.Second), Message: "msg2", Source: "tsuru", AppName: "myapp", Unit: "unit1"},
+		{Date: baseTime.Add(2 * time.Second), Message: "msg3", Source: "web", AppName: "myapp", Unit: "unit2"},
+	}
+	err := svc.Add("myapp", logs)
+	c.Assert(err, check.IsNil)
+	result, err := svc.List(ListLogArgs{AppName: "myapp", Limit: 2})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, logs[1:])
+	result, err = svc.List(ListLogArgs{AppName: "myapp", LogFilter: LogFilter{Source: "web"}})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, []Applog{logs[0], logs[2]})
+	result, err = svc.List(ListLogArgs{AppName: "myapp", LogFilter: LogFilter{Source: "web", Units: []string{"unit2"}}})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, logs[2:])
+	result, err = svc.List(ListLogArgs{AppName: "myapp", LogFilter: LogFilter{Units: []string{"unit1", "unit2"}, Message: "msg[13]"}})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, []Applog{logs[0], logs[2]})
+	result, err = svc.List(ListLogArgs{AppName: "myapp", LogFilter: LogFilter{Since: logs[1].Date, Until: logs[2].Date}})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, logs[1:2])
+	result, err = svc.List(ListLogArgs{AppName: "myapp", Limit: 1, LogFilter: LogFilter{Until: logs[2].Date}})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, logs[1:2])
+	err = svc.Remove("myapp")
+	c.Assert(err, check.IsNil)
+	result, err = svc.List(ListLogArgs{AppName: "myapp"})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 0)
+}
+
+func (s *S) TestLogFilterValidate(c *check.C) {
+	now := time.Now()
+	tests := []struct {
+		filter LogFilter
+		err    string
+	}{
+		{LogFilter{}, ""},
+		{LogFilter{Message: "err(or)?", Since: now, Until: now.Add(time.Second)}, ""},
+		{LogFilter{Message: "("}, "invalid message expression: .*"},
+		{LogFilter{Message: `GET /[a-z]+\.(png|jpe?g) \$[^ ]*`}, ""},
+		{LogFilter{Message: "^err"}, `invalid message expression: character '\^' must be escaped`},
+		{LogFilter{Message: "err$"}, `invalid message expression: character '\$' must be escaped`},
+		{LogFilter{Message: "user@host"}, `invalid message expression: character '@' must be escaped`},
+		{LogFilter{Message: `\d+`}, `invalid message expression: escape sequence "\\\\d" is not supported`},
+		{LogFilter{Message: "(?i)err"}, "invalid message expression: group flags are not supported"},
+		{LogFilter{Message: "[[:alpha:]]"}, "invalid message expression: character classes can't be nested"},
+		{LogFilter{Message: "(a+)+b"}, "invalid message expression: nested quantifiers are not supported"},
+		{LogFilter{Message: "((ab)*c)*"}, "invalid message expression: nested quantifiers are not supported"},
+		{LogFilter{Message: "(x(a{2,})y)?"}, "invalid message expression: nested quantifiers are not supported"},
+		{LogFilter{Message: `(a\+)+ [(+]+`}, ""},
+		{LogFilter{Message: strings.Repeat("a", maxMessageExpressionLength+1)}, "invalid message expression: expression can't be longer than 256 characters"},
+		{LogFilter{Since: now, Until: now}, "since must be before until"},
+	}
+	for _, tt := range tests {
+		err := tt.filter.Validate()
+		if tt.err == "" {
+			c.Check(err, check.IsNil)
+		} else {
+			c.Check(err, check.ErrorMatches, tt.err)
+			c.Check(err, check.FitsTypeOf, &tsuruErrors.ValidationError{})
+		}
+	}
+}
+
+func (s *S) TestLogFilterMatcher(c *check.C) {
+	now := time.Now()
+	filter := LogFilter{
+		Source:  "web",
+		Units:   []string{"unit1", "unit2"},
+		Message: "err(or)?",
+		Since:   now,
+		Until:   now.Add(time.Minute),
+	}
+	match, err := filter.Matcher()
+	c.Assert(err, check.IsNil)
+	base := Applog{Date: now, Message: "error", Source: "web", Unit: "unit2"}
+	c.Assert(match(&base), check.Equals, true)
+	tests := []func(l *Applog){
+		func(l *Applog) { l.Source = "tsuru" },
+		func(l *Applog) { l.Unit = "unit3" },
+		func(l *Applog) { l.Message = "no error" },
+		func(l *Applog) { l.Date = now.Add(-time.Second) },
+		func(l *Applog) { l.Date = now.Add(time.Minute) },
+	}
+	for i, change := range tests {
+		l := base
+		change(&l)
+		c.Check(match(&l), check.Equals, false, check.Commentf("test %d", i))
+	}
+	match, err = (&LogFilter{}).Matcher()
+	c.Assert(err, check.IsNil)
+	c.Assert(match(&Applog{}), check.Equals, true)
+}
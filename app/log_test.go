@@ -16,7 +16,7 @@ import (
 
 func (s *S) TestNewLogListener(c *check.C) {
 	app := App{Name: "myapp"}
-	l, err := NewLogListener(&app, Applog{})
+	l, err := NewLogListener(&app, LogFilter{})
 	c.Assert(err, check.IsNil)
 	defer l.Close()
 	c.Assert(l.q, check.NotNil)
@@ -28,7 +28,7 @@ func (s *S) TestNewLogListener(c *check.C) {
 
 func (s *S) TestNewLogListenerClosingChannel(c *check.C) {
 	app := App{Name: "myapp"}
-	l, err := NewLogListener(&app, Applog{})
+	l, err := NewLogListener(&app, LogFilter{})
 	c.Assert(err, check.IsNil)
 	c.Assert(l.q, check.NotNil)
 	c.Assert(l.c, check.NotNil)
@@ -39,7 +39,7 @@ func (s *S) TestNewLogListenerClosingChannel(c *check.C) {
 
 func (s *S) TestLogListenerClose(c *check.C) {
 	app := App{Name: "myapp"}
-	l, err := NewLogListener(&app, Applog{})
+	l, err := NewLogListener(&app, LogFilter{})
 	c.Assert(err, check.IsNil)
 	err = l.Close()
 	c.Assert(err, check.IsNil)
@@ -49,7 +49,7 @@ func (s *S) TestLogListenerClose(c *check.C) {
 
 func (s *S) TestLogListenerDoubleClose(c *check.C) {
 	app := App{Name: "yourapp"}
-	l, err := NewLogListener(&app, Applog{})
+	l, err := NewLogListener(&app, LogFilter{})
 	c.Assert(err, check.IsNil)
 	err = l.Close()
 	c.Assert(err, check.IsNil)
@@ -63,7 +63,7 @@ func (s *S) TestNotify(c *check.C) {
 		sync.Mutex
 	}
 	app := App{Name: "fade"}
-	l, err := NewLogListener(&app, Applog{})
+	l, err := NewLogListener(&app, LogFilter{})
 	c.Assert(err, check.IsNil)
 	defer l.Close()
 	go func() {
@@ -114,7 +114,7 @@ func (s *S) TestNotifyFiltered(c *check.C) {
 		sync.Mutex
 	}
 	app := App{Name: "fade"}
-	l, err := NewLogListener(&app, Applog{Source: "tsuru", Unit: "unit1"})
+	l, err := NewLogListener(&app, LogFilter{Source: "tsuru", Units: []string{"unit1"}})
 	c.Assert(err, check.IsNil)
 	defer l.Close()
 	go func() {
@@ -168,7 +168,7 @@ func (s *S) TestNotifySendOnClosedChannel(c *check.C) {
 		c.Assert(recover(), check.IsNil)
 	}()
 	app := App{Name: "fade"}
-	l, err := NewLogListener(&app, Applog{})
+	l, err := NewLogListener(&app, LogFilter{})
 	c.Assert(err, check.IsNil)
 	err = l.Close()
 	c.Assert(err, check.IsNil)
@@ -193,7 +193,7 @@ func (s *S) TestLogDispatcherSend(c *check.C) {
 	timeout := time.After(5 * time.Second)
 loop:
 	for {
-		logs, logsErr := app.LastLogs(1, Applog{})
+		logs, logsErr := app.LastLogs(1, LogFilter{})
 		c.Assert(logsErr, check.IsNil)
 		if len(logs) == 1 {
 			break
@@ -207,7 +207,7 @@ loop:
 		}
 	}
 	dispatcher.Stop()
-	logs, err := app.LastLogs(1, Applog{})
+	logs, err := app.LastLogs(1, LogFilter{})
 	c.Assert(err, check.IsNil)
 	c.Assert(logs, check.DeepEquals, []Applog{logMsg})
 }
@@ -243,7 +243,7 @@ func (s *S) TestLogDispatcherSendDBFailure(c *check.C) {
 	timeout := time.After(10 * time.Second)
 loop:
 	for {
-		logs, logsErr := app.LastLogs(10, Applog{})
+		logs, logsErr := app.LastLogs(10, LogFilter{})
 		c.Assert(logsErr, check.IsNil)
 		if len(logs) == 10 {
 			break
@@ -0,0 +1,279 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package elasticsearch implements an app log storage using the HTTP API of
+// Elasticsearch compatible servers. Logs are written to daily indices.
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+	tsuruNet "github.com/tsuru/tsuru/net"
+)
+
+const (
+	defaultIndexPrefix = "tsuru-logs"
+	defaultDocType     = "applog"
+	// maxResults is the default max result window of Elasticsearch indices.
+	maxResults = 10000
+)
+
+// templates keeps the index templates already created, indexed by the
+// server URL and index prefix.
+var templates = struct {
+	sync.Mutex
+	created map[string]bool
+}{created: make(map[string]bool)}
+
+func init() {
+	app.RegisterLogService("elasticsearch", createLogService)
+}
+
+type esLogService struct {
+	url         string
+	indexPrefix string
+	docType     string
+	client      *http.Client
+}
+
+type esDoc struct {
+	Date    time.Time `json:"date"`
+	Message string    `json:"message"`
+	Source  string    `json:"source"`
+	AppName string    `json:"app"`
+	Unit    string    `json:"unit"`
+}
+
+func createLogService() (app.LogService, error) {
+	url, err := config.GetString("app-logs:elasticsearch:url")
+	if err != nil {
+		return nil, errors.New("app-logs:elasticsearch:url is required")
+	}
+	indexPrefix, err := config.GetString("app-logs:elasticsearch:index-prefix")
+	if err != nil {
+		indexPrefix = defaultIndexPrefix
+	}
+	docType, err := config.GetString("app-logs:elasticsearch:doc-type")
+	if err != nil {
+		docType = defaultDocType
+	}
+	return &esLogService{
+		url:         strings.TrimRight(url, "/"),
+		indexPrefix: indexPrefix,
+		docType:     docType,
+		client:      tsuruNet.Dial5Full300Client,
+	}, nil
+}
+
+func (s *esLogService) index(date time.Time) string {
+	return s.indexPrefix + "-" + date.UTC().Format("2006.01.02")
+}
+
+func (s *esLogService) do(method, path, contentType string, body []byte, result interface{}) error {
+	req, err := http.NewRequest(method, s.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	rsp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	data, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return err
+	}
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return errors.Errorf("invalid response from elasticsearch %s %s: %d - %s", method, path, rsp.StatusCode, data)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
+
+func (s *esLogService) doJSON(method, path string, body, result interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return s.do(method, path, "application/json", data, result)
+}
+
+// ensureTemplate creates the index template mapping the filterable fields as
+// keywords, so they can be used in term queries. Messages are also indexed as
+// keywords in message.raw, to be matched by regular expressions.
+func (s *esLogService) ensureTemplate() error {
+	key := s.url + "/" + s.indexPrefix
+	templates.Lock()
+	defer templates.Unlock()
+	if templates.created[key] {
+		return nil
+	}
+	template := map[string]interface{}{
+		"template": s.indexPrefix + "-*",
+		"mappings": map[string]interface{}{
+			s.docType: map[string]interface{}{
+				"properties": map[string]interface{}{
+					"date": map[string]string{"type": "date"},
+					"message": map[string]interface{}{
+						"type": "text",
+						"fields": map[string]interface{}{
+							"raw": map[string]interface{}{"type": "keyword", "ignore_above": 8191},
+						},
+					},
+					"source": map[string]string{"type": "keyword"},
+					"app":    map[string]string{"type": "keyword"},
+					"unit":   map[string]string{"type": "keyword"},
+				},
+			},
+		},
+	}
+	err := s.doJSON("PUT", "/_template/"+s.indexPrefix, template, nil)
+	if err != nil {
+		return err
+	}
+	templates.created[key] = true
+	return nil
+}
+
+func (s *esLogService) Add(appName string, logs []app.Applog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	err := s.ensureTemplate()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, l := range logs {
+		action := map[string]interface{}{
+			"index": map[string]string{"_index": s.index(l.Date), "_type": s.docType},
+		}
+		err = encoder.Encode(action)
+		if err != nil {
+			return err
+		}
+		err = encoder.Encode(esDoc{
+			Date:    l.Date,
+			Message: l.Message,
+			Source:  l.Source,
+			AppName: appName,
+			Unit:    l.Unit,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	var result struct {
+		Errors bool
+		Items  []map[string]struct {
+			Error json.RawMessage
+		}
+	}
+	err = s.do("POST", "/_bulk", "application/x-ndjson", buf.Bytes(), &result)
+	if err != nil {
+		return err
+	}
+	if !result.Errors {
+		return nil
+	}
+	for _, item := range result.Items {
+		for _, action := range item {
+			if len(action.Error) > 0 {
+				return errors.Errorf("unable to index app logs: %s", action.Error)
+			}
+		}
+	}
+	return errors.New("unable to index app logs")
+}
+
+func (s *esLogService) query(args app.ListLogArgs) map[string]interface{} {
+	filters := []interface{}{
+		map[string]interface{}{"term": map[string]string{"app": args.AppName}},
+	}
+	if args.Source != "" {
+		filters = append(filters, map[string]interface{}{"term": map[string]string{"source": args.Source}})
+	}
+	if len(args.Units) > 0 {
+		filters = append(filters, map[string]interface{}{"terms": map[string][]string{"unit": args.Units}})
+	}
+	if args.Message != "" {
+		// Lucene regular expressions are anchored to the whole value.
+		filters = append(filters, map[string]interface{}{"regexp": map[string]string{"message.raw": ".*(" + args.Message + ").*"}})
+	}
+	dateRange := map[string]interface{}{}
+	if !args.Since.IsZero() {
+		dateRange["gte"] = args.Since
+	}
+	if !args.Until.IsZero() {
+		dateRange["lt"] = args.Until
+	}
+	if len(dateRange) > 0 {
+		filters = append(filters, map[string]interface{}{"range": map[string]interface{}{"date": dateRange}})
+	}
+	return map[string]interface{}{
+		"bool": map[string]interface{}{"filter": filters},
+	}
+}
+
+func (s *esLogService) List(args app.ListLogArgs) ([]app.Applog, error) {
+	err := args.Validate()
+	if err != nil {
+		return nil, err
+	}
+	size := args.Limit
+	if size <= 0 || size > maxResults {
+		size = maxResults
+	}
+	body := map[string]interface{}{
+		"size":  size,
+		"sort":  []interface{}{map[string]interface{}{"date": map[string]string{"order": "desc"}}},
+		"query": s.query(args),
+	}
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				Source esDoc `json:"_source"`
+			}
+		}
+	}
+	path := fmt.Sprintf("/%s-*/_search", s.indexPrefix)
+	err = s.doJSON("POST", path, body, &result)
+	if err != nil {
+		return nil, err
+	}
+	hits := result.Hits.Hits
+	logs := make([]app.Applog, len(hits))
+	for i, hit := range hits {
+		logs[len(hits)-1-i] = app.Applog{
+			Date:    hit.Source.Date,
+			Message: hit.Source.Message,
+			Source:  hit.Source.Source,
+			AppName: hit.Source.AppName,
+			Unit:    hit.Source.Unit,
+		}
+	}
+	return logs, nil
+}
+
+func (s *esLogService) Remove(appName string) error {
+	body := map[string]interface{}{
+		"query": s.query(app.ListLogArgs{AppName: appName}),
+	}
+	path := fmt.Sprintf("/%s-*/_delete_by_query", s.indexPrefix)
+	return s.doJSON("POST", path, body, nil)
+}
@@ -0,0 +1,197 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package elasticsearch
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type request struct {
+	method string
+	path   string
+	body   string
+}
+
+type S struct {
+	server   *httptest.Server
+	requests []request
+	response string
+}
+
+var _ = check.Suite(&S{})
+
+func (s *S) SetUpTest(c *check.C) {
+	s.requests = nil
+	s.response = "{}"
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.requests = append(s.requests, request{method: r.Method, path: r.URL.Path, body: string(body)})
+		w.Write([]byte(s.response))
+	}))
+	templates.Lock()
+	templates.created = make(map[string]bool)
+	templates.Unlock()
+	config.Set("app-logs:storage", "elasticsearch")
+	config.Set("app-logs:elasticsearch:url", s.server.URL+"/")
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	s.server.Close()
+	config.Unset("app-logs")
+}
+
+func (s *S) service(c *check.C) *esLogService {
+	svc, err := app.GetLogService()
+	c.Assert(err, check.IsNil)
+	c.Assert(svc, check.FitsTypeOf, &esLogService{})
+	return svc.(*esLogService)
+}
+
+func (s *S) TestGetLogService(c *check.C) {
+	svc := s.service(c)
+	c.Assert(svc.url, check.Equals, s.server.URL)
+	c.Assert(svc.indexPrefix, check.Equals, "tsuru-logs")
+	c.Assert(svc.docType, check.Equals, "applog")
+}
+
+func (s *S) TestGetLogServiceNoURL(c *check.C) {
+	config.Unset("app-logs:elasticsearch:url")
+	_, err := app.GetLogService()
+	c.Assert(err, check.ErrorMatches, "app-logs:elasticsearch:url is required")
+}
+
+func (s *S) TestAdd(c *check.C) {
+	svc := s.service(c)
+	s.response = `{"errors": false, "items": []}`
+	date := time.Date(2017, 6, 16, 15, 0, 0, 0, time.UTC)
+	logs := []app.Applog{
+		{Date: date, Message: "msg1", Source: "web", AppName: "myapp", Unit: "unit1"},
+		{Date: date.Add(24 * time.Hour), Message: "msg2", Source: "tsuru", AppName: "myapp", Unit: "unit2"},
+	}
+	err := svc.Add("myapp", logs)
+	c.Assert(err, check.IsNil)
+	err = svc.Add("myapp", logs[:1])
+	c.Assert(err, check.IsNil)
+	c.Assert(s.requests, check.HasLen, 3)
+	c.Assert(s.requests[0].method, check.Equals, "PUT")
+	c.Assert(s.requests[0].path, check.Equals, "/_template/tsuru-logs")
+	var template map[string]interface{}
+	err = json.Unmarshal([]byte(s.requests[0].body), &template)
+	c.Assert(err, check.IsNil)
+	c.Assert(template["template"], check.Equals, "tsuru-logs-*")
+	c.Assert(s.requests[1].method, check.Equals, "POST")
+	c.Assert(s.requests[1].path, check.Equals, "/_bulk")
+	lines := strings.Split(strings.TrimSpace(s.requests[1].body), "\n")
+	c.Assert(lines, check.DeepEquals, []string{
+		`{"index":{"_index":"tsuru-logs-2017.06.16","_type":"applog"}}`,
+		`{"date":"2017-06-16T15:00:00Z","message":"msg1","source":"web","app":"myapp","unit":"unit1"}`,
+		`{"index":{"_index":"tsuru-logs-2017.06.17","_type":"applog"}}`,
+		`{"date":"2017-06-17T15:00:00Z","message":"msg2","source":"tsuru","app":"myapp","unit":"unit2"}`,
+	})
+	c.Assert(s.requests[2].path, check.Equals, "/_bulk")
+}
+
+func (s *S) TestAddItemErrors(c *check.C) {
+	svc := s.service(c)
+	s.response = `{"errors": true, "items": [{"index": {"status": 201}}, {"index": {"status": 400, "error": {"type": "mapper_parsing_exception"}}}]}`
+	err := svc.Add("myapp", []app.Applog{{Date: time.Now(), Message: "msg1"}})
+	c.Assert(err, check.ErrorMatches, `unable to index app logs: {"type": "mapper_parsing_exception"}`)
+}
+
+func (s *S) TestAddInvalidResponse(c *check.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	config.Set("app-logs:elasticsearch:url", server.URL)
+	svc := s.service(c)
+	err := svc.Add("myapp", []app.Applog{{Date: time.Now(), Message: "msg1"}})
+	c.Assert(err, check.ErrorMatches, `(?s)invalid response from elasticsearch PUT /_template/tsuru-logs: 503 - unavailable.*`)
+}
+
+func (s *S) TestList(c *check.C) {
+	svc := s.service(c)
+	s.response = `{"hits": {"hits": [
+		{"_source": {"date":"2017-06-16T15:00:01Z","message":"msg2","source":"web","app":"myapp","unit":"unit1"}},
+		{"_source": {"date":"2017-06-16T15:00:00Z","message":"msg1","source":"web","app":"myapp","unit":"unit1"}}
+	]}}`
+	logs, err := svc.List(app.ListLogArgs{AppName: "myapp", LogFilter: app.LogFilter{Source: "web"}, Limit: 2})
+	c.Assert(err, check.IsNil)
+	date := time.Date(2017, 6, 16, 15, 0, 0, 0, time.UTC)
+	c.Assert(logs, check.DeepEquals, []app.Applog{
+		{Date: date, Message: "msg1", Source: "web", AppName: "myapp", Unit: "unit1"},
+		{Date: date.Add(time.Second), Message: "msg2", Source: "web", AppName: "myapp", Unit: "unit1"},
+	})
+	c.Assert(s.requests, check.HasLen, 1)
+	c.Assert(s.requests[0].method, check.Equals, "POST")
+	c.Assert(s.requests[0].path, check.Equals, "/tsuru-logs-*/_search")
+	c.Assert(s.requests[0].body, check.Equals, `{"query":{"bool":{"filter":[{"term":{"app":"myapp"}},{"term":{"source":"web"}}]}},"size":2,"sort":[{"date":{"order":"desc"}}]}`)
+}
+
+func (s *S) TestListNoLimit(c *check.C) {
+	svc := s.service(c)
+	logs, err := svc.List(app.ListLogArgs{AppName: "myapp", LogFilter: app.LogFilter{Units: []string{"unit1"}}})
+	c.Assert(err, check.IsNil)
+	c.Assert(logs, check.DeepEquals, []app.Applog{})
+	c.Assert(s.requests[0].body, check.Equals, `{"query":{"bool":{"filter":[{"term":{"app":"myapp"}},{"terms":{"unit":["unit1"]}}]}},"size":10000,"sort":[{"date":{"order":"desc"}}]}`)
+}
+
+func (s *S) TestListSearch(c *check.C) {
+	svc := s.service(c)
+	since := time.Date(2017, 6, 16, 15, 0, 0, 0, time.UTC)
+	filter := app.LogFilter{
+		Units:   []string{"unit1", "unit2"},
+		Message: "error|panic",
+		Since:   since,
+		Until:   since.Add(time.Hour),
+	}
+	_, err := svc.List(app.ListLogArgs{AppName: "myapp", LogFilter: filter, Limit: 10})
+	c.Assert(err, check.IsNil)
+	var body map[string]interface{}
+	err = json.Unmarshal([]byte(s.requests[0].body), &body)
+	c.Assert(err, check.IsNil)
+	filters := body["query"].(map[string]interface{})["bool"].(map[string]interface{})["filter"]
+	c.Assert(filters, check.DeepEquals, []interface{}{
+		map[string]interface{}{"term": map[string]interface{}{"app": "myapp"}},
+		map[string]interface{}{"terms": map[string]interface{}{"unit": []interface{}{"unit1", "unit2"}}},
+		map[string]interface{}{"regexp": map[string]interface{}{"message.raw": ".*(error|panic).*"}},
+		map[string]interface{}{"range": map[string]interface{}{"date": map[string]interface{}{
+			"gte": "2017-06-16T15:00:00Z",
+			"lt":  "2017-06-16T16:00:00Z",
+		}}},
+	})
+}
+
+func (s *S) TestListInvalidFilter(c *check.C) {
+	svc := s.service(c)
+	since := time.Now()
+	_, err := svc.List(app.ListLogArgs{AppName: "myapp", LogFilter: app.LogFilter{Since: since, Until: since}})
+	c.Assert(err, check.ErrorMatches, "since must be before until")
+	c.Assert(s.requests, check.HasLen, 0)
+}
+
+func (s *S) TestRemove(c *check.C) {
+	svc := s.service(c)
+	err := svc.Remove("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(s.requests, check.HasLen, 1)
+	c.Assert(s.requests[0].method, check.Equals, "POST")
+	c.Assert(s.requests[0].path, check.Equals, "/tsuru-logs-*/_delete_by_query")
+	c.Assert(s.requests[0].body, check.Equals, `{"query":{"bool":{"filter":[{"term":{"app":"myapp"}}]}}}`)
+}
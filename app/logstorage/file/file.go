@@ -0,0 +1,276 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package file implements an app log storage writing each app log to JSON
+// lines files in the local filesystem. Files are rotated and compressed once
+// they reach a maximum size.
+package file
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+)
+
+const (
+	currentFile      = "current.log"
+	rotatedSuffix    = ".log.gz"
+	rotatedTimeFmt   = "20060102T150405.000000000Z"
+	defaultDir       = "/var/lib/tsuru/app-logs"
+	defaultMaxSizeMB = 100
+	defaultMaxFiles  = 10
+)
+
+// appLocks serializes the access to the files of each app, as every log
+// service instance shares the same directory.
+var appLocks = struct {
+	sync.Mutex
+	m map[string]*sync.Mutex
+}{m: make(map[string]*sync.Mutex)}
+
+func init() {
+	app.RegisterLogService("file", createLogService)
+}
+
+type fileLogService struct {
+	dir      string
+	maxSize  int64
+	maxFiles int
+	maxAge   time.Duration
+}
+
+func createLogService() (app.LogService, error) {
+	dir, err := config.GetString("app-logs:file:dir")
+	if err != nil {
+		dir = defaultDir
+	}
+	maxSize, err := config.GetInt("app-logs:file:max-size")
+	if err != nil {
+		maxSize = defaultMaxSizeMB
+	}
+	maxFiles, err := config.GetInt("app-logs:file:max-files")
+	if err != nil {
+		maxFiles = defaultMaxFiles
+	}
+	maxAge, _ := config.GetInt("app-logs:file:max-age")
+	return &fileLogService{
+		dir:      dir,
+		maxSize:  int64(maxSize) * 1024 * 1024,
+		maxFiles: maxFiles,
+		maxAge:   time.Duration(maxAge) * 24 * time.Hour,
+	}, nil
+}
+
+func lockApp(appName string) func() {
+	appLocks.Lock()
+	mu, ok := appLocks.m[appName]
+	if !ok {
+		mu = &sync.Mutex{}
+		appLocks.m[appName] = mu
+	}
+	appLocks.Unlock()
+	mu.Lock()
+	return mu.Unlock
+}
+
+func (s *fileLogService) appDir(appName string) string {
+	return filepath.Join(s.dir, appName)
+}
+
+func (s *fileLogService) Add(appName string, logs []app.Applog) error {
+	defer lockApp(appName)()
+	dir := s.appDir(appName)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, currentFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, l := range logs {
+		err = encoder.Encode(l)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	info, err := f.Stat()
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if info.Size() < s.maxSize {
+		return nil
+	}
+	err = s.rotate(dir)
+	if err != nil {
+		return err
+	}
+	return s.prune(dir)
+}
+
+// rotate compresses the current file into a file named after the rotation
+// time, so rotated files sort from the oldest to the newest one.
+func (s *fileLogService) rotate(dir string) error {
+	current := filepath.Join(dir, currentFile)
+	src, err := os.Open(current)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	name := filepath.Join(dir, time.Now().UTC().Format(rotatedTimeFmt)+rotatedSuffix)
+	dst, err := os.OpenFile(name+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name + ".tmp")
+		return err
+	}
+	err = os.Rename(name+".tmp", name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(current)
+}
+
+// prune removes the oldest rotated files exceeding max-files, along with
+// files older than max-age.
+func (s *fileLogService) prune(dir string) error {
+	files, err := rotatedFiles(dir)
+	if err != nil {
+		return err
+	}
+	for i, name := range files {
+		remove := s.maxFiles > 0 && i < len(files)-s.maxFiles
+		if !remove && s.maxAge > 0 {
+			info, err := os.Stat(name)
+			if err != nil {
+				return err
+			}
+			remove = time.Since(info.ModTime()) > s.maxAge
+		}
+		if remove {
+			err = os.Remove(name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func rotatedFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+rotatedSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (s *fileLogService) List(args app.ListLogArgs) ([]app.Applog, error) {
+	defer lockApp(args.AppName)()
+	dir := s.appDir(args.AppName)
+	files, err := rotatedFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, filepath.Join(dir, currentFile))
+	match, err := args.Matcher()
+	if err != nil {
+		return nil, err
+	}
+	logs := []app.Applog{}
+	for i := len(files) - 1; i >= 0; i-- {
+		limit := 0
+		if args.Limit > 0 {
+			limit = args.Limit - len(logs)
+			if limit <= 0 {
+				break
+			}
+		}
+		fileLogs, err := readFile(files[i], match, limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(fileLogs) > 0 {
+			logs = append(fileLogs, logs...)
+		}
+	}
+	return logs, nil
+}
+
+// readFile returns the last limit entries of the file accepted by match, or
+// every accepted entry when limit is zero.
+func readFile(name string, match func(*app.Applog) bool, limit int) ([]app.Applog, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(name, rotatedSuffix) {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	var logs []app.Applog
+	decoder := json.NewDecoder(r)
+	for {
+		var l app.Applog
+		err = decoder.Decode(&l)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !match(&l) {
+			continue
+		}
+		logs = append(logs, l)
+		if limit > 0 && len(logs) > limit {
+			logs = logs[1:]
+		}
+	}
+	return logs, nil
+}
+
+func (s *fileLogService) Remove(appName string) error {
+	defer lockApp(appName)()
+	return os.RemoveAll(s.appDir(appName))
+}
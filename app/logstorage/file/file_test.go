@@ -0,0 +1,204 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package file
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct {
+	dir string
+}
+
+var _ = check.Suite(&S{})
+
+func (s *S) SetUpTest(c *check.C) {
+	var err error
+	s.dir, err = ioutil.TempDir("", "tsuru-app-logs")
+	c.Assert(err, check.IsNil)
+	config.Set("app-logs:storage", "file")
+	config.Set("app-logs:file:dir", s.dir)
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	os.RemoveAll(s.dir)
+	config.Unset("app-logs")
+}
+
+func (s *S) logs(n int, source string) []app.Applog {
+	baseTime := time.Date(2017, 6, 16, 15, 0, 0, 0, time.UTC)
+	logs := make([]app.Applog, n)
+	for i := range logs {
+		logs[i] = app.Applog{
+			Date:    baseTime.Add(time.Duration(i) * time.Second),
+			Message: fmt.Sprintf("msg%d", i),
+			Source:  source,
+			AppName: "myapp",
+			Unit:    "unit1",
+		}
+	}
+	return logs
+}
+
+func (s *S) TestGetLogService(c *check.C) {
+	svc, err := app.GetLogService()
+	c.Assert(err, check.IsNil)
+	c.Assert(svc, check.FitsTypeOf, &fileLogService{})
+	fileSvc := svc.(*fileLogService)
+	c.Assert(fileSvc.dir, check.Equals, s.dir)
+	c.Assert(fileSvc.maxSize, check.Equals, int64(100*1024*1024))
+	c.Assert(fileSvc.maxFiles, check.Equals, 10)
+	c.Assert(fileSvc.maxAge, check.Equals, time.Duration(0))
+}
+
+func (s *S) TestAddAndList(c *check.C) {
+	svc := &fileLogService{dir: s.dir, maxSize: 1024 * 1024}
+	logs := s.logs(5, "web")
+	err := svc.Add("myapp", logs[:3])
+	c.Assert(err, check.IsNil)
+	err = svc.Add("myapp", logs[3:])
+	c.Assert(err, check.IsNil)
+	result, err := svc.List(app.ListLogArgs{AppName: "myapp"})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, logs)
+	result, err = svc.List(app.ListLogArgs{AppName: "myapp", Limit: 2})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, logs[3:])
+	_, err = os.Stat(filepath.Join(s.dir, "myapp", "current.log"))
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestListFiltered(c *check.C) {
+	svc := &fileLogService{dir: s.dir, maxSize: 1024 * 1024}
+	webLogs := s.logs(3, "web")
+	tsuruLogs := s.logs(2, "tsuru")
+	tsuruLogs[1].Unit = "unit2"
+	err := svc.Add("myapp", append(webLogs, tsuruLogs...))
+	c.Assert(err, check.IsNil)
+	result, err := svc.List(app.ListLogArgs{AppName: "myapp", LogFilter: app.LogFilter{Source: "tsuru"}})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, tsuruLogs)
+	result, err = svc.List(app.ListLogArgs{AppName: "myapp", LogFilter: app.LogFilter{Source: "tsuru", Units: []string{"unit2"}}})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, tsuruLogs[1:])
+	result, err = svc.List(app.ListLogArgs{AppName: "myapp", LogFilter: app.LogFilter{Message: "msg[02]"}})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, []app.Applog{webLogs[0], webLogs[2], tsuruLogs[0]})
+}
+
+func (s *S) TestListTimeRangePaging(c *check.C) {
+	svc := &fileLogService{dir: s.dir, maxSize: 200, maxFiles: 10}
+	logs := s.logs(10, "web")
+	for _, l := range logs {
+		err := svc.Add("myapp", []app.Applog{l})
+		c.Assert(err, check.IsNil)
+	}
+	files, err := rotatedFiles(filepath.Join(s.dir, "myapp"))
+	c.Assert(err, check.IsNil)
+	c.Assert(len(files) > 1, check.Equals, true)
+	filter := app.LogFilter{Since: logs[2].Date, Until: logs[8].Date}
+	result, err := svc.List(app.ListLogArgs{AppName: "myapp", LogFilter: filter})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, logs[2:8])
+	result, err = svc.List(app.ListLogArgs{AppName: "myapp", Limit: 4})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, logs[6:])
+	filter = app.LogFilter{Until: result[0].Date}
+	result, err = svc.List(app.ListLogArgs{AppName: "myapp", Limit: 4, LogFilter: filter})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, logs[2:6])
+}
+
+func (s *S) TestListInvalidFilter(c *check.C) {
+	svc := &fileLogService{dir: s.dir, maxSize: 1024 * 1024}
+	_, err := svc.List(app.ListLogArgs{AppName: "myapp", LogFilter: app.LogFilter{Message: "("}})
+	c.Assert(err, check.ErrorMatches, "invalid message expression: .*")
+}
+
+func (s *S) TestListNoLogs(c *check.C) {
+	svc := &fileLogService{dir: s.dir, maxSize: 1024 * 1024}
+	result, err := svc.List(app.ListLogArgs{AppName: "myapp", Limit: 10})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, []app.Applog{})
+}
+
+func (s *S) TestAddRotatesAndCompresses(c *check.C) {
+	svc := &fileLogService{dir: s.dir, maxSize: 1, maxFiles: 10}
+	logs := s.logs(3, "web")
+	for _, l := range logs {
+		err := svc.Add("myapp", []app.Applog{l})
+		c.Assert(err, check.IsNil)
+	}
+	files, err := rotatedFiles(filepath.Join(s.dir, "myapp"))
+	c.Assert(err, check.IsNil)
+	c.Assert(files, check.HasLen, 3)
+	_, err = os.Stat(filepath.Join(s.dir, "myapp", "current.log"))
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+	result, err := svc.List(app.ListLogArgs{AppName: "myapp"})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, logs)
+	result, err = svc.List(app.ListLogArgs{AppName: "myapp", Limit: 2})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, logs[1:])
+}
+
+func (s *S) TestAddPrunesRotatedFiles(c *check.C) {
+	svc := &fileLogService{dir: s.dir, maxSize: 1, maxFiles: 2}
+	logs := s.logs(4, "web")
+	for _, l := range logs {
+		err := svc.Add("myapp", []app.Applog{l})
+		c.Assert(err, check.IsNil)
+	}
+	files, err := rotatedFiles(filepath.Join(s.dir, "myapp"))
+	c.Assert(err, check.IsNil)
+	c.Assert(files, check.HasLen, 2)
+	result, err := svc.List(app.ListLogArgs{AppName: "myapp"})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, logs[2:])
+}
+
+func (s *S) TestAddPrunesOldFiles(c *check.C) {
+	svc := &fileLogService{dir: s.dir, maxSize: 1, maxAge: 24 * time.Hour}
+	logs := s.logs(2, "web")
+	err := svc.Add("myapp", logs[:1])
+	c.Assert(err, check.IsNil)
+	files, err := rotatedFiles(filepath.Join(s.dir, "myapp"))
+	c.Assert(err, check.IsNil)
+	c.Assert(files, check.HasLen, 1)
+	old := time.Now().Add(-48 * time.Hour)
+	err = os.Chtimes(files[0], old, old)
+	c.Assert(err, check.IsNil)
+	err = svc.Add("myapp", logs[1:])
+	c.Assert(err, check.IsNil)
+	result, err := svc.List(app.ListLogArgs{AppName: "myapp"})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, logs[1:])
+}
+
+func (s *S) TestRemove(c *check.C) {
+	svc := &fileLogService{dir: s.dir, maxSize: 1024 * 1024}
+	err := svc.Add("myapp", s.logs(2, "web"))
+	c.Assert(err, check.IsNil)
+	err = svc.Remove("myapp")
+	c.Assert(err, check.IsNil)
+	_, err = os.Stat(filepath.Join(s.dir, "myapp"))
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+	result, err := svc.List(app.ListLogArgs{AppName: "myapp"})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 0)
+}
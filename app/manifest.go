@@ -0,0 +1,534 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/auth"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/service"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	ManifestCreateApp   = "create-app"
+	ManifestUpdate      = "update"
+	ManifestSetEnv      = "set-env"
+	ManifestUnsetEnv    = "unset-env"
+	ManifestAddCName    = "add-cname"
+	ManifestRemoveCName = "remove-cname"
+	ManifestAddUnits    = "add-units"
+	ManifestRemoveUnits = "remove-units"
+	ManifestBind        = "bind"
+	ManifestUnbind      = "unbind"
+	ManifestGrant       = "grant"
+	ManifestRevoke      = "revoke"
+)
+
+// Manifest is a declarative description of an app. Empty attributes and nil
+// lists are left untouched when the manifest is applied, while lists that are
+// set are authoritative: envs, cnames, service bindings and teams not listed
+// are removed from the app. Processes not listed in Units are kept as they
+// are.
+type Manifest struct {
+	Name        string            `yaml:"name" json:"name"`
+	Platform    string            `yaml:"platform,omitempty" json:"platform,omitempty"`
+	Plan        string            `yaml:"plan,omitempty" json:"plan,omitempty"`
+	Pool        string            `yaml:"pool,omitempty" json:"pool,omitempty"`
+	Router      string            `yaml:"router,omitempty" json:"router,omitempty"`
+	TeamOwner   string            `yaml:"team-owner,omitempty" json:"team-owner,omitempty"`
+	Description string            `yaml:"description,omitempty" json:"description,omitempty"`
+	Teams       []string          `yaml:"teams,omitempty" json:"teams,omitempty"`
+	Envs        []ManifestEnv     `yaml:"envs,omitempty" json:"envs,omitempty"`
+	CNames      []string          `yaml:"cnames,omitempty" json:"cnames,omitempty"`
+	Units       map[string]uint   `yaml:"units,omitempty" json:"units,omitempty"`
+	Services    []ManifestService `yaml:"services,omitempty" json:"services,omitempty"`
+}
+
+// ManifestEnv is an environment variable in a manifest. Secret variables are
+// exported without their values, and a secret variable without value keeps
+// the value already stored in the app.
+type ManifestEnv struct {
+	Name   string `yaml:"name" json:"name"`
+	Value  string `yaml:"value,omitempty" json:"value,omitempty"`
+	Secret bool   `yaml:"secret,omitempty" json:"secret,omitempty"`
+}
+
+type ManifestService struct {
+	Service  string `yaml:"service" json:"service"`
+	Instance string `yaml:"instance" json:"instance"`
+}
+
+// ManifestChange is a single change needed to converge an app to a manifest.
+type ManifestChange struct {
+	Action   string `json:"action"`
+	Name     string `json:"name,omitempty"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	Units    uint   `json:"units,omitempty"`
+	Secret   bool   `json:"secret,omitempty"`
+	Service  string `json:"service,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+func (c ManifestChange) String() string {
+	switch c.Action {
+	case ManifestCreateApp:
+		return fmt.Sprintf("create app %q", c.Name)
+	case ManifestUpdate:
+		return fmt.Sprintf("update %s from %q to %q", c.Name, c.From, c.To)
+	case ManifestSetEnv:
+		return fmt.Sprintf("set env %s", c.Name)
+	case ManifestUnsetEnv:
+		return fmt.Sprintf("unset env %s", c.Name)
+	case ManifestAddCName:
+		return fmt.Sprintf("add cname %s", c.Name)
+	case ManifestRemoveCName:
+		return fmt.Sprintf("remove cname %s", c.Name)
+	case ManifestAddUnits:
+		return fmt.Sprintf("add %d units to process %q", c.Units, c.Name)
+	case ManifestRemoveUnits:
+		return fmt.Sprintf("remove %d units from process %q", c.Units, c.Name)
+	case ManifestBind:
+		return fmt.Sprintf("bind service instance %s/%s", c.Service, c.Instance)
+	case ManifestUnbind:
+		return fmt.Sprintf("unbind service instance %s/%s", c.Service, c.Instance)
+	case ManifestGrant:
+		return fmt.Sprintf("grant access to team %s", c.Name)
+	case ManifestRevoke:
+		return fmt.Sprintf("revoke access from team %s", c.Name)
+	}
+	return c.Action
+}
+
+// Permission returns the permission required to apply the change in the app.
+func (c ManifestChange) Permission() *permission.PermissionScheme {
+	switch c.Action {
+	case ManifestCreateApp:
+		return permission.PermAppCreate
+	case ManifestUpdate:
+		switch c.Name {
+		case "plan":
+			return permission.PermAppUpdatePlan
+		case "pool":
+			return permission.PermAppUpdatePool
+		case "router":
+			return permission.PermAppUpdateRouter
+		case "team-owner":
+			return permission.PermAppUpdateTeamowner
+		}
+		return permission.PermAppUpdateDescription
+	case ManifestSetEnv:
+		return permission.PermAppUpdateEnvSet
+	case ManifestUnsetEnv:
+		return permission.PermAppUpdateEnvUnset
+	case ManifestAddCName:
+		return permission.PermAppUpdateCnameAdd
+	case ManifestRemoveCName:
+		return permission.PermAppUpdateCnameRemove
+	case ManifestAddUnits:
+		return permission.PermAppUpdateUnitAdd
+	case ManifestRemoveUnits:
+		return permission.PermAppUpdateUnitRemove
+	case ManifestBind:
+		return permission.PermAppUpdateBind
+	case ManifestUnbind:
+		return permission.PermAppUpdateUnbind
+	case ManifestGrant:
+		return permission.PermAppUpdateGrant
+	case ManifestRevoke:
+		return permission.PermAppUpdateRevoke
+	}
+	return nil
+}
+
+// ParseManifest decodes a YAML manifest.
+func ParseManifest(data []byte) (*Manifest, error) {
+	var m Manifest
+	err := yaml.Unmarshal(data, &m)
+	if err != nil {
+		return nil, &tsuruErrors.ValidationError{Message: fmt.Sprintf("invalid manifest: %s", err)}
+	}
+	envNames := make(map[string]bool, len(m.Envs))
+	for _, env := range m.Envs {
+		if env.Name == "" {
+			return nil, &tsuruErrors.ValidationError{Message: "invalid manifest: envs must have a name"}
+		}
+		if envNames[env.Name] {
+			return nil, &tsuruErrors.ValidationError{Message: fmt.Sprintf("invalid manifest: duplicated env %s", env.Name)}
+		}
+		envNames[env.Name] = true
+	}
+	for _, s := range m.Services {
+		if s.Service == "" || s.Instance == "" {
+			return nil, &tsuruErrors.ValidationError{Message: "invalid manifest: services must have a service and an instance"}
+		}
+	}
+	return &m, nil
+}
+
+// Manifest returns the manifest describing the current state of the app.
+// Values of secret environment variables are not included.
+func (app *App) Manifest() (*Manifest, error) {
+	return app.manifest(false)
+}
+
+func (app *App) manifest(withSecrets bool) (*Manifest, error) {
+	m := &Manifest{
+		Name:        app.Name,
+		Platform:    app.Platform,
+		Plan:        app.Plan.Name,
+		Pool:        app.Pool,
+		Router:      app.Router,
+		TeamOwner:   app.TeamOwner,
+		Description: app.Description,
+		Teams:       append([]string{}, app.Teams...),
+		Envs:        []ManifestEnv{},
+		CNames:      append([]string{}, app.CName...),
+		Units:       map[string]uint{},
+		Services:    []ManifestService{},
+	}
+	sort.Strings(m.Teams)
+	sort.Strings(m.CNames)
+	for _, env := range app.Envs() {
+		if !env.Public && !env.Secret {
+			continue
+		}
+		mEnv := ManifestEnv{Name: env.Name, Value: env.Value, Secret: env.Secret}
+		if env.Secret && !withSecrets {
+			mEnv.Value = ""
+		}
+		m.Envs = append(m.Envs, mEnv)
+	}
+	sort.Sort(manifestEnvList(m.Envs))
+	units, err := app.Units()
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range units {
+		m.Units[u.ProcessName]++
+	}
+	instances, err := app.serviceInstances()
+	if err != nil {
+		return nil, err
+	}
+	for _, si := range instances {
+		m.Services = append(m.Services, ManifestService{Service: si.ServiceName, Instance: si.Name})
+	}
+	sort.Sort(manifestServiceList(m.Services))
+	return m, nil
+}
+
+type manifestEnvList []ManifestEnv
+
+func (l manifestEnvList) Len() int           { return len(l) }
+func (l manifestEnvList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l manifestEnvList) Less(i, j int) bool { return l[i].Name < l[j].Name }
+
+type manifestServiceList []ManifestService
+
+func (l manifestServiceList) Len() int      { return len(l) }
+func (l manifestServiceList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l manifestServiceList) Less(i, j int) bool {
+	if l[i].Service == l[j].Service {
+		return l[i].Instance < l[j].Instance
+	}
+	return l[i].Service < l[j].Service
+}
+
+// ManifestDiff holds the changes needed to converge an app to a manifest. App
+// is nil when the app doesn't exist yet and will be created.
+type ManifestDiff struct {
+	App      *App
+	Manifest *Manifest
+	Changes  []ManifestChange
+}
+
+// NewManifestDiff compares the manifest with the current state of the app it
+// describes.
+func NewManifestDiff(m *Manifest) (*ManifestDiff, error) {
+	if m.Name == "" {
+		return nil, &tsuruErrors.ValidationError{Message: "invalid manifest: the app name is required"}
+	}
+	a, err := GetByName(m.Name)
+	if err != nil && err != ErrAppNotFound {
+		return nil, err
+	}
+	current := &Manifest{Name: m.Name}
+	if a != nil {
+		current, err = a.manifest(true)
+		if err != nil {
+			return nil, err
+		}
+	} else if m.TeamOwner != "" {
+		current.Teams = []string{m.TeamOwner}
+	}
+	changes, err := diffManifests(current, m, a == nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ManifestDiff{App: a, Manifest: m, Changes: changes}, nil
+}
+
+func diffManifests(current, desired *Manifest, create bool) ([]ManifestChange, error) {
+	var changes []ManifestChange
+	if create {
+		changes = append(changes, ManifestChange{Action: ManifestCreateApp, Name: desired.Name})
+	} else {
+		if desired.Platform != "" && desired.Platform != current.Platform {
+			msg := fmt.Sprintf("changing the platform of an existing app is not supported, app %q uses %q", current.Name, current.Platform)
+			return nil, &tsuruErrors.ValidationError{Message: msg}
+		}
+		fields := []struct{ name, from, to string }{
+			{"description", current.Description, desired.Description},
+			{"plan", current.Plan, desired.Plan},
+			{"pool", current.Pool, desired.Pool},
+			{"router", current.Router, desired.Router},
+			{"team-owner", current.TeamOwner, desired.TeamOwner},
+		}
+		for _, f := range fields {
+			if f.to != "" && f.to != f.from {
+				changes = append(changes, ManifestChange{Action: ManifestUpdate, Name: f.name, From: f.from, To: f.to})
+			}
+		}
+	}
+	if desired.Teams != nil {
+		add, remove := diffStrings(current.Teams, desired.Teams)
+		for _, t := range add {
+			changes = append(changes, ManifestChange{Action: ManifestGrant, Name: t})
+		}
+		for _, t := range remove {
+			changes = append(changes, ManifestChange{Action: ManifestRevoke, Name: t})
+		}
+	}
+	if desired.Envs != nil {
+		currentEnvs := make(map[string]ManifestEnv, len(current.Envs))
+		for _, env := range current.Envs {
+			currentEnvs[env.Name] = env
+		}
+		desiredEnvs := make(map[string]bool, len(desired.Envs))
+		for _, env := range desired.Envs {
+			desiredEnvs[env.Name] = true
+			old, exists := currentEnvs[env.Name]
+			if env.Secret && env.Value == "" {
+				if !exists || !old.Secret {
+					return nil, &tsuruErrors.ValidationError{Message: fmt.Sprintf("a value is required for the secret env %s", env.Name)}
+				}
+				continue
+			}
+			if exists && old.Value == env.Value && old.Secret == env.Secret {
+				continue
+			}
+			change := ManifestChange{Action: ManifestSetEnv, Name: env.Name, Secret: env.Secret}
+			if !env.Secret && !old.Secret {
+				change.From = old.Value
+				change.To = env.Value
+			}
+			changes = append(changes, change)
+		}
+		for _, env := range current.Envs {
+			if !desiredEnvs[env.Name] {
+				changes = append(changes, ManifestChange{Action: ManifestUnsetEnv, Name: env.Name, Secret: env.Secret})
+			}
+		}
+	}
+	if desired.CNames != nil {
+		add, remove := diffStrings(current.CNames, desired.CNames)
+		for _, cname := range remove {
+			changes = append(changes, ManifestChange{Action: ManifestRemoveCName, Name: cname})
+		}
+		for _, cname := range add {
+			changes = append(changes, ManifestChange{Action: ManifestAddCName, Name: cname})
+		}
+	}
+	if desired.Services != nil {
+		key := func(s ManifestService) string { return s.Service + "/" + s.Instance }
+		var currentServices, desiredServices []string
+		for _, s := range current.Services {
+			currentServices = append(currentServices, key(s))
+		}
+		for _, s := range desired.Services {
+			desiredServices = append(desiredServices, key(s))
+		}
+		add, remove := diffStrings(currentServices, desiredServices)
+		for _, s := range remove {
+			parts := strings.SplitN(s, "/", 2)
+			changes = append(changes, ManifestChange{Action: ManifestUnbind, Service: parts[0], Instance: parts[1]})
+		}
+		for _, s := range add {
+			parts := strings.SplitN(s, "/", 2)
+			changes = append(changes, ManifestChange{Action: ManifestBind, Service: parts[0], Instance: parts[1]})
+		}
+	}
+	processes := make([]string, 0, len(desired.Units))
+	for process := range desired.Units {
+		processes = append(processes, process)
+	}
+	sort.Strings(processes)
+	for _, process := range processes {
+		want, have := desired.Units[process], current.Units[process]
+		if want > have {
+			changes = append(changes, ManifestChange{Action: ManifestAddUnits, Name: process, Units: want - have})
+		} else if want < have {
+			changes = append(changes, ManifestChange{Action: ManifestRemoveUnits, Name: process, Units: have - want})
+		}
+	}
+	return changes, nil
+}
+
+// diffStrings returns the sorted items only present in desired and the ones
+// only present in current.
+func diffStrings(current, desired []string) (add, remove []string) {
+	currentSet := make(map[string]bool, len(current))
+	for _, item := range current {
+		currentSet[item] = true
+	}
+	desiredSet := make(map[string]bool, len(desired))
+	for _, item := range desired {
+		desiredSet[item] = true
+		if !currentSet[item] {
+			add = append(add, item)
+		}
+	}
+	for _, item := range current {
+		if !desiredSet[item] {
+			remove = append(remove, item)
+		}
+	}
+	sort.Strings(add)
+	sort.Strings(remove)
+	return add, remove
+}
+
+// Apply converges the app to the manifest, creating it when needed. Changes
+// are applied in order, attributes and environment variables are each updated
+// at once. Progress is written to w.
+func (d *ManifestDiff) Apply(user *auth.User, w io.Writer) error {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	var update App
+	var setEnvs []bind.EnvVar
+	var unsetEnvs []string
+	for _, change := range d.Changes {
+		switch change.Action {
+		case ManifestUpdate:
+			switch change.Name {
+			case "description":
+				update.Description = change.To
+			case "plan":
+				update.Plan.Name = change.To
+			case "pool":
+				update.Pool = change.To
+			case "router":
+				update.Router = change.To
+			case "team-owner":
+				update.TeamOwner = change.To
+			}
+		case ManifestSetEnv:
+			for _, env := range d.Manifest.Envs {
+				if env.Name == change.Name {
+					setEnvs = append(setEnvs, bind.EnvVar{
+						Name:   env.Name,
+						Value:  env.Value,
+						Public: !env.Secret,
+						Secret: env.Secret,
+					})
+				}
+			}
+		case ManifestUnsetEnv:
+			unsetEnvs = append(unsetEnvs, change.Name)
+		}
+	}
+	var updated, envsChanged, bindingsChanged bool
+	for _, change := range d.Changes {
+		fmt.Fprintf(w, "---- %s ----\n", change)
+		err := d.applyChange(change, user, w)
+		switch {
+		case err != nil:
+		case change.Action == ManifestUpdate && !updated:
+			updated = true
+			err = d.App.Update(update, w)
+		case (change.Action == ManifestSetEnv || change.Action == ManifestUnsetEnv) && !envsChanged:
+			envsChanged = true
+			err = d.App.UnsetEnvs(bind.UnsetEnvApp{VariableNames: unsetEnvs, ShouldRestart: len(setEnvs) == 0}, w)
+			if err == nil {
+				err = d.App.SetEnvs(bind.SetEnvApp{Envs: setEnvs, ShouldRestart: true}, w)
+			}
+		case change.Action == ManifestBind || change.Action == ManifestUnbind:
+			bindingsChanged = true
+		}
+		if err != nil {
+			return errors.Wrapf(err, "unable to %s", change)
+		}
+	}
+	if bindingsChanged && !envsChanged {
+		units, err := d.App.Units()
+		if err != nil {
+			return err
+		}
+		if len(units) > 0 {
+			return d.App.Restart("", w)
+		}
+	}
+	return nil
+}
+
+func (d *ManifestDiff) applyChange(change ManifestChange, user *auth.User, w io.Writer) error {
+	a := d.App
+	switch change.Action {
+	case ManifestCreateApp:
+		m := d.Manifest
+		a = &App{
+			Name:        m.Name,
+			Platform:    m.Platform,
+			Plan:        Plan{Name: m.Plan},
+			Pool:        m.Pool,
+			Router:      m.Router,
+			TeamOwner:   m.TeamOwner,
+			Description: m.Description,
+		}
+		err := CreateApp(a, user)
+		if err != nil {
+			return err
+		}
+		d.App = a
+	case ManifestGrant, ManifestRevoke:
+		team, err := auth.GetTeam(change.Name)
+		if err != nil {
+			return err
+		}
+		if change.Action == ManifestGrant {
+			return a.Grant(team)
+		}
+		return a.Revoke(team)
+	case ManifestAddCName:
+		return a.AddCName(change.Name)
+	case ManifestRemoveCName:
+		return a.RemoveCName(change.Name)
+	case ManifestBind, ManifestUnbind:
+		si, err := service.GetServiceInstance(change.Service, change.Instance)
+		if err != nil {
+			return err
+		}
+		if change.Action == ManifestBind {
+			return si.BindApp(a, false, w)
+		}
+		return si.UnbindApp(a, false, w)
+	case ManifestAddUnits:
+		return a.AddUnits(change.Units, change.Name, w)
+	case ManifestRemoveUnits:
+		return a.RemoveUnits(change.Units, change.Name, w)
+	}
+	return nil
+}
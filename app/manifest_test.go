@@ -0,0 +1,275 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/auth"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/quota"
+	"github.com/tsuru/tsuru/service"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestParseManifest(c *check.C) {
+	m, err := ParseManifest([]byte(`
+name: myapp
+platform: python
+plan: default-plan
+team-owner: tsuruteam
+teams: [tsuruteam, otherteam]
+envs:
+  - name: DATABASE_HOST
+    value: localhost
+  - name: DATABASE_PASSWORD
+    secret: true
+cnames: [myapp.example.com]
+units:
+  web: 2
+  worker: 1
+services:
+  - service: mysql
+    instance: mydb
+`))
+	c.Assert(err, check.IsNil)
+	c.Assert(m, check.DeepEquals, &Manifest{
+		Name:      "myapp",
+		Platform:  "python",
+		Plan:      "default-plan",
+		TeamOwner: "tsuruteam",
+		Teams:     []string{"tsuruteam", "otherteam"},
+		Envs: []ManifestEnv{
+			{Name: "DATABASE_HOST", Value: "localhost"},
+			{Name: "DATABASE_PASSWORD", Secret: true},
+		},
+		CNames:   []string{"myapp.example.com"},
+		Units:    map[string]uint{"web": 2, "worker": 1},
+		Services: []ManifestService{{Service: "mysql", Instance: "mydb"}},
+	})
+}
+
+func (s *S) TestParseManifestInvalid(c *check.C) {
+	tests := []struct {
+		data string
+		msg  string
+	}{
+		{"name: [a", `invalid manifest: .*`},
+		{"name: myapp\nenvs: [{value: x}]", `invalid manifest: envs must have a name`},
+		{"name: myapp\nenvs: [{name: A}, {name: A}]", `invalid manifest: duplicated env A`},
+		{"name: myapp\nservices: [{service: mysql}]", `invalid manifest: services must have a service and an instance`},
+	}
+	for _, t := range tests {
+		_, err := ParseManifest([]byte(t.data))
+		c.Assert(err, check.ErrorMatches, t.msg)
+		c.Assert(err, check.FitsTypeOf, &tsuruErrors.ValidationError{})
+	}
+}
+
+func (s *S) TestAppManifest(c *check.C) {
+	config.Set("secret-envs:key", testSecretEnvKey1)
+	defer config.Unset("secret-envs")
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name, Quota: quota.Unlimited}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.setEnvsToApp(bind.SetEnvApp{Envs: []bind.EnvVar{
+		{Name: "DATABASE_HOST", Value: "localhost", Public: true},
+		{Name: "DATABASE_PASSWORD", Value: "123", Secret: true},
+		{Name: "SERVICE_VAR", Value: "private", InstanceName: "mydb"},
+	}}, nil)
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("myapp.example.com")
+	c.Assert(err, check.IsNil)
+	err = a.AddUnits(2, "web", nil)
+	c.Assert(err, check.IsNil)
+	instance := service.ServiceInstance{Name: "mydb", ServiceName: "mysql", Apps: []string{a.Name}}
+	err = s.conn.ServiceInstances().Insert(&instance)
+	c.Assert(err, check.IsNil)
+	defer s.conn.ServiceInstances().Remove(bson.M{"name": "mydb"})
+	m, err := a.Manifest()
+	c.Assert(err, check.IsNil)
+	c.Assert(m, check.DeepEquals, &Manifest{
+		Name:      "myapp",
+		Platform:  "python",
+		Plan:      s.defaultPlan.Name,
+		Pool:      s.Pool,
+		Router:    "fake",
+		TeamOwner: s.team.Name,
+		Teams:     []string{s.team.Name},
+		Envs: []ManifestEnv{
+			{Name: "DATABASE_HOST", Value: "localhost"},
+			{Name: "DATABASE_PASSWORD", Secret: true},
+		},
+		CNames:   []string{"myapp.example.com"},
+		Units:    map[string]uint{"web": 2},
+		Services: []ManifestService{{Service: "mysql", Instance: "mydb"}},
+	})
+	m, err = a.manifest(true)
+	c.Assert(err, check.IsNil)
+	c.Assert(m.Envs[1], check.DeepEquals, ManifestEnv{Name: "DATABASE_PASSWORD", Value: "123", Secret: true})
+}
+
+func (s *S) TestDiffManifests(c *check.C) {
+	current := &Manifest{
+		Name:        "myapp",
+		Platform:    "python",
+		Plan:        "small",
+		Description: "my app",
+		Teams:       []string{"team1", "team2"},
+		Envs: []ManifestEnv{
+			{Name: "A", Value: "1"},
+			{Name: "B", Value: "2"},
+			{Name: "C", Value: "secret", Secret: true},
+			{Name: "D", Value: "old"},
+		},
+		CNames:   []string{"a.example.com"},
+		Units:    map[string]uint{"web": 3, "worker": 1},
+		Services: []ManifestService{{Service: "mysql", Instance: "db1"}},
+	}
+	desired := &Manifest{
+		Name:     "myapp",
+		Platform: "python",
+		Plan:     "large",
+		Teams:    []string{"team1", "team3"},
+		Envs: []ManifestEnv{
+			{Name: "A", Value: "1"},
+			{Name: "B", Value: "3"},
+			{Name: "C", Secret: true},
+			{Name: "E", Value: "new", Secret: true},
+		},
+		CNames:   []string{"b.example.com"},
+		Units:    map[string]uint{"web": 1, "worker": 2},
+		Services: []ManifestService{{Service: "mysql", Instance: "db1"}, {Service: "redis", Instance: "cache"}},
+	}
+	changes, err := diffManifests(current, desired, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(changes, check.DeepEquals, []ManifestChange{
+		{Action: ManifestUpdate, Name: "plan", From: "small", To: "large"},
+		{Action: ManifestGrant, Name: "team3"},
+		{Action: ManifestRevoke, Name: "team2"},
+		{Action: ManifestSetEnv, Name: "B", From: "2", To: "3"},
+		{Action: ManifestSetEnv, Name: "E", Secret: true},
+		{Action: ManifestUnsetEnv, Name: "D"},
+		{Action: ManifestRemoveCName, Name: "a.example.com"},
+		{Action: ManifestAddCName, Name: "b.example.com"},
+		{Action: ManifestBind, Service: "redis", Instance: "cache"},
+		{Action: ManifestRemoveUnits, Name: "web", Units: 2},
+		{Action: ManifestAddUnits, Name: "worker", Units: 1},
+	})
+	changes, err = diffManifests(current, &Manifest{Name: "myapp"}, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(changes, check.HasLen, 0)
+	changes, err = diffManifests(current, current, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(changes, check.HasLen, 0)
+}
+
+func (s *S) TestDiffManifestsInvalid(c *check.C) {
+	current := &Manifest{Name: "myapp", Platform: "python", Envs: []ManifestEnv{{Name: "A", Value: "1"}}}
+	_, err := diffManifests(current, &Manifest{Name: "myapp", Platform: "ruby"}, false)
+	c.Assert(err, check.ErrorMatches, `changing the platform of an existing app is not supported, app "myapp" uses "python"`)
+	_, err = diffManifests(current, &Manifest{Name: "myapp", Envs: []ManifestEnv{{Name: "A", Secret: true}}}, false)
+	c.Assert(err, check.ErrorMatches, `a value is required for the secret env A`)
+}
+
+func (s *S) TestNewManifestDiffNewApp(c *check.C) {
+	diff, err := NewManifestDiff(&Manifest{
+		Name:      "myapp",
+		Platform:  "python",
+		TeamOwner: s.team.Name,
+		Teams:     []string{s.team.Name},
+		CNames:    []string{"myapp.example.com"},
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(diff.App, check.IsNil)
+	c.Assert(diff.Changes, check.DeepEquals, []ManifestChange{
+		{Action: ManifestCreateApp, Name: "myapp"},
+		{Action: ManifestAddCName, Name: "myapp.example.com"},
+	})
+}
+
+func (s *S) TestManifestDiffApply(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"DATABASE_USER":"root"}`))
+	}))
+	defer ts.Close()
+	srvc := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, check.IsNil)
+	instance := service.ServiceInstance{Name: "mydb", ServiceName: "mysql", Teams: []string{s.team.Name}}
+	err = instance.Create()
+	c.Assert(err, check.IsNil)
+	otherTeam := auth.Team{Name: "otherteam"}
+	err = s.conn.Teams().Insert(otherTeam)
+	c.Assert(err, check.IsNil)
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name, Quota: quota.Unlimited}
+	err = CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.setEnvsToApp(bind.SetEnvApp{Envs: []bind.EnvVar{
+		{Name: "OLD", Value: "1", Public: true},
+	}}, nil)
+	c.Assert(err, check.IsNil)
+	diff, err := NewManifestDiff(&Manifest{
+		Name:        "myapp",
+		Description: "new description",
+		Teams:       []string{s.team.Name, otherTeam.Name},
+		Envs:        []ManifestEnv{{Name: "NEW", Value: "2"}},
+		CNames:      []string{"myapp.example.com"},
+		Units:       map[string]uint{"web": 2},
+		Services:    []ManifestService{{Service: "mysql", Instance: "mydb"}},
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(diff.Changes, check.HasLen, 7)
+	var buf bytes.Buffer
+	err = diff.Apply(s.user, &buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Matches, `(?s)---- update description from "" to "new description" ----.*---- add 2 units to process "web" ----.*`)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Description, check.Equals, "new description")
+	c.Assert(dbApp.Teams, check.DeepEquals, []string{s.team.Name, otherTeam.Name})
+	c.Assert(dbApp.CName, check.DeepEquals, []string{"myapp.example.com"})
+	_, ok := dbApp.Env["OLD"]
+	c.Assert(ok, check.Equals, false)
+	c.Assert(dbApp.Env["NEW"].Value, check.Equals, "2")
+	c.Assert(dbApp.Env["DATABASE_USER"].Value, check.Equals, "root")
+	units, err := dbApp.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 2)
+	diff, err = NewManifestDiff(&Manifest{
+		Name:     "myapp",
+		Teams:    []string{s.team.Name, otherTeam.Name},
+		Envs:     []ManifestEnv{{Name: "NEW", Value: "2"}},
+		CNames:   []string{"myapp.example.com"},
+		Units:    map[string]uint{"web": 2},
+		Services: []ManifestService{{Service: "mysql", Instance: "mydb"}},
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(diff.Changes, check.HasLen, 0)
+}
+
+func (s *S) TestManifestDiffApplyCreatesApp(c *check.C) {
+	diff, err := NewManifestDiff(&Manifest{
+		Name:      "myapp",
+		Platform:  "python",
+		TeamOwner: s.team.Name,
+		Envs:      []ManifestEnv{{Name: "A", Value: "1"}},
+	})
+	c.Assert(err, check.IsNil)
+	err = diff.Apply(s.user, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(diff.App, check.NotNil)
+	dbApp, err := GetByName("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Platform, check.Equals, "python")
+	c.Assert(dbApp.TeamOwner, check.Equals, s.team.Name)
+	c.Assert(dbApp.Env["A"].Value, check.Equals, "1")
+}
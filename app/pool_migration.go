@@ -0,0 +1,271 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/app/image"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var ErrMigrationCanceled = errors.New("migration canceled by user action")
+
+type poolMigration struct {
+	app         *App
+	newApp      *App
+	evt         *event.Event
+	writer      io.Writer
+	oldProv     provision.Provisioner
+	newProv     provision.Provisioner
+	deployer    provision.RollbackableDeployer
+	router      router.Router
+	image       string
+	provisioned bool
+	newRoutes   []*url.URL
+	oldRoutes   []*url.URL
+	drained     bool
+}
+
+// MigratePool moves the app to a pool using a different provisioner without
+// downtime. The current image of the app is deployed in the new provisioner,
+// with the same number of units per process, and routes are moved to the new
+// units before the old ones are destroyed. Any failure, or the cancellation
+// of the event, before the app is saved in the new pool removes the new
+// units and restores the original routes. The app images are kept in both
+// cases. The caller must hold the app lock during the whole migration.
+func (app *App) MigratePool(poolName string, evt *event.Event, w io.Writer) error {
+	if poolName == app.Pool {
+		return errors.Errorf("app %q is already in pool %q", app.Name, poolName)
+	}
+	if app.Deploys == 0 {
+		return errors.New("app must be deployed before being migrated")
+	}
+	pool, err := provision.GetPoolByName(poolName)
+	if err != nil {
+		return err
+	}
+	oldProv, err := app.getProvisioner()
+	if err != nil {
+		return err
+	}
+	newProv, err := pool.GetProvisioner()
+	if err != nil {
+		return err
+	}
+	if oldProv.GetName() == newProv.GetName() {
+		return errors.Errorf("pool %q uses the same provisioner as the app, use app-update to change its pool", poolName)
+	}
+	deployer, ok := newProv.(provision.RollbackableDeployer)
+	if !ok {
+		return provision.ProvisionerNotSupported{Prov: newProv, Action: "app migration"}
+	}
+	newApp, err := GetByName(app.Name)
+	if err != nil {
+		return err
+	}
+	newApp.Pool = pool.Name
+	newApp.provisioner = newProv
+	err = newApp.validate()
+	if err != nil {
+		return err
+	}
+	img, err := image.AppCurrentImageName(app.Name)
+	if err != nil {
+		return err
+	}
+	r, err := app.GetRouter()
+	if err != nil {
+		return err
+	}
+	evt.SetLogWriter(w)
+	m := poolMigration{
+		app:      app,
+		newApp:   newApp,
+		evt:      evt,
+		writer:   evt,
+		oldProv:  oldProv,
+		newProv:  newProv,
+		deployer: deployer,
+		router:   r,
+		image:    img,
+	}
+	err = m.run()
+	if err != nil {
+		m.rollback(err)
+		return err
+	}
+	m.destroyOld()
+	app.Pool = newApp.Pool
+	app.provisioner = newProv
+	return nil
+}
+
+func (m *poolMigration) checkCanceled() error {
+	canceled, err := m.evt.AckCancel()
+	if err != nil {
+		log.Errorf("unable to check if event should be canceled, ignoring: %s", err)
+		return nil
+	}
+	if canceled {
+		return ErrMigrationCanceled
+	}
+	return nil
+}
+
+func processUnitCount(units []provision.Unit) map[string]int {
+	count := make(map[string]int)
+	for _, u := range units {
+		count[u.ProcessName]++
+	}
+	return count
+}
+
+func (m *poolMigration) run() error {
+	oldUnits, err := m.oldProv.Units(m.app)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(m.writer, "\n---- Provisioning app %q in pool %q (%s) ----\n", m.app.Name, m.newApp.Pool, m.newProv.GetName())
+	err = m.newProv.Provision(m.newApp)
+	if err != nil {
+		return err
+	}
+	m.provisioned = true
+	if err = m.checkCanceled(); err != nil {
+		return err
+	}
+	fmt.Fprintf(m.writer, "\n---- Deploying image %s ----\n", m.image)
+	_, err = m.deployer.Rollback(m.newApp, m.image, m.evt)
+	if err != nil {
+		return err
+	}
+	if err = m.checkCanceled(); err != nil {
+		return err
+	}
+	err = m.matchUnits(processUnitCount(oldUnits))
+	if err != nil {
+		return err
+	}
+	if err = m.checkCanceled(); err != nil {
+		return err
+	}
+	fmt.Fprintf(m.writer, "\n---- Adding routes to new units ----\n")
+	newAddrs, err := m.newProv.RoutableAddresses(m.newApp)
+	if err != nil {
+		return err
+	}
+	newHosts := make(map[string]bool, len(newAddrs))
+	for i := range newAddrs {
+		newHosts[newAddrs[i].Host] = true
+		m.newRoutes = append(m.newRoutes, &newAddrs[i])
+	}
+	err = m.router.AddRoutes(m.app.Name, m.newRoutes)
+	if err != nil {
+		return err
+	}
+	if err = m.checkCanceled(); err != nil {
+		return err
+	}
+	fmt.Fprintf(m.writer, "\n---- Draining old units ----\n")
+	oldAddrs, err := m.oldProv.RoutableAddresses(m.app)
+	if err != nil {
+		return err
+	}
+	for i := range oldAddrs {
+		if !newHosts[oldAddrs[i].Host] {
+			m.oldRoutes = append(m.oldRoutes, &oldAddrs[i])
+		}
+	}
+	m.drained = true
+	err = m.router.RemoveRoutes(m.app.Name, m.oldRoutes)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": m.app.Name}, bson.M{"$set": bson.M{"pool": m.newApp.Pool}})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// matchUnits adds or removes units in the new provisioner until each process
+// has the same number of units it had in the old provisioner.
+func (m *poolMigration) matchUnits(wanted map[string]int) error {
+	newUnits, err := m.newProv.Units(m.newApp)
+	if err != nil {
+		return err
+	}
+	current := processUnitCount(newUnits)
+	for process, count := range wanted {
+		diff := count - current[process]
+		if diff > 0 {
+			fmt.Fprintf(m.writer, "\n---- Adding %d units to process %q ----\n", diff, process)
+			err = m.newProv.AddUnits(m.newApp, uint(diff), process, m.writer)
+		} else if diff < 0 {
+			fmt.Fprintf(m.writer, "\n---- Removing %d units from process %q ----\n", -diff, process)
+			err = m.newProv.RemoveUnits(m.newApp, uint(-diff), process, m.writer)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *poolMigration) rollback(cause error) {
+	fmt.Fprintf(m.writer, "\n---- Migration failed, rolling back: %s ----\n", cause)
+	if m.drained && len(m.oldRoutes) > 0 {
+		err := m.router.AddRoutes(m.app.Name, m.oldRoutes)
+		if err != nil {
+			log.Errorf("[app migration] unable to restore routes for app %q: %s", m.app.Name, err)
+		}
+	}
+	if len(m.newRoutes) > 0 {
+		err := m.router.RemoveRoutes(m.app.Name, m.newRoutes)
+		if err != nil {
+			log.Errorf("[app migration] unable to remove new routes for app %q: %s", m.app.Name, err)
+		}
+	}
+	if m.provisioned {
+		err := destroyUnits(m.newProv, m.newApp)
+		if err != nil {
+			log.Errorf("[app migration] unable to destroy app %q in provisioner %q: %s", m.app.Name, m.newProv.GetName(), err)
+		}
+	}
+}
+
+// destroyUnits removes the app from the provisioner while keeping its images,
+// which are shared by both provisioners during the migration.
+func destroyUnits(prov provision.Provisioner, app *App) error {
+	if destroyer, ok := prov.(provision.UnitsDestroyer); ok {
+		return destroyer.DestroyUnits(app)
+	}
+	return prov.Destroy(app)
+}
+
+// destroyOld removes the app from the old provisioner. Failures are only
+// logged, as the app is already running and saved in the new pool.
+func (m *poolMigration) destroyOld() {
+	fmt.Fprintf(m.writer, "\n---- Destroying old units in provisioner %q ----\n", m.oldProv.GetName())
+	err := destroyUnits(m.oldProv, m.app)
+	if err != nil {
+		log.Errorf("[app migration] unable to destroy app %q in provisioner %q: %s", m.app.Name, m.oldProv.GetName(), err)
+		fmt.Fprintf(m.writer, " ---> Unable to destroy old units: %s\n", err)
+	}
+}
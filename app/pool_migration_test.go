@@ -0,0 +1,140 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/tsuru/tsuru/app/image"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/provisiontest"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type migrationTargetProvisioner struct {
+	*provisiontest.FakeProvisioner
+}
+
+func (p *migrationTargetProvisioner) GetName() string {
+	return "fake-target"
+}
+
+func (s *S) prepareMigration(c *check.C) (*App, *event.Event, *migrationTargetProvisioner) {
+	target := &migrationTargetProvisioner{FakeProvisioner: provisiontest.NewFakeProvisioner()}
+	provision.Register("fake-target", func() (provision.Provisioner, error) {
+		return target, nil
+	})
+	err := provision.AddPool(provision.AddPoolOptions{Name: "target-pool", Public: true, Provisioner: "fake-target"})
+	c.Assert(err, check.IsNil)
+	a := App{
+		Name:      "otherapp",
+		Platform:  "zend",
+		Teams:     []string{s.team.Name},
+		TeamOwner: s.team.Name,
+		Router:    "fake",
+	}
+	err = CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = s.conn.Apps().Update(bson.M{"name": a.Name}, bson.M{"$set": bson.M{"deploys": 1}})
+	c.Assert(err, check.IsNil)
+	a.Deploys = 1
+	err = image.AppendAppImageName(a.Name, "registry.somewhere/tsuru/app-otherapp:v1")
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.AddUnits(&a, 2, "web", nil)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.AddUnits(&a, 1, "worker", nil)
+	c.Assert(err, check.IsNil)
+	evt, err := event.New(&event.Opts{
+		Target:        event.Target{Type: "app", Value: a.Name},
+		Kind:          permission.PermAppUpdatePoolMigrate,
+		RawOwner:      event.Owner{Type: event.OwnerTypeUser, Name: s.user.Email},
+		Allowed:       event.Allowed(permission.PermApp),
+		AllowedCancel: event.Allowed(permission.PermApp),
+		Cancelable:    true,
+	})
+	c.Assert(err, check.IsNil)
+	return &a, evt, target
+}
+
+func (s *S) TestMigratePool(c *check.C) {
+	a, evt, target := s.prepareMigration(c)
+	defer provision.Unregister("fake-target")
+	oldUnits, err := a.Units()
+	c.Assert(err, check.IsNil)
+	var buf bytes.Buffer
+	err = a.MigratePool("target-pool", evt, &buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Pool, check.Equals, "target-pool")
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Pool, check.Equals, "target-pool")
+	c.Assert(s.provisioner.Provisioned(a), check.Equals, false)
+	newUnits := target.GetUnits(a)
+	c.Assert(processUnitCount(newUnits), check.DeepEquals, map[string]int{"web": 2, "worker": 1})
+	for _, u := range oldUnits {
+		c.Assert(routertest.FakeRouter.HasRoute(a.Name, u.Address.String()), check.Equals, false)
+	}
+	for _, u := range newUnits {
+		c.Assert(routertest.FakeRouter.HasRoute(a.Name, u.Address.String()), check.Equals, true)
+	}
+	c.Assert(buf.String(), check.Matches, `(?s).*Provisioning app "otherapp" in pool "target-pool".*Draining old units.*Destroying old units.*`)
+}
+
+func (s *S) TestMigratePoolRollbackOnFailure(c *check.C) {
+	a, evt, target := s.prepareMigration(c)
+	defer provision.Unregister("fake-target")
+	oldUnits, err := a.Units()
+	c.Assert(err, check.IsNil)
+	target.PrepareFailure("AddUnits", errors.New("add units failed"))
+	err = a.MigratePool("target-pool", evt, &bytes.Buffer{})
+	c.Assert(err, check.ErrorMatches, "add units failed")
+	c.Assert(a.Pool, check.Equals, s.Pool)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Pool, check.Equals, s.Pool)
+	c.Assert(target.Provisioned(a), check.Equals, false)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.DeepEquals, oldUnits)
+	for _, u := range oldUnits {
+		c.Assert(routertest.FakeRouter.HasRoute(a.Name, u.Address.String()), check.Equals, true)
+	}
+}
+
+func (s *S) TestMigratePoolCanceled(c *check.C) {
+	a, evt, target := s.prepareMigration(c)
+	defer provision.Unregister("fake-target")
+	err := evt.TryCancel("changed my mind", s.user.Email)
+	c.Assert(err, check.IsNil)
+	err = a.MigratePool("target-pool", evt, &bytes.Buffer{})
+	c.Assert(err, check.Equals, ErrMigrationCanceled)
+	c.Assert(target.Provisioned(a), check.Equals, false)
+	c.Assert(s.provisioner.Provisioned(a), check.Equals, true)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Pool, check.Equals, s.Pool)
+}
+
+func (s *S) TestMigratePoolSameProvisioner(c *check.C) {
+	a, evt, _ := s.prepareMigration(c)
+	defer provision.Unregister("fake-target")
+	err := provision.AddPool(provision.AddPoolOptions{Name: "other-pool", Public: true, Provisioner: "fake"})
+	c.Assert(err, check.IsNil)
+	err = a.MigratePool("other-pool", evt, &bytes.Buffer{})
+	c.Assert(err, check.ErrorMatches, `pool "other-pool" uses the same provisioner as the app, use app-update to change its pool`)
+}
+
+func (s *S) TestMigratePoolNotDeployed(c *check.C) {
+	a, evt, _ := s.prepareMigration(c)
+	defer provision.Unregister("fake-target")
+	a.Deploys = 0
+	err := a.MigratePool("target-pool", evt, &bytes.Buffer{})
+	c.Assert(err, check.ErrorMatches, "app must be deployed before being migrated")
+}
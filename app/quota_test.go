@@ -5,9 +5,11 @@
 package app
 
 import (
+	"bytes"
 	"runtime"
 	"sync"
 
+	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/quota"
 	"gopkg.in/check.v1"
 	"gopkg.in/mgo.v2"
@@ -209,3 +211,72 @@ func (s *S) TestChangeQuotaAppNotFound(c *check.C) {
 	c.Assert(err, check.NotNil)
 	c.Assert(err, check.Equals, mgo.ErrNotFound)
 }
+
+func (s *S) TestCreateAppTeamQuotaExceeded(c *check.C) {
+	a := App{Name: "america", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = auth.ChangeTeamQuota(&s.team, auth.TeamQuota{Apps: 1, Units: -1, Memory: -1, ServiceInstances: -1})
+	c.Assert(err, check.IsNil)
+	other := App{Name: "europe", Platform: "python", TeamOwner: s.team.Name}
+	err = CreateApp(&other, s.user)
+	e, ok := err.(*AppCreationError)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(e.Err, check.ErrorMatches, `Quota exceeded for apps of team "tsuruteam". Available: 0. Requested: 1.`)
+	_, err = GetByName(other.Name)
+	c.Assert(err, check.Equals, ErrAppNotFound)
+}
+
+func (s *S) TestAddUnitsTeamQuotaExceeded(c *check.C) {
+	a := App{Name: "warpaint", Platform: "python", TeamOwner: s.team.Name, Quota: quota.Unlimited}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = auth.ChangeTeamQuota(&s.team, auth.TeamQuota{Apps: -1, Units: 3, Memory: 3 * s.defaultPlan.Memory, ServiceInstances: -1})
+	c.Assert(err, check.IsNil)
+	err = a.AddUnits(2, "web", nil)
+	c.Assert(err, check.IsNil)
+	err = a.AddUnits(2, "web", nil)
+	c.Assert(err, check.ErrorMatches, `Quota exceeded for units of team "tsuruteam". Available: 1. Requested: 2.`)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 2)
+}
+
+func (s *S) TestUpdatePlanTeamQuotaExceeded(c *check.C) {
+	plan := Plan{Name: "large", CpuShare: 100, Memory: 4 * s.defaultPlan.Memory}
+	err := s.conn.Plans().Insert(plan)
+	c.Assert(err, check.IsNil)
+	a := App{Name: "warpaint", Platform: "python", TeamOwner: s.team.Name, Quota: quota.Unlimited}
+	err = CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddUnits(2, "web", nil)
+	c.Assert(err, check.IsNil)
+	err = auth.ChangeTeamQuota(&s.team, auth.TeamQuota{Apps: -1, Units: -1, Memory: 4 * s.defaultPlan.Memory, ServiceInstances: -1})
+	c.Assert(err, check.IsNil)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	err = dbApp.Update(App{Plan: Plan{Name: "large"}}, new(bytes.Buffer))
+	c.Assert(err, check.FitsTypeOf, &quota.QuotaExceededError{})
+	c.Assert(err, check.ErrorMatches, `Quota exceeded for memory of team "tsuruteam". .*`)
+	dbApp, err = GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Plan.Name, check.Equals, s.defaultPlan.Name)
+}
+
+func (s *S) TestDeleteReleasesTeamQuota(c *check.C) {
+	a := App{Name: "warpaint", Platform: "python", TeamOwner: s.team.Name, Quota: quota.Unlimited}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddUnits(2, "web", nil)
+	c.Assert(err, check.IsNil)
+	team, err := auth.GetTeam(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(team.QuotaInUse, check.DeepEquals, &auth.TeamQuota{Apps: 1, Units: 2, Memory: 2 * s.defaultPlan.Memory})
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	err = Delete(dbApp, nil)
+	c.Assert(err, check.IsNil)
+	team, err = auth.GetTeam(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(team.QuotaInUse, check.DeepEquals, &auth.TeamQuota{})
+}
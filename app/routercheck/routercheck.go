@@ -0,0 +1,282 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package routercheck compares the routes, cnames and backends tsuru expects
+// for each app with the ones found in the routers, optionally repairing the
+// differences.
+package routercheck
+
+import (
+	"net/url"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/router"
+	"github.com/tsuru/tsuru/router/rebuild"
+)
+
+const (
+	KindMissingBackend = "missing-backend"
+	KindMissingRoute   = "missing-route"
+	KindExtraRoute     = "extra-route"
+	KindMissingCName   = "missing-cname"
+	KindExtraCName     = "extra-cname"
+	KindOrphanBackend  = "orphan-backend"
+)
+
+// Issue is a difference between tsuru and a router. Backend is the app name
+// for every kind of issue, including orphan backends, whose apps no longer
+// exist.
+type Issue struct {
+	Backend string `json:"backend"`
+	Kind    string `json:"kind"`
+	Value   string `json:"value,omitempty"`
+	Fixed   bool   `json:"fixed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Report holds the issues found in a router. Errors lists the apps that
+// could not be checked.
+type Report struct {
+	Router string            `json:"router"`
+	Issues []Issue           `json:"issues"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+func (r *Report) addError(backend string, err error) {
+	if r.Errors == nil {
+		r.Errors = make(map[string]string)
+	}
+	r.Errors[backend] = err.Error()
+}
+
+// Check checks every app of the routers named in routerNames, or of all
+// routers when none is given. When repair is true, the issues found are
+// fixed by rebuilding the routes of the affected apps and removing orphan
+// backends.
+func Check(routerNames []string, repair bool) ([]Report, error) {
+	plans, err := router.List()
+	if err != nil {
+		return nil, err
+	}
+	if len(routerNames) > 0 {
+		wanted := make(map[string]bool, len(routerNames))
+		for _, name := range routerNames {
+			wanted[name] = true
+		}
+		var filtered []router.PlanRouter
+		for _, p := range plans {
+			if wanted[p.Name] {
+				filtered = append(filtered, p)
+				delete(wanted, p.Name)
+			}
+		}
+		for name := range wanted {
+			return nil, &router.ErrRouterNotFound{Name: name}
+		}
+		plans = filtered
+	}
+	apps, err := app.List(nil)
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]Report, 0, len(plans))
+	for _, p := range plans {
+		report, err := checkRouter(p, apps, repair)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to check router %q", p.Name)
+		}
+		reports = append(reports, *report)
+	}
+	return reports, nil
+}
+
+func checkRouter(plan router.PlanRouter, apps []app.App, repair bool) (*Report, error) {
+	r, err := router.Get(plan.Name)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{Router: plan.Name, Issues: []Issue{}}
+	existing := make(map[string]bool, len(apps))
+	for i := range apps {
+		a := &apps[i]
+		existing[a.Name] = true
+		if a.Router != plan.Name {
+			continue
+		}
+		issues, err := checkApp(r, a)
+		if err != nil {
+			report.addError(a.Name, err)
+			continue
+		}
+		if repair && len(issues) > 0 {
+			repairApp(r, a, issues)
+		}
+		report.Issues = append(report.Issues, issues...)
+	}
+	orphans, err := orphanBackends(r, plan.Type, existing)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range orphans {
+		issue := Issue{Backend: name, Kind: KindOrphanBackend}
+		if repair {
+			setResult(&issue, r.RemoveBackend(name))
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+	return report, nil
+}
+
+func checkApp(r router.Router, a *app.App) ([]Issue, error) {
+	swapped, _, err := router.IsSwapped(a.Name)
+	if err == router.ErrBackendNotFound {
+		return []Issue{{Backend: a.Name, Kind: KindMissingBackend}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if swapped {
+		// The routes of swapped apps belong to the app they were swapped
+		// with, there's nothing to compare them with.
+		return nil, nil
+	}
+	_, err = r.Addr(a.Name)
+	if err == router.ErrBackendNotFound || err == router.ErrRouteNotFound {
+		return []Issue{{Backend: a.Name, Kind: KindMissingBackend}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	addresses, err := a.RoutableAddresses()
+	if err != nil {
+		return nil, err
+	}
+	routes, err := r.Routes(a.Name)
+	if err != nil {
+		return nil, err
+	}
+	expected := make([]string, len(addresses))
+	for i, addr := range addresses {
+		expected[i] = addr.Host
+	}
+	var issues []Issue
+	missing, extra := diff(expected, hosts(routes))
+	for _, h := range missing {
+		issues = append(issues, Issue{Backend: a.Name, Kind: KindMissingRoute, Value: h})
+	}
+	for _, h := range extra {
+		issues = append(issues, Issue{Backend: a.Name, Kind: KindExtraRoute, Value: h})
+	}
+	cnameRouter, ok := r.(router.CNameRouter)
+	if !ok {
+		return issues, nil
+	}
+	cnames, err := cnameRouter.CNames(a.Name)
+	if err != nil {
+		return nil, err
+	}
+	missing, extra = diff(a.CName, hosts(cnames))
+	for _, h := range missing {
+		issues = append(issues, Issue{Backend: a.Name, Kind: KindMissingCName, Value: h})
+	}
+	for _, h := range extra {
+		issues = append(issues, Issue{Backend: a.Name, Kind: KindExtraCName, Value: h})
+	}
+	return issues, nil
+}
+
+func repairApp(r router.Router, a *app.App, issues []Issue) {
+	locked, err := a.InternalLock("router-check")
+	if err == nil && !locked {
+		err = errors.Errorf("app %q is locked", a.Name)
+	}
+	if err != nil {
+		for i := range issues {
+			setResult(&issues[i], err)
+		}
+		return
+	}
+	defer a.Unlock()
+	var rebuildErr error
+	rebuilt := false
+	for i := range issues {
+		if issues[i].Kind == KindExtraCName {
+			cnameRouter := r.(router.CNameRouter)
+			setResult(&issues[i], cnameRouter.UnsetCName(issues[i].Value, a.Name))
+			continue
+		}
+		if !rebuilt {
+			_, rebuildErr = rebuild.RebuildRoutes(a)
+			rebuilt = true
+		}
+		setResult(&issues[i], rebuildErr)
+	}
+}
+
+func setResult(issue *Issue, err error) {
+	if err != nil {
+		issue.Error = err.Error()
+		return
+	}
+	issue.Fixed = true
+}
+
+// orphanBackends returns the backends that still exist in the router but
+// whose apps were removed.
+func orphanBackends(r router.Router, kind string, existing map[string]bool) ([]string, error) {
+	names, err := router.StoredApps(kind)
+	if err != nil {
+		return nil, err
+	}
+	var orphans []string
+	for _, name := range names {
+		if existing[name] {
+			continue
+		}
+		swapped, _, err := router.IsSwapped(name)
+		if err != nil || swapped {
+			continue
+		}
+		_, err = r.Addr(name)
+		if err == nil {
+			orphans = append(orphans, name)
+		}
+	}
+	sort.Strings(orphans)
+	return orphans, nil
+}
+
+func hosts(urls []*url.URL) []string {
+	result := make([]string, len(urls))
+	for i, u := range urls {
+		result[i] = u.Host
+	}
+	return result
+}
+
+// diff returns the sorted values that are only in expected and only in
+// actual.
+func diff(expected, actual []string) (missing []string, extra []string) {
+	inActual := make(map[string]bool, len(actual))
+	for _, v := range actual {
+		inActual[v] = true
+	}
+	inExpected := make(map[string]bool, len(expected))
+	for _, v := range expected {
+		inExpected[v] = true
+		if !inActual[v] {
+			missing = append(missing, v)
+		}
+	}
+	for _, v := range actual {
+		if !inExpected[v] {
+			extra = append(extra, v)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(extra)
+	return missing, extra
+}
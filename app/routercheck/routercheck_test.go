@@ -0,0 +1,209 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routercheck
+
+import (
+	"net/url"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/router"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) newApp(c *check.C, name string, units uint, cnames ...string) *app.App {
+	a := app.App{Name: name, Router: "fake", CName: cnames}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	err = routertest.FakeRouter.AddBackend(name)
+	c.Assert(err, check.IsNil)
+	for _, cname := range cnames {
+		err = routertest.FakeRouter.SetCName(cname, name)
+		c.Assert(err, check.IsNil)
+	}
+	if units > 0 {
+		err = s.provisioner.AddUnits(&a, units, "web", nil)
+		c.Assert(err, check.IsNil)
+	}
+	return &a
+}
+
+func (s *S) TestCheckNoIssues(c *check.C) {
+	s.newApp(c, "myapp", 2, "myapp.io")
+	reports, err := Check(nil, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(reports, check.DeepEquals, []Report{{Router: "fake", Issues: []Issue{}}})
+}
+
+func (s *S) TestCheckRoutes(c *check.C) {
+	a := s.newApp(c, "myapp", 2)
+	units := s.provisioner.GetUnits(a)
+	err := routertest.FakeRouter.RemoveRoute("myapp", units[0].Address)
+	c.Assert(err, check.IsNil)
+	extra, _ := url.Parse("http://10.0.0.99:8080")
+	err = routertest.FakeRouter.AddRoute("myapp", extra)
+	c.Assert(err, check.IsNil)
+	reports, err := Check([]string{"fake"}, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(reports, check.HasLen, 1)
+	c.Assert(reports[0].Issues, check.DeepEquals, []Issue{
+		{Backend: "myapp", Kind: KindMissingRoute, Value: units[0].Address.Host},
+		{Backend: "myapp", Kind: KindExtraRoute, Value: extra.Host},
+	})
+	c.Assert(routertest.FakeRouter.HasRoute("myapp", extra.String()), check.Equals, true)
+}
+
+func (s *S) TestCheckCNames(c *check.C) {
+	s.newApp(c, "myapp", 1, "myapp.io")
+	err := routertest.FakeRouter.UnsetCName("myapp.io", "myapp")
+	c.Assert(err, check.IsNil)
+	err = routertest.FakeRouter.SetCName("other.io", "myapp")
+	c.Assert(err, check.IsNil)
+	reports, err := Check(nil, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(reports[0].Issues, check.DeepEquals, []Issue{
+		{Backend: "myapp", Kind: KindMissingCName, Value: "myapp.io"},
+		{Backend: "myapp", Kind: KindExtraCName, Value: "other.io"},
+	})
+}
+
+func (s *S) TestCheckMissingBackend(c *check.C) {
+	a := app.App{Name: "myapp", Router: "fake"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	reports, err := Check(nil, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(reports[0].Issues, check.DeepEquals, []Issue{
+		{Backend: "myapp", Kind: KindMissingBackend},
+	})
+}
+
+func (s *S) TestCheckOrphanBackend(c *check.C) {
+	s.newApp(c, "myapp", 1)
+	err := routertest.FakeRouter.AddBackend("removed")
+	c.Assert(err, check.IsNil)
+	err = routertest.FakeRouter.AddBackend("removed-cleanly")
+	c.Assert(err, check.IsNil)
+	err = routertest.FakeRouter.RemoveBackend("removed-cleanly")
+	c.Assert(err, check.IsNil)
+	reports, err := Check(nil, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(reports[0].Issues, check.DeepEquals, []Issue{
+		{Backend: "removed", Kind: KindOrphanBackend},
+	})
+}
+
+func (s *S) TestCheckSkipsOtherRouters(c *check.C) {
+	a := app.App{Name: "myapp", Router: "other"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	reports, err := Check(nil, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(reports[0].Issues, check.HasLen, 0)
+}
+
+func (s *S) TestCheckUnknownRouter(c *check.C) {
+	_, err := Check([]string{"unknown"}, false)
+	c.Assert(err, check.DeepEquals, &router.ErrRouterNotFound{Name: "unknown"})
+}
+
+func (s *S) TestCheckRepair(c *check.C) {
+	a := s.newApp(c, "myapp", 2, "myapp.io")
+	units := s.provisioner.GetUnits(a)
+	err := routertest.FakeRouter.RemoveRoute("myapp", units[0].Address)
+	c.Assert(err, check.IsNil)
+	extra, _ := url.Parse("http://10.0.0.99:8080")
+	err = routertest.FakeRouter.AddRoute("myapp", extra)
+	c.Assert(err, check.IsNil)
+	err = routertest.FakeRouter.SetCName("other.io", "myapp")
+	c.Assert(err, check.IsNil)
+	err = routertest.FakeRouter.AddBackend("removed")
+	c.Assert(err, check.IsNil)
+	reports, err := Check(nil, true)
+	c.Assert(err, check.IsNil)
+	c.Assert(reports[0].Issues, check.DeepEquals, []Issue{
+		{Backend: "myapp", Kind: KindMissingRoute, Value: units[0].Address.Host, Fixed: true},
+		{Backend: "myapp", Kind: KindExtraRoute, Value: extra.Host, Fixed: true},
+		{Backend: "myapp", Kind: KindExtraCName, Value: "other.io", Fixed: true},
+		{Backend: "removed", Kind: KindOrphanBackend, Fixed: true},
+	})
+	c.Assert(routertest.FakeRouter.HasRoute("myapp", units[0].Address.String()), check.Equals, true)
+	c.Assert(routertest.FakeRouter.HasRoute("myapp", extra.String()), check.Equals, false)
+	c.Assert(routertest.FakeRouter.HasCName("other.io"), check.Equals, false)
+	c.Assert(routertest.FakeRouter.HasBackend("removed"), check.Equals, false)
+	reports, err = Check(nil, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(reports[0].Issues, check.HasLen, 0)
+}
+
+func (s *S) TestCheckRepairLockedApp(c *check.C) {
+	a := s.newApp(c, "myapp", 1)
+	extra, _ := url.Parse("http://10.0.0.99:8080")
+	err := routertest.FakeRouter.AddRoute("myapp", extra)
+	c.Assert(err, check.IsNil)
+	locked, err := app.AcquireApplicationLock(a.Name, "someone", "deploy")
+	c.Assert(err, check.IsNil)
+	c.Assert(locked, check.Equals, true)
+	defer app.ReleaseApplicationLock(a.Name)
+	reports, err := Check(nil, true)
+	c.Assert(err, check.IsNil)
+	c.Assert(reports[0].Issues, check.DeepEquals, []Issue{
+		{Backend: "myapp", Kind: KindExtraRoute, Value: extra.Host, Error: `app "myapp" is locked`},
+	})
+	c.Assert(routertest.FakeRouter.HasRoute("myapp", extra.String()), check.Equals, true)
+}
+
+func (s *S) TestNewScheduler(c *check.C) {
+	config.Set("router-check:enabled", true)
+	config.Set("router-check:repair", true)
+	config.Set("router-check:run-interval", 600)
+	defer config.Unset("router-check")
+	sched, err := newScheduler()
+	c.Assert(err, check.IsNil)
+	c.Assert(sched.Enabled, check.Equals, true)
+	c.Assert(sched.Repair, check.Equals, true)
+	c.Assert(sched.RunInterval.Minutes(), check.Equals, float64(10))
+}
+
+func (s *S) TestNewSchedulerDefaults(c *check.C) {
+	sched, err := newScheduler()
+	c.Assert(err, check.IsNil)
+	c.Assert(sched.Enabled, check.Equals, false)
+	c.Assert(sched.Repair, check.Equals, false)
+	c.Assert(sched.RunInterval.Hours(), check.Equals, float64(1))
+}
+
+func (s *S) TestSchedulerRunOnceRegistersEvent(c *check.C) {
+	s.newApp(c, "myapp", 1)
+	err := routertest.FakeRouter.AddBackend("removed")
+	c.Assert(err, check.IsNil)
+	sched := &Scheduler{}
+	err = sched.runOnce()
+	c.Assert(err, check.IsNil)
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeRouter, Value: "fake"},
+		Kind:   eventKind,
+		EndCustomData: map[string]interface{}{
+			"router":         "fake",
+			"issues.backend": "removed",
+			"issues.kind":    KindOrphanBackend,
+			"issues.fixed":   false,
+		},
+	}, eventtest.HasEvent)
+	c.Assert(routertest.FakeRouter.HasBackend("removed"), check.Equals, true)
+}
+
+func (s *S) TestSchedulerRunOnceNoIssues(c *check.C) {
+	s.newApp(c, "myapp", 1)
+	sched := &Scheduler{}
+	err := sched.runOnce()
+	c.Assert(err, check.IsNil)
+	c.Assert(eventtest.EventDesc{IsEmpty: true}, eventtest.HasEvent)
+}
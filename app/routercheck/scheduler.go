@@ -0,0 +1,129 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routercheck
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/api/shutdown"
+	"github.com/tsuru/tsuru/db/lock"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/permission"
+)
+
+const (
+	eventKind = "router-check"
+	lockName  = "router-check"
+)
+
+// Scheduler periodically checks every router, registering an internal event
+// for each router with issues. When Repair is set, the issues are also
+// fixed. Only the tsurud instance holding the router-check lock runs the
+// checks.
+type Scheduler struct {
+	RunInterval time.Duration
+	Repair      bool
+	Enabled     bool
+	done        chan bool
+}
+
+func Initialize() error {
+	s, err := newScheduler()
+	if err != nil {
+		return err
+	}
+	if !s.Enabled {
+		return nil
+	}
+	shutdown.Register(s)
+	go s.run()
+	return nil
+}
+
+func newScheduler() (*Scheduler, error) {
+	enabled, _ := config.GetBool("router-check:enabled")
+	repair, _ := config.GetBool("router-check:repair")
+	runInterval, _ := config.GetInt("router-check:run-interval")
+	if runInterval < 0 {
+		return nil, errors.New("router-check:run-interval must be a positive number of seconds")
+	}
+	s := &Scheduler{
+		RunInterval: time.Duration(runInterval) * time.Second,
+		Repair:      repair,
+		Enabled:     enabled,
+		done:        make(chan bool),
+	}
+	if s.RunInterval == 0 {
+		s.RunInterval = time.Hour
+	}
+	return s, nil
+}
+
+func (s *Scheduler) run() {
+	lock.RunPeriodic(lockName, s.RunInterval, s.runOnce, s.done)
+}
+
+func (s *Scheduler) runOnce() error {
+	reports, err := Check(nil, s.Repair)
+	if err != nil {
+		return err
+	}
+	for i := range reports {
+		report := &reports[i]
+		if len(report.Issues) == 0 {
+			continue
+		}
+		log.Debugf("[router-check] %d issues found in router %q", len(report.Issues), report.Router)
+		err = registerEvent(report)
+		if err != nil {
+			log.Errorf("[router-check] unable to register event for router %q: %s", report.Router, err)
+		}
+	}
+	return nil
+}
+
+func registerEvent(report *Report) error {
+	evt, err := event.NewInternal(&event.Opts{
+		Target:       event.Target{Type: event.TargetTypeRouter, Value: report.Router},
+		InternalKind: eventKind,
+		CustomData:   report,
+		Allowed:      event.Allowed(permission.PermRouterReadEvents),
+	})
+	if err != nil {
+		if _, ok := err.(event.ErrEventLocked); ok {
+			return nil
+		}
+		return err
+	}
+	var evtErr error
+	if n := countFailed(report); n > 0 {
+		evtErr = errors.Errorf("unable to repair %d issues", n)
+	}
+	return evt.DoneCustomData(evtErr, report)
+}
+
+func countFailed(report *Report) int {
+	n := 0
+	for _, issue := range report.Issues {
+		if issue.Error != "" {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Scheduler) Shutdown() {
+	if s.Enabled {
+		s.done <- true
+		s.Enabled = false
+	}
+}
+
+func (s *Scheduler) String() string {
+	return "router checker"
+}
@@ -0,0 +1,223 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/log"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// secretEnvPrefix identifies encrypted values, which are stored as
+// "tsuru-secret:<key id>:<base64 of nonce and ciphertext>".
+const secretEnvPrefix = "tsuru-secret:"
+
+var ErrNoSecretEnvKey = errors.New("secret environment variables require the secret-envs:key setting")
+
+type secretEnvKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+func newSecretEnvKey(encoded string) (*secretEnvKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid secret env key, must be base64 encoded")
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid secret env key")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	return &secretEnvKey{id: hex.EncodeToString(sum[:4]), aead: aead}, nil
+}
+
+// secretEnvKeys returns the key used to encrypt new values, set in
+// secret-envs:key, and every key accepted for decryption, which also include
+// the ones in secret-envs:old-keys.
+func secretEnvKeys() (*secretEnvKey, map[string]*secretEnvKey, error) {
+	encoded, _ := config.GetString("secret-envs:key")
+	if encoded == "" {
+		return nil, nil, ErrNoSecretEnvKey
+	}
+	current, err := newSecretEnvKey(encoded)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := map[string]*secretEnvKey{current.id: current}
+	oldKeys, _ := config.GetList("secret-envs:old-keys")
+	for _, encoded := range oldKeys {
+		key, err := newSecretEnvKey(encoded)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys[key.id] = key
+	}
+	return current, keys, nil
+}
+
+func (k *secretEnvKey) encrypt(value string) (string, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return "", err
+	}
+	sealed := k.aead.Seal(nonce, nonce, []byte(value), nil)
+	return secretEnvPrefix + k.id + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func secretEnvKeyID(value string) string {
+	parts := strings.SplitN(strings.TrimPrefix(value, secretEnvPrefix), ":", 2)
+	return parts[0]
+}
+
+func encryptSecretEnv(value string) (string, error) {
+	key, _, err := secretEnvKeys()
+	if err != nil {
+		return "", err
+	}
+	return key.encrypt(value)
+}
+
+func decryptSecretEnv(value string) (string, error) {
+	_, keys, err := secretEnvKeys()
+	if err != nil {
+		return "", err
+	}
+	return decryptSecretEnvWithKeys(value, keys)
+}
+
+func decryptSecretEnvWithKeys(value string, keys map[string]*secretEnvKey) (string, error) {
+	if !strings.HasPrefix(value, secretEnvPrefix) {
+		return "", errors.New("invalid secret env value")
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, secretEnvPrefix), ":", 2)
+	if len(parts) != 2 {
+		return "", errors.New("invalid secret env value")
+	}
+	key, ok := keys[parts[0]]
+	if !ok {
+		return "", errors.Errorf("no key available to decrypt secret env, key id %q", parts[0])
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.Wrap(err, "invalid secret env value")
+	}
+	nonceSize := key.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", errors.New("invalid secret env value")
+	}
+	plain, err := key.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to decrypt secret env")
+	}
+	return string(plain), nil
+}
+
+// decryptedEnvs returns a copy of the app environment variables with the
+// values of secret variables decrypted. Secret variables that can't be
+// decrypted are left out.
+func (app *App) decryptedEnvs() map[string]bind.EnvVar {
+	hasSecret := false
+	for _, env := range app.Env {
+		if env.Secret {
+			hasSecret = true
+			break
+		}
+	}
+	if !hasSecret {
+		return app.Env
+	}
+	_, keys, err := secretEnvKeys()
+	if err != nil {
+		log.Errorf("[secret envs] unable to decrypt envs for app %q: %s", app.Name, err)
+	}
+	envs := make(map[string]bind.EnvVar, len(app.Env))
+	for name, env := range app.Env {
+		if env.Secret {
+			if keys == nil {
+				continue
+			}
+			env.Value, err = decryptSecretEnvWithKeys(env.Value, keys)
+			if err != nil {
+				log.Errorf("[secret envs] unable to decrypt env %q for app %q: %s", name, app.Name, err)
+				continue
+			}
+		}
+		envs[name] = env
+	}
+	return envs
+}
+
+// RotateSecretEnvs re-encrypts, using the current secret-envs:key, the
+// secret environment variables of every app encrypted with one of the keys
+// in secret-envs:old-keys. It returns the number of updated variables.
+func RotateSecretEnvs(w io.Writer) (int, error) {
+	current, keys, err := secretEnvKeys()
+	if err != nil {
+		return 0, err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	var apps []App
+	err = conn.Apps().Find(bson.M{"env": bson.M{"$exists": true}}).Select(bson.M{"name": 1, "env": 1}).All(&apps)
+	if err != nil {
+		return 0, err
+	}
+	rotated := 0
+	for _, a := range apps {
+		count := 0
+		for name, env := range a.Env {
+			if !env.Secret || secretEnvKeyID(env.Value) == current.id {
+				continue
+			}
+			plain, err := decryptSecretEnvWithKeys(env.Value, keys)
+			if err != nil {
+				return rotated, errors.Wrapf(err, "unable to rotate env %q for app %q", name, a.Name)
+			}
+			value, err := current.encrypt(plain)
+			if err != nil {
+				return rotated, err
+			}
+			field := fmt.Sprintf("env.%s.value", name)
+			err = conn.Apps().Update(bson.M{"name": a.Name, field: env.Value}, bson.M{"$set": bson.M{field: value}})
+			if err == mgo.ErrNotFound {
+				// the env was changed since the app was loaded, and is already
+				// using the current key.
+				continue
+			}
+			if err != nil {
+				return rotated, err
+			}
+			count++
+		}
+		rotated += count
+		if count > 0 && w != nil {
+			fmt.Fprintf(w, "%d secret envs rotated for app %q\n", count, a.Name)
+		}
+	}
+	return rotated, nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app/bind"
+	"gopkg.in/check.v1"
+)
+
+const (
+	testSecretEnvKey1 = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	testSecretEnvKey2 = "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="
+)
+
+func (s *S) TestEncryptDecryptSecretEnv(c *check.C) {
+	config.Set("secret-envs:key", testSecretEnvKey1)
+	defer config.Unset("secret-envs")
+	encrypted, err := encryptSecretEnv("my password")
+	c.Assert(err, check.IsNil)
+	c.Assert(strings.HasPrefix(encrypted, secretEnvPrefix), check.Equals, true)
+	c.Assert(strings.Contains(encrypted, "my password"), check.Equals, false)
+	other, err := encryptSecretEnv("my password")
+	c.Assert(err, check.IsNil)
+	c.Assert(other, check.Not(check.Equals), encrypted)
+	decrypted, err := decryptSecretEnv(encrypted)
+	c.Assert(err, check.IsNil)
+	c.Assert(decrypted, check.Equals, "my password")
+	config.Set("secret-envs:key", testSecretEnvKey2)
+	_, err = decryptSecretEnv(encrypted)
+	c.Assert(err, check.ErrorMatches, `no key available to decrypt secret env, key id ".+"`)
+	config.Set("secret-envs:old-keys", []interface{}{testSecretEnvKey1})
+	decrypted, err = decryptSecretEnv(encrypted)
+	c.Assert(err, check.IsNil)
+	c.Assert(decrypted, check.Equals, "my password")
+}
+
+func (s *S) TestEncryptSecretEnvInvalidKey(c *check.C) {
+	_, err := encryptSecretEnv("value")
+	c.Assert(err, check.Equals, ErrNoSecretEnvKey)
+	config.Set("secret-envs:key", "bm90IGEga2V5")
+	defer config.Unset("secret-envs")
+	_, err = encryptSecretEnv("value")
+	c.Assert(err, check.ErrorMatches, `invalid secret env key: .*`)
+}
+
+func (s *S) TestSetEnvsSecret(c *check.C) {
+	config.Set("secret-envs:key", testSecretEnvKey1)
+	defer config.Unset("secret-envs")
+	a := App{Name: "myapp", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.setEnvsToApp(bind.SetEnvApp{
+		Envs: []bind.EnvVar{
+			{Name: "DATABASE_PASSWORD", Value: "123", Public: true, Secret: true},
+			{Name: "DATABASE_HOST", Value: "localhost", Public: true},
+		},
+	}, nil)
+	c.Assert(err, check.IsNil)
+	newApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	stored := newApp.Env["DATABASE_PASSWORD"]
+	c.Assert(stored.Secret, check.Equals, true)
+	c.Assert(stored.Public, check.Equals, false)
+	c.Assert(strings.HasPrefix(stored.Value, secretEnvPrefix), check.Equals, true)
+	c.Assert(newApp.Envs(), check.DeepEquals, map[string]bind.EnvVar{
+		"DATABASE_PASSWORD": {Name: "DATABASE_PASSWORD", Value: "123", Secret: true},
+		"DATABASE_HOST":     {Name: "DATABASE_HOST", Value: "localhost", Public: true},
+	})
+	c.Assert(newApp.Env["DATABASE_PASSWORD"].Value, check.Equals, stored.Value)
+}
+
+func (s *S) TestSetEnvsSecretWithoutKey(c *check.C) {
+	a := App{Name: "myapp", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.setEnvsToApp(bind.SetEnvApp{
+		Envs: []bind.EnvVar{{Name: "DATABASE_PASSWORD", Value: "123", Secret: true}},
+	}, nil)
+	c.Assert(err, check.Equals, ErrNoSecretEnvKey)
+	newApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	_, ok := newApp.Env["DATABASE_PASSWORD"]
+	c.Assert(ok, check.Equals, false)
+}
+
+func (s *S) TestEnvsSecretWithUnknownKey(c *check.C) {
+	config.Set("secret-envs:key", testSecretEnvKey1)
+	defer config.Unset("secret-envs")
+	encrypted, err := encryptSecretEnv("123")
+	c.Assert(err, check.IsNil)
+	config.Set("secret-envs:key", testSecretEnvKey2)
+	a := App{Name: "myapp", Env: map[string]bind.EnvVar{
+		"DATABASE_PASSWORD": {Name: "DATABASE_PASSWORD", Value: encrypted, Secret: true},
+		"DATABASE_HOST":     {Name: "DATABASE_HOST", Value: "localhost", Public: true},
+	}}
+	c.Assert(a.Envs(), check.DeepEquals, map[string]bind.EnvVar{
+		"DATABASE_HOST": {Name: "DATABASE_HOST", Value: "localhost", Public: true},
+	})
+}
+
+func (s *S) TestRotateSecretEnvs(c *check.C) {
+	config.Set("secret-envs:key", testSecretEnvKey1)
+	defer config.Unset("secret-envs")
+	a := App{Name: "myapp", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.setEnvsToApp(bind.SetEnvApp{
+		Envs: []bind.EnvVar{
+			{Name: "DATABASE_PASSWORD", Value: "123", Secret: true},
+			{Name: "API_KEY", Value: "abc", Secret: true},
+			{Name: "DATABASE_HOST", Value: "localhost", Public: true},
+		},
+	}, nil)
+	c.Assert(err, check.IsNil)
+	config.Set("secret-envs:key", testSecretEnvKey2)
+	config.Set("secret-envs:old-keys", []interface{}{testSecretEnvKey1})
+	var buf bytes.Buffer
+	n, err := RotateSecretEnvs(&buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 2)
+	c.Assert(buf.String(), check.Equals, "2 secret envs rotated for app \"myapp\"\n")
+	n, err = RotateSecretEnvs(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 0)
+	config.Unset("secret-envs:old-keys")
+	newApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	envs := newApp.Envs()
+	c.Assert(envs["DATABASE_PASSWORD"].Value, check.Equals, "123")
+	c.Assert(envs["API_KEY"].Value, check.Equals, "abc")
+	c.Assert(envs["DATABASE_HOST"].Value, check.Equals, "localhost")
+}
@@ -0,0 +1,283 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/router"
+)
+
+type DeployStrategyKind string
+
+const (
+	DeployStrategyCanary    DeployStrategyKind = "canary"
+	DeployStrategyBlueGreen DeployStrategyKind = "blue-green"
+)
+
+const defaultCanaryPercent = 10
+
+var ErrDeployCanceled = errors.New("deploy canceled by user action")
+
+// DeployStrategy describes how a new version of an app replaces the current
+// one. An empty Kind means every unit is replaced at once by the provisioner.
+type DeployStrategy struct {
+	Kind DeployStrategyKind
+	// Percent is the percentage of units moved to the new version on each
+	// canary step. On routers supporting route weights, every new unit is
+	// started in the first step and Percent is the percentage of the traffic
+	// moved to them on each step instead.
+	Percent int
+	// Interval is the time to wait between canary steps.
+	Interval time.Duration
+}
+
+func (s DeployStrategy) Validate() error {
+	switch s.Kind {
+	case "", DeployStrategyBlueGreen:
+	case DeployStrategyCanary:
+		if s.Percent < 0 || s.Percent > 100 {
+			return errors.Errorf("invalid canary percent: %d", s.Percent)
+		}
+	default:
+		return errors.Errorf("invalid deploy strategy: %q", s.Kind)
+	}
+	return nil
+}
+
+// steps returns the percentage of units running the new version at the end
+// of each step of the strategy.
+func (s DeployStrategy) steps() []int {
+	if s.Kind != DeployStrategyCanary {
+		return []int{100}
+	}
+	percent := s.Percent
+	if percent == 0 {
+		percent = defaultCanaryPercent
+	}
+	var steps []int
+	for p := percent; p < 100; p += percent {
+		steps = append(steps, p)
+	}
+	return append(steps, 100)
+}
+
+func unitsAddresses(units []provision.Unit) []*url.URL {
+	addrs := make([]*url.URL, 0, len(units))
+	for _, u := range units {
+		if u.Address != nil {
+			addrs = append(addrs, u.Address)
+		}
+	}
+	return addrs
+}
+
+type strategyDeploy struct {
+	opts     *DeployOptions
+	evt      *event.Event
+	deployer provision.VersionedDeployer
+	router   router.Router
+	// weighted is set when the router is able to split traffic between
+	// versions, in which case old units keep their routes until the last
+	// step.
+	weighted       router.WeightedRouter
+	weightsChanged bool
+	image          string
+	oldRoutes      []*url.URL
+	newUnits       []provision.Unit
+	unrouted       int
+	writer         io.Writer
+	appName        string
+	stepCount      int
+}
+
+// deployWithStrategy starts units of the new image alongside the current
+// ones, moving traffic to them progressively. Any failure removes the new
+// units and restores the original routes.
+func deployWithStrategy(opts *DeployOptions, evt *event.Event) (string, error) {
+	if err := opts.Strategy.Validate(); err != nil {
+		return "", err
+	}
+	prov, err := opts.App.getProvisioner()
+	if err != nil {
+		return "", err
+	}
+	deployer, ok := prov.(provision.VersionedDeployer)
+	if !ok {
+		return "", provision.ProvisionerNotSupported{Prov: prov, Action: fmt.Sprintf("%s deploy strategy", opts.Strategy.Kind)}
+	}
+	if opts.Kind != DeployRollback && opts.Kind != DeployImage {
+		return "", errors.Errorf("deploy strategy %q is only available for image and rollback deploys", opts.Strategy.Kind)
+	}
+	r, err := opts.App.GetRouter()
+	if err != nil {
+		return "", err
+	}
+	addrs, err := prov.RoutableAddresses(opts.App)
+	if err != nil {
+		return "", err
+	}
+	oldRoutes := make([]*url.URL, len(addrs))
+	for i := range addrs {
+		oldRoutes[i] = &addrs[i]
+	}
+	img, err := deployer.PrepareVersion(opts.App, opts.Image, evt)
+	if err != nil {
+		return "", err
+	}
+	d := strategyDeploy{
+		opts:      opts,
+		evt:       evt,
+		deployer:  deployer,
+		router:    r,
+		image:     img,
+		oldRoutes: oldRoutes,
+		writer:    evt,
+		appName:   opts.App.Name,
+	}
+	d.weighted, _ = r.(router.WeightedRouter)
+	err = d.run()
+	if err != nil {
+		d.rollback(err)
+		return "", err
+	}
+	return d.image, nil
+}
+
+func (d *strategyDeploy) run() error {
+	total := len(d.oldRoutes)
+	if total == 0 {
+		total = 1
+	}
+	steps := d.opts.Strategy.steps()
+	d.stepCount = len(steps)
+	for i, percent := range steps {
+		if err := checkCanceled(d.evt); err != nil {
+			return err
+		}
+		fmt.Fprintf(d.writer, "\n---- %s deploy step %d/%d: moving %d%% of traffic to %s ----\n", d.opts.Strategy.Kind, i+1, d.stepCount, percent, d.image)
+		want := (total*percent + 99) / 100
+		if d.weighted != nil {
+			// Weights are only meaningful when both versions run the
+			// same number of units, so all of them are started at once.
+			want = total
+		}
+		if missing := want - len(d.newUnits); missing > 0 {
+			units, err := d.deployer.AddVersionUnits(d.opts.App, d.image, uint(missing), d.writer)
+			if err != nil {
+				return err
+			}
+			d.newUnits = append(d.newUnits, units...)
+			if d.weighted != nil && len(d.oldRoutes) > 0 {
+				err = router.SetRoutesWeight(d.weighted, d.appName, d.oldRoutes, router.MaxRouteWeight)
+				if err != nil {
+					return err
+				}
+				d.weightsChanged = true
+			}
+			err = d.router.AddRoutes(d.appName, unitsAddresses(units))
+			if err != nil {
+				return err
+			}
+		}
+		var err error
+		if d.weighted != nil {
+			err = d.shiftWeights(percent)
+		} else {
+			err = d.unrouteOld(len(d.oldRoutes) - (total - want))
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(d.writer, " ---> %d new units routed, %d old units routed\n", len(d.newUnits), len(d.oldRoutes)-d.unrouted)
+		if i < d.stepCount-1 && d.opts.Strategy.Interval > 0 {
+			time.Sleep(d.opts.Strategy.Interval)
+		}
+	}
+	fmt.Fprintf(d.writer, "\n---- Promoting %s ----\n", d.image)
+	return d.deployer.PromoteVersion(d.opts.App, d.image, d.writer)
+}
+
+// shiftWeights sends the given percentage of the traffic to the new units.
+// Old units lose their routes once every request goes to the new ones, and
+// the new routes get back the default weight, used by units added later.
+func (d *strategyDeploy) shiftWeights(percent int) error {
+	if percent >= 100 {
+		err := d.unrouteOld(len(d.oldRoutes))
+		if err != nil || !d.weightsChanged {
+			return err
+		}
+		return router.SetRoutesWeight(d.weighted, d.appName, unitsAddresses(d.newUnits), router.DefaultRouteWeight)
+	}
+	if len(d.oldRoutes) == 0 {
+		return nil
+	}
+	err := router.SetRoutesWeight(d.weighted, d.appName, unitsAddresses(d.newUnits), percent)
+	if err != nil {
+		return err
+	}
+	return router.SetRoutesWeight(d.weighted, d.appName, d.oldRoutes, 100-percent)
+}
+
+// unrouteOld removes the routes of the first n old units.
+func (d *strategyDeploy) unrouteOld(n int) error {
+	if n <= d.unrouted {
+		return nil
+	}
+	err := d.router.RemoveRoutes(d.appName, d.oldRoutes[d.unrouted:n])
+	if err != nil {
+		return err
+	}
+	d.unrouted = n
+	return nil
+}
+
+func (d *strategyDeploy) rollback(cause error) {
+	fmt.Fprintf(d.writer, "\n---- %s deploy failed, rolling back: %s ----\n", d.opts.Strategy.Kind, cause)
+	if d.unrouted > 0 {
+		err := d.router.AddRoutes(d.appName, d.oldRoutes[:d.unrouted])
+		if err != nil {
+			log.Errorf("[deploy strategy] unable to restore routes for app %q: %s", d.appName, err)
+		}
+	}
+	if d.weightsChanged {
+		err := router.SetRoutesWeight(d.weighted, d.appName, d.oldRoutes, router.DefaultRouteWeight)
+		if err != nil {
+			log.Errorf("[deploy strategy] unable to restore route weights for app %q: %s", d.appName, err)
+		}
+	}
+	if len(d.newUnits) > 0 {
+		err := d.router.RemoveRoutes(d.appName, unitsAddresses(d.newUnits))
+		if err != nil {
+			log.Errorf("[deploy strategy] unable to remove new routes for app %q: %s", d.appName, err)
+		}
+	}
+	err := d.deployer.RemoveVersionUnits(d.opts.App, d.image, d.writer)
+	if err != nil {
+		log.Errorf("[deploy strategy] unable to remove units for image %q: %s", d.image, err)
+	}
+}
+
+func checkCanceled(evt *event.Event) error {
+	if evt == nil {
+		return nil
+	}
+	canceled, err := evt.AckCancel()
+	if err != nil {
+		log.Errorf("unable to check if event should be canceled, ignoring: %s", err)
+		return nil
+	}
+	if canceled {
+		return ErrDeployCanceled
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/router"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+// unweightedRouter hides the weight support of the fake router.
+type unweightedRouter struct {
+	router.Router
+}
+
+func init() {
+	router.Register("fake-unweighted", func(string, string) (router.Router, error) {
+		return unweightedRouter{&routertest.FakeRouter}, nil
+	})
+}
+
+func (s *S) TestDeployStrategySteps(c *check.C) {
+	c.Assert(DeployStrategy{}.steps(), check.DeepEquals, []int{100})
+	c.Assert(DeployStrategy{Kind: DeployStrategyBlueGreen}.steps(), check.DeepEquals, []int{100})
+	c.Assert(DeployStrategy{Kind: DeployStrategyCanary, Percent: 30}.steps(), check.DeepEquals, []int{30, 60, 90, 100})
+	c.Assert(DeployStrategy{Kind: DeployStrategyCanary, Percent: 50}.steps(), check.DeepEquals, []int{50, 100})
+	c.Assert(DeployStrategy{Kind: DeployStrategyCanary}.steps(), check.HasLen, 10)
+}
+
+func (s *S) TestDeployStrategyValidate(c *check.C) {
+	c.Assert(DeployStrategy{}.Validate(), check.IsNil)
+	c.Assert(DeployStrategy{Kind: DeployStrategyCanary, Percent: 20}.Validate(), check.IsNil)
+	c.Assert(DeployStrategy{Kind: DeployStrategyCanary, Percent: 120}.Validate(), check.ErrorMatches, `invalid canary percent: 120`)
+	c.Assert(DeployStrategy{Kind: "rainbow"}.Validate(), check.ErrorMatches, `invalid deploy strategy: "rainbow"`)
+}
+
+func (s *S) newStrategyDeployApp(c *check.C, routerName string) (*App, *event.Event) {
+	a := App{
+		Name:      "otherapp",
+		Platform:  "zend",
+		Teams:     []string{s.team.Name},
+		TeamOwner: s.team.Name,
+		Router:    routerName,
+	}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.AddUnits(&a, 4, "web", nil)
+	c.Assert(err, check.IsNil)
+	evt, err := event.New(&event.Opts{
+		Target:   event.Target{Type: "app", Value: a.Name},
+		Kind:     permission.PermAppDeploy,
+		RawOwner: event.Owner{Type: event.OwnerTypeUser, Name: s.user.Email},
+		Allowed:  event.Allowed(permission.PermApp),
+	})
+	c.Assert(err, check.IsNil)
+	return &a, evt
+}
+
+func (s *S) TestDeployWithCanaryStrategy(c *check.C) {
+	a, evt := s.newStrategyDeployApp(c, "fake")
+	oldUnits, err := a.Units()
+	c.Assert(err, check.IsNil)
+	writer := &bytes.Buffer{}
+	imgID, err := Deploy(DeployOptions{
+		App:          a,
+		OutputStream: writer,
+		Image:        "registry.somewhere/tsuru/app-example:v2",
+		Event:        evt,
+		Strategy:     DeployStrategy{Kind: DeployStrategyCanary, Percent: 50},
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(imgID, check.Equals, "registry.somewhere/tsuru/app-example:v2")
+	newUnits, err := a.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(newUnits, check.HasLen, 4)
+	for _, u := range oldUnits {
+		c.Assert(routertest.FakeRouter.HasRoute(a.Name, u.Address.String()), check.Equals, false)
+	}
+	weights, err := routertest.FakeRouter.RouteWeights(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(weights, check.HasLen, 4)
+	for _, u := range newUnits {
+		c.Assert(routertest.FakeRouter.HasRoute(a.Name, u.Address.String()), check.Equals, true)
+		c.Assert(weights[u.Address.String()], check.Equals, router.DefaultRouteWeight)
+	}
+	c.Assert(writer.String(), check.Matches, `(?s).*canary deploy step 1/2: moving 50% of traffic.*4 new units routed, 4 old units routed.*canary deploy step 2/2: moving 100% of traffic.*Promoting.*`)
+}
+
+func (s *S) TestDeployWithCanaryStrategyUnweightedRouter(c *check.C) {
+	config.Set("routers:fake-unweighted:type", "fake-unweighted")
+	defer config.Unset("routers:fake-unweighted")
+	a, evt := s.newStrategyDeployApp(c, "fake-unweighted")
+	oldUnits, err := a.Units()
+	c.Assert(err, check.IsNil)
+	writer := &bytes.Buffer{}
+	_, err = Deploy(DeployOptions{
+		App:          a,
+		OutputStream: writer,
+		Image:        "registry.somewhere/tsuru/app-example:v2",
+		Event:        evt,
+		Strategy:     DeployStrategy{Kind: DeployStrategyCanary, Percent: 50},
+	})
+	c.Assert(err, check.IsNil)
+	newUnits, err := a.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(newUnits, check.HasLen, 4)
+	for _, u := range oldUnits {
+		c.Assert(routertest.FakeRouter.HasRoute(a.Name, u.Address.String()), check.Equals, false)
+	}
+	for _, u := range newUnits {
+		c.Assert(routertest.FakeRouter.HasRoute(a.Name, u.Address.String()), check.Equals, true)
+	}
+	c.Assert(writer.String(), check.Matches, `(?s).*canary deploy step 1/2.*2 new units routed, 2 old units routed.*canary deploy step 2/2.*4 new units routed, 0 old units routed.*`)
+}
+
+func (s *S) TestDeployWithCanaryStrategyRollbackOnUnhealthyUnits(c *check.C) {
+	a, evt := s.newStrategyDeployApp(c, "fake")
+	oldUnits, err := a.Units()
+	c.Assert(err, check.IsNil)
+	s.provisioner.PrepareFailure("AddVersionUnits", errors.New("healthcheck fail"))
+	_, err = Deploy(DeployOptions{
+		App:          a,
+		OutputStream: &bytes.Buffer{},
+		Image:        "registry.somewhere/tsuru/app-example:v2",
+		Event:        evt,
+		Strategy:     DeployStrategy{Kind: DeployStrategyCanary, Percent: 50},
+	})
+	c.Assert(err, check.ErrorMatches, "healthcheck fail")
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.DeepEquals, oldUnits)
+	routes, err := routertest.FakeRouter.Routes(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.HasLen, 4)
+}
+
+func (s *S) TestDeployWithBlueGreenStrategyRollbackOnFailure(c *check.C) {
+	a, evt := s.newStrategyDeployApp(c, "fake")
+	oldUnits, err := a.Units()
+	c.Assert(err, check.IsNil)
+	s.provisioner.PrepareFailure("PromoteVersion", errors.New("promote failed"))
+	_, err = Deploy(DeployOptions{
+		App:          a,
+		OutputStream: &bytes.Buffer{},
+		Image:        "registry.somewhere/tsuru/app-example:v2",
+		Event:        evt,
+		Strategy:     DeployStrategy{Kind: DeployStrategyBlueGreen},
+	})
+	c.Assert(err, check.ErrorMatches, "promote failed")
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.DeepEquals, oldUnits)
+	for _, u := range oldUnits {
+		c.Assert(routertest.FakeRouter.HasRoute(a.Name, u.Address.String()), check.Equals, true)
+	}
+	c.Assert(s.provisioner.VersionUnits(a, "registry.somewhere/tsuru/app-example:v2"), check.HasLen, 0)
+	routes, err := routertest.FakeRouter.Routes(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.HasLen, 4)
+	weights, err := routertest.FakeRouter.RouteWeights(a.Name)
+	c.Assert(err, check.IsNil)
+	for _, u := range oldUnits {
+		c.Assert(weights[u.Address.Host], check.Equals, router.DefaultRouteWeight)
+	}
+}
+
+func (s *S) TestDeployWithStrategyInvalidKind(c *check.C) {
+	a, evt := s.newStrategyDeployApp(c, "fake")
+	_, err := Deploy(DeployOptions{
+		App:          a,
+		OutputStream: &bytes.Buffer{},
+		ArchiveURL:   "https://s3.amazonaws.com/smt/archive.tar.gz",
+		Event:        evt,
+		Strategy:     DeployStrategy{Kind: DeployStrategyCanary},
+	})
+	c.Assert(err, check.ErrorMatches, `deploy strategy "canary" is only available for image and rollback deploys`)
+}
@@ -26,7 +26,19 @@ func init() {
 	auth.RegisterScheme("native", NativeScheme{})
 }
 
+// Login authenticates the user with the "email" and "password" params. Users
+// with two-factor authentication enabled, or required by one of their roles,
+// must also provide a code, either in the "otp" param or in a second step,
+// retrying the login with the "challenge" and "otp" params after a
+// *auth.TwoFactorRequired error.
 func (s NativeScheme) Login(params map[string]string) (auth.Token, error) {
+	if challenge, ok := params["challenge"]; ok {
+		token, err := loginWithChallenge(challenge, params["otp"])
+		if err != nil {
+			return nil, err
+		}
+		return token, nil
+	}
 	email, ok := params["email"]
 	if !ok {
 		return nil, ErrMissingEmailError
@@ -39,7 +51,30 @@ func (s NativeScheme) Login(params map[string]string) (auth.Token, error) {
 	if err != nil {
 		return nil, err
 	}
-	token, err := createToken(user, password)
+	if err = checkPassword(user.Password, password); err != nil {
+		return nil, err
+	}
+	tf, err := getTwoFactor(user.Email)
+	if err != nil {
+		return nil, err
+	}
+	if tf.Enabled || twoFactorRequired(user) {
+		otp := params["otp"]
+		if otp == "" || !tf.Enabled {
+			challenge, err := newTwoFactorChallenge(user, tf)
+			if err != nil {
+				return nil, err
+			}
+			return nil, challenge
+		}
+		if err = tf.verifyCode(otp, true); err != nil {
+			return nil, err
+		}
+		if err = tf.save(); err != nil {
+			return nil, err
+		}
+	}
+	token, err := insertToken(user)
 	if err != nil {
 		return nil, err
 	}
@@ -139,6 +174,10 @@ func (s NativeScheme) Remove(u *auth.User) error {
 	if err != nil {
 		return err
 	}
+	err = deleteTwoFactor(u.Email)
+	if err != nil {
+		return err
+	}
 	return u.Delete()
 }
 
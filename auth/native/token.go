@@ -41,12 +41,20 @@ type Token struct {
 	Expires   time.Duration `json:"expires"`
 	UserEmail string        `json:"email"`
 	AppName   string        `json:"app"`
+
+	recoveryCodes []string
 }
 
 func (t *Token) GetValue() string {
 	return t.Token
 }
 
+// RecoveryCodes returns the two-factor recovery codes generated by the login
+// that created the token, if any. They're never stored.
+func (t *Token) RecoveryCodes() []string {
+	return t.recoveryCodes
+}
+
 func (t *Token) User() (*auth.User, error) {
 	return auth.GetUserByEmail(t.UserEmail)
 }
@@ -169,13 +177,7 @@ func checkPassword(passwordHash string, password string) error {
 	return auth.AuthenticationFailure{Message: "Authentication failed, wrong password."}
 }
 
-func createToken(u *auth.User, password string) (*Token, error) {
-	if u.Email == "" {
-		return nil, errors.New("User does not have an email")
-	}
-	if err := checkPassword(u.Password, password); err != nil {
-		return nil, err
-	}
+func insertToken(u *auth.User) (*Token, error) {
 	conn, err := db.Conn()
 	if err != nil {
 		return nil, err
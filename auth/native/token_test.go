@@ -197,12 +197,12 @@ func (s *S) TestRemoveOldWithoutSetting(c *check.C) {
 	c.Assert(err, check.NotNil)
 }
 
-func (s *S) TestCreateTokenShouldSaveTheTokenInTheDatabase(c *check.C) {
+func (s *S) TestInsertTokenShouldSaveTheTokenInTheDatabase(c *check.C) {
 	u := auth.User{Email: "wolverine@xmen.com", Password: "123456"}
 	_, err := nativeScheme.Create(&u)
 	c.Assert(err, check.IsNil)
 	defer u.Delete()
-	_, err = createToken(&u, "123456")
+	_, err = insertToken(&u)
 	c.Assert(err, check.IsNil)
 	var result Token
 	err = s.conn.Tokens().Find(bson.M{"useremail": u.Email}).One(&result)
@@ -210,7 +210,7 @@ func (s *S) TestCreateTokenShouldSaveTheTokenInTheDatabase(c *check.C) {
 	c.Assert(result.Token, check.NotNil)
 }
 
-func (s *S) TestCreateTokenRemoveOldTokens(c *check.C) {
+func (s *S) TestInsertTokenRemoveOldTokens(c *check.C) {
 	config.Set("auth:max-simultaneous-sessions", 2)
 	u := auth.User{Email: "para@xmen.com", Password: "123456"}
 	_, err := nativeScheme.Create(&u)
@@ -223,7 +223,7 @@ func (s *S) TestCreateTokenRemoveOldTokens(c *check.C) {
 	t2.Token += "aa"
 	err = s.conn.Tokens().Insert(t1, t2)
 	c.Assert(err, check.IsNil)
-	_, err = createToken(&u, "123456")
+	_, err = insertToken(&u)
 	c.Assert(err, check.IsNil)
 	ok := make(chan bool, 1)
 	go func() {
@@ -244,7 +244,7 @@ func (s *S) TestCreateTokenRemoveOldTokens(c *check.C) {
 	}
 }
 
-func (s *S) TestCreateTokenUsesDefaultCostWhenHasCostIsUndefined(c *check.C) {
+func (s *S) TestInsertTokenUsesDefaultCostWhenHasCostIsUndefined(c *check.C) {
 	err := config.Unset("auth:hash-cost")
 	c.Assert(err, check.IsNil)
 	defer config.Set("auth:hash-cost", bcrypt.MinCost)
@@ -254,24 +254,15 @@ func (s *S) TestCreateTokenUsesDefaultCostWhenHasCostIsUndefined(c *check.C) {
 	defer u.Delete()
 	cost = 0
 	tokenExpire = 0
-	_, err = createToken(&u, "123456")
+	_, err = insertToken(&u)
 	c.Assert(err, check.IsNil)
 }
 
-func (s *S) TestCreateTokenShouldReturnErrorIfTheProvidedUserDoesNotHaveEmailDefined(c *check.C) {
+func (s *S) TestInsertTokenShouldReturnErrorIfTheProvidedUserDoesNotHaveEmailDefined(c *check.C) {
 	u := auth.User{Password: "123"}
-	_, err := createToken(&u, "123")
-	c.Assert(err, check.NotNil)
-	c.Assert(err, check.ErrorMatches, "^User does not have an email$")
-}
-
-func (s *S) TestCreateTokenShouldValidateThePassword(c *check.C) {
-	u := auth.User{Email: "me@gmail.com", Password: "123456"}
-	_, err := nativeScheme.Create(&u)
-	c.Assert(err, check.IsNil)
-	defer u.Delete()
-	_, err = createToken(&u, "123")
+	_, err := insertToken(&u)
 	c.Assert(err, check.NotNil)
+	c.Assert(err, check.ErrorMatches, "^Impossible to generate tokens for users without email$")
 }
 
 func (s *S) TestGetToken(c *check.C) {
@@ -0,0 +1,494 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package native
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/errors"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	totpPeriod           = 30
+	totpDigits           = 6
+	totpSkew             = 1
+	totpSecretSize       = 20
+	recoveryCodeCount    = 10
+	challengeExpiration  = 5 * time.Minute
+	maxChallengeAttempts = 5
+	maxFailedAttempts    = 10
+	failureLockout       = 15 * time.Minute
+	defaultTOTPIssuer    = "tsuru"
+)
+
+var (
+	ErrMissingOTP              = &errors.ValidationError{Message: "you must provide the two-factor authentication code"}
+	ErrInvalidOTP              = auth.AuthenticationFailure{Message: "Authentication failed, invalid two-factor authentication code."}
+	ErrInvalidChallenge        = auth.AuthenticationFailure{Message: "Authentication failed, invalid or expired two-factor challenge."}
+	ErrTwoFactorLocked         = auth.AuthenticationFailure{Message: "Authentication failed, too many invalid two-factor authentication codes. Try again later."}
+	ErrTwoFactorEnabled        = &errors.ConflictError{Message: "two-factor authentication is already enabled"}
+	ErrTwoFactorNotEnabled     = &errors.ValidationError{Message: "two-factor authentication is not enabled"}
+	ErrTwoFactorNotPending     = &errors.ValidationError{Message: "there is no pending two-factor authentication enrollment"}
+	ErrTwoFactorRequiredByRole = &errors.NotAuthorizedError{Message: "two-factor authentication is required by one of your roles"}
+)
+
+// twoFactor holds the TOTP secret of a user. The secret is set but Enabled is
+// false while the enrollment is pending confirmation. Recovery codes are
+// stored as SHA-256 hashes and removed once used. LastStep is the time step of
+// the last accepted code, codes are not accepted twice. FailedAttempts counts
+// the invalid codes since the last accepted one, no code is accepted until
+// LockedUntil once it reaches maxFailedAttempts.
+type twoFactor struct {
+	UserEmail      string `bson:"_id"`
+	Secret         string
+	Enabled        bool
+	RecoveryCodes  []string
+	LastStep       int64
+	FailedAttempts int
+	LockedUntil    time.Time
+}
+
+// twoFactorChallenge is created when the password of a user with two-factor
+// authentication is accepted, and consumed by the second login step.
+type twoFactorChallenge struct {
+	Token     string `bson:"_id"`
+	UserEmail string
+	Creation  time.Time
+	Attempts  int
+}
+
+func getTwoFactor(email string) (*twoFactor, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var tf twoFactor
+	err = conn.TwoFactor().FindId(email).One(&tf)
+	if err == mgo.ErrNotFound {
+		return &twoFactor{UserEmail: email}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tf, nil
+}
+
+func (tf *twoFactor) save() error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.TwoFactor().UpsertId(tf.UserEmail, tf)
+	return err
+}
+
+func deleteTwoFactor(email string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.TwoFactor().RemoveId(email)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// twoFactorRequired returns whether any of the roles of the user is listed in
+// the auth:two-factor:required-roles setting.
+func twoFactorRequired(u *auth.User) bool {
+	roles, _ := config.GetList("auth:two-factor:required-roles")
+	for _, required := range roles {
+		for _, r := range u.Roles {
+			if r.Name == required {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkCode validates a TOTP code, accepting codes from the adjacent time
+// steps to allow for clock drift. Recovery codes are also accepted when
+// allowRecovery is true. The caller must save tf when the code is valid.
+func (tf *twoFactor) checkCode(code string, allowRecovery bool, now time.Time) bool {
+	code = strings.Replace(strings.TrimSpace(code), " ", "", -1)
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= tf.LastStep {
+			continue
+		}
+		expected, err := totpCode(tf.Secret, step)
+		if err == nil && hmac.Equal([]byte(expected), []byte(code)) {
+			tf.LastStep = step
+			return true
+		}
+	}
+	if !allowRecovery {
+		return false
+	}
+	hashed := hashRecoveryCode(code)
+	for i, c := range tf.RecoveryCodes {
+		if hmac.Equal([]byte(c), []byte(hashed)) {
+			tf.RecoveryCodes = append(tf.RecoveryCodes[:i], tf.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// verifyCode checks a code like checkCode, refusing any code while the user
+// is locked out. Invalid codes are counted, locking the user out after
+// maxFailedAttempts of them, so the limit holds across challenges and logins.
+// The caller must save tf when the code is valid.
+func (tf *twoFactor) verifyCode(code string, allowRecovery bool) error {
+	now := time.Now()
+	if now.Before(tf.LockedUntil) {
+		return ErrTwoFactorLocked
+	}
+	if tf.Secret != "" && tf.checkCode(code, allowRecovery, now) {
+		tf.FailedAttempts = 0
+		return nil
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var updated twoFactor
+	_, err = conn.TwoFactor().FindId(tf.UserEmail).Apply(mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"failedattempts": 1}},
+		ReturnNew: true,
+	}, &updated)
+	if err == mgo.ErrNotFound {
+		return ErrInvalidOTP
+	}
+	if err != nil {
+		return err
+	}
+	if updated.FailedAttempts < maxFailedAttempts {
+		return ErrInvalidOTP
+	}
+	err = conn.TwoFactor().UpdateId(tf.UserEmail, bson.M{
+		"$set": bson.M{"failedattempts": 0, "lockeduntil": now.Add(failureLockout)},
+	})
+	if err != nil {
+		return err
+	}
+	return ErrTwoFactorLocked
+}
+
+// newRecoveryCodes replaces the recovery codes of tf, returning the new codes
+// in clear text.
+func (tf *twoFactor) newRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	tf.RecoveryCodes = make([]string, recoveryCodeCount)
+	for i := range codes {
+		var b [5]byte
+		_, err := rand.Read(b[:])
+		if err != nil {
+			return nil, err
+		}
+		raw := hex.EncodeToString(b[:])
+		codes[i] = raw[:5] + "-" + raw[5:]
+		tf.RecoveryCodes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(code, "-", "", -1))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func newTOTPSecret() (string, error) {
+	var b [totpSecretSize]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(b[:]), nil
+}
+
+// totpCode generates the code for the given time step as described in RFC
+// 6238, using HMAC-SHA1.
+func totpCode(secret string, step int64) (string, error) {
+	key, err := base32.StdEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod), nil
+}
+
+func provisioningURI(secret, email string) string {
+	issuer, _ := config.GetString("auth:two-factor:issuer")
+	if issuer == "" {
+		issuer = defaultTOTPIssuer
+	}
+	v := url.Values{}
+	v.Set("secret", strings.TrimRight(secret, "="))
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + email,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+// newTwoFactorChallenge creates the challenge for the second login step. When
+// the user has not enrolled yet, a pending secret is created, or reused, and
+// its provisioning URI is returned along with the challenge.
+func newTwoFactorChallenge(u *auth.User, tf *twoFactor) (*auth.TwoFactorRequired, error) {
+	result := auth.TwoFactorRequired{}
+	if !tf.Enabled {
+		if tf.Secret == "" {
+			secret, err := newTOTPSecret()
+			if err != nil {
+				return nil, err
+			}
+			tf.Secret = secret
+			err = tf.save()
+			if err != nil {
+				return nil, err
+			}
+		}
+		result.EnrollURI = provisioningURI(tf.Secret, u.Email)
+	}
+	challenge := twoFactorChallenge{
+		Token:     token(u.Email, crypto.SHA256),
+		UserEmail: u.Email,
+		Creation:  time.Now(),
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	err = conn.TwoFactorChallenges().Insert(challenge)
+	if err != nil {
+		return nil, err
+	}
+	result.Challenge = challenge.Token
+	return &result, nil
+}
+
+// loginWithChallenge is the second login step, creating the token when the
+// code is valid. A code sent for a user that has not enrolled yet confirms
+// the enrollment, and the recovery codes generated for the user are returned
+// along with the token.
+func loginWithChallenge(challengeToken, code string) (*Token, error) {
+	if code == "" {
+		return nil, ErrMissingOTP
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var challenge twoFactorChallenge
+	err = conn.TwoFactorChallenges().FindId(challengeToken).One(&challenge)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, ErrInvalidChallenge
+		}
+		return nil, err
+	}
+	if time.Since(challenge.Creation) > challengeExpiration {
+		conn.TwoFactorChallenges().RemoveId(challengeToken)
+		return nil, ErrInvalidChallenge
+	}
+	user, err := auth.GetUserByEmail(challenge.UserEmail)
+	if err != nil {
+		return nil, err
+	}
+	tf, err := getTwoFactor(user.Email)
+	if err != nil {
+		return nil, err
+	}
+	err = tf.verifyCode(code, tf.Enabled)
+	if err != nil {
+		if challenge.Attempts+1 >= maxChallengeAttempts || err == ErrTwoFactorLocked {
+			conn.TwoFactorChallenges().RemoveId(challengeToken)
+		} else {
+			conn.TwoFactorChallenges().UpdateId(challengeToken, bson.M{"$inc": bson.M{"attempts": 1}})
+		}
+		return nil, err
+	}
+	err = conn.TwoFactorChallenges().RemoveId(challengeToken)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, ErrInvalidChallenge
+		}
+		return nil, err
+	}
+	var codes []string
+	if !tf.Enabled {
+		codes, err = tf.newRecoveryCodes()
+		if err != nil {
+			return nil, err
+		}
+		tf.Enabled = true
+	}
+	err = tf.save()
+	if err != nil {
+		return nil, err
+	}
+	token, err := insertToken(user)
+	if err != nil {
+		return nil, err
+	}
+	token.recoveryCodes = codes
+	return token, nil
+}
+
+func (s NativeScheme) TwoFactorStatus(u *auth.User) (*auth.TwoFactorStatus, error) {
+	tf, err := getTwoFactor(u.Email)
+	if err != nil {
+		return nil, err
+	}
+	return &auth.TwoFactorStatus{
+		Enabled:       tf.Enabled,
+		Required:      twoFactorRequired(u),
+		RecoveryCodes: len(tf.RecoveryCodes),
+	}, nil
+}
+
+// StartTwoFactorEnrollment generates a new secret for the user, which is only
+// used after the enrollment is confirmed with a valid code.
+func (s NativeScheme) StartTwoFactorEnrollment(u *auth.User) (*auth.TwoFactorEnrollment, error) {
+	tf, err := getTwoFactor(u.Email)
+	if err != nil {
+		return nil, err
+	}
+	if tf.Enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	tf.Secret, err = newTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	tf.LastStep = 0
+	err = tf.save()
+	if err != nil {
+		return nil, err
+	}
+	return &auth.TwoFactorEnrollment{
+		Secret: strings.TrimRight(tf.Secret, "="),
+		URI:    provisioningURI(tf.Secret, u.Email),
+	}, nil
+}
+
+// ConfirmTwoFactorEnrollment enables two-factor authentication for the user,
+// returning the recovery codes.
+func (s NativeScheme) ConfirmTwoFactorEnrollment(u *auth.User, code string) ([]string, error) {
+	if code == "" {
+		return nil, ErrMissingOTP
+	}
+	tf, err := getTwoFactor(u.Email)
+	if err != nil {
+		return nil, err
+	}
+	if tf.Enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if tf.Secret == "" {
+		return nil, ErrTwoFactorNotPending
+	}
+	err = tf.verifyCode(code, false)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := tf.newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	tf.Enabled = true
+	err = tf.save()
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor disables two-factor authentication for the user, given a
+// valid TOTP or recovery code. It's not allowed when a role of the user
+// requires two-factor authentication.
+func (s NativeScheme) DisableTwoFactor(u *auth.User, code string) error {
+	if twoFactorRequired(u) {
+		return ErrTwoFactorRequiredByRole
+	}
+	if code == "" {
+		return ErrMissingOTP
+	}
+	tf, err := getTwoFactor(u.Email)
+	if err != nil {
+		return err
+	}
+	if !tf.Enabled {
+		return ErrTwoFactorNotEnabled
+	}
+	err = tf.verifyCode(code, true)
+	if err != nil {
+		return err
+	}
+	return deleteTwoFactor(u.Email)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user,
+// invalidating the previous ones.
+func (s NativeScheme) RegenerateRecoveryCodes(u *auth.User) ([]string, error) {
+	tf, err := getTwoFactor(u.Email)
+	if err != nil {
+		return nil, err
+	}
+	if !tf.Enabled {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	codes, err := tf.newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = tf.save()
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
@@ -0,0 +1,275 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package native
+
+import (
+	"encoding/base32"
+	"net/url"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/auth"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) currentCode(c *check.C, secret string) string {
+	code, err := totpCode(secret, time.Now().Unix()/totpPeriod)
+	c.Assert(err, check.IsNil)
+	return code
+}
+
+func (s *S) enableTwoFactor(c *check.C, u *auth.User) (*twoFactor, []string) {
+	enrollment, err := nativeScheme.StartTwoFactorEnrollment(u)
+	c.Assert(err, check.IsNil)
+	codes, err := nativeScheme.ConfirmTwoFactorEnrollment(u, s.currentCode(c, enrollment.Secret))
+	c.Assert(err, check.IsNil)
+	tf, err := getTwoFactor(u.Email)
+	c.Assert(err, check.IsNil)
+	// allows the current code to be used again by the tests
+	tf.LastStep = 0
+	err = tf.save()
+	c.Assert(err, check.IsNil)
+	return tf, codes
+}
+
+func (s *S) TestTOTPCode(c *check.C) {
+	// Test vectors from RFC 6238, truncated to 6 digits.
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		code, err := totpCode(secret, tt.time/totpPeriod)
+		c.Assert(err, check.IsNil)
+		c.Check(code, check.Equals, tt.code)
+	}
+}
+
+func (s *S) TestCheckCode(c *check.C) {
+	secret, err := newTOTPSecret()
+	c.Assert(err, check.IsNil)
+	tf := &twoFactor{Secret: secret}
+	now := time.Now()
+	step := now.Unix() / totpPeriod
+	previous, err := totpCode(secret, step-1)
+	c.Assert(err, check.IsNil)
+	c.Assert(tf.checkCode(previous, false, now), check.Equals, true)
+	c.Assert(tf.LastStep, check.Equals, step-1)
+	c.Assert(tf.checkCode(previous, false, now), check.Equals, false)
+	old, err := totpCode(secret, step-2)
+	c.Assert(err, check.IsNil)
+	c.Assert(tf.checkCode(old, false, now), check.Equals, false)
+	c.Assert(tf.checkCode("000000x", false, now), check.Equals, false)
+}
+
+func (s *S) TestCheckCodeRecovery(c *check.C) {
+	tf := &twoFactor{}
+	codes, err := tf.newRecoveryCodes()
+	c.Assert(err, check.IsNil)
+	c.Assert(codes, check.HasLen, recoveryCodeCount)
+	c.Assert(codes[0], check.Matches, "^[0-9a-f]{5}-[0-9a-f]{5}$")
+	c.Assert(tf.checkCode(codes[0], false, time.Now()), check.Equals, false)
+	c.Assert(tf.checkCode(codes[0], true, time.Now()), check.Equals, true)
+	c.Assert(tf.RecoveryCodes, check.HasLen, recoveryCodeCount-1)
+	c.Assert(tf.checkCode(codes[0], true, time.Now()), check.Equals, false)
+}
+
+func (s *S) TestProvisioningURI(c *check.C) {
+	config.Set("auth:two-factor:issuer", "mytsuru")
+	defer config.Unset("auth:two-factor:issuer")
+	uri, err := url.Parse(provisioningURI("JBSWY3DPEHPK3PXP", "me@tsuru.io"))
+	c.Assert(err, check.IsNil)
+	c.Assert(uri.Scheme, check.Equals, "otpauth")
+	c.Assert(uri.Host, check.Equals, "totp")
+	c.Assert(uri.Path, check.Equals, "/mytsuru:me@tsuru.io")
+	c.Assert(uri.Query().Get("secret"), check.Equals, "JBSWY3DPEHPK3PXP")
+	c.Assert(uri.Query().Get("issuer"), check.Equals, "mytsuru")
+}
+
+func (s *S) TestTwoFactorEnrollment(c *check.C) {
+	status, err := nativeScheme.TwoFactorStatus(s.user)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.DeepEquals, &auth.TwoFactorStatus{})
+	enrollment, err := nativeScheme.StartTwoFactorEnrollment(s.user)
+	c.Assert(err, check.IsNil)
+	c.Assert(enrollment.Secret, check.HasLen, 32)
+	_, err = nativeScheme.ConfirmTwoFactorEnrollment(s.user, "000000x")
+	c.Assert(err, check.Equals, ErrInvalidOTP)
+	codes, err := nativeScheme.ConfirmTwoFactorEnrollment(s.user, s.currentCode(c, enrollment.Secret))
+	c.Assert(err, check.IsNil)
+	c.Assert(codes, check.HasLen, recoveryCodeCount)
+	status, err = nativeScheme.TwoFactorStatus(s.user)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.DeepEquals, &auth.TwoFactorStatus{Enabled: true, RecoveryCodes: recoveryCodeCount})
+	_, err = nativeScheme.StartTwoFactorEnrollment(s.user)
+	c.Assert(err, check.Equals, ErrTwoFactorEnabled)
+}
+
+func (s *S) TestConfirmTwoFactorEnrollmentNotPending(c *check.C) {
+	_, err := nativeScheme.ConfirmTwoFactorEnrollment(s.user, "123456")
+	c.Assert(err, check.Equals, ErrTwoFactorNotPending)
+}
+
+func (s *S) TestLoginTwoFactor(c *check.C) {
+	tf, _ := s.enableTwoFactor(c, s.user)
+	_, err := nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456"})
+	challenge, ok := err.(*auth.TwoFactorRequired)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(challenge.Challenge, check.Not(check.Equals), "")
+	c.Assert(challenge.EnrollURI, check.Equals, "")
+	_, err = nativeScheme.Login(map[string]string{"challenge": challenge.Challenge, "otp": "000000x"})
+	c.Assert(err, check.Equals, ErrInvalidOTP)
+	token, err := nativeScheme.Login(map[string]string{"challenge": challenge.Challenge, "otp": s.currentCode(c, tf.Secret)})
+	c.Assert(err, check.IsNil)
+	c.Assert(token.GetUserName(), check.Equals, s.user.Email)
+	_, err = nativeScheme.Login(map[string]string{"challenge": challenge.Challenge, "otp": s.currentCode(c, tf.Secret)})
+	c.Assert(err, check.Equals, ErrInvalidChallenge)
+}
+
+func (s *S) TestLoginTwoFactorSingleStep(c *check.C) {
+	tf, codes := s.enableTwoFactor(c, s.user)
+	token, err := nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456", "otp": s.currentCode(c, tf.Secret)})
+	c.Assert(err, check.IsNil)
+	c.Assert(token.GetUserName(), check.Equals, s.user.Email)
+	token, err = nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456", "otp": codes[0]})
+	c.Assert(err, check.IsNil)
+	c.Assert(token.GetUserName(), check.Equals, s.user.Email)
+	_, err = nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456", "otp": codes[0]})
+	c.Assert(err, check.Equals, ErrInvalidOTP)
+}
+
+func (s *S) TestLoginTwoFactorWrongPassword(c *check.C) {
+	s.enableTwoFactor(c, s.user)
+	_, err := nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "1234567"})
+	_, ok := err.(auth.AuthenticationFailure)
+	c.Assert(ok, check.Equals, true)
+}
+
+func (s *S) TestLoginTwoFactorChallengeAttempts(c *check.C) {
+	s.enableTwoFactor(c, s.user)
+	_, err := nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456"})
+	challenge := err.(*auth.TwoFactorRequired)
+	for i := 0; i < maxChallengeAttempts; i++ {
+		_, err = nativeScheme.Login(map[string]string{"challenge": challenge.Challenge, "otp": "000000x"})
+		c.Assert(err, check.Equals, ErrInvalidOTP)
+	}
+	_, err = nativeScheme.Login(map[string]string{"challenge": challenge.Challenge, "otp": "000000x"})
+	c.Assert(err, check.Equals, ErrInvalidChallenge)
+}
+
+func (s *S) TestLoginTwoFactorLockout(c *check.C) {
+	tf, _ := s.enableTwoFactor(c, s.user)
+	params := map[string]string{"email": s.user.Email, "password": "123456", "otp": "000000x"}
+	for i := 0; i < maxFailedAttempts-1; i++ {
+		_, err := nativeScheme.Login(params)
+		c.Assert(err, check.Equals, ErrInvalidOTP)
+	}
+	_, err := nativeScheme.Login(params)
+	c.Assert(err, check.Equals, ErrTwoFactorLocked)
+	params["otp"] = s.currentCode(c, tf.Secret)
+	_, err = nativeScheme.Login(params)
+	c.Assert(err, check.Equals, ErrTwoFactorLocked)
+	_, err = nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456"})
+	challenge := err.(*auth.TwoFactorRequired)
+	_, err = nativeScheme.Login(map[string]string{"challenge": challenge.Challenge, "otp": params["otp"]})
+	c.Assert(err, check.Equals, ErrTwoFactorLocked)
+	err = s.conn.TwoFactor().UpdateId(s.user.Email, map[string]interface{}{
+		"$set": map[string]interface{}{"lockeduntil": time.Now().Add(-time.Second)},
+	})
+	c.Assert(err, check.IsNil)
+	token, err := nativeScheme.Login(params)
+	c.Assert(err, check.IsNil)
+	c.Assert(token.GetUserName(), check.Equals, s.user.Email)
+	tf, err = getTwoFactor(s.user.Email)
+	c.Assert(err, check.IsNil)
+	c.Assert(tf.FailedAttempts, check.Equals, 0)
+}
+
+func (s *S) TestLoginTwoFactorChallengeExpired(c *check.C) {
+	s.enableTwoFactor(c, s.user)
+	_, err := nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456"})
+	challenge := err.(*auth.TwoFactorRequired)
+	err = s.conn.TwoFactorChallenges().UpdateId(challenge.Challenge, map[string]interface{}{
+		"$set": map[string]interface{}{"creation": time.Now().Add(-challengeExpiration - time.Second)},
+	})
+	c.Assert(err, check.IsNil)
+	_, err = nativeScheme.Login(map[string]string{"challenge": challenge.Challenge, "otp": "123456"})
+	c.Assert(err, check.Equals, ErrInvalidChallenge)
+}
+
+func (s *S) TestLoginTwoFactorRequiredByRole(c *check.C) {
+	config.Set("auth:two-factor:required-roles", []interface{}{"admin"})
+	defer config.Unset("auth:two-factor")
+	s.user.Roles = []auth.RoleInstance{{Name: "admin"}}
+	err := s.user.Update()
+	c.Assert(err, check.IsNil)
+	_, err = nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456", "otp": "123456"})
+	challenge, ok := err.(*auth.TwoFactorRequired)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(challenge.EnrollURI, check.Matches, "^otpauth://totp/.*")
+	tf, err := getTwoFactor(s.user.Email)
+	c.Assert(err, check.IsNil)
+	c.Assert(tf.Enabled, check.Equals, false)
+	_, err = nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456"})
+	second := err.(*auth.TwoFactorRequired)
+	c.Assert(second.EnrollURI, check.Equals, challenge.EnrollURI)
+	token, err := nativeScheme.Login(map[string]string{"challenge": second.Challenge, "otp": s.currentCode(c, tf.Secret)})
+	c.Assert(err, check.IsNil)
+	c.Assert(token.GetUserName(), check.Equals, s.user.Email)
+	codes := token.(auth.RecoveryCodesToken).RecoveryCodes()
+	c.Assert(codes, check.HasLen, recoveryCodeCount)
+	status, err := nativeScheme.TwoFactorStatus(s.user)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.DeepEquals, &auth.TwoFactorStatus{Enabled: true, Required: true, RecoveryCodes: recoveryCodeCount})
+	token, err = nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456", "otp": codes[0]})
+	c.Assert(err, check.IsNil)
+	c.Assert(token.(auth.RecoveryCodesToken).RecoveryCodes(), check.HasLen, 0)
+	err = nativeScheme.DisableTwoFactor(s.user, "123456")
+	c.Assert(err, check.Equals, ErrTwoFactorRequiredByRole)
+}
+
+func (s *S) TestDisableTwoFactor(c *check.C) {
+	_, codes := s.enableTwoFactor(c, s.user)
+	err := nativeScheme.DisableTwoFactor(s.user, "000000x")
+	c.Assert(err, check.Equals, ErrInvalidOTP)
+	err = nativeScheme.DisableTwoFactor(s.user, codes[1])
+	c.Assert(err, check.IsNil)
+	status, err := nativeScheme.TwoFactorStatus(s.user)
+	c.Assert(err, check.IsNil)
+	c.Assert(status.Enabled, check.Equals, false)
+	err = nativeScheme.DisableTwoFactor(s.user, codes[2])
+	c.Assert(err, check.Equals, ErrTwoFactorNotEnabled)
+	token, err := nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456"})
+	c.Assert(err, check.IsNil)
+	c.Assert(token.GetUserName(), check.Equals, s.user.Email)
+}
+
+func (s *S) TestRegenerateRecoveryCodes(c *check.C) {
+	_, err := nativeScheme.RegenerateRecoveryCodes(s.user)
+	c.Assert(err, check.Equals, ErrTwoFactorNotEnabled)
+	_, oldCodes := s.enableTwoFactor(c, s.user)
+	codes, err := nativeScheme.RegenerateRecoveryCodes(s.user)
+	c.Assert(err, check.IsNil)
+	c.Assert(codes, check.HasLen, recoveryCodeCount)
+	err = nativeScheme.DisableTwoFactor(s.user, oldCodes[0])
+	c.Assert(err, check.Equals, ErrInvalidOTP)
+	err = nativeScheme.DisableTwoFactor(s.user, codes[0])
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestRemoveUserRemovesTwoFactor(c *check.C) {
+	s.enableTwoFactor(c, s.user)
+	err := nativeScheme.Remove(s.user)
+	c.Assert(err, check.IsNil)
+	n, err := s.conn.TwoFactor().FindId(s.user.Email).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 0)
+}
@@ -0,0 +1,340 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/db"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	ErrPersonalTokenNotFound    = errors.New("personal token not found")
+	ErrPersonalTokenExists      = &tsuruErrors.ConflictError{Message: "a personal token with this name already exists"}
+	ErrInvalidPersonalTokenName = &tsuruErrors.ValidationError{Message: "personal token name must contain only letters, numbers, dashes and underscores, with at most 40 characters"}
+
+	personalTokenNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,40}$`)
+)
+
+// TokenPermission is a permission granted to a personal token. An empty
+// ContextType means the global context.
+type TokenPermission struct {
+	Name         string `json:"name"`
+	ContextType  string `json:"contexttype"`
+	ContextValue string `json:"contextvalue"`
+}
+
+func (p TokenPermission) String() string {
+	value := p.ContextValue
+	if value != "" {
+		value = " " + value
+	}
+	return fmt.Sprintf("%s(%s%s)", p.Name, p.ContextType, value)
+}
+
+// PersonalToken is a named token created by a user. Tokens without
+// AllowedPermissions have every permission of the user, otherwise they're
+// restricted to the allowed permissions the user still has. The token value
+// is only available right after the creation, only its hash is stored. A
+// zero ExpiresAt means the token never expires.
+type PersonalToken struct {
+	Hash               string            `json:"-" bson:"_id"`
+	Token              string            `json:"token,omitempty" bson:"-"`
+	Name               string            `json:"name"`
+	UserEmail          string            `json:"email"`
+	CreatedAt          time.Time         `json:"created_at"`
+	ExpiresAt          time.Time         `json:"expires_at"`
+	AllowedPermissions []TokenPermission `json:"permissions"`
+}
+
+type PersonalTokenOpts struct {
+	Name        string
+	ExpiresIn   time.Duration
+	Permissions []TokenPermission
+}
+
+func (t *PersonalToken) GetValue() string {
+	return t.Token
+}
+
+func (t *PersonalToken) User() (*User, error) {
+	return GetUserByEmail(t.UserEmail)
+}
+
+func (t *PersonalToken) IsAppToken() bool {
+	return false
+}
+
+func (t *PersonalToken) GetUserName() string {
+	return t.UserEmail
+}
+
+func (t *PersonalToken) GetAppName() string {
+	return ""
+}
+
+func (t *PersonalToken) Permissions() ([]permission.Permission, error) {
+	perms, err := BaseTokenPermission(t)
+	if err != nil || len(t.AllowedPermissions) == 0 {
+		return perms, err
+	}
+	var allowed []permission.Permission
+	for _, p := range t.AllowedPermissions {
+		perm, err := p.permission()
+		if err != nil {
+			log.Errorf("ignoring permission %s of personal token %q from %q: %s", p, t.Name, t.UserEmail, err)
+			continue
+		}
+		allowed = append(allowed, perm)
+	}
+	perms, err = appendAppContexts(perms, allowed)
+	if err != nil {
+		return nil, err
+	}
+	return permission.Intersect(perms, allowed), nil
+}
+
+func (t *PersonalToken) expired() bool {
+	return !t.ExpiresAt.IsZero() && time.Now().After(t.ExpiresAt)
+}
+
+func (p *TokenPermission) permission() (permission.Permission, error) {
+	name := p.Name
+	if name == "*" {
+		name = ""
+	}
+	scheme, err := permission.SafeGet(name)
+	if err != nil {
+		return permission.Permission{}, err
+	}
+	ctxType := permission.CtxGlobal
+	if p.ContextType != "" {
+		ctxType, err = permission.ParseContext(p.ContextType)
+		if err != nil {
+			return permission.Permission{}, err
+		}
+	}
+	allowed := false
+	for _, t := range scheme.AllowedContexts() {
+		if t == ctxType {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return permission.Permission{}, errors.Errorf("context type %q is not allowed for this permission", ctxType)
+	}
+	if ctxType == permission.CtxGlobal {
+		if p.ContextValue != "" {
+			return permission.Permission{}, errors.New("global context must not have a value")
+		}
+	} else if p.ContextValue == "" {
+		return permission.Permission{}, errors.Errorf("context type %q requires a value", ctxType)
+	}
+	return permission.Permission{Scheme: scheme, Context: permission.Context(ctxType, p.ContextValue)}, nil
+}
+
+// appendAppContexts adds to perms a copy, in the app context, of the
+// permissions granted through the teams and pool of each app referenced by
+// allowed. Permission checks on apps also consider their teams and pool, so
+// a user with a permission on a team has it on the app context too.
+func appendAppContexts(perms, allowed []permission.Permission) ([]permission.Permission, error) {
+	var appNames []string
+	seen := make(map[string]bool)
+	for _, p := range allowed {
+		if p.Context.CtxType == permission.CtxApp && !seen[p.Context.Value] {
+			seen[p.Context.Value] = true
+			appNames = append(appNames, p.Context.Value)
+		}
+	}
+	if len(appNames) == 0 {
+		return perms, nil
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var apps []struct {
+		Name  string
+		Teams []string
+		Pool  string
+	}
+	err = conn.Apps().Find(bson.M{"name": bson.M{"$in": appNames}}).Select(bson.M{"name": 1, "teams": 1, "pool": 1}).All(&apps)
+	if err != nil {
+		return nil, err
+	}
+	result := perms
+	for _, a := range apps {
+		contexts := append(permission.Contexts(permission.CtxTeam, a.Teams), permission.Context(permission.CtxPool, a.Pool))
+		for _, p := range perms {
+			for _, ctx := range contexts {
+				if p.Context == ctx {
+					result = append(result, permission.Permission{
+						Scheme:  p.Scheme,
+						Context: permission.Context(permission.CtxApp, a.Name),
+					})
+					break
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+func hashPersonalToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreatePersonalToken creates a personal token for the user of the given
+// token. Every requested permission must be granted to the creator token,
+// and tokens created by restricted personal tokens inherit their
+// restrictions when no permission is requested. Likewise, tokens created by
+// expiring personal tokens inherit their expiration when none is requested,
+// and can't expire after them.
+func CreatePersonalToken(creator Token, opts PersonalTokenOpts) (*PersonalToken, error) {
+	if !personalTokenNameRegexp.MatchString(opts.Name) {
+		return nil, ErrInvalidPersonalTokenName
+	}
+	if opts.ExpiresIn < 0 {
+		return nil, &tsuruErrors.ValidationError{Message: "expiration must be a positive duration"}
+	}
+	allowed := opts.Permissions
+	if len(allowed) == 0 {
+		if pt, ok := creator.(*PersonalToken); ok {
+			allowed = pt.AllowedPermissions
+		}
+	}
+	if len(allowed) > 0 {
+		creatorPerms, err := creator.Permissions()
+		if err != nil {
+			return nil, err
+		}
+		var perms []permission.Permission
+		for _, p := range allowed {
+			perm, err := p.permission()
+			if err != nil {
+				return nil, &tsuruErrors.ValidationError{Message: fmt.Sprintf("invalid permission %s: %s", p, err)}
+			}
+			perms = append(perms, perm)
+		}
+		creatorPerms, err = appendAppContexts(creatorPerms, perms)
+		if err != nil {
+			return nil, err
+		}
+		for i, perm := range perms {
+			if !permission.CheckFromPermList(creatorPerms, perm.Scheme, perm.Context) {
+				return nil, &tsuruErrors.NotAuthorizedError{Message: fmt.Sprintf("you don't have permission %s", allowed[i])}
+			}
+		}
+	}
+	var raw [32]byte
+	_, err := rand.Read(raw[:])
+	if err != nil {
+		return nil, err
+	}
+	value := hex.EncodeToString(raw[:])
+	t := PersonalToken{
+		Hash:               hashPersonalToken(value),
+		Token:              value,
+		Name:               opts.Name,
+		UserEmail:          creator.GetUserName(),
+		CreatedAt:          time.Now().UTC(),
+		AllowedPermissions: allowed,
+	}
+	if opts.ExpiresIn > 0 {
+		t.ExpiresAt = t.CreatedAt.Add(opts.ExpiresIn)
+	}
+	if pt, ok := creator.(*PersonalToken); ok && !pt.ExpiresAt.IsZero() {
+		if t.ExpiresAt.IsZero() {
+			t.ExpiresAt = pt.ExpiresAt
+		} else if t.ExpiresAt.After(pt.ExpiresAt) {
+			return nil, &tsuruErrors.ValidationError{Message: "expiration can't be after the expiration of the current token"}
+		}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	err = conn.PersonalTokens().Insert(t)
+	if mgo.IsDup(err) {
+		return nil, ErrPersonalTokenExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ListPersonalTokens returns the personal tokens of the user, sorted by
+// name, including the expired ones.
+func ListPersonalTokens(email string) ([]PersonalToken, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	tokens := []PersonalToken{}
+	err = conn.PersonalTokens().Find(bson.M{"useremail": email}).Sort("name").All(&tokens)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func RevokePersonalToken(email, name string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.PersonalTokens().Remove(bson.M{"useremail": email, "name": name})
+	if err == mgo.ErrNotFound {
+		return ErrPersonalTokenNotFound
+	}
+	return err
+}
+
+func getPersonalToken(header string) (*PersonalToken, error) {
+	token, err := ParseToken(header)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var t PersonalToken
+	err = conn.PersonalTokens().FindId(hashPersonalToken(token)).One(&t)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	if t.expired() {
+		return nil, ErrInvalidToken
+	}
+	t.Token = token
+	return &t, nil
+}
+
+func PersonalTokenAuth(token string) (*PersonalToken, error) {
+	return getPersonalToken(token)
+}
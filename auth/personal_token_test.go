@@ -0,0 +1,221 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"time"
+
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) userWithRole(c *check.C, roleName, ctxType, ctxValue string, perms ...string) *User {
+	role, err := permission.NewRole(roleName, ctxType, "")
+	c.Assert(err, check.IsNil)
+	err = role.AddPermissions(perms...)
+	c.Assert(err, check.IsNil)
+	err = s.user.AddRole(roleName, ctxValue)
+	c.Assert(err, check.IsNil)
+	return s.user
+}
+
+func (s *S) TestCreatePersonalToken(c *check.C) {
+	creator := &APIToken{UserEmail: s.user.Email}
+	t, err := CreatePersonalToken(creator, PersonalTokenOpts{Name: "ci", ExpiresIn: time.Hour})
+	c.Assert(err, check.IsNil)
+	c.Assert(t.Token, check.HasLen, 64)
+	c.Assert(t.Name, check.Equals, "ci")
+	c.Assert(t.UserEmail, check.Equals, s.user.Email)
+	c.Assert(t.ExpiresAt.Sub(t.CreatedAt), check.Equals, time.Hour)
+	var stored PersonalToken
+	err = s.conn.PersonalTokens().Find(bson.M{"name": "ci"}).One(&stored)
+	c.Assert(err, check.IsNil)
+	c.Assert(stored.Hash, check.Equals, hashPersonalToken(t.Token))
+	c.Assert(stored.Hash, check.Not(check.Equals), t.Token)
+	found, err := PersonalTokenAuth("bearer " + t.Token)
+	c.Assert(err, check.IsNil)
+	c.Assert(found.Name, check.Equals, "ci")
+	c.Assert(found.GetValue(), check.Equals, t.Token)
+	c.Assert(found.GetUserName(), check.Equals, s.user.Email)
+}
+
+func (s *S) TestCreatePersonalTokenDuplicated(c *check.C) {
+	creator := &APIToken{UserEmail: s.user.Email}
+	_, err := CreatePersonalToken(creator, PersonalTokenOpts{Name: "ci"})
+	c.Assert(err, check.IsNil)
+	_, err = CreatePersonalToken(creator, PersonalTokenOpts{Name: "ci"})
+	c.Assert(err, check.Equals, ErrPersonalTokenExists)
+}
+
+func (s *S) TestCreatePersonalTokenInvalidName(c *check.C) {
+	creator := &APIToken{UserEmail: s.user.Email}
+	for _, name := range []string{"", "my token", "a/b"} {
+		_, err := CreatePersonalToken(creator, PersonalTokenOpts{Name: name})
+		c.Check(err, check.Equals, ErrInvalidPersonalTokenName)
+	}
+}
+
+func (s *S) TestCreatePersonalTokenInvalidPermission(c *check.C) {
+	creator := &APIToken{UserEmail: s.user.Email}
+	tests := []TokenPermission{
+		{Name: "app.nonexisting"},
+		{Name: "app.deploy", ContextType: "invalid", ContextValue: "x"},
+		{Name: "team.create", ContextType: "app", ContextValue: "myapp"},
+		{Name: "app.deploy", ContextType: "app"},
+	}
+	for _, p := range tests {
+		_, err := CreatePersonalToken(creator, PersonalTokenOpts{Name: "ci", Permissions: []TokenPermission{p}})
+		_, ok := err.(*errors.ValidationError)
+		c.Check(ok, check.Equals, true, check.Commentf("%s: %v", p, err))
+	}
+}
+
+func (s *S) TestCreatePersonalTokenPermissionNotGranted(c *check.C) {
+	s.userWithRole(c, "deployer", "team", "cobrateam", "app.deploy")
+	creator := &APIToken{UserEmail: s.user.Email}
+	_, err := CreatePersonalToken(creator, PersonalTokenOpts{
+		Name:        "ci",
+		Permissions: []TokenPermission{{Name: "app.update", ContextType: "team", ContextValue: "cobrateam"}},
+	})
+	c.Assert(err, check.FitsTypeOf, &errors.NotAuthorizedError{})
+	_, err = CreatePersonalToken(creator, PersonalTokenOpts{
+		Name:        "ci",
+		Permissions: []TokenPermission{{Name: "app.deploy", ContextType: "team", ContextValue: "cobrateam"}},
+	})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestPersonalTokenPermissions(c *check.C) {
+	s.userWithRole(c, "deployer", "team", "cobrateam", "app")
+	err := s.conn.Apps().Insert(bson.M{"name": "myapp", "teams": []string{"cobrateam"}, "pool": "pool1"})
+	c.Assert(err, check.IsNil)
+	err = s.conn.Apps().Insert(bson.M{"name": "otherapp", "teams": []string{"otherteam"}, "pool": "pool1"})
+	c.Assert(err, check.IsNil)
+	creator := &APIToken{UserEmail: s.user.Email}
+	t, err := CreatePersonalToken(creator, PersonalTokenOpts{
+		Name:        "deploy-myapp",
+		Permissions: []TokenPermission{{Name: "app.deploy", ContextType: "app", ContextValue: "myapp"}},
+	})
+	c.Assert(err, check.IsNil)
+	perms, err := t.Permissions()
+	c.Assert(err, check.IsNil)
+	c.Assert(perms, check.DeepEquals, []permission.Permission{
+		{Scheme: permission.PermAppDeploy, Context: permission.Context(permission.CtxApp, "myapp")},
+	})
+	c.Assert(permission.Check(t, permission.PermAppDeploy, permission.Context(permission.CtxApp, "myapp")), check.Equals, true)
+	c.Assert(permission.Check(t, permission.PermAppDeploy, permission.Context(permission.CtxApp, "otherapp")), check.Equals, false)
+	c.Assert(permission.Check(t, permission.PermAppUpdate, permission.Context(permission.CtxApp, "myapp")), check.Equals, false)
+	_, err = CreatePersonalToken(creator, PersonalTokenOpts{
+		Name:        "deploy-otherapp",
+		Permissions: []TokenPermission{{Name: "app.deploy", ContextType: "app", ContextValue: "otherapp"}},
+	})
+	c.Assert(err, check.FitsTypeOf, &errors.NotAuthorizedError{})
+}
+
+func (s *S) TestPersonalTokenPermissionsFollowUserRoles(c *check.C) {
+	s.userWithRole(c, "deployer", "team", "cobrateam", "app.deploy")
+	creator := &APIToken{UserEmail: s.user.Email}
+	t, err := CreatePersonalToken(creator, PersonalTokenOpts{
+		Name:        "ci",
+		Permissions: []TokenPermission{{Name: "app.deploy", ContextType: "team", ContextValue: "cobrateam"}},
+	})
+	c.Assert(err, check.IsNil)
+	err = s.user.RemoveRole("deployer", "cobrateam")
+	c.Assert(err, check.IsNil)
+	perms, err := t.Permissions()
+	c.Assert(err, check.IsNil)
+	c.Assert(perms, check.HasLen, 0)
+}
+
+func (s *S) TestPersonalTokenUnrestrictedPermissions(c *check.C) {
+	creator := &APIToken{UserEmail: s.user.Email}
+	t, err := CreatePersonalToken(creator, PersonalTokenOpts{Name: "ci"})
+	c.Assert(err, check.IsNil)
+	perms, err := t.Permissions()
+	c.Assert(err, check.IsNil)
+	userPerms, err := s.user.Permissions()
+	c.Assert(err, check.IsNil)
+	c.Assert(perms, check.DeepEquals, userPerms)
+}
+
+func (s *S) TestCreatePersonalTokenInheritsRestrictions(c *check.C) {
+	s.userWithRole(c, "deployer", "team", "cobrateam", "app.deploy")
+	creator := &APIToken{UserEmail: s.user.Email}
+	restricted, err := CreatePersonalToken(creator, PersonalTokenOpts{
+		Name:        "ci",
+		Permissions: []TokenPermission{{Name: "app.deploy", ContextType: "team", ContextValue: "cobrateam"}},
+	})
+	c.Assert(err, check.IsNil)
+	t, err := CreatePersonalToken(restricted, PersonalTokenOpts{Name: "ci2"})
+	c.Assert(err, check.IsNil)
+	c.Assert(t.AllowedPermissions, check.DeepEquals, restricted.AllowedPermissions)
+	_, err = CreatePersonalToken(restricted, PersonalTokenOpts{
+		Name:        "ci3",
+		Permissions: []TokenPermission{{Name: "user", ContextType: "user", ContextValue: s.user.Email}},
+	})
+	c.Assert(err, check.FitsTypeOf, &errors.NotAuthorizedError{})
+}
+
+func (s *S) TestCreatePersonalTokenInheritsExpiration(c *check.C) {
+	creator := &APIToken{UserEmail: s.user.Email}
+	expiring, err := CreatePersonalToken(creator, PersonalTokenOpts{Name: "ci", ExpiresIn: 24 * time.Hour})
+	c.Assert(err, check.IsNil)
+	t, err := CreatePersonalToken(expiring, PersonalTokenOpts{Name: "ci2"})
+	c.Assert(err, check.IsNil)
+	c.Assert(t.ExpiresAt, check.DeepEquals, expiring.ExpiresAt)
+	t, err = CreatePersonalToken(expiring, PersonalTokenOpts{Name: "ci3", ExpiresIn: time.Hour})
+	c.Assert(err, check.IsNil)
+	c.Assert(t.ExpiresAt.Before(expiring.ExpiresAt), check.Equals, true)
+	_, err = CreatePersonalToken(expiring, PersonalTokenOpts{Name: "ci4", ExpiresIn: 48 * time.Hour})
+	c.Assert(err, check.FitsTypeOf, &errors.ValidationError{})
+	tokens, err := ListPersonalTokens(s.user.Email)
+	c.Assert(err, check.IsNil)
+	c.Assert(tokens, check.HasLen, 3)
+}
+
+func (s *S) TestPersonalTokenAuthExpired(c *check.C) {
+	creator := &APIToken{UserEmail: s.user.Email}
+	t, err := CreatePersonalToken(creator, PersonalTokenOpts{Name: "ci", ExpiresIn: time.Hour})
+	c.Assert(err, check.IsNil)
+	err = s.conn.PersonalTokens().UpdateId(t.Hash, bson.M{"$set": bson.M{"expiresat": time.Now().Add(-time.Minute)}})
+	c.Assert(err, check.IsNil)
+	_, err = PersonalTokenAuth("bearer " + t.Token)
+	c.Assert(err, check.Equals, ErrInvalidToken)
+}
+
+func (s *S) TestPersonalTokenAuthNotFound(c *check.C) {
+	_, err := PersonalTokenAuth("bearer invalid")
+	c.Assert(err, check.Equals, ErrInvalidToken)
+}
+
+func (s *S) TestListPersonalTokens(c *check.C) {
+	creator := &APIToken{UserEmail: s.user.Email}
+	_, err := CreatePersonalToken(creator, PersonalTokenOpts{Name: "b"})
+	c.Assert(err, check.IsNil)
+	_, err = CreatePersonalToken(creator, PersonalTokenOpts{Name: "a"})
+	c.Assert(err, check.IsNil)
+	_, err = CreatePersonalToken(&APIToken{UserEmail: "other@tsuru.io"}, PersonalTokenOpts{Name: "c"})
+	c.Assert(err, check.IsNil)
+	tokens, err := ListPersonalTokens(s.user.Email)
+	c.Assert(err, check.IsNil)
+	c.Assert(tokens, check.HasLen, 2)
+	c.Assert(tokens[0].Name, check.Equals, "a")
+	c.Assert(tokens[0].Token, check.Equals, "")
+	c.Assert(tokens[1].Name, check.Equals, "b")
+}
+
+func (s *S) TestRevokePersonalToken(c *check.C) {
+	creator := &APIToken{UserEmail: s.user.Email}
+	t, err := CreatePersonalToken(creator, PersonalTokenOpts{Name: "ci"})
+	c.Assert(err, check.IsNil)
+	err = RevokePersonalToken(s.user.Email, "ci")
+	c.Assert(err, check.IsNil)
+	_, err = PersonalTokenAuth("bearer " + t.Token)
+	c.Assert(err, check.Equals, ErrInvalidToken)
+	err = RevokePersonalToken(s.user.Email, "ci")
+	c.Assert(err, check.Equals, ErrPersonalTokenNotFound)
+}
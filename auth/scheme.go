@@ -27,6 +27,53 @@ type ManagedScheme interface {
 	ChangePassword(token Token, oldPassword string, newPassword string) error
 }
 
+// TwoFactorScheme is implemented by schemes supporting TOTP based two-factor
+// authentication.
+type TwoFactorScheme interface {
+	Scheme
+	TwoFactorStatus(user *User) (*TwoFactorStatus, error)
+	StartTwoFactorEnrollment(user *User) (*TwoFactorEnrollment, error)
+	ConfirmTwoFactorEnrollment(user *User, code string) ([]string, error)
+	DisableTwoFactor(user *User, code string) error
+	RegenerateRecoveryCodes(user *User) ([]string, error)
+}
+
+// RecoveryCodesToken is implemented by tokens created by a login that
+// confirmed a two-factor authentication enrollment, holding the recovery codes
+// generated for the user.
+type RecoveryCodesToken interface {
+	Token
+	RecoveryCodes() []string
+}
+
+type TwoFactorStatus struct {
+	Enabled       bool `json:"enabled"`
+	Required      bool `json:"required"`
+	RecoveryCodes int  `json:"recovery_codes"`
+}
+
+// TwoFactorEnrollment holds the secret of a pending enrollment, URI is the
+// otpauth:// provisioning URI, usually displayed as a QR code.
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// TwoFactorRequired is returned by Login when the password was accepted but a
+// second factor is needed to complete the login. The login must be retried
+// with the challenge and a TOTP or recovery code as the "challenge" and "otp"
+// params. EnrollURI is set when the user is required to use two-factor
+// authentication but has not enrolled yet, in which case the code confirms
+// the enrollment.
+type TwoFactorRequired struct {
+	Challenge string `json:"challenge"`
+	EnrollURI string `json:"enroll_uri,omitempty"`
+}
+
+func (e *TwoFactorRequired) Error() string {
+	return "two-factor authentication code required"
+}
+
 type AuthenticationFailure struct {
 	Message string
 }
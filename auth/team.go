@@ -41,6 +41,8 @@ func (e *ErrTeamStillUsed) Error() string {
 type Team struct {
 	Name         string `bson:"_id" json:"name"`
 	CreatingUser string
+	Quota        *TeamQuota `bson:",omitempty" json:"-"`
+	QuotaInUse   *TeamQuota `bson:",omitempty" json:"-"`
 }
 
 // AllowedApps returns the apps that the team has access.
@@ -0,0 +1,253 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/quota"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var ErrTeamQuotaLesserThanUsage = errors.New("new limit is lesser than the current allocated value")
+
+// TeamQuota holds the resources used by apps and service instances owned by
+// a team. When used as limits, negative values mean unlimited.
+type TeamQuota struct {
+	Apps             int   `json:"apps"`
+	Units            int   `json:"units"`
+	Memory           int64 `json:"memory"`
+	ServiceInstances int   `json:"serviceInstances"`
+}
+
+func defaultTeamQuotaLimit(name string) int {
+	limit, err := config.GetInt("quota:teams:" + name)
+	if err != nil || limit < 0 {
+		return -1
+	}
+	return limit
+}
+
+// QuotaLimits returns the quota limits of the team, falling back to the
+// values in the quota:teams config when the team has no quota defined.
+func (t *Team) QuotaLimits() TeamQuota {
+	if t.Quota != nil {
+		return *t.Quota
+	}
+	return TeamQuota{
+		Apps:             defaultTeamQuotaLimit("apps"),
+		Units:            defaultTeamQuotaLimit("units"),
+		Memory:           int64(defaultTeamQuotaLimit("memory")),
+		ServiceInstances: defaultTeamQuotaLimit("service-instances"),
+	}
+}
+
+// QuotaUsage returns the resources used by the apps and service instances
+// owned by the team, as reserved in its quota. Teams that never had resources
+// reserved have their usage computed from the apps and service instances they
+// own.
+func (t *Team) QuotaUsage() (TeamQuota, error) {
+	if t.QuotaInUse != nil {
+		return *t.QuotaInUse, nil
+	}
+	return t.currentQuotaUsage()
+}
+
+// currentQuotaUsage computes the resources currently used by the apps and
+// service instances owned by the team. Memory is the sum of the plan memory of
+// every unit.
+func (t *Team) currentQuotaUsage() (TeamQuota, error) {
+	var usage TeamQuota
+	conn, err := db.Conn()
+	if err != nil {
+		return usage, err
+	}
+	defer conn.Close()
+	var apps []struct {
+		Plan struct {
+			Memory int64
+		}
+		Quota quota.Quota
+	}
+	err = conn.Apps().Find(bson.M{"teamowner": t.Name}).Select(bson.M{"plan.memory": 1, "quota": 1}).All(&apps)
+	if err != nil {
+		return usage, err
+	}
+	usage.Apps = len(apps)
+	for _, a := range apps {
+		usage.Units += a.Quota.InUse
+		usage.Memory += int64(a.Quota.InUse) * a.Plan.Memory
+	}
+	usage.ServiceInstances, err = conn.ServiceInstances().Find(bson.M{"teamowner": t.Name}).Count()
+	if err != nil {
+		return usage, err
+	}
+	return usage, nil
+}
+
+// initQuotaInUse stores the current usage of the team, so it can be
+// atomically incremented by later reservations.
+func (t *Team) initQuotaInUse(conn *db.Storage) error {
+	usage, err := t.currentQuotaUsage()
+	if err != nil {
+		return err
+	}
+	err = conn.Teams().Update(
+		bson.M{"_id": t.Name, "quotainuse": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"quotainuse": usage}},
+	)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+type teamQuotaResource struct {
+	name, field             string
+	limit, inUse, requested int64
+}
+
+func teamQuotaResources(limits, inUse, requested TeamQuota) []teamQuotaResource {
+	return []teamQuotaResource{
+		{"apps", "apps", int64(limits.Apps), int64(inUse.Apps), int64(requested.Apps)},
+		{"units", "units", int64(limits.Units), int64(inUse.Units), int64(requested.Units)},
+		{"memory", "memory", limits.Memory, inUse.Memory, requested.Memory},
+		{"service instances", "serviceinstances", int64(limits.ServiceInstances), int64(inUse.ServiceInstances), int64(requested.ServiceInstances)},
+	}
+}
+
+func teamQuotaInc(amount TeamQuota, sign int64) bson.M {
+	return bson.M{
+		"quotainuse.apps":             sign * int64(amount.Apps),
+		"quotainuse.units":            sign * int64(amount.Units),
+		"quotainuse.memory":           sign * amount.Memory,
+		"quotainuse.serviceinstances": sign * int64(amount.ServiceInstances),
+	}
+}
+
+// ReserveTeamQuota reserves the requested amount of resources in the quota of
+// the team, returning a *quota.QuotaExceededError describing the first
+// exhausted quota when there isn't enough space available. The reservation is
+// made with a single conditional update, so concurrent reservations can't
+// exceed the limits. Teams that don't exist have no quota enforced.
+func ReserveTeamQuota(teamName string, requested TeamQuota) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for {
+		team, err := GetTeam(teamName)
+		if err == ErrTeamNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if team.QuotaInUse == nil {
+			err = team.initQuotaInUse(conn)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		query := bson.M{"_id": team.Name}
+		for _, r := range teamQuotaResources(team.QuotaLimits(), *team.QuotaInUse, requested) {
+			if r.limit < 0 || r.requested <= 0 {
+				continue
+			}
+			if r.inUse+r.requested > r.limit {
+				available := r.limit - r.inUse
+				if available < 0 {
+					available = 0
+				}
+				return &quota.QuotaExceededError{
+					Requested: uint(r.requested),
+					Available: uint(available),
+					Resource:  fmt.Sprintf("%s of team %q", r.name, team.Name),
+				}
+			}
+			query["quotainuse."+r.field] = bson.M{"$lte": r.limit - r.requested}
+		}
+		err = conn.Teams().Update(query, bson.M{"$inc": teamQuotaInc(requested, 1)})
+		if err != mgo.ErrNotFound {
+			return err
+		}
+	}
+}
+
+// ReleaseTeamQuota releases resources previously reserved in the quota of the
+// team.
+func ReleaseTeamQuota(teamName string, released TeamQuota) error {
+	return incTeamQuotaInUse(teamName, released, -1)
+}
+
+// AddTeamQuotaInUse adds resources to the quota usage of the team without
+// checking its limits. It's used to account for resources that were already
+// allocated.
+func AddTeamQuotaInUse(teamName string, added TeamQuota) error {
+	return incTeamQuotaInUse(teamName, added, 1)
+}
+
+func incTeamQuotaInUse(teamName string, amount TeamQuota, sign int64) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// Teams without a stored usage have it computed from the resources they
+	// own on the first reservation, so there's nothing to update.
+	err = conn.Teams().Update(
+		bson.M{"_id": teamName, "quotainuse": bson.M{"$exists": true}},
+		bson.M{"$inc": teamQuotaInc(amount, sign)},
+	)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// ChangeTeamQuota redefines the quota limits of the team. Each new limit must
+// be bigger than or equal to the amount of the resource currently in use by
+// the team, and negative limits mean unlimited.
+func ChangeTeamQuota(team *Team, limits TeamQuota) error {
+	usage, err := team.QuotaUsage()
+	if err != nil {
+		return err
+	}
+	if limits.Apps < 0 {
+		limits.Apps = -1
+	}
+	if limits.Units < 0 {
+		limits.Units = -1
+	}
+	if limits.Memory < 0 {
+		limits.Memory = -1
+	}
+	if limits.ServiceInstances < 0 {
+		limits.ServiceInstances = -1
+	}
+	if (limits.Apps >= 0 && limits.Apps < usage.Apps) ||
+		(limits.Units >= 0 && limits.Units < usage.Units) ||
+		(limits.Memory >= 0 && limits.Memory < usage.Memory) ||
+		(limits.ServiceInstances >= 0 && limits.ServiceInstances < usage.ServiceInstances) {
+		return ErrTeamQuotaLesserThanUsage
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Teams().UpdateId(team.Name, bson.M{"$set": bson.M{"quota": limits}})
+	if err != nil {
+		return err
+	}
+	team.Quota = &limits
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/quota"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) insertTeamQuotaResources(c *check.C) {
+	apps := []bson.M{
+		{"name": "app1", "teamowner": s.team.Name, "plan": bson.M{"memory": 100}, "quota": bson.M{"limit": -1, "inuse": 2}},
+		{"name": "app2", "teamowner": s.team.Name, "plan": bson.M{"memory": 50}, "quota": bson.M{"limit": -1, "inuse": 1}},
+		{"name": "app3", "teamowner": "otherteam", "plan": bson.M{"memory": 50}, "quota": bson.M{"limit": -1, "inuse": 10}},
+	}
+	for _, a := range apps {
+		err := s.conn.Apps().Insert(a)
+		c.Assert(err, check.IsNil)
+	}
+	err := s.conn.ServiceInstances().Insert(
+		bson.M{"name": "db1", "service_name": "mysql", "teamowner": s.team.Name},
+		bson.M{"name": "db2", "service_name": "mysql", "teamowner": "otherteam"},
+	)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestTeamQuotaLimits(c *check.C) {
+	team := Team{Name: "myteam"}
+	c.Assert(team.QuotaLimits(), check.DeepEquals, TeamQuota{Apps: -1, Units: -1, Memory: -1, ServiceInstances: -1})
+	config.Set("quota:teams:apps", 10)
+	config.Set("quota:teams:memory", 1024)
+	defer config.Unset("quota:teams")
+	c.Assert(team.QuotaLimits(), check.DeepEquals, TeamQuota{Apps: 10, Units: -1, Memory: 1024, ServiceInstances: -1})
+	team.Quota = &TeamQuota{Apps: 1, Units: 2, Memory: 3, ServiceInstances: 4}
+	c.Assert(team.QuotaLimits(), check.DeepEquals, TeamQuota{Apps: 1, Units: 2, Memory: 3, ServiceInstances: 4})
+}
+
+func (s *S) TestTeamQuotaUsage(c *check.C) {
+	s.insertTeamQuotaResources(c)
+	usage, err := s.team.QuotaUsage()
+	c.Assert(err, check.IsNil)
+	c.Assert(usage, check.DeepEquals, TeamQuota{Apps: 2, Units: 3, Memory: 250, ServiceInstances: 1})
+}
+
+func (s *S) TestReserveTeamQuota(c *check.C) {
+	s.insertTeamQuotaResources(c)
+	err := ReserveTeamQuota(s.team.Name, TeamQuota{Apps: 1, Units: 1, Memory: 50})
+	c.Assert(err, check.IsNil)
+	team, err := GetTeam(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(team.QuotaInUse, check.DeepEquals, &TeamQuota{Apps: 3, Units: 4, Memory: 300, ServiceInstances: 1})
+	err = ChangeTeamQuota(team, TeamQuota{Apps: 4, Units: 5, Memory: 350, ServiceInstances: -1})
+	c.Assert(err, check.IsNil)
+	err = ReserveTeamQuota(s.team.Name, TeamQuota{Apps: 2})
+	c.Assert(err, check.DeepEquals, &quota.QuotaExceededError{Requested: 2, Available: 1, Resource: `apps of team "cobrateam"`})
+	err = ReserveTeamQuota(s.team.Name, TeamQuota{Units: 1, Memory: 100})
+	c.Assert(err, check.ErrorMatches, `Quota exceeded for memory of team "cobrateam". Available: 50. Requested: 100.`)
+	err = ReserveTeamQuota(s.team.Name, TeamQuota{ServiceInstances: 100})
+	c.Assert(err, check.IsNil)
+	team, err = GetTeam(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(team.QuotaInUse, check.DeepEquals, &TeamQuota{Apps: 3, Units: 4, Memory: 300, ServiceInstances: 101})
+	err = ReserveTeamQuota("unknownteam", TeamQuota{Apps: 100})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestReserveTeamQuotaIsSafe(c *check.C) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(runtime.NumCPU()))
+	err := ChangeTeamQuota(s.team, TeamQuota{Apps: 10, Units: -1, Memory: -1, ServiceInstances: -1})
+	c.Assert(err, check.IsNil)
+	var wg sync.WaitGroup
+	for i := 0; i < 24; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ReserveTeamQuota(s.team.Name, TeamQuota{Apps: 1})
+		}()
+	}
+	wg.Wait()
+	team, err := GetTeam(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(team.QuotaInUse.Apps, check.Equals, 10)
+}
+
+func (s *S) TestReleaseTeamQuota(c *check.C) {
+	s.insertTeamQuotaResources(c)
+	err := ReleaseTeamQuota(s.team.Name, TeamQuota{Apps: 1})
+	c.Assert(err, check.IsNil)
+	team, err := GetTeam(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(team.QuotaInUse, check.IsNil)
+	err = ReserveTeamQuota(s.team.Name, TeamQuota{Units: 2, Memory: 100})
+	c.Assert(err, check.IsNil)
+	err = ReleaseTeamQuota(s.team.Name, TeamQuota{Apps: 1, Units: 3, Memory: 200})
+	c.Assert(err, check.IsNil)
+	team, err = GetTeam(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(team.QuotaInUse, check.DeepEquals, &TeamQuota{Apps: 1, Units: 2, Memory: 150, ServiceInstances: 1})
+	usage, err := team.QuotaUsage()
+	c.Assert(err, check.IsNil)
+	c.Assert(usage, check.DeepEquals, *team.QuotaInUse)
+}
+
+func (s *S) TestAddTeamQuotaInUse(c *check.C) {
+	s.insertTeamQuotaResources(c)
+	err := ChangeTeamQuota(s.team, TeamQuota{Apps: -1, Units: 3, Memory: -1, ServiceInstances: -1})
+	c.Assert(err, check.IsNil)
+	err = ReserveTeamQuota(s.team.Name, TeamQuota{})
+	c.Assert(err, check.IsNil)
+	err = AddTeamQuotaInUse(s.team.Name, TeamQuota{Units: 2, Memory: 100})
+	c.Assert(err, check.IsNil)
+	team, err := GetTeam(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(team.QuotaInUse, check.DeepEquals, &TeamQuota{Apps: 2, Units: 5, Memory: 350, ServiceInstances: 1})
+}
+
+func (s *S) TestChangeTeamQuota(c *check.C) {
+	s.insertTeamQuotaResources(c)
+	err := ChangeTeamQuota(s.team, TeamQuota{Apps: 1, Units: -1, Memory: -1, ServiceInstances: -1})
+	c.Assert(err, check.Equals, ErrTeamQuotaLesserThanUsage)
+	err = ChangeTeamQuota(s.team, TeamQuota{Apps: 2, Units: -10, Memory: 250, ServiceInstances: 1})
+	c.Assert(err, check.IsNil)
+	team, err := GetTeam(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(team.Quota, check.DeepEquals, &TeamQuota{Apps: 2, Units: -1, Memory: 250, ServiceInstances: 1})
+}
@@ -145,6 +145,10 @@ func (u *User) Delete() error {
 	if err != nil {
 		log.Errorf("failed to remove user %q from the database: %s", u.Email, err)
 	}
+	_, err = conn.PersonalTokens().RemoveAll(bson.M{"useremail": u.Email})
+	if err != nil {
+		log.Errorf("failed to remove personal tokens of user %q from the database: %s", u.Email, err)
+	}
 	err = repository.Manager().RemoveUser(u.Email)
 	if err != nil {
 		log.Errorf("failed to remove user %q from the repository manager: %s", u.Email, err)
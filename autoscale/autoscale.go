@@ -7,6 +7,7 @@ package autoscale
 import (
 	"fmt"
 	"io"
+	"strings"
 	"sync"
 	"time"
 
@@ -36,6 +37,7 @@ type Config struct {
 	RunInterval         time.Duration
 	TotalMemoryMetadata string
 	Enabled             bool
+	metrics             nodeMetricsSource
 	done                chan bool
 	writer              io.Writer
 }
@@ -68,12 +70,28 @@ func newConfig() *Config {
 	waitSecondsNewMachine, _ := config.GetInt("docker:auto-scale:wait-new-time")
 	runInterval, _ := config.GetInt("docker:auto-scale:run-interval")
 	totalMemoryMetadata, _ := config.GetString("docker:scheduler:total-memory-metadata")
+	metricsURL, _ := config.GetString("docker:auto-scale:metrics-url")
+	cpuQuery, _ := config.GetString("docker:auto-scale:cpu-query")
+	if cpuQuery == "" {
+		cpuQuery = defaultNodeCPUQuery
+	}
+	memoryQuery, _ := config.GetString("docker:auto-scale:memory-query")
+	if memoryQuery == "" {
+		memoryQuery = defaultNodeMemoryQuery
+	}
 	c := &Config{
 		TotalMemoryMetadata: totalMemoryMetadata,
 		WaitTimeNewMachine:  time.Duration(waitSecondsNewMachine) * time.Second,
 		RunInterval:         time.Duration(runInterval) * time.Second,
 		Enabled:             enabled,
 		done:                make(chan bool),
+		metrics: &promNodeMetrics{
+			client: promClient{url: metricsURL},
+			queries: map[string]string{
+				NodeMetricCPU:    cpuQuery,
+				NodeMetricMemory: memoryQuery,
+			},
+		},
 	}
 	if c.RunInterval == 0 {
 		c.RunInterval = time.Hour
@@ -112,10 +130,20 @@ type autoScaler interface {
 }
 
 func (a *Config) scalerForRule(rule *Rule) (autoScaler, error) {
-	if rule.MaxContainerCount > 0 {
+	switch rule.Scaler {
+	case ScalerCount:
 		return &countScaler{Config: a, rule: rule}, nil
+	case ScalerMemory:
+		return &memoryScaler{Config: a, rule: rule}, nil
+	case ScalerMetrics:
+		return &metricsScaler{Config: a, rule: rule}, nil
+	case "":
+		if rule.MaxContainerCount > 0 {
+			return &countScaler{Config: a, rule: rule}, nil
+		}
+		return &memoryScaler{Config: a, rule: rule}, nil
 	}
-	return &memoryScaler{Config: a, rule: rule}, nil
+	return nil, errors.Errorf("invalid scaler %q", rule.Scaler)
 }
 
 func (a *Config) run() error {
@@ -209,9 +237,10 @@ func (a *Config) runScaler() (retErr error) {
 }
 
 type EventCustomData struct {
-	Result *ScalerResult
-	Nodes  []provision.NodeSpec
-	Rule   *Rule
+	Result   *ScalerResult
+	Nodes    []provision.NodeSpec
+	Rule     *Rule
+	Schedule *ScheduleLimits
 }
 
 func nodesToSpec(nodes []provision.Node) []provision.NodeSpec {
@@ -241,6 +270,7 @@ func (a *Config) runScalerInNodes(prov provision.NodeProvisioner, pool string, n
 	var sResult *ScalerResult
 	var evtNodes []provision.NodeSpec
 	var rule *Rule
+	var limits *ScheduleLimits
 	defer func() {
 		if retErr != nil {
 			evt.Logf(retErr.Error())
@@ -250,9 +280,10 @@ func (a *Config) runScalerInNodes(prov provision.NodeProvisioner, pool string, n
 			evt.Abort()
 		} else {
 			evt.DoneCustomData(retErr, EventCustomData{
-				Result: sResult,
-				Nodes:  evtNodes,
-				Rule:   rule,
+				Result:   sResult,
+				Nodes:    evtNodes,
+				Rule:     rule,
+				Schedule: limits,
 			})
 		}
 	}()
@@ -266,26 +297,42 @@ func (a *Config) runScalerInNodes(prov provision.NodeProvisioner, pool string, n
 			return
 		}
 		evt.Logf("no auto scale rule for %s", pool)
-		return
-	}
-	if !rule.Enabled {
+		rule = nil
+	} else if !rule.Enabled {
 		evt.Logf("auto scale rule disabled for %s", pool)
-		return
+		rule = nil
 	}
-	scaler, err := a.scalerForRule(rule)
+	limits, err = scheduleLimitsForPool(pool, time.Now())
 	if err != nil {
-		retErr = errors.Wrapf(err, "error getting scaler for %s", pool)
+		retErr = errors.Wrapf(err, "unable to fetch auto scale schedules for %s", pool)
 		return
 	}
-	evt.Logf("running scaler %T for %q: %q", scaler, provision.PoolMetadataName, pool)
-	sResult, err = scaler.scale(pool, nodes)
-	if err != nil {
-		if _, ok := err.(errAppNotLocked); ok {
-			evt.Logf("aborting scaler for now, gonna retry later: %s", err)
+	if rule == nil && limits == nil {
+		return
+	}
+	sResult = &ScalerResult{}
+	if rule != nil {
+		var scaler autoScaler
+		scaler, err = a.scalerForRule(rule)
+		if err != nil {
+			retErr = errors.Wrapf(err, "error getting scaler for %s", pool)
+			return
+		}
+		evt.Logf("running scaler %T for %q: %q", scaler, provision.PoolMetadataName, pool)
+		sResult, err = scaler.scale(pool, nodes)
+		if err != nil {
+			if _, ok := err.(errAppNotLocked); ok {
+				evt.Logf("aborting scaler for now, gonna retry later: %s", err)
+				sResult = nil
+				return
+			}
+			retErr = errors.Wrapf(err, "error scaling group %s", pool)
 			return
 		}
-		retErr = errors.Wrapf(err, "error scaling group %s", pool)
-		return
+	}
+	if limits != nil {
+		evt.Logf("enforcing schedules %v for %q: %q", limits.Schedules, provision.PoolMetadataName, pool)
+		applyScheduleLimits(limits, nodes, sResult)
 	}
 	if sResult.ToAdd > 0 {
 		evt.Logf("running event \"add\" for %q: %#v", pool, sResult)
@@ -306,7 +353,7 @@ func (a *Config) runScalerInNodes(prov provision.NodeProvisioner, pool string, n
 			return
 		}
 	}
-	if !rule.PreventRebalance {
+	if rule == nil || !rule.PreventRebalance {
 		err := a.rebalanceIfNeeded(evt, prov, pool, nodes, sResult)
 		if err != nil {
 			if sResult.IsRebalanceOnly() {
@@ -318,6 +365,36 @@ func (a *Config) runScalerInNodes(prov provision.NodeProvisioner, pool string, n
 	}
 }
 
+// applyScheduleLimits changes the scaler result so that the number of nodes
+// in the pool after the scaling action is within the limits.
+func applyScheduleLimits(limits *ScheduleLimits, nodes []provision.Node, sResult *ScalerResult) {
+	final := len(nodes) + sResult.ToAdd - len(sResult.ToRemove)
+	reason := fmt.Sprintf("schedules %s", strings.Join(limits.Schedules, ", "))
+	if final < limits.MinNodes {
+		if len(nodes) < limits.MinNodes {
+			sResult.ToAdd = limits.MinNodes - len(nodes)
+			sResult.ToRemove = nil
+			sResult.Reason = fmt.Sprintf("%s require at least %d nodes", reason, limits.MinNodes)
+		} else {
+			sResult.ToRemove = sResult.ToRemove[:len(nodes)-limits.MinNodes]
+		}
+		return
+	}
+	if limits.MaxNodes == 0 || final <= limits.MaxNodes {
+		return
+	}
+	if len(nodes) <= limits.MaxNodes {
+		sResult.ToAdd = limits.MaxNodes - len(nodes)
+		return
+	}
+	sResult.ToAdd = 0
+	chosenNodes := chooseNodeForRemoval(nodes, len(nodes)-limits.MaxNodes)
+	if len(chosenNodes) > 0 {
+		sResult.ToRemove = nodesToSpec(chosenNodes)
+		sResult.Reason = fmt.Sprintf("%s allow at most %d nodes", reason, limits.MaxNodes)
+	}
+}
+
 func (a *Config) rebalanceIfNeeded(evt *event.Event, prov provision.NodeProvisioner, pool string, nodes []provision.Node, sResult *ScalerResult) error {
 	if len(sResult.ToRemove) > 0 {
 		return nil
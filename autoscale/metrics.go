@@ -0,0 +1,149 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package autoscale
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/net"
+	"github.com/tsuru/tsuru/provision"
+)
+
+const (
+	ScalerCount   = "count"
+	ScalerMemory  = "memory"
+	ScalerMetrics = "metrics"
+
+	NodeMetricCPU    = "cpu"
+	NodeMetricMemory = "memory"
+
+	defaultNodeCPUQuery    = `1 - avg(rate(node_cpu{mode="idle",instance=~"{{.Host}}(:[0-9]+)?"}[5m]))`
+	defaultNodeMemoryQuery = `1 - sum(node_memory_MemAvailable{instance=~"{{.Host}}(:[0-9]+)?"}) / sum(node_memory_MemTotal{instance=~"{{.Host}}(:[0-9]+)?"})`
+)
+
+// nodeMetricsSource returns the usage ratio, between 0 and 1, of the given
+// metric for each node, indexed by node address.
+type nodeMetricsSource interface {
+	nodesUsage(metric, pool string, nodes []provision.Node) (map[string]float64, error)
+}
+
+type promNodeMetrics struct {
+	client  promClient
+	queries map[string]string
+}
+
+func (m *promNodeMetrics) nodesUsage(metric, pool string, nodes []provision.Node) (map[string]float64, error) {
+	tpl, ok := m.queries[metric]
+	if !ok {
+		return nil, errors.Errorf("no query for metric %q", metric)
+	}
+	usage := make(map[string]float64, len(nodes))
+	for _, n := range nodes {
+		query, err := renderQuery(tpl, struct{ Pool, Address, Host string }{
+			Pool:    pool,
+			Address: n.Address(),
+			Host:    net.URLToHost(n.Address()),
+		})
+		if err != nil {
+			return nil, err
+		}
+		usage[n.Address()], err = m.client.query(query)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to get %s metric for node %s", metric, n.Address())
+		}
+	}
+	return usage, nil
+}
+
+// metricsScaler adds or removes nodes based on the real usage of the nodes in
+// the pool. Nodes are added when the average usage goes above the rule scale
+// up threshold and removed when it goes below the scale down threshold, in
+// both cases aiming the middle point between both thresholds, so that a
+// scaling action never causes the opposite action in the next run. No action
+// is taken while the last scaling event for the pool is within the rule
+// cooldown window.
+type metricsScaler struct {
+	*Config
+	rule *Rule
+}
+
+func (a *metricsScaler) scale(pool string, nodes []provision.Node) (*ScalerResult, error) {
+	if a.rule.CooldownSeconds > 0 {
+		lastScale, err := lastScaleTime(pool)
+		if err != nil {
+			return nil, err
+		}
+		cooldown := time.Duration(a.rule.CooldownSeconds) * time.Second
+		if since := time.Since(lastScale); since < cooldown {
+			a.logDebug("skipping pool %s, last scale was %s ago, cooldown is %s", pool, since, cooldown)
+			return &ScalerResult{}, nil
+		}
+	}
+	if a.metrics == nil {
+		return nil, errors.New("no metrics source configured")
+	}
+	usage, err := a.metrics.nodesUsage(a.rule.Metric, pool, nodes)
+	if err != nil {
+		return nil, err
+	}
+	var total float64
+	for _, n := range nodes {
+		value, ok := usage[n.Address()]
+		if !ok {
+			return nil, errors.Errorf("no %s metric for node %s", a.rule.Metric, n.Address())
+		}
+		total += value
+	}
+	avg := total / float64(len(nodes))
+	scaleUp := float64(a.rule.ScaleUpThreshold)
+	scaleDown := float64(a.rule.ScaleDownThreshold)
+	desired := int(math.Ceil(total / ((scaleUp + scaleDown) / 2)))
+	if desired < 1 {
+		desired = 1
+	}
+	if avg > scaleUp {
+		toAdd := desired - len(nodes)
+		if toAdd < 1 {
+			toAdd = 1
+		}
+		return &ScalerResult{
+			ToAdd:  toAdd,
+			Reason: fmt.Sprintf("average %s usage is %.2f, above %.2f", a.rule.Metric, avg, scaleUp),
+		}, nil
+	}
+	if avg >= scaleDown || desired >= len(nodes) {
+		return &ScalerResult{}, nil
+	}
+	chosenNodes := chooseNodeForRemoval(nodes, len(nodes)-desired)
+	if len(chosenNodes) == 0 {
+		a.logDebug("would remove any node but can't due to metadata restrictions")
+		return &ScalerResult{}, nil
+	}
+	return &ScalerResult{
+		ToRemove: nodesToSpec(chosenNodes),
+		Reason:   fmt.Sprintf("average %s usage is %.2f, below %.2f", a.rule.Metric, avg, scaleDown),
+	}, nil
+}
+
+func lastScaleTime(pool string) (time.Time, error) {
+	notRunning := false
+	evts, err := event.List(&event.Filter{
+		Target:   event.Target{Type: event.TargetTypePool, Value: pool},
+		KindName: EventKind,
+		Running:  &notRunning,
+		Limit:    1,
+	})
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "unable to list auto scale events for %s", pool)
+	}
+	if len(evts) == 0 {
+		return time.Time{}, nil
+	}
+	return evts[0].EndTime, nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package autoscale
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type fakeNodeMetrics struct {
+	usage map[string]float64
+	calls int
+}
+
+func (m *fakeNodeMetrics) nodesUsage(metric, pool string, nodes []provision.Node) (map[string]float64, error) {
+	m.calls++
+	return m.usage, nil
+}
+
+func (s *S) addMetricsRule(c *check.C, cooldown int) {
+	rule := Rule{
+		MetadataFilter:     "pool1",
+		Scaler:             ScalerMetrics,
+		Metric:             NodeMetricCPU,
+		ScaleUpThreshold:   0.8,
+		ScaleDownThreshold: 0.4,
+		CooldownSeconds:    cooldown,
+		Enabled:            true,
+		PreventRebalance:   true,
+	}
+	err := rule.Update()
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) addNode2(c *check.C) {
+	err := s.p.AddNode(provision.AddNodeOptions{
+		Address: "http://n2:2",
+		Metadata: map[string]string{
+			provision.PoolMetadataName: "pool1",
+			"iaas":                     "my-scale-iaas",
+		},
+	})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestAutoScaleMetricsScalerAddNodes(c *check.C) {
+	s.addMetricsRule(c, 0)
+	a := newConfig()
+	a.metrics = &fakeNodeMetrics{usage: map[string]float64{"http://n1:1": 0.95}}
+	err := a.runOnce()
+	c.Assert(err, check.IsNil)
+	nodes, err := s.p.ListNodes(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 2, check.Commentf("log: %s", s.logBuf.String()))
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: provision.PoolMetadataName, Value: "pool1"},
+		Kind:   "autoscale",
+		EndCustomData: map[string]interface{}{
+			"result.toadd":  1,
+			"result.reason": "average cpu usage is 0.95, above 0.80",
+			"rule.scaler":   ScalerMetrics,
+		},
+		LogMatches: `(?s).*running scaler.*metricsScaler.*pool1.*new machine created.*`,
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestAutoScaleMetricsScalerRemoveNodes(c *check.C) {
+	s.addNode2(c)
+	s.addMetricsRule(c, 0)
+	a := newConfig()
+	a.metrics = &fakeNodeMetrics{usage: map[string]float64{"http://n1:1": 0.2, "http://n2:2": 0.1}}
+	err := a.runOnce()
+	c.Assert(err, check.IsNil)
+	nodes, err := s.p.ListNodes(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 1)
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: provision.PoolMetadataName, Value: "pool1"},
+		Kind:   "autoscale",
+		EndCustomData: map[string]interface{}{
+			"result.toremove": bson.M{"$size": 1},
+			"result.reason":   "average cpu usage is 0.15, below 0.40",
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestAutoScaleMetricsScalerHysteresis(c *check.C) {
+	s.addNode2(c)
+	s.addMetricsRule(c, 0)
+	a := newConfig()
+	a.metrics = &fakeNodeMetrics{usage: map[string]float64{"http://n1:1": 0.35, "http://n2:2": 0.35}}
+	err := a.runOnce()
+	c.Assert(err, check.IsNil)
+	nodes, err := s.p.ListNodes(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 2)
+	evts, err := event.All()
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 0)
+}
+
+func (s *S) TestAutoScaleMetricsScalerCooldown(c *check.C) {
+	s.addMetricsRule(c, 3600)
+	a := newConfig()
+	metrics := &fakeNodeMetrics{usage: map[string]float64{"http://n1:1": 0.95}}
+	a.metrics = metrics
+	err := a.runOnce()
+	c.Assert(err, check.IsNil)
+	metrics.usage = map[string]float64{"http://n1:1": 0.95, "http://n2:2": 0.95}
+	err = a.runOnce()
+	c.Assert(err, check.IsNil)
+	c.Assert(metrics.calls, check.Equals, 1)
+	nodes, err := s.p.ListNodes(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 2)
+}
+
+func (s *S) TestAutoScaleMetricsScalerMissingNodeMetric(c *check.C) {
+	s.addNode2(c)
+	s.addMetricsRule(c, 0)
+	a := newConfig()
+	a.metrics = &fakeNodeMetrics{usage: map[string]float64{"http://n1:1": 0.95}}
+	a.runOnce()
+	c.Assert(eventtest.EventDesc{
+		Target:       event.Target{Type: provision.PoolMetadataName, Value: "pool1"},
+		Kind:         "autoscale",
+		ErrorMatches: `error scaling group pool1: no cpu metric for node http://n2:2`,
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestAutoScaleMetricsRuleInvalid(c *check.C) {
+	tests := []struct {
+		rule Rule
+		err  string
+	}{
+		{Rule{Scaler: "other"}, `invalid rule, scaler must be one of "count", "memory" or "metrics", got "other"`},
+		{Rule{Scaler: ScalerMetrics, Metric: "disk"}, `invalid rule, metric must be "cpu" or "memory", got "disk"`},
+		{Rule{Scaler: ScalerMetrics, ScaleUpThreshold: 1.5}, `invalid rule, scale up threshold needs to be at most 1.0, got 1.5.*`},
+		{Rule{Scaler: ScalerMetrics, ScaleUpThreshold: 0.5, ScaleDownThreshold: 0.6}, `invalid rule, scale down threshold needs to be between 0.0 and the scale up threshold, got 0.6.*`},
+		{Rule{Scaler: ScalerMetrics, CooldownSeconds: -1}, `invalid rule, cooldown can't be negative, got -1`},
+	}
+	for _, tt := range tests {
+		err := tt.rule.Update()
+		c.Assert(err, check.ErrorMatches, tt.err)
+		c.Assert(tt.rule.Error, check.Matches, tt.err)
+	}
+	rule := Rule{MetadataFilter: "pool1", Scaler: ScalerMetrics}
+	err := rule.Update()
+	c.Assert(err, check.IsNil)
+	c.Assert(rule.Metric, check.Equals, NodeMetricCPU)
+	c.Assert(rule.ScaleUpThreshold, check.Equals, float32(0.8))
+	c.Assert(rule.ScaleDownThreshold, check.Equals, float32(0.4))
+}
+
+func (s *S) TestPromNodeMetrics(c *check.C) {
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		queries = append(queries, query)
+		value := "0.5"
+		if query == "usage{pool=\"pool1\",host=\"n2\"}" {
+			value = "0.25"
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1490000000,%q]}]}}`, value)
+	}))
+	defer srv.Close()
+	s.addNode2(c)
+	nodes, err := s.p.ListNodes(nil)
+	c.Assert(err, check.IsNil)
+	m := promNodeMetrics{
+		client:  promClient{url: srv.URL},
+		queries: map[string]string{NodeMetricMemory: `usage{pool="{{.Pool}}",host="{{.Host}}"}`},
+	}
+	usage, err := m.nodesUsage(NodeMetricMemory, "pool1", nodes)
+	c.Assert(err, check.IsNil)
+	c.Assert(usage, check.DeepEquals, map[string]float64{"http://n1:1": 0.5, "http://n2:2": 0.25})
+	c.Assert(queries, check.DeepEquals, []string{`usage{pool="pool1",host="n1"}`, `usage{pool="pool1",host="n2"}`})
+	_, err = m.nodesUsage(NodeMetricCPU, "pool1", nodes)
+	c.Assert(err, check.ErrorMatches, `no query for metric "cpu"`)
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package autoscale
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/net"
+)
+
+// promClient runs instant queries against a Prometheus compatible HTTP API.
+type promClient struct {
+	url string
+}
+
+type promResponse struct {
+	Status string
+	Error  string
+	Data   struct {
+		ResultType string
+		Result     []struct {
+			Metric map[string]string
+			Value  []interface{}
+		}
+	}
+}
+
+// query returns the sum of all samples returned by the expression.
+func (c *promClient) query(expr string) (float64, error) {
+	if c.url == "" {
+		return 0, errors.New("metrics url not configured")
+	}
+	u := strings.TrimRight(c.url, "/") + "/api/v1/query?" + url.Values{"query": []string{expr}}.Encode()
+	rsp, err := net.Dial5Full60ClientNoKeepAlive.Get(u)
+	if err != nil {
+		return 0, errors.Wrapf(err, "unable to query metrics")
+	}
+	defer rsp.Body.Close()
+	var data promResponse
+	err = json.NewDecoder(rsp.Body).Decode(&data)
+	if err != nil {
+		return 0, errors.Wrapf(err, "unable to decode metrics response (status %d)", rsp.StatusCode)
+	}
+	if rsp.StatusCode != http.StatusOK || data.Status != "success" {
+		return 0, errors.Errorf("invalid metrics response (status %d): %s", rsp.StatusCode, data.Error)
+	}
+	var total float64
+	for _, r := range data.Data.Result {
+		if len(r.Value) != 2 {
+			continue
+		}
+		strValue, _ := r.Value[1].(string)
+		value, err := strconv.ParseFloat(strValue, 64)
+		if err != nil {
+			return 0, errors.Wrapf(err, "invalid metric value %q", strValue)
+		}
+		total += value
+	}
+	return total, nil
+}
+
+func renderQuery(tpl string, data interface{}) (string, error) {
+	t, err := template.New("query").Parse(tpl)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid query template %q", tpl)
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -23,6 +23,14 @@ type Rule struct {
 	MaxMemoryRatio    float32
 	Enabled           bool
 	PreventRebalance  bool
+	// Scaler selects the scaler used by the rule, one of "count", "memory"
+	// or "metrics". When empty, the count scaler is used if
+	// MaxContainerCount is set, otherwise the memory scaler is used.
+	Scaler             string
+	Metric             string
+	ScaleUpThreshold   float32
+	ScaleDownThreshold float32
+	CooldownSeconds    int
 }
 
 type ruleList []Rule
@@ -32,6 +40,15 @@ func (l ruleList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
 func (l ruleList) Less(i, j int) bool { return l[i].MetadataFilter < l[j].MetadataFilter }
 
 func (r *Rule) normalize() error {
+	switch r.Scaler {
+	case "", ScalerCount, ScalerMemory:
+	case ScalerMetrics:
+		return r.normalizeMetrics()
+	default:
+		err := errors.Errorf("invalid rule, scaler must be one of %q, %q or %q, got %q", ScalerCount, ScalerMemory, ScalerMetrics, r.Scaler)
+		r.Error = err.Error()
+		return err
+	}
 	if r.ScaleDownRatio == 0.0 {
 		r.ScaleDownRatio = 1.333
 	} else if r.ScaleDownRatio <= 1.0 {
@@ -52,6 +69,32 @@ func (r *Rule) normalize() error {
 	return nil
 }
 
+func (r *Rule) normalizeMetrics() error {
+	if r.Metric == "" {
+		r.Metric = NodeMetricCPU
+	}
+	if r.ScaleUpThreshold == 0.0 {
+		r.ScaleUpThreshold = 0.8
+	}
+	if r.ScaleDownThreshold == 0.0 {
+		r.ScaleDownThreshold = 0.4
+	}
+	var err error
+	if r.Metric != NodeMetricCPU && r.Metric != NodeMetricMemory {
+		err = errors.Errorf("invalid rule, metric must be %q or %q, got %q", NodeMetricCPU, NodeMetricMemory, r.Metric)
+	} else if r.ScaleUpThreshold > 1.0 {
+		err = errors.Errorf("invalid rule, scale up threshold needs to be at most 1.0, got %f", r.ScaleUpThreshold)
+	} else if r.ScaleDownThreshold < 0.0 || r.ScaleDownThreshold >= r.ScaleUpThreshold {
+		err = errors.Errorf("invalid rule, scale down threshold needs to be between 0.0 and the scale up threshold, got %f", r.ScaleDownThreshold)
+	} else if r.CooldownSeconds < 0 {
+		err = errors.Errorf("invalid rule, cooldown can't be negative, got %d", r.CooldownSeconds)
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return err
+}
+
 func (r *Rule) Update() error {
 	coll, err := autoScaleRuleCollection()
 	if err != nil {
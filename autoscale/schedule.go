@@ -0,0 +1,337 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package autoscale
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/storage"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Schedule sets bounds to the number of nodes in the pools matching
+// MetadataFilter during a time window. The window starts at each time matching
+// the cron-like Cron expression ("minute hour day-of-month month day-of-week")
+// evaluated in Location, and lasts for DurationMinutes. An empty
+// MetadataFilter matches every pool and a zero MaxNodes means no upper bound.
+type Schedule struct {
+	Name            string `bson:"_id"`
+	MetadataFilter  string
+	Cron            string
+	DurationMinutes int
+	Location        string
+	MinNodes        int
+	MaxNodes        int
+	Enabled         bool
+}
+
+// ScheduleStatus is the state of a schedule at a given time.
+type ScheduleStatus struct {
+	Schedule
+	Active      bool
+	ActiveUntil time.Time `json:",omitempty"`
+	NextStart   time.Time `json:",omitempty"`
+}
+
+// ScheduleLimits are the node count bounds resulting from all the schedules
+// active for a pool.
+type ScheduleLimits struct {
+	MinNodes  int
+	MaxNodes  int
+	Schedules []string
+}
+
+func (s *Schedule) validate() error {
+	if s.Name == "" {
+		return errors.New("schedule name is required")
+	}
+	if _, err := parseCron(s.Cron); err != nil {
+		return err
+	}
+	if _, err := s.location(); err != nil {
+		return errors.Wrapf(err, "invalid location %q", s.Location)
+	}
+	if s.DurationMinutes <= 0 {
+		return errors.New("schedule duration must be greater than 0")
+	}
+	if s.MinNodes < 0 || s.MaxNodes < 0 {
+		return errors.New("number of nodes can't be negative")
+	}
+	if s.MaxNodes > 0 && s.MaxNodes < s.MinNodes {
+		return errors.New("maximum number of nodes must be greater than or equal to the minimum")
+	}
+	return nil
+}
+
+func (s *Schedule) location() (*time.Location, error) {
+	if s.Location == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(s.Location)
+}
+
+// Status returns whether the schedule is active at the given time, until when
+// it's active and when its next window starts.
+func (s *Schedule) Status(now time.Time) (ScheduleStatus, error) {
+	status := ScheduleStatus{Schedule: *s}
+	spec, err := parseCron(s.Cron)
+	if err != nil {
+		return status, err
+	}
+	loc, err := s.location()
+	if err != nil {
+		return status, err
+	}
+	now = now.In(loc)
+	duration := time.Duration(s.DurationMinutes) * time.Minute
+	start := spec.next(now.Add(-duration))
+	if !start.IsZero() && !start.After(now) {
+		status.Active = true
+		status.ActiveUntil = start.Add(duration)
+	}
+	status.NextStart = spec.next(now)
+	return status, nil
+}
+
+func (s *Schedule) matchesPool(pool string) bool {
+	return s.MetadataFilter == "" || s.MetadataFilter == pool
+}
+
+func (s *Schedule) Update() error {
+	err := s.validate()
+	if err != nil {
+		return err
+	}
+	coll, err := autoScaleScheduleCollection()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	_, err = coll.UpsertId(s.Name, s)
+	return err
+}
+
+func autoScaleScheduleCollection() (*storage.Collection, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	name, err := config.GetString("docker:collection")
+	if err != nil {
+		name = "docker"
+	}
+	return conn.Collection(fmt.Sprintf("%s_auto_scale_schedule", name)), nil
+}
+
+func ListSchedules() ([]Schedule, error) {
+	coll, err := autoScaleScheduleCollection()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	var schedules []Schedule
+	err = coll.Find(nil).Sort("_id").All(&schedules)
+	if err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+func DeleteSchedule(name string) error {
+	coll, err := autoScaleScheduleCollection()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	return coll.RemoveId(name)
+}
+
+// SchedulesStatus returns the status of every registered schedule at the
+// given time.
+func SchedulesStatus(now time.Time) ([]ScheduleStatus, error) {
+	schedules, err := ListSchedules()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]ScheduleStatus, len(schedules))
+	for i := range schedules {
+		statuses[i], err = schedules[i].Status(now)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid schedule %q", schedules[i].Name)
+		}
+	}
+	return statuses, nil
+}
+
+// scheduleLimitsForPool combines all enabled schedules active for the pool at
+// the given time, using the largest minimum and the smallest maximum. It
+// returns nil if no schedule is active.
+func scheduleLimitsForPool(pool string, now time.Time) (*ScheduleLimits, error) {
+	coll, err := autoScaleScheduleCollection()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	var schedules []Schedule
+	err = coll.Find(bson.M{"enabled": true}).Sort("_id").All(&schedules)
+	if err != nil {
+		return nil, err
+	}
+	var limits *ScheduleLimits
+	for i := range schedules {
+		if !schedules[i].matchesPool(pool) {
+			continue
+		}
+		status, err := schedules[i].Status(now)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid schedule %q", schedules[i].Name)
+		}
+		if !status.Active {
+			continue
+		}
+		if limits == nil {
+			limits = &ScheduleLimits{}
+		}
+		limits.Schedules = append(limits.Schedules, schedules[i].Name)
+		if schedules[i].MinNodes > limits.MinNodes {
+			limits.MinNodes = schedules[i].MinNodes
+		}
+		if schedules[i].MaxNodes > 0 && (limits.MaxNodes == 0 || schedules[i].MaxNodes < limits.MaxNodes) {
+			limits.MaxNodes = schedules[i].MaxNodes
+		}
+	}
+	return limits, nil
+}
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12},
+	{name: "day of week", min: 0, max: 7},
+}
+
+// cronSpec is a parsed cron expression, each field holds a bit set of the
+// matching values.
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+func parseCron(expr string) (*cronSpec, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronFields) {
+		return nil, errors.Errorf("invalid cron expression %q, expected %d fields", expr, len(cronFields))
+	}
+	var bits [5]uint64
+	for i, f := range cronFields {
+		var err error
+		bits[i], err = f.parse(parts[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid cron expression %q", expr)
+		}
+	}
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+	return &cronSpec{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: parts[2] == "*",
+		dowStar: parts[4] == "*",
+	}, nil
+}
+
+func (f *cronField) parse(value string) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(value, ",") {
+		rangePart, step := item, 1
+		if idx := strings.Index(item, "/"); idx != -1 {
+			var err error
+			rangePart = item[:idx]
+			step, err = strconv.Atoi(item[idx+1:])
+			if err != nil || step <= 0 {
+				return 0, errors.Errorf("invalid step in %s %q", f.name, item)
+			}
+		}
+		start, end := f.min, f.max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			start, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, errors.Errorf("invalid %s %q", f.name, item)
+			}
+			end = start
+			if len(bounds) == 2 {
+				end, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return 0, errors.Errorf("invalid %s %q", f.name, item)
+				}
+			} else if step > 1 {
+				end = f.max
+			}
+		}
+		if start < f.min || end > f.max || start > end {
+			return 0, errors.Errorf("%s %q out of range %d-%d", f.name, item, f.min, f.max)
+		}
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func (c *cronSpec) matchesDay(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// next returns the first time matching the spec strictly after t, truncated
+// to the minute. A zero time is returned if there's no match in the next five
+// years.
+func (c *cronSpec) next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
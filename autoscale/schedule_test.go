@@ -0,0 +1,171 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package autoscale
+
+import (
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestParseCronNext(c *check.C) {
+	base := time.Date(2017, time.March, 10, 14, 30, 20, 0, time.UTC) // friday
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2017, time.March, 10, 14, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2017, time.March, 10, 14, 45, 0, 0, time.UTC)},
+		{"0 18 * * *", time.Date(2017, time.March, 10, 18, 0, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2017, time.March, 13, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2017, time.March, 12, 9, 0, 0, 0, time.UTC)},
+		{"30 8,20 1 * *", time.Date(2017, time.April, 1, 8, 30, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2017, time.March, 13, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		spec, err := parseCron(tt.expr)
+		c.Assert(err, check.IsNil, check.Commentf("expr: %s", tt.expr))
+		c.Assert(spec.next(base), check.DeepEquals, tt.expected, check.Commentf("expr: %s", tt.expr))
+	}
+}
+
+func (s *S) TestParseCronInvalid(c *check.C) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"* * * *", `invalid cron expression "\* \* \* \*", expected 5 fields`},
+		{"60 * * * *", `invalid cron expression "60 \* \* \* \*": minute "60" out of range 0-59`},
+		{"* * 0 * *", `invalid cron expression "\* \* 0 \* \*": day of month "0" out of range 1-31`},
+		{"*/0 * * * *", `invalid cron expression "\*/0 \* \* \* \*": invalid step in minute "\*/0"`},
+		{"a * * * *", `invalid cron expression "a \* \* \* \*": invalid minute "a"`},
+		{"5-2 * * * *", `invalid cron expression "5-2 \* \* \* \*": minute "5-2" out of range 0-59`},
+	}
+	for _, tt := range tests {
+		_, err := parseCron(tt.expr)
+		c.Assert(err, check.ErrorMatches, tt.err)
+	}
+}
+
+func (s *S) TestScheduleStatus(c *check.C) {
+	schedule := Schedule{Name: "peak", Cron: "0 18 * * *", DurationMinutes: 180}
+	status, err := schedule.Status(time.Date(2017, time.March, 10, 19, 0, 0, 0, time.UTC))
+	c.Assert(err, check.IsNil)
+	c.Assert(status.Active, check.Equals, true)
+	c.Assert(status.ActiveUntil, check.DeepEquals, time.Date(2017, time.March, 10, 21, 0, 0, 0, time.UTC))
+	c.Assert(status.NextStart, check.DeepEquals, time.Date(2017, time.March, 11, 18, 0, 0, 0, time.UTC))
+	status, err = schedule.Status(time.Date(2017, time.March, 10, 21, 0, 0, 0, time.UTC))
+	c.Assert(err, check.IsNil)
+	c.Assert(status.Active, check.Equals, false)
+	schedule.Location = "America/Sao_Paulo"
+	status, err = schedule.Status(time.Date(2017, time.March, 10, 21, 30, 0, 0, time.UTC))
+	c.Assert(err, check.IsNil)
+	c.Assert(status.Active, check.Equals, true)
+}
+
+func (s *S) TestScheduleUpdateInvalid(c *check.C) {
+	tests := []struct {
+		schedule Schedule
+		err      string
+	}{
+		{Schedule{Cron: "* * * * *", DurationMinutes: 1}, "schedule name is required"},
+		{Schedule{Name: "a", Cron: "* * *", DurationMinutes: 1}, `invalid cron expression "\* \* \*", expected 5 fields`},
+		{Schedule{Name: "a", Cron: "* * * * *", DurationMinutes: 1, Location: "Nowhere/City"}, `invalid location "Nowhere/City".*`},
+		{Schedule{Name: "a", Cron: "* * * * *"}, "schedule duration must be greater than 0"},
+		{Schedule{Name: "a", Cron: "* * * * *", DurationMinutes: 1, MinNodes: -1}, "number of nodes can't be negative"},
+		{Schedule{Name: "a", Cron: "* * * * *", DurationMinutes: 1, MinNodes: 3, MaxNodes: 2}, "maximum number of nodes must be greater than or equal to the minimum"},
+	}
+	for _, tt := range tests {
+		err := tt.schedule.Update()
+		c.Assert(err, check.ErrorMatches, tt.err)
+	}
+}
+
+func (s *S) TestScheduleLimitsForPool(c *check.C) {
+	schedules := []Schedule{
+		{Name: "all", Cron: "* * * * *", DurationMinutes: 10, MinNodes: 2, MaxNodes: 10, Enabled: true},
+		{Name: "pool1", MetadataFilter: "pool1", Cron: "* * * * *", DurationMinutes: 10, MinNodes: 4, MaxNodes: 8, Enabled: true},
+		{Name: "pool2", MetadataFilter: "pool2", Cron: "* * * * *", DurationMinutes: 10, MinNodes: 6, Enabled: true},
+		{Name: "disabled", Cron: "* * * * *", DurationMinutes: 10, MinNodes: 9},
+		{Name: "inactive", Cron: "0 0 1 1 *", DurationMinutes: 1, MinNodes: 9, Enabled: true},
+	}
+	for i := range schedules {
+		err := schedules[i].Update()
+		c.Assert(err, check.IsNil)
+	}
+	limits, err := scheduleLimitsForPool("pool1", time.Date(2017, time.March, 10, 19, 0, 0, 0, time.UTC))
+	c.Assert(err, check.IsNil)
+	c.Assert(limits, check.DeepEquals, &ScheduleLimits{MinNodes: 4, MaxNodes: 8, Schedules: []string{"all", "pool1"}})
+	limits, err = scheduleLimitsForPool("pool3", time.Date(2017, time.March, 10, 19, 0, 0, 0, time.UTC))
+	c.Assert(err, check.IsNil)
+	c.Assert(limits, check.DeepEquals, &ScheduleLimits{MinNodes: 2, MaxNodes: 10, Schedules: []string{"all"}})
+	err = DeleteSchedule("all")
+	c.Assert(err, check.IsNil)
+	limits, err = scheduleLimitsForPool("pool3", time.Date(2017, time.March, 10, 19, 0, 0, 0, time.UTC))
+	c.Assert(err, check.IsNil)
+	c.Assert(limits, check.IsNil)
+}
+
+func (s *S) TestAutoScaleConfigRunScheduleMinNodes(c *check.C) {
+	schedule := Schedule{Name: "peak", MetadataFilter: "pool1", Cron: "* * * * *", DurationMinutes: 10, MinNodes: 3, Enabled: true}
+	err := schedule.Update()
+	c.Assert(err, check.IsNil)
+	a := newConfig()
+	err = a.runOnce()
+	c.Assert(err, check.IsNil)
+	nodes, err := s.p.ListNodes(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 3, check.Commentf("log: %s", s.logBuf.String()))
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: provision.PoolMetadataName, Value: "pool1"},
+		Kind:   "autoscale",
+		EndCustomData: map[string]interface{}{
+			"result.toadd":       2,
+			"result.reason":      "schedules peak require at least 3 nodes",
+			"schedule.minnodes":  3,
+			"schedule.schedules": []interface{}{"peak"},
+		},
+		LogMatches: `(?s).*enforcing schedules \[peak\].*`,
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestAutoScaleConfigRunScheduleMaxNodes(c *check.C) {
+	config.Set("docker:auto-scale:max-container-count", 1)
+	for _, addr := range []string{"http://n2:2", "http://n3:3"} {
+		err := s.p.AddNode(provision.AddNodeOptions{
+			Address: addr,
+			Metadata: map[string]string{
+				provision.PoolMetadataName: "pool1",
+				"iaas":                     "my-scale-iaas",
+			},
+		})
+		c.Assert(err, check.IsNil)
+	}
+	_, err := s.p.AddUnitsToNode(s.appInstance, 2, "web", nil, "n1:1")
+	c.Assert(err, check.IsNil)
+	schedule := Schedule{Name: "night", Cron: "* * * * *", DurationMinutes: 10, MaxNodes: 2, Enabled: true}
+	err = schedule.Update()
+	c.Assert(err, check.IsNil)
+	a := newConfig()
+	err = a.runOnce()
+	c.Assert(err, check.IsNil)
+	nodes, err := s.p.ListNodes(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 2)
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: provision.PoolMetadataName, Value: "pool1"},
+		Kind:   "autoscale",
+		EndCustomData: map[string]interface{}{
+			"result.toremove": bson.M{"$size": 1},
+			"result.reason":   "schedules night allow at most 2 nodes",
+		},
+	}, eventtest.HasEvent)
+}
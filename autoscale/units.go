@@ -0,0 +1,219 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package autoscale
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/api/shutdown"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/provision"
+)
+
+const (
+	UnitsEventKind = "app.autoscale"
+
+	defaultCPUQuery      = `avg(rate(container_cpu_usage_seconds_total{tsuru_app_name="{{.App}}",tsuru_process_name="{{.Process}}"}[1m])) * 100`
+	defaultRequestsQuery = `sum(rate(tsuru_router_requests_total{app="{{.App}}",process="{{.Process}}"}[1m]))`
+)
+
+// unitsMetricsSource returns the current value of an auto scale rule metric
+// for an app process.
+type unitsMetricsSource interface {
+	processMetric(appName string, rule *app.AutoScaleRule) (float64, error)
+}
+
+type promUnitsMetrics struct {
+	client  promClient
+	queries map[string]string
+}
+
+func (m *promUnitsMetrics) processMetric(appName string, rule *app.AutoScaleRule) (float64, error) {
+	tpl, ok := m.queries[rule.Metric]
+	if !ok {
+		return 0, errors.Errorf("no query for metric %q", rule.Metric)
+	}
+	query, err := renderQuery(tpl, struct{ App, Process string }{App: appName, Process: rule.Process})
+	if err != nil {
+		return 0, err
+	}
+	return m.client.query(query)
+}
+
+var globalUnitsConfig *UnitsConfig
+
+// UnitsConfig holds the configuration of the app units auto scaler, which
+// periodically evaluates the auto scale rules of every app adding or removing
+// units of each process.
+type UnitsConfig struct {
+	RunInterval time.Duration
+	Enabled     bool
+	metrics     unitsMetricsSource
+	done        chan bool
+}
+
+func InitializeUnits() error {
+	globalUnitsConfig = newUnitsConfig()
+	if !globalUnitsConfig.Enabled {
+		return nil
+	}
+	shutdown.Register(globalUnitsConfig)
+	go globalUnitsConfig.run()
+	return nil
+}
+
+func newUnitsConfig() *UnitsConfig {
+	enabled, _ := config.GetBool("units-auto-scale:enabled")
+	runInterval, _ := config.GetInt("units-auto-scale:run-interval")
+	metricsURL, _ := config.GetString("units-auto-scale:metrics-url")
+	cpuQuery, _ := config.GetString("units-auto-scale:cpu-query")
+	if cpuQuery == "" {
+		cpuQuery = defaultCPUQuery
+	}
+	requestsQuery, _ := config.GetString("units-auto-scale:requests-query")
+	if requestsQuery == "" {
+		requestsQuery = defaultRequestsQuery
+	}
+	c := &UnitsConfig{
+		RunInterval: time.Duration(runInterval) * time.Second,
+		Enabled:     enabled,
+		done:        make(chan bool),
+		metrics: &promUnitsMetrics{
+			client: promClient{url: metricsURL},
+			queries: map[string]string{
+				app.AutoScaleMetricCPU:      cpuQuery,
+				app.AutoScaleMetricRequests: requestsQuery,
+			},
+		},
+	}
+	if c.RunInterval == 0 {
+		c.RunInterval = time.Minute
+	}
+	return c
+}
+
+func (c *UnitsConfig) run() error {
+	for {
+		err := c.runScaler()
+		if err != nil {
+			log.Errorf("[units autoscale] %s", err)
+		}
+		select {
+		case <-c.done:
+			return err
+		case <-time.After(c.RunInterval):
+		}
+	}
+}
+
+func (c *UnitsConfig) Shutdown() {
+	if c.Enabled {
+		c.done <- true
+		c.Enabled = false
+	}
+}
+
+func (c *UnitsConfig) String() string {
+	return "units auto scale"
+}
+
+func (c *UnitsConfig) runScaler() (retErr error) {
+	defer func() {
+		if r := recover(); r != nil {
+			retErr = errors.Errorf("recovered panic, we can never stop! panic: %v", r)
+		}
+	}()
+	apps, err := app.ListAutoScaleApps()
+	if err != nil {
+		return errors.Wrap(err, "error listing apps")
+	}
+	for i := range apps {
+		for j := range apps[i].AutoScale {
+			rule := &apps[i].AutoScale[j]
+			if !rule.Enabled {
+				continue
+			}
+			c.scaleProcess(&apps[i], rule)
+		}
+	}
+	return nil
+}
+
+// UnitsEventCustomData is stored as the end data of units auto scale events.
+type UnitsEventCustomData struct {
+	Rule   app.AutoScaleRule
+	Value  float64
+	Before uint
+	After  uint
+}
+
+func (c *UnitsConfig) scaleProcess(a *app.App, rule *app.AutoScaleRule) {
+	units, err := a.Units()
+	if err != nil {
+		log.Errorf("[units autoscale] unable to list units for app %q: %s", a.Name, err)
+		return
+	}
+	var current, running uint
+	for _, u := range units {
+		if u.ProcessName != rule.Process {
+			continue
+		}
+		current++
+		if u.Status != provision.StatusStopped && u.Status != provision.StatusAsleep {
+			running++
+		}
+	}
+	if running == 0 {
+		log.Debugf("[units autoscale] skipping app %q process %q: no running units", a.Name, rule.Process)
+		return
+	}
+	value, err := c.metrics.processMetric(a.Name, rule)
+	if err != nil {
+		log.Errorf("[units autoscale] unable to get %s metric for app %q process %q: %s", rule.Metric, a.Name, rule.Process, err)
+		return
+	}
+	desired := rule.DesiredUnits(current, value)
+	if desired == current {
+		log.Debugf("[units autoscale] nothing to do for app %q process %q: %d units, %s = %f", a.Name, rule.Process, current, rule.Metric, value)
+		return
+	}
+	evt, err := event.NewInternal(&event.Opts{
+		Target:       event.Target{Type: event.TargetTypeApp, Value: a.Name},
+		InternalKind: UnitsEventKind,
+		Allowed: event.Allowed(permission.PermAppReadEvents, append(permission.Contexts(permission.CtxTeam, a.Teams),
+			permission.Context(permission.CtxApp, a.Name),
+			permission.Context(permission.CtxPool, a.Pool),
+		)...),
+	})
+	if err != nil {
+		if _, ok := err.(event.ErrEventLocked); ok {
+			log.Debugf("[units autoscale] skipping app %q, event already running", a.Name)
+		} else {
+			log.Errorf("[units autoscale] error creating event for app %q: %s", a.Name, err)
+		}
+		return
+	}
+	evt.Logf("%s for process %q is %f (target %f), scaling from %d to %d units", rule.Metric, rule.Process, value, rule.Target, current, desired)
+	if desired > current {
+		err = a.AddUnits(desired-current, rule.Process, evt)
+	} else {
+		err = a.RemoveUnits(current-desired, rule.Process, evt)
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "unable to scale process %q", rule.Process)
+		evt.Logf("%s", err)
+	}
+	evt.DoneCustomData(err, UnitsEventCustomData{
+		Rule:   *rule,
+		Value:  value,
+		Before: current,
+		After:  desired,
+	})
+}
@@ -0,0 +1,183 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package autoscale
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/quota"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type fakeUnitsMetrics struct {
+	values map[string]float64
+	calls  int
+}
+
+func (m *fakeUnitsMetrics) processMetric(appName string, rule *app.AutoScaleRule) (float64, error) {
+	m.calls++
+	return m.values[appName+"/"+rule.Process], nil
+}
+
+func (s *S) setAppAutoScale(c *check.C, rules ...app.AutoScaleRule) *app.App {
+	err := s.conn.Apps().Update(bson.M{"name": s.appInstance.GetName()}, bson.M{"$set": bson.M{
+		"autoscale": rules,
+		"quota":     quota.Unlimited,
+	}})
+	c.Assert(err, check.IsNil)
+	a, err := app.GetByName(s.appInstance.GetName())
+	c.Assert(err, check.IsNil)
+	return a
+}
+
+func (s *S) TestUnitsAutoScaleAddUnits(c *check.C) {
+	a := s.setAppAutoScale(c, app.AutoScaleRule{
+		Process: "web", MinUnits: 1, MaxUnits: 10, Metric: app.AutoScaleMetricCPU, Target: 50, Enabled: true,
+	})
+	err := s.p.AddUnits(a, 2, "web", nil)
+	c.Assert(err, check.IsNil)
+	metrics := &fakeUnitsMetrics{values: map[string]float64{"myapp/web": 100}}
+	conf := newUnitsConfig()
+	conf.metrics = metrics
+	err = conf.runScaler()
+	c.Assert(err, check.IsNil)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 4)
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeApp, Value: "myapp"},
+		Kind:   UnitsEventKind,
+		EndCustomData: map[string]interface{}{
+			"rule.process": "web",
+			"value":        100.0,
+			"before":       2,
+			"after":        4,
+		},
+		LogMatches: `cpu for process "web" is 100.0+ \(target 50.0+\), scaling from 2 to 4 units`,
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestUnitsAutoScaleRemoveUnitsRespectsMinimum(c *check.C) {
+	a := s.setAppAutoScale(c, app.AutoScaleRule{
+		Process: "web", MinUnits: 2, MaxUnits: 10, Metric: app.AutoScaleMetricRequests, Target: 100, Enabled: true,
+	})
+	err := s.p.AddUnits(a, 5, "web", nil)
+	c.Assert(err, check.IsNil)
+	conf := newUnitsConfig()
+	conf.metrics = &fakeUnitsMetrics{values: map[string]float64{"myapp/web": 10}}
+	err = conf.runScaler()
+	c.Assert(err, check.IsNil)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 2)
+}
+
+func (s *S) TestUnitsAutoScaleNothingToDo(c *check.C) {
+	a := s.setAppAutoScale(c, app.AutoScaleRule{
+		Process: "web", MinUnits: 1, MaxUnits: 10, Metric: app.AutoScaleMetricRequests, Target: 100, Enabled: true,
+	})
+	err := s.p.AddUnits(a, 3, "web", nil)
+	c.Assert(err, check.IsNil)
+	conf := newUnitsConfig()
+	conf.metrics = &fakeUnitsMetrics{values: map[string]float64{"myapp/web": 250}}
+	err = conf.runScaler()
+	c.Assert(err, check.IsNil)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 3)
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeApp, Value: "myapp"},
+		Kind:   UnitsEventKind,
+	}, check.Not(eventtest.HasEvent))
+}
+
+func (s *S) TestUnitsAutoScaleIgnoresDisabledRules(c *check.C) {
+	s.setAppAutoScale(c, app.AutoScaleRule{
+		Process: "web", MinUnits: 1, MaxUnits: 10, Metric: app.AutoScaleMetricCPU, Target: 50, Enabled: false,
+	})
+	metrics := &fakeUnitsMetrics{}
+	conf := newUnitsConfig()
+	conf.metrics = metrics
+	err := conf.runScaler()
+	c.Assert(err, check.IsNil)
+	c.Assert(metrics.calls, check.Equals, 0)
+}
+
+func (s *S) TestUnitsAutoScaleSkipsProcessWithoutUnits(c *check.C) {
+	a := s.setAppAutoScale(c, app.AutoScaleRule{
+		Process: "web", MinUnits: 2, MaxUnits: 10, Metric: app.AutoScaleMetricCPU, Target: 50, Enabled: true,
+	})
+	metrics := &fakeUnitsMetrics{values: map[string]float64{"myapp/web": 0}}
+	conf := newUnitsConfig()
+	conf.metrics = metrics
+	err := conf.runScaler()
+	c.Assert(err, check.IsNil)
+	c.Assert(metrics.calls, check.Equals, 0)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 0)
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeApp, Value: "myapp"},
+		Kind:   UnitsEventKind,
+	}, check.Not(eventtest.HasEvent))
+}
+
+func (s *S) TestUnitsAutoScaleSkipsStoppedProcess(c *check.C) {
+	a := s.setAppAutoScale(c, app.AutoScaleRule{
+		Process: "web", MinUnits: 1, MaxUnits: 10, Metric: app.AutoScaleMetricCPU, Target: 50, Enabled: true,
+	})
+	err := s.p.AddUnits(a, 3, "web", nil)
+	c.Assert(err, check.IsNil)
+	err = s.p.Stop(a, "web")
+	c.Assert(err, check.IsNil)
+	metrics := &fakeUnitsMetrics{values: map[string]float64{"myapp/web": 0}}
+	conf := newUnitsConfig()
+	conf.metrics = metrics
+	err = conf.runScaler()
+	c.Assert(err, check.IsNil)
+	c.Assert(metrics.calls, check.Equals, 0)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 3)
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeApp, Value: "myapp"},
+		Kind:   UnitsEventKind,
+	}, check.Not(eventtest.HasEvent))
+}
+
+func (s *S) TestPromUnitsMetrics(c *check.C) {
+	var query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.URL.Path, check.Equals, "/api/v1/query")
+		query = r.URL.Query().Get("query")
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1490000000,"12.5"]},{"metric":{},"value":[1490000000,"7.5"]}]}}`)
+	}))
+	defer srv.Close()
+	m := promUnitsMetrics{
+		client:  promClient{url: srv.URL},
+		queries: map[string]string{app.AutoScaleMetricRequests: `sum(rate(reqs{app="{{.App}}",process="{{.Process}}"}[1m]))`},
+	}
+	value, err := m.processMetric("myapp", &app.AutoScaleRule{Process: "web", Metric: app.AutoScaleMetricRequests})
+	c.Assert(err, check.IsNil)
+	c.Assert(value, check.Equals, 20.0)
+	c.Assert(query, check.Equals, `sum(rate(reqs{app="myapp",process="web"}[1m]))`)
+}
+
+func (s *S) TestPromClientError(c *check.C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"status":"error","error":"parse error"}`)
+	}))
+	defer srv.Close()
+	client := promClient{url: srv.URL}
+	_, err := client.query("invalid{")
+	c.Assert(err, check.ErrorMatches, `invalid metrics response \(status 400\): parse error`)
+}
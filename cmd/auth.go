@@ -43,34 +43,103 @@ func nativeLogin(context *Context, client *Client) error {
 		return err
 	}
 	fmt.Fprintln(context.Stdout)
-	u, err := GetURL("/users/" + email + "/tokens")
+	v := url.Values{}
+	v.Set("password", password)
+	out, err := postLogin(client, "/users/"+email+"/tokens", v)
 	if err != nil {
 		return err
 	}
-	v := url.Values{}
-	v.Set("password", password)
+	if out.Challenge != "" {
+		out, err = twoFactorLogin(context, client, out)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(context.Stdout, "Successfully logged in!")
+	err = writeToken(out.Token)
+	if err != nil {
+		return err
+	}
+	if len(out.RecoveryCodes) > 0 {
+		showRecoveryCodes(context, out.RecoveryCodes)
+	}
+	return nil
+}
+
+type loginResult struct {
+	Token         string   `json:"token"`
+	Challenge     string   `json:"challenge"`
+	EnrollURI     string   `json:"enroll_uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func postLogin(client *Client, path string, v url.Values) (*loginResult, error) {
+	u, err := GetURL(path)
+	if err != nil {
+		return nil, err
+	}
 	b := strings.NewReader(v.Encode())
 	request, err := http.NewRequest("POST", u, b)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	response, err := client.Do(request)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer response.Body.Close()
 	result, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	out := make(map[string]interface{})
+	var out loginResult
 	err = json.Unmarshal(result, &out)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	fmt.Fprintln(context.Stdout, "Successfully logged in!")
-	return writeToken(out["token"].(string))
+	return &out, nil
+}
+
+// twoFactorLogin completes the login of users with two-factor
+// authentication, asking for the code. Users that have not enrolled yet are
+// shown the provisioning URI first.
+func twoFactorLogin(context *Context, client *Client, challenge *loginResult) (*loginResult, error) {
+	if challenge.EnrollURI != "" {
+		fmt.Fprintf(context.Stdout, `Two-factor authentication is required for your account. Add the following
+URI to your authenticator app, or use it to generate a QR code:
+
+%s
+
+`, challenge.EnrollURI)
+	}
+	fmt.Fprint(context.Stdout, "Two-factor authentication code: ")
+	var code string
+	fmt.Fscanf(context.Stdin, "%s\n", &code)
+	if code == "" {
+		return nil, errors.New("You must provide the two-factor authentication code!")
+	}
+	v := url.Values{}
+	v.Set("challenge", challenge.Challenge)
+	v.Set("otp", code)
+	out, err := postLogin(client, "/auth/login", v)
+	if err != nil {
+		return nil, err
+	}
+	if out.Token == "" {
+		return nil, errors.New("unable to complete two-factor authentication")
+	}
+	return out, nil
+}
+
+func showRecoveryCodes(context *Context, codes []string) {
+	fmt.Fprintf(context.Stdout, `
+Two-factor authentication enabled. Store the following recovery codes in a safe
+place, each of them may be used once in place of a code from your
+authenticator app:
+
+	%s
+`, strings.Join(codes, "\n\t"))
 }
 
 func (c *login) getScheme() *loginScheme {
@@ -102,8 +171,9 @@ func (c *login) Info() *Info {
 		Usage: usage,
 		Desc: `Initiates a new tsuru session for a user. If using tsuru native authentication
 scheme, it will ask for the email and the password and check if the user is
-successfully authenticated. If using OAuth, it will open a web browser for the
-user to complete the login.
+successfully authenticated, also asking for a code from the authenticator app
+when two-factor authentication is enabled for the user. If using OAuth, it will
+open a web browser for the user to complete the login.
 
 After that, the token generated by the tsuru server will be stored in
 [[${HOME}/.tsuru/token]].
@@ -83,6 +83,91 @@ func (s *S) TestNativeLoginWithoutEmailFromArg(c *check.C) {
 	c.Assert(token, check.Equals, "sometoken")
 }
 
+func (s *S) TestNativeLoginTwoFactor(c *check.C) {
+	os.Unsetenv("TSURU_TOKEN")
+	nativeScheme()
+	fsystem = &fstest.RecordingFs{FileContent: "old-token"}
+	defer func() {
+		fsystem = nil
+	}()
+	expected := "Password: \nTwo-factor authentication code: Successfully logged in!\n"
+	reader := strings.NewReader("chico\n123456\n")
+	context := Context{[]string{"foo@foo.com"}, globalManager.stdout, globalManager.stderr, reader}
+	transport := cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: `{"challenge": "abc"}`, Status: http.StatusOK},
+				CondFunc: func(r *http.Request) bool {
+					return r.URL.Path == "/1.0/users/foo@foo.com/tokens" && r.FormValue("password") == "chico"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: `{"token": "sometoken"}`, Status: http.StatusOK},
+				CondFunc: func(r *http.Request) bool {
+					return r.URL.Path == "/1.0/auth/login" && r.FormValue("challenge") == "abc" && r.FormValue("otp") == "123456"
+				},
+			},
+		},
+	}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	command := login{}
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(globalManager.stdout.(*bytes.Buffer).String(), check.Equals, expected)
+	token, err := ReadToken()
+	c.Assert(err, check.IsNil)
+	c.Assert(token, check.Equals, "sometoken")
+}
+
+func (s *S) TestNativeLoginTwoFactorEnroll(c *check.C) {
+	os.Unsetenv("TSURU_TOKEN")
+	nativeScheme()
+	fsystem = &fstest.RecordingFs{FileContent: "old-token"}
+	defer func() {
+		fsystem = nil
+	}()
+	reader := strings.NewReader("chico\n123456\n")
+	context := Context{[]string{"foo@foo.com"}, globalManager.stdout, globalManager.stderr, reader}
+	transport := cmdtest.MultiConditionalTransport{
+		ConditionalTransports: []cmdtest.ConditionalTransport{
+			{
+				Transport: cmdtest.Transport{Message: `{"challenge": "abc", "enroll_uri": "otpauth://totp/tsuru:foo@foo.com?secret=X"}`, Status: http.StatusOK},
+				CondFunc: func(r *http.Request) bool {
+					return r.URL.Path == "/1.0/users/foo@foo.com/tokens"
+				},
+			},
+			{
+				Transport: cmdtest.Transport{Message: `{"token": "sometoken", "recovery_codes": ["aaaaa-bbbbb", "ccccc-ddddd"]}`, Status: http.StatusOK},
+				CondFunc: func(r *http.Request) bool {
+					return r.URL.Path == "/1.0/auth/login" && r.FormValue("challenge") == "abc" && r.FormValue("otp") == "123456"
+				},
+			},
+		},
+	}
+	client := NewClient(&http.Client{Transport: &transport}, nil, globalManager)
+	command := login{}
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	output := globalManager.stdout.(*bytes.Buffer).String()
+	c.Assert(output, check.Matches, "(?s).*otpauth://totp/tsuru:foo@foo.com\\?secret=X.*")
+	c.Assert(output, check.Matches, "(?s).*Successfully logged in!.*aaaaa-bbbbb\n\tccccc-ddddd\n$")
+}
+
+func (s *S) TestNativeLoginTwoFactorWithoutCode(c *check.C) {
+	os.Unsetenv("TSURU_TOKEN")
+	nativeScheme()
+	fsystem = &fstest.RecordingFs{FileContent: "old-token"}
+	defer func() {
+		fsystem = nil
+	}()
+	reader := strings.NewReader("chico\n\n")
+	context := Context{[]string{"foo@foo.com"}, globalManager.stdout, globalManager.stderr, reader}
+	client := NewClient(&http.Client{Transport: &cmdtest.Transport{Message: `{"challenge": "abc"}`, Status: http.StatusOK}}, nil, globalManager)
+	command := login{}
+	err := command.Run(&context, client)
+	c.Assert(err, check.ErrorMatches, "You must provide the two-factor authentication code!")
+}
+
 func (s *S) TestNativeLoginShouldNotDependOnTsuruTokenFile(c *check.C) {
 	nativeScheme()
 	rfs := &fstest.RecordingFs{}
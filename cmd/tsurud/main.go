@@ -12,6 +12,8 @@ import (
 	"github.com/google/gops/agent"
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/api"
+	_ "github.com/tsuru/tsuru/app/logstorage/elasticsearch"
+	_ "github.com/tsuru/tsuru/app/logstorage/file"
 	"github.com/tsuru/tsuru/cmd"
 	"github.com/tsuru/tsuru/iaas/dockermachine"
 	"github.com/tsuru/tsuru/provision"
@@ -33,6 +35,8 @@ func buildManager() *cmd.Manager {
 	m.Register(&tsurudCommand{Command: &migrateCmd{}})
 	m.Register(&tsurudCommand{Command: gandalfSyncCmd{}})
 	m.Register(&tsurudCommand{Command: createRootUserCmd{}})
+	m.Register(&tsurudCommand{Command: secretEnvsRotateCmd{}})
+	m.Register(&tsurudCommand{Command: &routerCheckCmd{}})
 	m.Register(&migrationListCmd{})
 	err := registerProvisionersCommands(m)
 	if err != nil {
@@ -68,6 +68,24 @@ func (s *S) TestGandalfSyncCmdIsRegistered(c *check.C) {
 	c.Assert(sync.Command, check.FitsTypeOf, gandalfSyncCmd{})
 }
 
+func (s *S) TestSecretEnvsRotateCmdIsRegistered(c *check.C) {
+	manager := buildManager()
+	cmd, ok := manager.Commands["secret-envs-rotate"]
+	c.Assert(ok, check.Equals, true)
+	rotate, ok := cmd.(*tsurudCommand)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(rotate.Command, check.FitsTypeOf, secretEnvsRotateCmd{})
+}
+
+func (s *S) TestRouterCheckCmdIsRegistered(c *check.C) {
+	manager := buildManager()
+	cmd, ok := manager.Commands["router-check"]
+	c.Assert(ok, check.Equals, true)
+	routerCheck, ok := cmd.(*tsurudCommand)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(routerCheck.Command, check.FitsTypeOf, &routerCheckCmd{})
+}
+
 func (s *S) TestShouldRegisterAllCommandsFromProvisioners(c *check.C) {
 	fp := provisiontest.NewFakeProvisioner()
 	p := CommandableProvisioner{FakeProvisioner: fp}
@@ -0,0 +1,85 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/tsuru/gnuflag"
+	"github.com/tsuru/tsuru/app/routercheck"
+	"github.com/tsuru/tsuru/cmd"
+)
+
+type routerCheckCmd struct {
+	fs      *gnuflag.FlagSet
+	routers []string
+	repair  bool
+}
+
+func (*routerCheckCmd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "router-check",
+		Usage: "router-check [-r/--router name]... [--repair]",
+		Desc: `Compares the backends, routes and cnames of every app with the ones found in
+the routers, reporting missing and extra routes, missing backends, cname
+mismatches and backends of removed apps. With --repair, the routes of apps
+with issues are rebuilt and orphan backends are removed.`,
+	}
+}
+
+func (c *routerCheckCmd) Run(context *cmd.Context, client *cmd.Client) error {
+	reports, err := routercheck.Check(c.routers, c.repair)
+	if err != nil {
+		return err
+	}
+	tbl := cmd.NewTable()
+	tbl.Headers = cmd.Row{"Router", "Backend", "Issue", "Value"}
+	if c.repair {
+		tbl.Headers = append(tbl.Headers, "Fixed?")
+	}
+	issues := 0
+	for _, report := range reports {
+		for _, issue := range report.Issues {
+			row := cmd.Row{report.Router, issue.Backend, issue.Kind, issue.Value}
+			if c.repair {
+				fixed := "yes"
+				if !issue.Fixed {
+					fixed = "no: " + issue.Error
+				}
+				row = append(row, fixed)
+			}
+			tbl.AddRow(row)
+			issues++
+		}
+	}
+	if issues == 0 {
+		fmt.Fprintln(context.Stdout, "No issues found.")
+	} else {
+		fmt.Fprint(context.Stdout, tbl.String())
+	}
+	for _, report := range reports {
+		apps := make([]string, 0, len(report.Errors))
+		for app := range report.Errors {
+			apps = append(apps, app)
+		}
+		sort.Strings(apps)
+		for _, app := range apps {
+			fmt.Fprintf(context.Stderr, "Unable to check app %q in router %q: %s\n", app, report.Router, report.Errors[app])
+		}
+	}
+	return nil
+}
+
+func (c *routerCheckCmd) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("router-check", gnuflag.ExitOnError)
+		routerMsg := "Router to check, may be repeated. All routers are checked by default"
+		c.fs.Var(cmd.StringSliceFlagWrapper{Dst: &c.routers}, "router", routerMsg)
+		c.fs.Var(cmd.StringSliceFlagWrapper{Dst: &c.routers}, "r", routerMsg)
+		c.fs.BoolVar(&c.repair, "repair", false, "Fix the issues found")
+	}
+	return c.fs
+}
@@ -0,0 +1,33 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/cmd"
+)
+
+type secretEnvsRotateCmd struct{}
+
+func (secretEnvsRotateCmd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "secret-envs-rotate",
+		Usage: "secret-envs-rotate",
+		Desc: `Re-encrypts the secret environment variables of all apps using the key in
+secret-envs:key. The previous keys must be listed in secret-envs:old-keys
+until this command finishes.`,
+	}
+}
+
+func (secretEnvsRotateCmd) Run(context *cmd.Context, client *cmd.Client) error {
+	n, err := app.RotateSecretEnvs(context.Stdout)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "%d secret envs rotated.\n", n)
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package lock provides named locks stored in MongoDB, used to run periodic
+// tasks in a single tsuru API instance. Locks are leases: they're held until
+// released or until they expire, so the instance holding a lock must renew it
+// before the expiration.
+package lock
+
+import (
+	"time"
+
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/log"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// owner identifies the running tsuru process as the holder of locks.
+var owner = bson.NewObjectId().Hex()
+
+type lock struct {
+	Name      string `bson:"_id"`
+	Owner     string
+	ExpiresAt time.Time
+}
+
+// Acquire acquires the lock with the given name, holding it for duration. A
+// lock already held by this process is renewed. It returns false when the lock
+// is held by another process.
+func Acquire(name string, duration time.Duration) (bool, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	now := time.Now().UTC()
+	query := bson.M{
+		"_id": name,
+		"$or": []bson.M{{"owner": owner}, {"expiresat": bson.M{"$lt": now}}},
+	}
+	_, err = conn.Locks().Upsert(query, bson.M{"$set": bson.M{"owner": owner, "expiresat": now.Add(duration)}})
+	if mgo.IsDup(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Release releases the lock with the given name, if it's held by this
+// process.
+func Release(name string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Locks().Remove(bson.M{"_id": name, "owner": owner})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// Run calls fn if the lock with the given name is acquired, holding it for
+// duration. fn is not called when the lock is held by another process.
+func Run(name string, duration time.Duration, fn func() error) error {
+	acquired, err := Acquire(name, duration)
+	if err != nil || !acquired {
+		return err
+	}
+	return fn()
+}
+
+// RunPeriodic calls fn every interval, until done receives a value, in the
+// process holding the lock with the given name. The lock is held for twice
+// the interval, so the process running fn renews it in the next run, and is
+// released when done receives a value. Errors are logged prefixed by the lock
+// name.
+func RunPeriodic(name string, interval time.Duration, fn func() error, done <-chan bool) {
+	for {
+		err := Run(name, 2*interval, fn)
+		if err != nil {
+			log.Errorf("[%s] %s", name, err)
+		}
+		select {
+		case <-done:
+			err = Release(name)
+			if err != nil {
+				log.Errorf("[%s] unable to release lock: %s", name, err)
+			}
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lock
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/dbtest"
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct {
+	conn *db.Storage
+}
+
+var _ = check.Suite(&S{})
+
+func (s *S) SetUpSuite(c *check.C) {
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "tsuru_db_lock_tests")
+	var err error
+	s.conn, err = db.Conn()
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) SetUpTest(c *check.C) {
+	err := dbtest.ClearAllCollections(s.conn.Locks().Database)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TearDownSuite(c *check.C) {
+	s.conn.Locks().Database.DropDatabase()
+	s.conn.Close()
+}
+
+func asOwner(name string, fn func()) {
+	original := owner
+	owner = name
+	defer func() { owner = original }()
+	fn()
+}
+
+func (s *S) TestAcquire(c *check.C) {
+	acquired, err := Acquire("mytask", time.Minute)
+	c.Assert(err, check.IsNil)
+	c.Assert(acquired, check.Equals, true)
+	acquired, err = Acquire("mytask", time.Minute)
+	c.Assert(err, check.IsNil)
+	c.Assert(acquired, check.Equals, true)
+	asOwner("other", func() {
+		acquired, err = Acquire("mytask", time.Minute)
+		c.Assert(err, check.IsNil)
+		c.Assert(acquired, check.Equals, false)
+		acquired, err = Acquire("othertask", time.Minute)
+		c.Assert(err, check.IsNil)
+		c.Assert(acquired, check.Equals, true)
+	})
+}
+
+func (s *S) TestAcquireExpired(c *check.C) {
+	acquired, err := Acquire("mytask", -time.Second)
+	c.Assert(err, check.IsNil)
+	c.Assert(acquired, check.Equals, true)
+	asOwner("other", func() {
+		acquired, err = Acquire("mytask", time.Minute)
+		c.Assert(err, check.IsNil)
+		c.Assert(acquired, check.Equals, true)
+	})
+	acquired, err = Acquire("mytask", time.Minute)
+	c.Assert(err, check.IsNil)
+	c.Assert(acquired, check.Equals, false)
+}
+
+func (s *S) TestRelease(c *check.C) {
+	acquired, err := Acquire("mytask", time.Minute)
+	c.Assert(err, check.IsNil)
+	c.Assert(acquired, check.Equals, true)
+	asOwner("other", func() {
+		err = Release("mytask")
+		c.Assert(err, check.IsNil)
+		acquired, err = Acquire("mytask", time.Minute)
+		c.Assert(err, check.IsNil)
+		c.Assert(acquired, check.Equals, false)
+	})
+	err = Release("mytask")
+	c.Assert(err, check.IsNil)
+	err = Release("mytask")
+	c.Assert(err, check.IsNil)
+	asOwner("other", func() {
+		acquired, err = Acquire("mytask", time.Minute)
+		c.Assert(err, check.IsNil)
+		c.Assert(acquired, check.Equals, true)
+	})
+}
+
+func (s *S) TestRun(c *check.C) {
+	var calls int
+	err := Run("mytask", time.Minute, func() error {
+		calls++
+		return nil
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(calls, check.Equals, 1)
+	asOwner("other", func() {
+		err = Run("mytask", time.Minute, func() error {
+			calls++
+			return nil
+		})
+		c.Assert(err, check.IsNil)
+	})
+	c.Assert(calls, check.Equals, 1)
+}
+
+func (s *S) TestRunError(c *check.C) {
+	err := Run("mytask", time.Minute, func() error {
+		return errors.New("my error")
+	})
+	c.Assert(err, check.ErrorMatches, "my error")
+}
+
+func (s *S) TestRunPeriodic(c *check.C) {
+	calls := make(chan bool, 10)
+	done := make(chan bool)
+	finished := make(chan bool)
+	go func() {
+		RunPeriodic("mytask", 10*time.Millisecond, func() error {
+			calls <- true
+			return nil
+		}, done)
+		close(finished)
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-calls:
+		case <-time.After(5 * time.Second):
+			c.Fatal("timeout waiting for periodic call")
+		}
+	}
+	done <- true
+	<-finished
+	n, err := s.conn.Locks().FindId("mytask").Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 0)
+}
+
+func (s *S) TestRunPeriodicLockHeldByOther(c *check.C) {
+	asOwner("other", func() {
+		acquired, err := Acquire("mytask", time.Minute)
+		c.Assert(err, check.IsNil)
+		c.Assert(acquired, check.Equals, true)
+	})
+	var calls int32
+	done := make(chan bool)
+	finished := make(chan bool)
+	go func() {
+		RunPeriodic("mytask", 10*time.Millisecond, func() error {
+			atomic.AddInt32(&calls, 1)
+			return nil
+		}, done)
+		close(finished)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	done <- true
+	<-finished
+	c.Assert(atomic.LoadInt32(&calls), check.Equals, int32(0))
+	n, err := s.conn.Locks().FindId("mytask").Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 1)
+}
@@ -143,10 +143,25 @@ func (s *Storage) Tokens() *storage.Collection {
 	return coll
 }
 
+func (s *Storage) PersonalTokens() *storage.Collection {
+	nameIndex := mgo.Index{Key: []string{"useremail", "name"}, Unique: true}
+	c := s.Collection("personal_tokens")
+	c.EnsureIndex(nameIndex)
+	return c
+}
+
 func (s *Storage) PasswordTokens() *storage.Collection {
 	return s.Collection("password_tokens")
 }
 
+func (s *Storage) TwoFactor() *storage.Collection {
+	return s.Collection("two_factor")
+}
+
+func (s *Storage) TwoFactorChallenges() *storage.Collection {
+	return s.Collection("two_factor_challenges")
+}
+
 func (s *Storage) UserActions() *storage.Collection {
 	return s.Collection("user_actions")
 }
@@ -185,7 +200,13 @@ func (s *LogStorage) Logs(appName string) *storage.Collection {
 		return nil
 	}
 	c := s.Collection("logs_" + appName)
-	c.Create(&logCappedInfo)
+	// Creating an existing collection fails, so the indexes are only ensured
+	// along with the collection.
+	if c.Create(&logCappedInfo) == nil {
+		c.EnsureIndex(mgo.Index{Key: []string{"date"}})
+		c.EnsureIndex(mgo.Index{Key: []string{"source", "date"}})
+		c.EnsureIndex(mgo.Index{Key: []string{"unit", "date"}})
+	}
 	return c
 }
 
@@ -229,9 +250,46 @@ func (s *Storage) Events() *storage.Collection {
 	return c
 }
 
+func (s *Storage) Webhooks() *storage.Collection {
+	return s.Collection("event_webhooks")
+}
+
+func (s *Storage) WebhookDeliveries() *storage.Collection {
+	webhookIndex := mgo.Index{Key: []string{"webhook", "-time"}}
+	c := s.Collection("event_webhook_deliveries")
+	c.EnsureIndex(webhookIndex)
+	return c
+}
+
+func (s *Storage) LogDrains() *storage.Collection {
+	nameIndex := mgo.Index{Key: []string{"app", "pool", "name"}, Unique: true}
+	c := s.Collection("log_drains")
+	c.EnsureIndex(nameIndex)
+	return c
+}
+
 func (s *Storage) InstallHosts() *storage.Collection {
 	nameIndex := mgo.Index{Key: []string{"name"}, Unique: true}
 	c := s.Collection("install_hosts")
 	c.EnsureIndex(nameIndex)
 	return c
 }
+
+func (s *Storage) ACMEAccounts() *storage.Collection {
+	return s.Collection("acme_accounts")
+}
+
+func (s *Storage) ACMECertificates() *storage.Collection {
+	appIndex := mgo.Index{Key: []string{"app"}}
+	c := s.Collection("acme_certificates")
+	c.EnsureIndex(appIndex)
+	return c
+}
+
+func (s *Storage) ACMEChallenges() *storage.Collection {
+	return s.Collection("acme_challenges")
+}
+
+func (s *Storage) Locks() *storage.Collection {
+	return s.Collection("locks")
+}
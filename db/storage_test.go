@@ -192,6 +192,25 @@ func (s *S) TestLogs(c *check.C) {
 	logs := strg.Logs("myapp")
 	logsc := strg.Collection("logs_myapp")
 	c.Assert(logs, check.DeepEquals, logsc)
+	indexes, err := logs.Indexes()
+	c.Assert(err, check.IsNil)
+	var keys [][]string
+	for _, index := range indexes {
+		keys = append(keys, index.Key)
+	}
+	c.Assert(keys, check.DeepEquals, [][]string{{"_id"}, {"date"}, {"source", "date"}, {"unit", "date"}})
+}
+
+func (s *S) TestLogsEnsuresIndexesOnlyOnCreation(c *check.C) {
+	strg, err := LogConn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	err = strg.Collection("logs_otherapp").Create(&logCappedInfo)
+	c.Assert(err, check.IsNil)
+	indexes, err := strg.Logs("otherapp").Indexes()
+	c.Assert(err, check.IsNil)
+	c.Assert(indexes, check.HasLen, 1)
+	c.Assert(indexes[0].Key, check.DeepEquals, []string{"_id"})
 }
 
 func (s *S) TestRoles(c *check.C) {
@@ -211,3 +230,12 @@ func (s *S) TestInstallHosts(c *check.C) {
 	hostsc := strg.Collection("install_hosts")
 	c.Assert(hosts, check.DeepEquals, hostsc)
 }
+
+func (s *S) TestLocks(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	locks := strg.Locks()
+	locksc := strg.Collection("locks")
+	c.Assert(locks, check.DeepEquals, locksc)
+}
@@ -27,6 +27,7 @@ import (
 var (
 	lockUpdateInterval = 30 * time.Second
 	lockExpireTimeout  = 5 * time.Minute
+	logFlushInterval   = time.Second
 	updater            = lockUpdater{
 		addCh:    make(chan *Target),
 		removeCh: make(chan *Target),
@@ -69,6 +70,8 @@ var (
 	TargetTypePlan            = TargetType("plan")
 	TargetTypeNodeContainer   = TargetType("node-container")
 	TargetTypeInstallHost     = TargetType("install-host")
+	TargetTypeWebhook         = TargetType("webhook")
+	TargetTypeRouter          = TargetType("router")
 )
 
 const (
@@ -191,6 +194,10 @@ func GetTargetType(t string) (TargetType, error) {
 		return TargetTypeTeam, nil
 	case "user":
 		return TargetTypeUser, nil
+	case "webhook":
+		return TargetTypeWebhook, nil
+	case "router":
+		return TargetTypeRouter, nil
 	}
 	return TargetType(""), ErrInvalidTargetType
 }
@@ -243,6 +250,11 @@ type Event struct {
 	eventData
 	logBuffer safe.Buffer
 	logWriter io.Writer
+	// logFlushMu guards the fields below and the Running flag, which is read
+	// by log flushes running in other goroutines.
+	logFlushMu      sync.Mutex
+	logFlushPending bool
+	logFlushedLen   int
 }
 
 type Opts struct {
@@ -702,6 +714,7 @@ func newEvt(opts *Opts) (*Event, error) {
 			if !opts.DisableLock {
 				updater.addCh <- &opts.Target
 			}
+			webhooks.dispatch(WebhookPhaseStart, &evt)
 			return &evt, nil
 		}
 		if mgo.IsDup(err) {
@@ -781,13 +794,58 @@ func (e *Event) Logf(format string, params ...interface{}) {
 		fmt.Fprintf(e.logWriter, format, params...)
 	}
 	fmt.Fprintf(&e.logBuffer, format, params...)
+	e.scheduleLogFlush()
 }
 
 func (e *Event) Write(data []byte) (int, error) {
 	if e.logWriter != nil {
 		e.logWriter.Write(data)
 	}
-	return e.logBuffer.Write(data)
+	n, err := e.logBuffer.Write(data)
+	e.scheduleLogFlush()
+	return n, err
+}
+
+// scheduleLogFlush stores the log of running events in the database at most
+// once every logFlushInterval, so it can be followed before the event is
+// done.
+func (e *Event) scheduleLogFlush() {
+	e.logFlushMu.Lock()
+	defer e.logFlushMu.Unlock()
+	if !e.Running || e.UniqueID == "" || e.logFlushPending {
+		return
+	}
+	e.logFlushPending = true
+	id := e.ID
+	time.AfterFunc(logFlushInterval, func() {
+		e.flushLog(id)
+	})
+}
+
+// flushLog stores the log of the event, unless nothing was written since the
+// last flush or the event is already done.
+func (e *Event) flushLog(id eventID) {
+	e.logFlushMu.Lock()
+	e.logFlushPending = false
+	logLen := e.logBuffer.Len()
+	if !e.Running || logLen == e.logFlushedLen {
+		e.logFlushMu.Unlock()
+		return
+	}
+	e.logFlushedLen = logLen
+	e.logFlushMu.Unlock()
+	conn, err := db.Conn()
+	if err != nil {
+		log.Errorf("[events] unable to store log for %s: %s", e, err)
+		return
+	}
+	defer conn.Close()
+	err = conn.Events().Update(bson.M{"_id": id, "running": true}, bson.M{
+		"$set": bson.M{"log": e.logBuffer.String()},
+	})
+	if err != nil && err != mgo.ErrNotFound {
+		log.Errorf("[events] unable to store log for %s: %s", e, err)
+	}
 }
 
 func (e *Event) TryCancel(reason, owner string) error {
@@ -879,7 +937,13 @@ func (e *Event) done(evtErr error, customData interface{}, abort bool) (err erro
 	defer conn.Close()
 	coll := conn.Events()
 	if abort {
-		return coll.RemoveId(e.ID)
+		err = coll.RemoveId(e.ID)
+		if err == nil {
+			e.EndTime = time.Now().UTC()
+			e.setDone()
+			webhooks.dispatch(WebhookPhaseAbort, e)
+		}
+		return err
 	}
 	if evtErr != nil {
 		e.Error = evtErr.Error()
@@ -891,7 +955,7 @@ func (e *Event) done(evtErr error, customData interface{}, abort bool) (err erro
 	if err != nil {
 		return err
 	}
-	e.Running = false
+	e.setDone()
 	e.Log = e.logBuffer.String()
 	var dbEvt Event
 	err = coll.FindId(e.ID).One(&dbEvt.eventData)
@@ -899,11 +963,22 @@ func (e *Event) done(evtErr error, customData interface{}, abort bool) (err erro
 		e.OtherCustomData = dbEvt.OtherCustomData
 	}
 	if len(e.ID.ObjId) != 0 {
-		return coll.UpdateId(e.ID, e.eventData)
+		err = coll.UpdateId(e.ID, e.eventData)
+	} else {
+		defer coll.RemoveId(e.ID)
+		e.ID = eventID{ObjId: e.UniqueID}
+		err = coll.Insert(e.eventData)
 	}
-	defer coll.RemoveId(e.ID)
-	e.ID = eventID{ObjId: e.UniqueID}
-	return coll.Insert(e.eventData)
+	if err == nil {
+		webhooks.dispatch(WebhookPhaseEnd, e)
+	}
+	return err
+}
+
+func (e *Event) setDone() {
+	e.logFlushMu.Lock()
+	e.Running = false
+	e.logFlushMu.Unlock()
 }
 
 type lockUpdater struct {
@@ -0,0 +1,142 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package event
+
+import (
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru/db"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	FeedMessageStart = "start"
+	FeedMessageLog   = "log"
+	FeedMessageEnd   = "end"
+)
+
+// feedClockSkew is subtracted from the time of each poll, so events stored
+// by other tsuru API instances with slightly different clocks aren't missed.
+var feedClockSkew = 5 * time.Second
+
+// FeedMessage is a change in an event followed by a Feed. Start and end
+// messages carry the event, without its log, and log messages carry the new
+// lines written to the event log.
+type FeedMessage struct {
+	Type    string
+	EventID bson.ObjectId
+	Event   *Event `json:",omitempty"`
+	Log     string `json:",omitempty"`
+}
+
+// Feed follows the events matching a filter, reporting events that start or
+// finish and the log lines of running events after it is created.
+type Feed struct {
+	filter  *Filter
+	started time.Time
+	since   time.Time
+	events  map[bson.ObjectId]*feedEntry
+}
+
+type feedEntry struct {
+	running   bool
+	endTime   time.Time
+	logOffset int
+}
+
+func NewFeed(filter *Filter) *Feed {
+	if filter == nil {
+		filter = &Filter{}
+	}
+	now := time.Now().UTC()
+	return &Feed{
+		filter:  filter,
+		started: now,
+		since:   now.Add(-feedClockSkew),
+		events:  map[bson.ObjectId]*feedEntry{},
+	}
+}
+
+// Next returns the messages for the changes since the last call. Events
+// already running when the feed is created are reported as started on the
+// first call.
+func (f *Feed) Next() ([]FeedMessage, error) {
+	query, err := f.filter.toQuery()
+	if err != nil {
+		if err == errInvalidQuery {
+			return nil, nil
+		}
+		return nil, err
+	}
+	now := time.Now().UTC()
+	query = bson.M{"$and": []bson.M{query, {"$or": []bson.M{
+		{"running": true},
+		{"starttime": bson.M{"$gte": f.since}},
+		{"endtime": bson.M{"$gte": f.since}},
+	}}}}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var allData []eventData
+	err = conn.Events().Find(query).Sort("starttime").All(&allData)
+	if err != nil {
+		return nil, err
+	}
+	var msgs []FeedMessage
+	seen := map[bson.ObjectId]bool{}
+	for _, data := range allData {
+		seen[data.UniqueID] = true
+		entry, known := f.events[data.UniqueID]
+		if !known {
+			if !data.Running && data.EndTime.Before(f.started) {
+				f.events[data.UniqueID] = &feedEntry{endTime: data.EndTime}
+				continue
+			}
+			entry = &feedEntry{running: true}
+			f.events[data.UniqueID] = entry
+			msgs = append(msgs, newFeedMessage(FeedMessageStart, data))
+		}
+		if !entry.running {
+			continue
+		}
+		if chunk := newLogLines(data, entry.logOffset); chunk != "" {
+			msgs = append(msgs, FeedMessage{Type: FeedMessageLog, EventID: data.UniqueID, Log: chunk})
+			entry.logOffset += len(chunk)
+		}
+		if !data.Running {
+			entry.running = false
+			entry.endTime = data.EndTime
+			msgs = append(msgs, newFeedMessage(FeedMessageEnd, data))
+		}
+	}
+	f.since = now.Add(-feedClockSkew)
+	for id, entry := range f.events {
+		if !seen[id] && (entry.running || entry.endTime.Before(f.since)) {
+			delete(f.events, id)
+		}
+	}
+	return msgs, nil
+}
+
+func newFeedMessage(msgType string, data eventData) FeedMessage {
+	data.Log = ""
+	return FeedMessage{Type: msgType, EventID: data.UniqueID, Event: &Event{eventData: data}}
+}
+
+// newLogLines returns the log written after offset. While the event is
+// running only complete lines are returned.
+func newLogLines(data eventData, offset int) string {
+	if len(data.Log) <= offset {
+		return ""
+	}
+	chunk := data.Log[offset:]
+	if data.Running {
+		chunk = chunk[:strings.LastIndex(chunk, "\n")+1]
+	}
+	return chunk
+}
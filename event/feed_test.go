@@ -0,0 +1,193 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package event
+
+import (
+	"errors"
+	"time"
+
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func feedMessageTypes(msgs []FeedMessage) []string {
+	types := make([]string, len(msgs))
+	for i, msg := range msgs {
+		types[i] = msg.Type
+	}
+	return types
+}
+
+func (s *S) TestFeedNext(c *check.C) {
+	oldInterval := logFlushInterval
+	logFlushInterval = time.Millisecond
+	defer func() { logFlushInterval = oldInterval }()
+	feed := NewFeed(&Filter{Target: Target{Type: "app", Value: "myapp"}})
+	msgs, err := feed.Next()
+	c.Assert(err, check.IsNil)
+	c.Assert(msgs, check.HasLen, 0)
+	evt, err := New(&Opts{
+		Target:  Target{Type: "app", Value: "myapp"},
+		Kind:    permission.PermAppUpdateEnvSet,
+		Owner:   s.token,
+		Allowed: Allowed(permission.PermAppReadEvents),
+	})
+	c.Assert(err, check.IsNil)
+	other, err := New(&Opts{
+		Target:  Target{Type: "app", Value: "otherapp"},
+		Kind:    permission.PermAppUpdateEnvSet,
+		Owner:   s.token,
+		Allowed: Allowed(permission.PermAppReadEvents),
+	})
+	c.Assert(err, check.IsNil)
+	defer other.Abort()
+	msgs, err = feed.Next()
+	c.Assert(err, check.IsNil)
+	c.Assert(feedMessageTypes(msgs), check.DeepEquals, []string{FeedMessageStart})
+	c.Assert(msgs[0].EventID, check.Equals, evt.UniqueID)
+	c.Assert(msgs[0].Event.Target, check.DeepEquals, evt.Target)
+	c.Assert(msgs[0].Event.Running, check.Equals, true)
+	evt.Logf("line 1")
+	evt.Write([]byte("partial"))
+	timeout := time.After(5 * time.Second)
+	for {
+		msgs, err = feed.Next()
+		c.Assert(err, check.IsNil)
+		if len(msgs) > 0 {
+			break
+		}
+		select {
+		case <-timeout:
+			c.Fatal("timeout waiting for log message")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	c.Assert(msgs, check.DeepEquals, []FeedMessage{{Type: FeedMessageLog, EventID: evt.UniqueID, Log: "line 1\n"}})
+	err = evt.Done(errors.New("my error"))
+	c.Assert(err, check.IsNil)
+	msgs, err = feed.Next()
+	c.Assert(err, check.IsNil)
+	c.Assert(feedMessageTypes(msgs), check.DeepEquals, []string{FeedMessageLog, FeedMessageEnd})
+	c.Assert(msgs[0].Log, check.Equals, "partial")
+	c.Assert(msgs[1].Event.Running, check.Equals, false)
+	c.Assert(msgs[1].Event.Error, check.Equals, "my error")
+	c.Assert(msgs[1].Event.Log, check.Equals, "")
+	msgs, err = feed.Next()
+	c.Assert(err, check.IsNil)
+	c.Assert(msgs, check.HasLen, 0)
+}
+
+func (s *S) TestFeedNextFinishedBeforeCreation(c *check.C) {
+	evt, err := New(&Opts{
+		Target:  Target{Type: "app", Value: "myapp"},
+		Kind:    permission.PermAppUpdateEnvSet,
+		Owner:   s.token,
+		Allowed: Allowed(permission.PermAppReadEvents),
+	})
+	c.Assert(err, check.IsNil)
+	err = evt.Done(nil)
+	c.Assert(err, check.IsNil)
+	running, err := New(&Opts{
+		Target:  Target{Type: "app", Value: "otherapp"},
+		Kind:    permission.PermAppUpdateEnvSet,
+		Owner:   s.token,
+		Allowed: Allowed(permission.PermAppReadEvents),
+	})
+	c.Assert(err, check.IsNil)
+	defer running.Abort()
+	feed := NewFeed(nil)
+	msgs, err := feed.Next()
+	c.Assert(err, check.IsNil)
+	c.Assert(feedMessageTypes(msgs), check.DeepEquals, []string{FeedMessageStart})
+	c.Assert(msgs[0].EventID, check.Equals, running.UniqueID)
+}
+
+func (s *S) TestFeedNextPermissions(c *check.C) {
+	feed := NewFeed(&Filter{Permissions: []permission.Permission{
+		{Scheme: permission.PermAppReadEvents, Context: permission.Context(permission.CtxTeam, "myteam")},
+	}})
+	allowed, err := New(&Opts{
+		Target:  Target{Type: "app", Value: "myapp"},
+		Kind:    permission.PermAppUpdateEnvSet,
+		Owner:   s.token,
+		Allowed: Allowed(permission.PermAppReadEvents, permission.Context(permission.CtxTeam, "myteam")),
+	})
+	c.Assert(err, check.IsNil)
+	defer allowed.Abort()
+	notAllowed, err := New(&Opts{
+		Target:  Target{Type: "app", Value: "otherapp"},
+		Kind:    permission.PermAppUpdateEnvSet,
+		Owner:   s.token,
+		Allowed: Allowed(permission.PermAppReadEvents, permission.Context(permission.CtxTeam, "otherteam")),
+	})
+	c.Assert(err, check.IsNil)
+	defer notAllowed.Abort()
+	msgs, err := feed.Next()
+	c.Assert(err, check.IsNil)
+	c.Assert(msgs, check.HasLen, 1)
+	c.Assert(msgs[0].EventID, check.Equals, allowed.UniqueID)
+}
+
+func (s *S) TestEventLogFlushedWhileRunning(c *check.C) {
+	oldInterval := logFlushInterval
+	logFlushInterval = time.Millisecond
+	defer func() { logFlushInterval = oldInterval }()
+	evt, err := New(&Opts{
+		Target:  Target{Type: "app", Value: "myapp"},
+		Kind:    permission.PermAppUpdateEnvSet,
+		Owner:   s.token,
+		Allowed: Allowed(permission.PermAppReadEvents),
+	})
+	c.Assert(err, check.IsNil)
+	defer evt.Abort()
+	evt.Logf("%s %d", "hey", 42)
+	timeout := time.After(5 * time.Second)
+	for {
+		dbEvt, err := GetByID(evt.UniqueID)
+		c.Assert(err, check.IsNil)
+		if dbEvt.Log != "" {
+			c.Assert(dbEvt.Log, check.Equals, "hey 42\n")
+			c.Assert(dbEvt.Running, check.Equals, true)
+			break
+		}
+		select {
+		case <-timeout:
+			c.Fatal("timeout waiting for log to be stored")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (s *S) TestEventLogFlushOnlyWhenChanged(c *check.C) {
+	evt, err := New(&Opts{
+		Target:  Target{Type: "app", Value: "myapp"},
+		Kind:    permission.PermAppUpdateEnvSet,
+		Owner:   s.token,
+		Allowed: Allowed(permission.PermAppReadEvents),
+	})
+	c.Assert(err, check.IsNil)
+	defer evt.Abort()
+	evt.logBuffer.WriteString("hey\n")
+	evt.flushLog(evt.ID)
+	dbEvt, err := GetByID(evt.UniqueID)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbEvt.Log, check.Equals, "hey\n")
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Events().UpdateId(evt.ID, bson.M{"$set": bson.M{"log": "changed"}})
+	c.Assert(err, check.IsNil)
+	evt.flushLog(evt.ID)
+	dbEvt, err = GetByID(evt.UniqueID)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbEvt.Log, check.Equals, "changed")
+	evt.logBuffer.WriteString("ho\n")
+	evt.flushLog(evt.ID)
+	dbEvt, err = GetByID(evt.UniqueID)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbEvt.Log, check.Equals, "hey\nho\n")
+}
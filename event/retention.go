@@ -0,0 +1,244 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package event
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/api/shutdown"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/lock"
+	"github.com/tsuru/tsuru/log"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	pruneBatchSize = 1000
+	pruneLockName  = "events-pruner"
+)
+
+// Pruner periodically removes finished events older than the retention
+// configured for their kind. When ExportDir is set, pruned events are written
+// to gzipped JSON files in it before being removed. Only the tsurud instance
+// holding the events-pruner lock prunes events.
+type Pruner struct {
+	RunInterval time.Duration
+	Default     time.Duration
+	Kinds       map[string]time.Duration
+	ExportDir   string
+	Enabled     bool
+	done        chan bool
+}
+
+func InitializePruner() error {
+	p, err := newPruner()
+	if err != nil {
+		return err
+	}
+	if !p.Enabled {
+		return nil
+	}
+	shutdown.Register(p)
+	go p.run()
+	return nil
+}
+
+func newPruner() (*Pruner, error) {
+	enabled, _ := config.GetBool("events:retention:enabled")
+	runInterval, _ := config.GetInt("events:retention:run-interval")
+	defaultDays, _ := config.GetInt("events:retention:default")
+	exportDir, _ := config.GetString("events:retention:export-dir")
+	p := &Pruner{
+		RunInterval: time.Duration(runInterval) * time.Second,
+		Default:     days(defaultDays),
+		Kinds:       map[string]time.Duration{},
+		ExportDir:   exportDir,
+		Enabled:     enabled,
+		done:        make(chan bool),
+	}
+	if p.RunInterval == 0 {
+		p.RunInterval = time.Hour
+	}
+	rawKinds, err := config.Get("events:retention:kinds")
+	if err != nil {
+		return p, nil
+	}
+	kinds, ok := rawKinds.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("events:retention:kinds must be a map of event kinds to days")
+	}
+	for k, v := range kinds {
+		value, err := strconv.Atoi(fmt.Sprint(v))
+		if err != nil || value < 0 {
+			return nil, errors.Errorf("invalid retention for event kind %v: %v", k, v)
+		}
+		p.Kinds[fmt.Sprint(k)] = days(value)
+	}
+	return p, nil
+}
+
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
+
+func (p *Pruner) run() {
+	lock.RunPeriodic(pruneLockName, p.RunInterval, p.runOnce, p.done)
+}
+
+func (p *Pruner) runOnce() error {
+	n, err := p.prune(time.Now().UTC())
+	if n > 0 {
+		log.Debugf("[events] [pruner] %d events removed", n)
+	}
+	return err
+}
+
+func (p *Pruner) Shutdown() {
+	if p.Enabled {
+		p.done <- true
+		p.Enabled = false
+	}
+}
+
+func (p *Pruner) String() string {
+	return "events pruner"
+}
+
+// retentionFor returns the retention for events of the given kind. Kinds are
+// matched exactly or by their dotted prefix, with the longest configured kind
+// winning, so "app" applies to "app.deploy" unless "app.deploy" is also set.
+// A zero retention means events are kept forever.
+func (p *Pruner) retentionFor(kind string) time.Duration {
+	retention := p.Default
+	matched := ""
+	for k, r := range p.Kinds {
+		if (k == kind || strings.HasPrefix(kind, k+".")) && len(k) > len(matched) {
+			matched = k
+			retention = r
+		}
+	}
+	return retention
+}
+
+func (p *Pruner) minRetention() time.Duration {
+	min := p.Default
+	for _, r := range p.Kinds {
+		if r > 0 && (min == 0 || r < min) {
+			min = r
+		}
+	}
+	return min
+}
+
+// prune removes the finished events whose retention expired, returning the
+// number of removed events.
+func (p *Pruner) prune(now time.Time) (int, error) {
+	minRetention := p.minRetention()
+	if minRetention == 0 {
+		return 0, nil
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	coll := conn.Events()
+	iter := coll.Find(bson.M{
+		"running": false,
+		"endtime": bson.M{"$lt": now.Add(-minRetention)},
+	}).Select(bson.M{"uniqueid": 1, "kind": 1, "endtime": 1}).Iter()
+	var ids []bson.ObjectId
+	var data eventData
+	for iter.Next(&data) {
+		retention := p.retentionFor(data.Kind.Name)
+		if retention > 0 && data.EndTime.Before(now.Add(-retention)) {
+			ids = append(ids, data.UniqueID)
+		}
+	}
+	err = iter.Close()
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for i := 0; i < len(ids); i += pruneBatchSize {
+		end := i + pruneBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		query := bson.M{"uniqueid": bson.M{"$in": ids[i:end]}}
+		if p.ExportDir != "" {
+			err = p.export(query, now, i/pruneBatchSize)
+			if err != nil {
+				return removed, errors.Wrap(err, "unable to export events")
+			}
+		}
+		info, err := coll.RemoveAll(query)
+		if err != nil {
+			return removed, err
+		}
+		removed += info.Removed
+	}
+	return removed, nil
+}
+
+// export writes the events matching query to a gzipped file in ExportDir,
+// with one JSON encoded event per line.
+func (p *Pruner) export(query bson.M, now time.Time, batch int) (err error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var allData []eventData
+	err = conn.Events().Find(query).Sort("starttime").All(&allData)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(p.ExportDir, 0755)
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(p.ExportDir, fmt.Sprintf("events-%s-%d.json.gz", now.Format("20060102T150405Z"), batch))
+	file, err := os.Create(name + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(name + ".tmp")
+		}
+	}()
+	gz := gzip.NewWriter(file)
+	encoder := json.NewEncoder(gz)
+	for i := range allData {
+		err = encoder.Encode(&Event{eventData: allData[i]})
+		if err != nil {
+			return err
+		}
+	}
+	err = gz.Close()
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if err != nil {
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
@@ -0,0 +1,217 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package event
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) newFinishedEvent(c *check.C, kind *permission.PermissionScheme, internalKind string, endTime time.Time) *Event {
+	opts := &Opts{
+		Target:  Target{Type: "app", Value: "myapp"},
+		Allowed: Allowed(permission.PermAppReadEvents),
+	}
+	if internalKind != "" {
+		opts.InternalKind = internalKind
+		evt, err := NewInternal(opts)
+		c.Assert(err, check.IsNil)
+		return s.finishEvent(c, evt, endTime)
+	}
+	opts.Kind = kind
+	opts.Owner = s.token
+	evt, err := New(opts)
+	c.Assert(err, check.IsNil)
+	return s.finishEvent(c, evt, endTime)
+}
+
+func (s *S) finishEvent(c *check.C, evt *Event, endTime time.Time) *Event {
+	err := evt.Done(nil)
+	c.Assert(err, check.IsNil)
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Events().Update(bson.M{"uniqueid": evt.UniqueID}, bson.M{"$set": bson.M{"endtime": endTime}})
+	c.Assert(err, check.IsNil)
+	return evt
+}
+
+func (s *S) TestNewPruner(c *check.C) {
+	config.Set("events:retention:enabled", true)
+	config.Set("events:retention:default", 90)
+	config.Set("events:retention:kinds", map[interface{}]interface{}{"app.deploy": 365, "healer": "30"})
+	config.Set("events:retention:export-dir", "/var/lib/tsuru/events")
+	defer config.Unset("events:retention")
+	p, err := newPruner()
+	c.Assert(err, check.IsNil)
+	c.Assert(p.Enabled, check.Equals, true)
+	c.Assert(p.RunInterval, check.Equals, time.Hour)
+	c.Assert(p.Default, check.Equals, 90*24*time.Hour)
+	c.Assert(p.ExportDir, check.Equals, "/var/lib/tsuru/events")
+	c.Assert(p.Kinds, check.DeepEquals, map[string]time.Duration{
+		"app.deploy": 365 * 24 * time.Hour,
+		"healer":     30 * 24 * time.Hour,
+	})
+	config.Set("events:retention:kinds", map[interface{}]interface{}{"healer": "a month"})
+	_, err = newPruner()
+	c.Assert(err, check.ErrorMatches, `invalid retention for event kind healer: a month`)
+}
+
+func (s *S) TestPrunerRetentionFor(c *check.C) {
+	p := Pruner{
+		Default: days(90),
+		Kinds: map[string]time.Duration{
+			"app":        days(10),
+			"app.deploy": days(365),
+			"healer":     0,
+		},
+	}
+	c.Assert(p.retentionFor("app.deploy"), check.Equals, days(365))
+	c.Assert(p.retentionFor("app.update.env.set"), check.Equals, days(10))
+	c.Assert(p.retentionFor("application"), check.Equals, days(90))
+	c.Assert(p.retentionFor("healer"), check.Equals, time.Duration(0))
+	c.Assert(p.minRetention(), check.Equals, days(10))
+}
+
+func (s *S) TestPrunerPrune(c *check.C) {
+	now := time.Now().UTC()
+	oldDeploy := s.newFinishedEvent(c, permission.PermAppDeploy, "", now.Add(-days(400)))
+	recentDeploy := s.newFinishedEvent(c, permission.PermAppDeploy, "", now.Add(-days(100)))
+	oldHealer := s.newFinishedEvent(c, nil, "healer", now.Add(-days(31)))
+	recentHealer := s.newFinishedEvent(c, nil, "healer", now.Add(-days(29)))
+	oldOther := s.newFinishedEvent(c, permission.PermAppUpdateEnvSet, "", now.Add(-days(1000)))
+	running, err := New(&Opts{
+		Target:  Target{Type: "app", Value: "otherapp"},
+		Kind:    permission.PermAppDeploy,
+		Owner:   s.token,
+		Allowed: Allowed(permission.PermAppReadEvents),
+	})
+	c.Assert(err, check.IsNil)
+	defer running.Abort()
+	p := Pruner{Kinds: map[string]time.Duration{"app.deploy": days(365), "healer": days(30)}}
+	n, err := p.prune(now)
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 2)
+	_, err = GetByID(oldDeploy.UniqueID)
+	c.Assert(err, check.Equals, ErrEventNotFound)
+	_, err = GetByID(oldHealer.UniqueID)
+	c.Assert(err, check.Equals, ErrEventNotFound)
+	for _, evt := range []*Event{recentDeploy, recentHealer, oldOther, running} {
+		_, err = GetByID(evt.UniqueID)
+		c.Assert(err, check.IsNil)
+	}
+}
+
+func (s *S) TestPrunerPruneNoRetention(c *check.C) {
+	now := time.Now().UTC()
+	evt := s.newFinishedEvent(c, permission.PermAppDeploy, "", now.Add(-days(1000)))
+	p := Pruner{}
+	n, err := p.prune(now)
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 0)
+	_, err = GetByID(evt.UniqueID)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestPrunerPruneExport(c *check.C) {
+	now := time.Date(2017, time.March, 10, 14, 30, 0, 0, time.UTC)
+	evt1 := s.newFinishedEvent(c, permission.PermAppDeploy, "", now.Add(-days(40)))
+	evt2 := s.newFinishedEvent(c, nil, "healer", now.Add(-days(35)))
+	dir := filepath.Join(c.MkDir(), "archive")
+	p := Pruner{Default: days(30), ExportDir: dir}
+	n, err := p.prune(now)
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 2)
+	file, err := os.Open(filepath.Join(dir, "events-20170310T143000Z-0.json.gz"))
+	c.Assert(err, check.IsNil)
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	c.Assert(err, check.IsNil)
+	scanner := bufio.NewScanner(gz)
+	var ids []string
+	for scanner.Scan() {
+		var exported struct {
+			UniqueID string
+			Kind     Kind
+		}
+		err = json.Unmarshal(scanner.Bytes(), &exported)
+		c.Assert(err, check.IsNil)
+		ids = append(ids, exported.UniqueID)
+	}
+	c.Assert(scanner.Err(), check.IsNil)
+	c.Assert(ids, check.DeepEquals, []string{evt1.UniqueID.Hex(), evt2.UniqueID.Hex()})
+	entries, err := filepath.Glob(filepath.Join(dir, "*"))
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.HasLen, 1)
+}
+
+func (s *S) TestPrunerPruneExportFailureKeepsEvents(c *check.C) {
+	now := time.Now().UTC()
+	evt := s.newFinishedEvent(c, permission.PermAppDeploy, "", now.Add(-days(40)))
+	dir := c.MkDir()
+	notDir := filepath.Join(dir, "file")
+	f, err := os.Create(notDir)
+	c.Assert(err, check.IsNil)
+	f.Close()
+	p := Pruner{Default: days(30), ExportDir: notDir}
+	_, err = p.prune(now)
+	c.Assert(err, check.ErrorMatches, `unable to export events: .*`)
+	_, err = GetByID(evt.UniqueID)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestPrunerRun(c *check.C) {
+	evt := s.newFinishedEvent(c, permission.PermAppDeploy, "", time.Now().UTC().Add(-days(40)))
+	p := Pruner{RunInterval: time.Hour, Default: days(30), done: make(chan bool)}
+	finished := make(chan bool)
+	go func() {
+		p.run()
+		close(finished)
+	}()
+	timeout := time.After(5 * time.Second)
+	for {
+		_, err := GetByID(evt.UniqueID)
+		if err == ErrEventNotFound {
+			break
+		}
+		select {
+		case <-timeout:
+			c.Fatal("timeout waiting for event to be pruned")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	p.done <- true
+	<-finished
+}
+
+func (s *S) TestPrunerRunLockHeldByOtherInstance(c *check.C) {
+	evt := s.newFinishedEvent(c, permission.PermAppDeploy, "", time.Now().UTC().Add(-days(40)))
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.Locks().Insert(bson.M{"_id": pruneLockName, "owner": "other", "expiresat": time.Now().UTC().Add(time.Hour)})
+	c.Assert(err, check.IsNil)
+	p := Pruner{RunInterval: 10 * time.Millisecond, Default: days(30), done: make(chan bool)}
+	finished := make(chan bool)
+	go func() {
+		p.run()
+		close(finished)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	p.done <- true
+	<-finished
+	_, err = GetByID(evt.UniqueID)
+	c.Assert(err, check.IsNil)
+}
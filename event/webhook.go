@@ -0,0 +1,403 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package event
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	WebhookPhaseStart = "start"
+	WebhookPhaseEnd   = "end"
+	// WebhookPhaseAbort is delivered when a started event is discarded,
+	// instead of the end phase.
+	WebhookPhaseAbort = "abort"
+
+	WebhookSignatureHeader = "X-Tsuru-Signature"
+	WebhookPhaseHeader     = "X-Tsuru-Event-Phase"
+	WebhookDeliveryHeader  = "X-Tsuru-Delivery"
+
+	webhookQueueSize         = 1000
+	defaultWebhookMaxRetries = 3
+	defaultWebhookTimeout    = 10 * time.Second
+)
+
+var (
+	ErrWebhookNotFound      = errors.New("webhook not found")
+	ErrWebhookAlreadyExists = errors.New("webhook already exists")
+
+	webhookRetryInterval = 5 * time.Second
+	webhooks             = webhookDispatcher{
+		ch:   make(chan webhookMessage, webhookQueueSize),
+		once: &sync.Once{},
+	}
+)
+
+// Webhook is an HTTP endpoint notified, with a signed POST request, every time
+// an event matching its filter starts, finishes or is aborted. Only events
+// that Owner, the user who last created or updated the webhook, is allowed to
+// read are delivered.
+type Webhook struct {
+	Name      string `bson:"_id"`
+	TeamOwner string
+	Owner     string
+	URL       string
+	Secret    string `json:"-"`
+	Filter    WebhookFilter
+}
+
+// WebhookFilter selects the events delivered to a webhook. Empty fields match
+// any value. Webhooks with SuccessOnly or ErrorOnly set are only notified when
+// events finish, never when they are aborted.
+type WebhookFilter struct {
+	TargetTypes  []string
+	TargetValues []string
+	KindNames    []string
+	SuccessOnly  bool
+	ErrorOnly    bool
+}
+
+// WebhookDelivery records the result of notifying a webhook about an event.
+type WebhookDelivery struct {
+	ID         bson.ObjectId `bson:"_id"`
+	Webhook    string
+	EventID    bson.ObjectId
+	Phase      string
+	URL        string
+	Time       time.Time
+	Duration   time.Duration
+	Attempts   int
+	StatusCode int
+	Successful bool
+	Error      string
+}
+
+type webhookPayload struct {
+	Phase string
+	Event *eventData
+}
+
+type webhookMessage struct {
+	phase string
+	evt   eventData
+}
+
+func (w *Webhook) validate() error {
+	if w.Name == "" {
+		return ErrValidation("webhook name is mandatory")
+	}
+	if w.TeamOwner == "" {
+		return ErrValidation("webhook team owner is mandatory")
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrValidation("webhook url must be a valid http or https url")
+	}
+	if w.Filter.SuccessOnly && w.Filter.ErrorOnly {
+		return ErrValidation("webhook filter can't be both success only and error only")
+	}
+	return nil
+}
+
+func (w *Webhook) matches(phase string, evt *eventData) bool {
+	f := &w.Filter
+	if (f.SuccessOnly || f.ErrorOnly) && phase != WebhookPhaseEnd {
+		return false
+	}
+	if (f.SuccessOnly && evt.Error != "") || (f.ErrorOnly && evt.Error == "") {
+		return false
+	}
+	return matchesAny(f.TargetTypes, string(evt.Target.Type)) &&
+		matchesAny(f.TargetValues, evt.Target.Value) &&
+		matchesAny(f.KindNames, evt.Kind.Name)
+}
+
+// ownerCanRead returns whether the webhook owner has the permission required
+// to read the event, in one of the contexts the event is allowed. perms
+// caches the permissions of webhook owners by email.
+func (w *Webhook) ownerCanRead(evt *eventData, perms map[string][]permission.Permission) bool {
+	scheme, err := permission.SafeGet(evt.Allowed.Scheme)
+	if err != nil {
+		return false
+	}
+	ownerPerms, ok := perms[w.Owner]
+	if !ok {
+		ownerPerms, err = userPermissions(w.Owner)
+		if err != nil {
+			log.Errorf("[events] [webhooks] unable to get permissions for %q, owner of %q: %s", w.Owner, w.Name, err)
+		}
+		perms[w.Owner] = ownerPerms
+	}
+	return permission.CheckFromPermList(ownerPerms, scheme, evt.Allowed.Contexts...)
+}
+
+func userPermissions(email string) ([]permission.Permission, error) {
+	user, err := auth.GetUserByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	return user.Permissions()
+}
+
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookSignature returns the hex encoded HMAC-SHA256 of the body using the
+// webhook secret, sent in the X-Tsuru-Signature header prefixed by "sha256=".
+func WebhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func CreateWebhook(w *Webhook) error {
+	err := w.validate()
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Webhooks().Insert(w)
+	if mgo.IsDup(err) {
+		return ErrWebhookAlreadyExists
+	}
+	return err
+}
+
+func UpdateWebhook(w *Webhook) error {
+	err := w.validate()
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Webhooks().UpdateId(w.Name, w)
+	if err == mgo.ErrNotFound {
+		return ErrWebhookNotFound
+	}
+	return err
+}
+
+func GetWebhook(name string) (*Webhook, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var w Webhook
+	err = conn.Webhooks().FindId(name).One(&w)
+	if err == mgo.ErrNotFound {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// ListWebhooks returns the webhooks owned by the given teams, a nil teams
+// slice returns every webhook.
+func ListWebhooks(teams []string) ([]Webhook, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var query bson.M
+	if teams != nil {
+		query = bson.M{"teamowner": bson.M{"$in": teams}}
+	}
+	var hooks []Webhook
+	err = conn.Webhooks().Find(query).Sort("_id").All(&hooks)
+	if err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+func DeleteWebhook(name string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Webhooks().RemoveId(name)
+	if err == mgo.ErrNotFound {
+		return ErrWebhookNotFound
+	}
+	if err != nil {
+		return err
+	}
+	_, err = conn.WebhookDeliveries().RemoveAll(bson.M{"webhook": name})
+	return err
+}
+
+// ListWebhookDeliveries returns the latest deliveries for the webhook, newest
+// first.
+func ListWebhookDeliveries(name string, limit int) ([]WebhookDelivery, error) {
+	if limit <= 0 || limit > filterMaxLimit {
+		limit = filterMaxLimit
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var deliveries []WebhookDelivery
+	err = conn.WebhookDeliveries().Find(bson.M{"webhook": name}).Sort("-time").Limit(limit).All(&deliveries)
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+type webhookDispatcher struct {
+	ch   chan webhookMessage
+	once *sync.Once
+}
+
+func (d *webhookDispatcher) start() {
+	d.once.Do(func() {
+		go d.spin()
+	})
+}
+
+func (d *webhookDispatcher) dispatch(phase string, evt *Event) {
+	d.start()
+	select {
+	case d.ch <- webhookMessage{phase: phase, evt: evt.eventData}:
+	default:
+		log.Errorf("[events] [webhooks] queue full, dropping %s notification for %s", phase, evt)
+	}
+}
+
+func (d *webhookDispatcher) spin() {
+	for msg := range d.ch {
+		hooks, err := ListWebhooks(nil)
+		if err != nil {
+			log.Errorf("[events] [webhooks] unable to list webhooks: %s", err)
+			continue
+		}
+		perms := map[string][]permission.Permission{}
+		for i := range hooks {
+			if hooks[i].matches(msg.phase, &msg.evt) && hooks[i].ownerCanRead(&msg.evt, perms) {
+				go hooks[i].deliver(msg.phase, msg.evt)
+			}
+		}
+	}
+}
+
+func (w *Webhook) deliver(phase string, evt eventData) {
+	delivery := WebhookDelivery{
+		ID:      bson.NewObjectId(),
+		Webhook: w.Name,
+		EventID: evt.UniqueID,
+		Phase:   phase,
+		URL:     w.URL,
+		Time:    time.Now().UTC(),
+	}
+	body, err := json.Marshal(webhookPayload{Phase: phase, Event: &evt})
+	if err != nil {
+		delivery.Error = err.Error()
+		w.saveDelivery(&delivery)
+		return
+	}
+	maxRetries := defaultWebhookMaxRetries
+	if _, cfgErr := config.Get("events:webhooks:max-retries"); cfgErr == nil {
+		maxRetries, _ = config.GetInt("events:webhooks:max-retries")
+	}
+	timeout := defaultWebhookTimeout
+	if seconds, _ := config.GetInt("events:webhooks:timeout"); seconds > 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+	client := &http.Client{Timeout: timeout}
+	interval := webhookRetryInterval
+	for {
+		delivery.Attempts++
+		delivery.StatusCode, err = w.post(client, delivery.ID, phase, body)
+		if err == nil || delivery.Attempts > maxRetries {
+			break
+		}
+		time.Sleep(interval)
+		interval *= 2
+	}
+	delivery.Duration = time.Since(delivery.Time)
+	delivery.Successful = err == nil
+	if err != nil {
+		delivery.Error = err.Error()
+		log.Errorf("[events] [webhooks] unable to deliver %s notification for event %s to %q: %s", phase, evt.UniqueID.Hex(), w.Name, err)
+	}
+	w.saveDelivery(&delivery)
+}
+
+func (w *Webhook) post(client *http.Client, id bson.ObjectId, phase string, body []byte) (int, error) {
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookPhaseHeader, phase)
+	req.Header.Set(WebhookDeliveryHeader, id.Hex())
+	if w.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, "sha256="+WebhookSignature(w.Secret, body))
+	}
+	rsp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer rsp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(rsp.Body, 64*1024))
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return rsp.StatusCode, errors.Errorf("invalid status code %d", rsp.StatusCode)
+	}
+	return rsp.StatusCode, nil
+}
+
+func (w *Webhook) saveDelivery(delivery *WebhookDelivery) {
+	conn, err := db.Conn()
+	if err != nil {
+		log.Errorf("[events] [webhooks] unable to save delivery: %s", err)
+		return
+	}
+	defer conn.Close()
+	err = conn.WebhookDeliveries().Insert(delivery)
+	if err != nil {
+		log.Errorf("[events] [webhooks] unable to save delivery: %s", err)
+	}
+}
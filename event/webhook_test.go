@@ -0,0 +1,437 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package event
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func webhookServer(statuses ...int) (*httptest.Server, chan webhookRequest) {
+	ch := make(chan webhookRequest, 10)
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		n := int(atomic.AddInt32(&calls, 1))
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+		}
+		ch <- webhookRequest{header: r.Header, body: body}
+	}))
+	return srv, ch
+}
+
+// webhookOwner creates a user with a role granting perms in the given
+// context, returning the user email.
+func webhookOwner(c *check.C, email, ctxType, ctxValue string, perms ...string) string {
+	role, err := permission.NewRole("role-"+email, ctxType, "")
+	c.Assert(err, check.IsNil)
+	err = role.AddPermissions(perms...)
+	c.Assert(err, check.IsNil)
+	user := &auth.User{Email: email, Password: "123456"}
+	err = user.Create()
+	c.Assert(err, check.IsNil)
+	err = user.AddRole(role.Name, ctxValue)
+	c.Assert(err, check.IsNil)
+	return email
+}
+
+func waitWebhookRequest(c *check.C, ch chan webhookRequest) webhookRequest {
+	select {
+	case req := <-ch:
+		return req
+	case <-time.After(5 * time.Second):
+		c.Fatal("timeout waiting for webhook request")
+	}
+	return webhookRequest{}
+}
+
+func waitWebhookDeliveries(c *check.C, name string, count int) []WebhookDelivery {
+	timeout := time.After(5 * time.Second)
+	for {
+		deliveries, err := ListWebhookDeliveries(name, 0)
+		c.Assert(err, check.IsNil)
+		if len(deliveries) >= count {
+			return deliveries
+		}
+		select {
+		case <-timeout:
+			c.Fatalf("timeout waiting for %d deliveries, got %d", count, len(deliveries))
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func (s *S) TestWebhookDeliveredOnStartAndEnd(c *check.C) {
+	srv, ch := webhookServer()
+	defer srv.Close()
+	err := CreateWebhook(&Webhook{
+		Name:      "hook1",
+		TeamOwner: "myteam",
+		Owner:     webhookOwner(c, "owner@example.com", "team", "myteam", "app.read.events"),
+		URL:       srv.URL,
+		Secret:    "s3cr3t",
+		Filter:    WebhookFilter{KindNames: []string{"app.update.env.set"}},
+	})
+	c.Assert(err, check.IsNil)
+	evt, err := New(&Opts{
+		Target:  Target{Type: "app", Value: "myapp"},
+		Kind:    permission.PermAppUpdateEnvSet,
+		Owner:   s.token,
+		Allowed: Allowed(permission.PermAppReadEvents, permission.Context(permission.CtxTeam, "myteam")),
+	})
+	c.Assert(err, check.IsNil)
+	req := waitWebhookRequest(c, ch)
+	c.Assert(req.header.Get(WebhookPhaseHeader), check.Equals, WebhookPhaseStart)
+	c.Assert(req.header.Get(WebhookSignatureHeader), check.Equals, "sha256="+WebhookSignature("s3cr3t", req.body))
+	c.Assert(req.header.Get(WebhookDeliveryHeader), check.Not(check.Equals), "")
+	var payload struct {
+		Phase string
+		Event struct {
+			UniqueID string
+			Target   Target
+			Kind     Kind
+			Running  bool
+			Error    string
+		}
+	}
+	err = json.Unmarshal(req.body, &payload)
+	c.Assert(err, check.IsNil)
+	c.Assert(payload.Phase, check.Equals, WebhookPhaseStart)
+	c.Assert(payload.Event.UniqueID, check.Equals, evt.UniqueID.Hex())
+	c.Assert(payload.Event.Target, check.DeepEquals, Target{Type: "app", Value: "myapp"})
+	c.Assert(payload.Event.Running, check.Equals, true)
+	err = evt.Done(errors.New("my error"))
+	c.Assert(err, check.IsNil)
+	req = waitWebhookRequest(c, ch)
+	c.Assert(req.header.Get(WebhookPhaseHeader), check.Equals, WebhookPhaseEnd)
+	err = json.Unmarshal(req.body, &payload)
+	c.Assert(err, check.IsNil)
+	c.Assert(payload.Phase, check.Equals, WebhookPhaseEnd)
+	c.Assert(payload.Event.Running, check.Equals, false)
+	c.Assert(payload.Event.Error, check.Equals, "my error")
+	deliveries := waitWebhookDeliveries(c, "hook1", 2)
+	c.Assert(deliveries, check.HasLen, 2)
+	for _, d := range deliveries {
+		c.Assert(d.Successful, check.Equals, true)
+		c.Assert(d.Attempts, check.Equals, 1)
+		c.Assert(d.StatusCode, check.Equals, http.StatusOK)
+		c.Assert(d.EventID, check.Equals, evt.UniqueID)
+		c.Assert(d.URL, check.Equals, srv.URL)
+	}
+}
+
+func (s *S) TestWebhookDeliveredOnAbort(c *check.C) {
+	srv, ch := webhookServer()
+	defer srv.Close()
+	err := CreateWebhook(&Webhook{
+		Name:      "hook1",
+		TeamOwner: "myteam",
+		Owner:     webhookOwner(c, "owner@example.com", "team", "myteam", "app.read.events"),
+		URL:       srv.URL,
+	})
+	c.Assert(err, check.IsNil)
+	evt, err := New(&Opts{
+		Target:  Target{Type: "app", Value: "myapp"},
+		Kind:    permission.PermAppUpdateEnvSet,
+		Owner:   s.token,
+		Allowed: Allowed(permission.PermAppReadEvents, permission.Context(permission.CtxTeam, "myteam")),
+	})
+	c.Assert(err, check.IsNil)
+	req := waitWebhookRequest(c, ch)
+	c.Assert(req.header.Get(WebhookPhaseHeader), check.Equals, WebhookPhaseStart)
+	err = evt.Abort()
+	c.Assert(err, check.IsNil)
+	req = waitWebhookRequest(c, ch)
+	c.Assert(req.header.Get(WebhookPhaseHeader), check.Equals, WebhookPhaseAbort)
+	var payload struct {
+		Phase string
+		Event struct {
+			UniqueID string
+			Running  bool
+		}
+	}
+	err = json.Unmarshal(req.body, &payload)
+	c.Assert(err, check.IsNil)
+	c.Assert(payload.Phase, check.Equals, WebhookPhaseAbort)
+	c.Assert(payload.Event.UniqueID, check.Equals, evt.UniqueID.Hex())
+	c.Assert(payload.Event.Running, check.Equals, false)
+	deliveries := waitWebhookDeliveries(c, "hook1", 2)
+	c.Assert(deliveries, check.HasLen, 2)
+}
+
+func (s *S) TestWebhookRetries(c *check.C) {
+	oldInterval := webhookRetryInterval
+	webhookRetryInterval = time.Millisecond
+	defer func() { webhookRetryInterval = oldInterval }()
+	config.Set("events:webhooks:max-retries", 1)
+	defer config.Unset("events:webhooks:max-retries")
+	srv, ch := webhookServer(http.StatusInternalServerError, http.StatusBadGateway, http.StatusInternalServerError, http.StatusOK)
+	defer srv.Close()
+	err := CreateWebhook(&Webhook{
+		Name:      "hook1",
+		TeamOwner: "myteam",
+		Owner:     webhookOwner(c, "owner@example.com", "team", "myteam", "app.read.events"),
+		URL:       srv.URL,
+		Filter:    WebhookFilter{ErrorOnly: true},
+	})
+	c.Assert(err, check.IsNil)
+	evt, err := New(&Opts{
+		Target:  Target{Type: "app", Value: "myapp"},
+		Kind:    permission.PermAppUpdateEnvSet,
+		Owner:   s.token,
+		Allowed: Allowed(permission.PermAppReadEvents, permission.Context(permission.CtxTeam, "myteam")),
+	})
+	c.Assert(err, check.IsNil)
+	err = evt.Done(errors.New("failed"))
+	c.Assert(err, check.IsNil)
+	waitWebhookRequest(c, ch)
+	waitWebhookRequest(c, ch)
+	deliveries := waitWebhookDeliveries(c, "hook1", 1)
+	c.Assert(deliveries, check.HasLen, 1)
+	c.Assert(deliveries[0].Phase, check.Equals, WebhookPhaseEnd)
+	c.Assert(deliveries[0].Successful, check.Equals, false)
+	c.Assert(deliveries[0].Attempts, check.Equals, 2)
+	c.Assert(deliveries[0].StatusCode, check.Equals, http.StatusBadGateway)
+	c.Assert(deliveries[0].Error, check.Equals, "invalid status code 502")
+}
+
+func (s *S) TestWebhookMatches(c *check.C) {
+	evt := eventData{
+		Target: Target{Type: TargetTypeApp, Value: "myapp"},
+		Kind:   Kind{Type: KindTypePermission, Name: "app.deploy"},
+	}
+	tests := []struct {
+		hook     Webhook
+		phase    string
+		evtErr   string
+		expected bool
+	}{
+		{Webhook{}, WebhookPhaseStart, "", true},
+		{Webhook{Filter: WebhookFilter{TargetTypes: []string{"node", "app"}}}, WebhookPhaseStart, "", true},
+		{Webhook{Filter: WebhookFilter{TargetTypes: []string{"node"}}}, WebhookPhaseStart, "", false},
+		{Webhook{Filter: WebhookFilter{TargetValues: []string{"otherapp"}}}, WebhookPhaseStart, "", false},
+		{Webhook{Filter: WebhookFilter{KindNames: []string{"app.deploy"}}}, WebhookPhaseEnd, "", true},
+		{Webhook{Filter: WebhookFilter{KindNames: []string{"app.create"}}}, WebhookPhaseEnd, "", false},
+		{Webhook{Filter: WebhookFilter{SuccessOnly: true}}, WebhookPhaseStart, "", false},
+		{Webhook{Filter: WebhookFilter{SuccessOnly: true}}, WebhookPhaseEnd, "", true},
+		{Webhook{Filter: WebhookFilter{SuccessOnly: true}}, WebhookPhaseEnd, "err", false},
+		{Webhook{Filter: WebhookFilter{ErrorOnly: true}}, WebhookPhaseEnd, "", false},
+		{Webhook{Filter: WebhookFilter{ErrorOnly: true}}, WebhookPhaseEnd, "err", true},
+		{Webhook{}, WebhookPhaseAbort, "", true},
+		{Webhook{Filter: WebhookFilter{SuccessOnly: true}}, WebhookPhaseAbort, "", false},
+	}
+	for i, tt := range tests {
+		evt.Error = tt.evtErr
+		c.Assert(tt.hook.matches(tt.phase, &evt), check.Equals, tt.expected, check.Commentf("test %d", i))
+	}
+}
+
+func (s *S) TestWebhookOwnerCanRead(c *check.C) {
+	teamOwner := webhookOwner(c, "team@example.com", "team", "myteam", "app.read.events")
+	deployer := webhookOwner(c, "deployer@example.com", "team", "myteam", "app.deploy")
+	poolOwner := webhookOwner(c, "pool@example.com", "pool", "pool1", "pool.read.events")
+	admin := webhookOwner(c, "admin@example.com", "global", "", "role.read.events")
+	teamCtx := permission.Context(permission.CtxTeam, "myteam")
+	tests := []struct {
+		owner    string
+		allowed  AllowedPermission
+		expected bool
+	}{
+		{teamOwner, Allowed(permission.PermAppReadEvents, teamCtx), true},
+		{teamOwner, Allowed(permission.PermAppReadEvents, permission.Context(permission.CtxTeam, "other")), false},
+		{teamOwner, Allowed(permission.PermAppReadEvents, permission.Context(permission.CtxGlobal, "")), false},
+		{teamOwner, Allowed(permission.PermPoolReadEvents, permission.Context(permission.CtxPool, "pool1")), false},
+		{deployer, Allowed(permission.PermAppReadEvents, teamCtx), false},
+		{poolOwner, Allowed(permission.PermPoolReadEvents, permission.Context(permission.CtxPool, "pool1")), true},
+		{poolOwner, Allowed(permission.PermPoolReadEvents, permission.Context(permission.CtxPool, "pool2")), false},
+		{admin, Allowed(permission.PermRoleReadEvents), true},
+		{teamOwner, Allowed(permission.PermRoleReadEvents), false},
+		{"unknown@example.com", Allowed(permission.PermAppReadEvents, teamCtx), false},
+	}
+	perms := map[string][]permission.Permission{}
+	for i, tt := range tests {
+		hook := Webhook{Name: "hook1", TeamOwner: "myteam", Owner: tt.owner}
+		evt := eventData{Allowed: tt.allowed}
+		c.Assert(hook.ownerCanRead(&evt, perms), check.Equals, tt.expected, check.Commentf("test %d", i))
+	}
+}
+
+func (s *S) TestWebhookDeliveredForHealerEvents(c *check.C) {
+	srv, ch := webhookServer()
+	defer srv.Close()
+	err := CreateWebhook(&Webhook{
+		Name:      "hook1",
+		TeamOwner: "myteam",
+		Owner:     webhookOwner(c, "pool@example.com", "pool", "pool1", "pool.read.events"),
+		URL:       srv.URL,
+		Filter:    WebhookFilter{KindNames: []string{"healer"}},
+	})
+	c.Assert(err, check.IsNil)
+	evt, err := NewInternal(&Opts{
+		Target:       Target{Type: TargetTypeNode, Value: "http://node1:2375"},
+		InternalKind: "healer",
+		Allowed:      Allowed(permission.PermPoolReadEvents, permission.Context(permission.CtxPool, "pool1")),
+	})
+	c.Assert(err, check.IsNil)
+	req := waitWebhookRequest(c, ch)
+	c.Assert(req.header.Get(WebhookPhaseHeader), check.Equals, WebhookPhaseStart)
+	err = evt.Done(nil)
+	c.Assert(err, check.IsNil)
+	req = waitWebhookRequest(c, ch)
+	c.Assert(req.header.Get(WebhookPhaseHeader), check.Equals, WebhookPhaseEnd)
+}
+
+func (s *S) TestWebhookDeliveredForAutoScaleEvents(c *check.C) {
+	srv, ch := webhookServer()
+	defer srv.Close()
+	err := CreateWebhook(&Webhook{
+		Name:      "hook1",
+		TeamOwner: "myteam",
+		Owner:     webhookOwner(c, "pool@example.com", "pool", "pool1", "pool.read.events"),
+		URL:       srv.URL,
+		Filter:    WebhookFilter{SuccessOnly: true},
+	})
+	c.Assert(err, check.IsNil)
+	for _, pool := range []string{"pool2", "pool1"} {
+		var evt *Event
+		evt, err = NewInternal(&Opts{
+			Target:       Target{Type: TargetTypePool, Value: pool},
+			InternalKind: "autoscale",
+			Allowed:      Allowed(permission.PermPoolReadEvents, permission.Context(permission.CtxPool, pool)),
+		})
+		c.Assert(err, check.IsNil)
+		err = evt.Done(nil)
+		c.Assert(err, check.IsNil)
+	}
+	req := waitWebhookRequest(c, ch)
+	var payload struct {
+		Event struct {
+			Target Target
+		}
+	}
+	err = json.Unmarshal(req.body, &payload)
+	c.Assert(err, check.IsNil)
+	c.Assert(payload.Event.Target, check.DeepEquals, Target{Type: TargetTypePool, Value: "pool1"})
+	deliveries := waitWebhookDeliveries(c, "hook1", 1)
+	c.Assert(deliveries, check.HasLen, 1)
+}
+
+func (s *S) TestWebhookDeliveredForRoleEvents(c *check.C) {
+	srv, ch := webhookServer()
+	defer srv.Close()
+	err := CreateWebhook(&Webhook{
+		Name:      "hook1",
+		TeamOwner: "myteam",
+		Owner:     webhookOwner(c, "admin@example.com", "global", "", "role.read.events"),
+		URL:       srv.URL,
+		Filter:    WebhookFilter{TargetTypes: []string{"role"}},
+	})
+	c.Assert(err, check.IsNil)
+	evt, err := New(&Opts{
+		Target:  Target{Type: TargetTypeRole, Value: "myrole"},
+		Kind:    permission.PermRoleCreate,
+		Owner:   s.token,
+		Allowed: Allowed(permission.PermRoleReadEvents),
+	})
+	c.Assert(err, check.IsNil)
+	req := waitWebhookRequest(c, ch)
+	c.Assert(req.header.Get(WebhookPhaseHeader), check.Equals, WebhookPhaseStart)
+	err = evt.Done(nil)
+	c.Assert(err, check.IsNil)
+	req = waitWebhookRequest(c, ch)
+	c.Assert(req.header.Get(WebhookPhaseHeader), check.Equals, WebhookPhaseEnd)
+}
+
+func (s *S) TestWebhookNotDeliveredWithoutOwnerPermission(c *check.C) {
+	srv, ch := webhookServer()
+	defer srv.Close()
+	err := CreateWebhook(&Webhook{
+		Name:      "hook1",
+		TeamOwner: "myteam",
+		Owner:     webhookOwner(c, "deployer@example.com", "team", "myteam", "app.deploy"),
+		URL:       srv.URL,
+	})
+	c.Assert(err, check.IsNil)
+	evt, err := New(&Opts{
+		Target:  Target{Type: "app", Value: "myapp"},
+		Kind:    permission.PermAppUpdateEnvSet,
+		Owner:   s.token,
+		Allowed: Allowed(permission.PermAppReadEvents, permission.Context(permission.CtxTeam, "myteam")),
+	})
+	c.Assert(err, check.IsNil)
+	err = evt.Done(nil)
+	c.Assert(err, check.IsNil)
+	select {
+	case <-ch:
+		c.Fatal("webhook delivered to owner without permission to read the event")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func (s *S) TestWebhookCRUD(c *check.C) {
+	hook := Webhook{Name: "hook1", TeamOwner: "myteam", URL: "http://example.com/hook"}
+	err := CreateWebhook(&hook)
+	c.Assert(err, check.IsNil)
+	err = CreateWebhook(&hook)
+	c.Assert(err, check.Equals, ErrWebhookAlreadyExists)
+	other := Webhook{Name: "hook2", TeamOwner: "otherteam", URL: "https://example.com/hook"}
+	err = CreateWebhook(&other)
+	c.Assert(err, check.IsNil)
+	hooks, err := ListWebhooks(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.DeepEquals, []Webhook{hook, other})
+	hooks, err = ListWebhooks([]string{"otherteam"})
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.DeepEquals, []Webhook{other})
+	hook.Filter.KindNames = []string{"app.deploy"}
+	err = UpdateWebhook(&hook)
+	c.Assert(err, check.IsNil)
+	dbHook, err := GetWebhook("hook1")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbHook, check.DeepEquals, &hook)
+	err = DeleteWebhook("hook1")
+	c.Assert(err, check.IsNil)
+	_, err = GetWebhook("hook1")
+	c.Assert(err, check.Equals, ErrWebhookNotFound)
+	err = DeleteWebhook("hook1")
+	c.Assert(err, check.Equals, ErrWebhookNotFound)
+	err = UpdateWebhook(&hook)
+	c.Assert(err, check.Equals, ErrWebhookNotFound)
+}
+
+func (s *S) TestWebhookValidation(c *check.C) {
+	tests := []struct {
+		hook Webhook
+		err  string
+	}{
+		{Webhook{TeamOwner: "t", URL: "http://a.com"}, "webhook name is mandatory"},
+		{Webhook{Name: "a", URL: "http://a.com"}, "webhook team owner is mandatory"},
+		{Webhook{Name: "a", TeamOwner: "t", URL: "ftp://a.com"}, "webhook url must be a valid http or https url"},
+		{Webhook{Name: "a", TeamOwner: "t", URL: "a.com"}, "webhook url must be a valid http or https url"},
+		{Webhook{Name: "a", TeamOwner: "t", URL: "http://a.com", Filter: WebhookFilter{ErrorOnly: true, SuccessOnly: true}}, "webhook filter can't be both success only and error only"},
+	}
+	for _, tt := range tests {
+		err := CreateWebhook(&tt.hook)
+		c.Assert(err, check.ErrorMatches, tt.err)
+		_, ok := err.(ErrValidation)
+		c.Assert(ok, check.Equals, true)
+	}
+}
@@ -45,6 +45,7 @@ github.com/tsuru/tsuru/api.removeKeyFromUser
 github.com/tsuru/tsuru/api.setNodeStatus
 github.com/tsuru/tsuru/api.kindList
 github.com/tsuru/tsuru/api.eventList
+github.com/tsuru/tsuru/api.eventStream
 github.com/tsuru/tsuru/api.eventInfo
 github.com/tsuru/tsuru/api.eventCancel
 github.com/tsuru/tsuru/api.listNodesHandler
@@ -59,7 +59,7 @@ var (
 	}
 )
 
-func parseContext(ctx string) (contextType, error) {
+func ParseContext(ctx string) (contextType, error) {
 	for _, t := range ContextTypes {
 		if string(t) == ctx {
 			return t, nil
@@ -218,6 +218,36 @@ func CheckFromPermList(perms []Permission, scheme *PermissionScheme, contexts ..
 	return false
 }
 
+// Intersect returns the permissions granted by both lists. A permission
+// present in both lists with different levels, e.g. app with a team context
+// and app.deploy with a global context, results in the most restrictive of
+// them, app.deploy with the team context.
+func Intersect(a, b []Permission) []Permission {
+	var result []Permission
+	for _, p1 := range a {
+		for _, p2 := range b {
+			var scheme *PermissionScheme
+			if p1.Scheme.IsParent(p2.Scheme) {
+				scheme = p2.Scheme
+			} else if p2.Scheme.IsParent(p1.Scheme) {
+				scheme = p1.Scheme
+			} else {
+				continue
+			}
+			var ctx PermissionContext
+			if p1.Context.CtxType == CtxGlobal {
+				ctx = p2.Context
+			} else if p2.Context.CtxType == CtxGlobal || p1.Context == p2.Context {
+				ctx = p1.Context
+			} else {
+				continue
+			}
+			result = append(result, Permission{Scheme: scheme, Context: ctx})
+		}
+	}
+	return result
+}
+
 func TeamForPermission(t Token, scheme *PermissionScheme) (string, error) {
 	allContexts := ContextsForPermission(t, scheme)
 	teams := make([]string, 0, len(allContexts))
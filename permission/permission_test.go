@@ -92,6 +92,39 @@ func (s *S) TestCheckSuperToken(c *check.C) {
 	c.Assert(Check(t, PermAppUpdateEnvUnset), check.Equals, true)
 }
 
+func (s *S) TestIntersect(c *check.C) {
+	userPerms := []Permission{
+		{Scheme: PermApp, Context: PermissionContext{CtxType: CtxTeam, Value: "team1"}},
+		{Scheme: PermAppDeploy, Context: PermissionContext{CtxType: CtxGlobal}},
+		{Scheme: PermTeamCreate, Context: PermissionContext{CtxType: CtxGlobal}},
+	}
+	allowed := []Permission{
+		{Scheme: PermAppUpdateEnvSet, Context: PermissionContext{CtxType: CtxGlobal}},
+		{Scheme: PermAppDeploy, Context: PermissionContext{CtxType: CtxApp, Value: "myapp"}},
+		{Scheme: PermNode, Context: PermissionContext{CtxType: CtxGlobal}},
+	}
+	c.Assert(Intersect(userPerms, allowed), check.DeepEquals, []Permission{
+		{Scheme: PermAppUpdateEnvSet, Context: PermissionContext{CtxType: CtxTeam, Value: "team1"}},
+		{Scheme: PermAppDeploy, Context: PermissionContext{CtxType: CtxApp, Value: "myapp"}},
+	})
+	c.Assert(Intersect(allowed, userPerms), check.DeepEquals, []Permission{
+		{Scheme: PermAppUpdateEnvSet, Context: PermissionContext{CtxType: CtxTeam, Value: "team1"}},
+		{Scheme: PermAppDeploy, Context: PermissionContext{CtxType: CtxApp, Value: "myapp"}},
+	})
+	c.Assert(Intersect(userPerms, nil), check.HasLen, 0)
+}
+
+func (s *S) TestIntersectSameContext(c *check.C) {
+	a := []Permission{{Scheme: PermAll, Context: PermissionContext{CtxType: CtxTeam, Value: "team1"}}}
+	b := []Permission{
+		{Scheme: PermAppDeploy, Context: PermissionContext{CtxType: CtxTeam, Value: "team1"}},
+		{Scheme: PermAppDeploy, Context: PermissionContext{CtxType: CtxTeam, Value: "team2"}},
+	}
+	c.Assert(Intersect(a, b), check.DeepEquals, []Permission{
+		{Scheme: PermAppDeploy, Context: PermissionContext{CtxType: CtxTeam, Value: "team1"}},
+	})
+}
+
 func (s *S) TestGetTeamForPermission(c *check.C) {
 	t := &userToken{
 		permissions: []Permission{
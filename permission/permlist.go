@@ -14,10 +14,12 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"app.update.description",
 	"app.update.log",
 	"app.update.pool",
+	"app.update.pool.migrate",
 	"app.update.unit.add",
 	"app.update.unit.remove",
 	"app.update.unit.register",
 	"app.update.unit.status",
+	"app.update.unit.autoscale",
 	"app.update.env.set",
 	"app.update.env.unset",
 	"app.update.restart",
@@ -37,6 +39,8 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"app.update.unbind",
 	"app.update.certificate.set",
 	"app.update.certificate.unset",
+	"app.update.log-drain.add",
+	"app.update.log-drain.remove",
 	"app.deploy",
 	"app.deploy.archive-url",
 	"app.deploy.build",
@@ -51,6 +55,7 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"app.read.metric",
 	"app.read.log",
 	"app.read.certificate",
+	"app.read.log-drain",
 	"app.delete",
 	"app.run",
 	"app.run.shell",
@@ -90,6 +95,8 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"team.create", []contextType{},
 ).add(
 	"team.read.events",
+	"team.read.quota",
+	"team.update.quota",
 	"team.delete",
 ).addWithCtx(
 	"user", []contextType{CtxUser},
@@ -98,9 +105,11 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 ).add(
 	"user.delete",
 	"user.read.events",
+	"user.read.tokens",
 	"user.update.token",
 	"user.update.quota",
 	"user.update.password",
+	"user.update.two-factor",
 	"user.update.reset",
 	"user.update.key.add",
 	"user.update.key.remove",
@@ -161,6 +170,9 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"pool.update.constraints.set",
 	"pool.read.constraints",
 	"pool.update.logs",
+	"pool.update.log-drain.add",
+	"pool.update.log-drain.remove",
+	"pool.read.log-drain",
 	"pool.delete",
 ).add(
 	"debug",
@@ -182,4 +194,16 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"nodecontainer.delete",
 ).add(
 	"install.manage",
+).addWithCtx(
+	"webhook", []contextType{CtxTeam},
+).add(
+	"webhook.create",
+	"webhook.read",
+	"webhook.read.events",
+	"webhook.update",
+	"webhook.delete",
+).add(
+	"router.read.check",
+	"router.read.events",
+	"router.update.repair",
 )
@@ -47,6 +47,7 @@ import (
 	_ "github.com/tsuru/tsuru/router/fusis"
 	_ "github.com/tsuru/tsuru/router/galeb"
 	_ "github.com/tsuru/tsuru/router/hipache"
+	_ "github.com/tsuru/tsuru/router/nginx"
 	_ "github.com/tsuru/tsuru/router/routertest"
 	_ "github.com/tsuru/tsuru/router/vulcand"
 	"github.com/tsuru/tsuru/safe"
@@ -400,11 +401,21 @@ func (p *dockerProvisioner) Rebuild(app provision.App, evt *event.Event) (string
 }
 
 func (p *dockerProvisioner) ImageDeploy(app provision.App, imageId string, evt *event.Event) (string, error) {
+	newImage, err := p.prepareImage(app, imageId, evt)
+	if err != nil {
+		return "", err
+	}
+	app.SetUpdatePlatform(true)
+	return newImage, p.deploy(app, newImage, evt)
+}
+
+// prepareImage pulls an image built outside tsuru and tags it as a new image
+// of the app.
+func (p *dockerProvisioner) prepareImage(app provision.App, imageId string, w io.Writer) (string, error) {
 	cluster := p.Cluster()
 	if !strings.Contains(imageId, ":") {
 		imageId = fmt.Sprintf("%s:latest", imageId)
 	}
-	w := evt
 	fmt.Fprintln(w, "---- Pulling image to tsuru ----")
 	pullOpts := docker.PullImageOptions{
 		Repository:        imageId,
@@ -430,7 +441,7 @@ func (p *dockerProvisioner) ImageDeploy(app provision.App, imageId string, evt *
 	if err != nil {
 		return "", err
 	}
-	newImage, err := dockercommon.PrepareImageForDeploy(dockercommon.PrepareImageArgs{
+	return dockercommon.PrepareImageForDeploy(dockercommon.PrepareImageArgs{
 		Client:      cluster,
 		App:         app,
 		ProcfileRaw: outBuf.String(),
@@ -438,11 +449,6 @@ func (p *dockerProvisioner) ImageDeploy(app provision.App, imageId string, evt *
 		AuthConfig:  p.RegistryAuthConfig(),
 		Out:         w,
 	})
-	if err != nil {
-		return "", err
-	}
-	app.SetUpdatePlatform(true)
-	return newImage, p.deploy(app, newImage, evt)
 }
 
 func (p *dockerProvisioner) ArchiveDeploy(app provision.App, archiveURL string, evt *event.Event) (string, error) {
@@ -555,24 +561,7 @@ func getContainersToAdd(data image.ImageMetadata, oldContainers []container.Cont
 }
 
 func (p *dockerProvisioner) Destroy(app provision.App) error {
-	containers, err := p.listContainersByApp(app.GetName())
-	if err != nil {
-		log.Errorf("Failed to list app containers: %s", err)
-		return err
-	}
-	args := changeUnitsPipelineArgs{
-		app:         app,
-		toRemove:    containers,
-		writer:      ioutil.Discard,
-		provisioner: p,
-		appDestroy:  true,
-	}
-	pipeline := action.NewPipeline(
-		&removeOldRoutes,
-		&provisionRemoveOldUnits,
-		&provisionUnbindOldUnits,
-	)
-	err = pipeline.Execute(args)
+	err := p.DestroyUnits(app)
 	if err != nil {
 		return err
 	}
@@ -598,6 +587,29 @@ func (p *dockerProvisioner) Destroy(app provision.App) error {
 	return nil
 }
 
+// DestroyUnits removes the routes and containers of the app, keeping its
+// images.
+func (p *dockerProvisioner) DestroyUnits(app provision.App) error {
+	containers, err := p.listContainersByApp(app.GetName())
+	if err != nil {
+		log.Errorf("Failed to list app containers: %s", err)
+		return err
+	}
+	args := changeUnitsPipelineArgs{
+		app:         app,
+		toRemove:    containers,
+		writer:      ioutil.Discard,
+		provisioner: p,
+		appDestroy:  true,
+	}
+	pipeline := action.NewPipeline(
+		&removeOldRoutes,
+		&provisionRemoveOldUnits,
+		&provisionUnbindOldUnits,
+	)
+	return pipeline.Execute(args)
+}
+
 func (p *dockerProvisioner) runRestartAfterHooks(cont *container.Container, w io.Writer) error {
 	yamlData, err := image.GetImageTsuruYamlData(cont.Image)
 	if err != nil {
@@ -1281,6 +1281,55 @@ func (s *S) TestProvisionerDestroyEmptyUnit(c *check.C) {
 	c.Assert(err, check.IsNil)
 }
 
+func (s *S) TestProvisionerDestroyUnitsKeepsImages(c *check.C) {
+	a, _ := s.newVersionedApp(c)
+	err := s.p.DestroyUnits(a)
+	c.Assert(err, check.IsNil)
+	containers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 0)
+	imgs, err := image.ListAppImages(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(imgs, check.HasLen, 2)
+	currentImg, err := image.AppCurrentImageName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(currentImg, check.Equals, "tsuru/app-otherapp:v1")
+}
+
+type migrationTargetProvisioner struct {
+	*provisiontest.FakeProvisioner
+}
+
+func (p *migrationTargetProvisioner) GetName() string {
+	return "fake-target"
+}
+
+func (s *S) TestMigratePoolKeepsImages(c *check.C) {
+	target := &migrationTargetProvisioner{FakeProvisioner: provisiontest.NewFakeProvisioner()}
+	provision.Register("fake-target", func() (provision.Provisioner, error) {
+		return target, nil
+	})
+	defer provision.Unregister("fake-target")
+	err := provision.AddPool(provision.AddPoolOptions{Name: "target-pool", Public: true, Provisioner: "fake-target"})
+	c.Assert(err, check.IsNil)
+	a, evt := s.newVersionedApp(c)
+	err = s.storage.Apps().Update(bson.M{"name": a.Name}, bson.M{"$set": bson.M{"deploys": 1}})
+	c.Assert(err, check.IsNil)
+	a.Deploys = 1
+	err = a.MigratePool("target-pool", evt, ioutil.Discard)
+	c.Assert(err, check.IsNil)
+	containers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 0)
+	c.Assert(target.GetUnits(a), check.HasLen, 1)
+	imgs, err := image.ListAppImages(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(imgs, check.HasLen, 2)
+	currentImg, err := image.AppCurrentImageName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(currentImg, check.Equals, "tsuru/app-otherapp:v1")
+}
+
 func (s *S) TestProvisionerAddUnits(c *check.C) {
 	err := s.newFakeImage(s.p, "tsuru/app-myapp", nil)
 	c.Assert(err, check.IsNil)
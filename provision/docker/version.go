@@ -0,0 +1,184 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/action"
+	"github.com/tsuru/tsuru/app/image"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ provision.VersionedDeployer = &dockerProvisioner{}
+
+// PrepareVersion returns the app image used by the units of a gradual
+// deploy. Images that don't belong to the app yet are pulled and tagged like
+// in an image deploy.
+func (p *dockerProvisioner) PrepareVersion(a provision.App, img string, w io.Writer) (string, error) {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	validImgs, err := image.ListValidAppImages(a.GetName())
+	if err != nil {
+		return "", err
+	}
+	currentImg, err := image.AppCurrentImageName(a.GetName())
+	if err != nil && err != image.ErrNoImagesAvailable {
+		return "", err
+	}
+	for _, validImg := range validImgs {
+		if validImg != img {
+			continue
+		}
+		if validImg == currentImg {
+			return "", errors.Errorf("image %q is already the current app image", img)
+		}
+		return img, nil
+	}
+	newImage, err := p.prepareImage(a, img, w)
+	if err != nil {
+		return "", err
+	}
+	a.SetUpdatePlatform(true)
+	return newImage, nil
+}
+
+// AddVersionUnits starts containers of the web process of the image,
+// binding and checking them like in a regular deploy. Routes are left to the
+// caller.
+func (p *dockerProvisioner) AddVersionUnits(a provision.App, img string, units uint, w io.Writer) ([]provision.Unit, error) {
+	if units == 0 {
+		return nil, errors.New("Cannot add 0 units")
+	}
+	if w == nil {
+		w = ioutil.Discard
+	}
+	imageData, err := image.GetImageCustomData(img)
+	if err != nil {
+		return nil, err
+	}
+	webProcessName, err := image.GetImageWebProcessName(img)
+	if err != nil {
+		return nil, err
+	}
+	evt, _ := w.(*event.Event)
+	args := changeUnitsPipelineArgs{
+		app:         a,
+		toAdd:       map[string]*containersToAdd{webProcessName: {Quantity: int(units)}},
+		writer:      w,
+		imageId:     img,
+		provisioner: p,
+		exposedPort: imageData.ExposedPort,
+		event:       evt,
+	}
+	pipeline := action.NewPipeline(
+		&provisionAddUnitsToHost,
+		&bindAndHealthcheck,
+	)
+	err = pipeline.Execute(args)
+	if err != nil {
+		return nil, err
+	}
+	containers := pipeline.Result().([]container.Container)
+	result := make([]provision.Unit, len(containers))
+	for i, c := range containers {
+		result[i] = c.AsUnit(a)
+	}
+	return result, nil
+}
+
+// RemoveVersionUnits unbinds and removes every container of the app running
+// the image.
+func (p *dockerProvisioner) RemoveVersionUnits(a provision.App, img string, w io.Writer) error {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	containers, err := p.ListContainers(bson.M{"appname": a.GetName(), "image": img})
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return nil
+	}
+	args := changeUnitsPipelineArgs{
+		app:         a,
+		toRemove:    containers,
+		writer:      w,
+		provisioner: p,
+	}
+	pipeline := action.NewPipeline(
+		&provisionRemoveOldUnits,
+		&provisionUnbindOldUnits,
+	)
+	return pipeline.Execute(args)
+}
+
+// PromoteVersion starts the remaining processes of the image, with as many
+// units as the current image runs, saves it as the app image and removes
+// every container running other images.
+func (p *dockerProvisioner) PromoteVersion(a provision.App, img string, w io.Writer) error {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	imageData, err := image.GetImageCustomData(img)
+	if err != nil {
+		return err
+	}
+	webProcessName, err := image.GetImageWebProcessName(img)
+	if err != nil {
+		return err
+	}
+	containers, err := p.listContainersByApp(a.GetName())
+	if err != nil {
+		return err
+	}
+	var toRemove, oldProcesses []container.Container
+	webUnits := 0
+	for _, c := range containers {
+		if c.Image == img {
+			webUnits++
+			continue
+		}
+		toRemove = append(toRemove, c)
+		if c.ProcessName != webProcessName {
+			oldProcesses = append(oldProcesses, c)
+		}
+	}
+	toAdd := getContainersToAdd(imageData, oldProcesses)
+	delete(toAdd, webProcessName)
+	quotaUnits := map[string]*containersToAdd{webProcessName: {Quantity: webUnits}}
+	for processName, ct := range toAdd {
+		quotaUnits[processName] = ct
+	}
+	if err = setQuota(a, quotaUnits); err != nil {
+		return err
+	}
+	evt, _ := w.(*event.Event)
+	args := changeUnitsPipelineArgs{
+		app:         a,
+		toAdd:       toAdd,
+		toRemove:    toRemove,
+		writer:      w,
+		imageId:     img,
+		provisioner: p,
+		exposedPort: imageData.ExposedPort,
+		event:       evt,
+	}
+	pipeline := action.NewPipeline(
+		&provisionAddUnitsToHost,
+		&bindAndHealthcheck,
+		&setRouterHealthcheck,
+		&updateAppImage,
+		&provisionRemoveOldUnits,
+		&provisionUnbindOldUnits,
+	)
+	return pipeline.Execute(args)
+}
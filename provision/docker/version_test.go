@@ -0,0 +1,113 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/app/image"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/quota"
+	"github.com/tsuru/tsuru/router/routertest"
+	"github.com/tsuru/tsuru/safe"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) newVersionedApp(c *check.C) (*app.App, *event.Event) {
+	err := s.newFakeImage(s.p, "tsuru/app-otherapp:v1", nil)
+	c.Assert(err, check.IsNil)
+	err = s.newFakeImage(s.p, "tsuru/app-otherapp:v2", nil)
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName("otherapp", "tsuru/app-otherapp:v2")
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName("otherapp", "tsuru/app-otherapp:v1")
+	c.Assert(err, check.IsNil)
+	a := s.newApp("otherapp")
+	a.Quota = quota.Unlimited
+	err = app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	evt, err := event.New(&event.Opts{
+		Target:  event.Target{Type: "app", Value: a.Name},
+		Kind:    permission.PermAppDeploy,
+		Owner:   s.token,
+		Allowed: event.Allowed(permission.PermApp),
+	})
+	c.Assert(err, check.IsNil)
+	_, err = s.p.Rollback(&a, "tsuru/app-otherapp:v1", evt)
+	c.Assert(err, check.IsNil)
+	return &a, evt
+}
+
+func (s *S) TestPrepareVersionCurrentImage(c *check.C) {
+	a, evt := s.newVersionedApp(c)
+	_, err := s.p.PrepareVersion(a, "tsuru/app-otherapp:v1", evt)
+	c.Assert(err, check.ErrorMatches, `image "tsuru/app-otherapp:v1" is already the current app image`)
+	img, err := s.p.PrepareVersion(a, "tsuru/app-otherapp:v2", evt)
+	c.Assert(err, check.IsNil)
+	c.Assert(img, check.Equals, "tsuru/app-otherapp:v2")
+}
+
+func (s *S) TestAddAndRemoveVersionUnits(c *check.C) {
+	a, evt := s.newVersionedApp(c)
+	units, err := s.p.AddVersionUnits(a, "tsuru/app-otherapp:v2", 2, evt)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 2)
+	for _, u := range units {
+		c.Assert(u.ProcessName, check.Equals, "web")
+		c.Assert(routertest.FakeRouter.HasRoute(a.Name, u.Address.String()), check.Equals, false)
+	}
+	containers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 3)
+	currentImg, err := image.AppCurrentImageName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(currentImg, check.Equals, "tsuru/app-otherapp:v1")
+	err = s.p.RemoveVersionUnits(a, "tsuru/app-otherapp:v2", evt)
+	c.Assert(err, check.IsNil)
+	containers, err = s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 1)
+	c.Assert(containers[0].Image, check.Equals, "tsuru/app-otherapp:v1")
+}
+
+func (s *S) TestPromoteVersion(c *check.C) {
+	a, evt := s.newVersionedApp(c)
+	_, err := s.p.AddVersionUnits(a, "tsuru/app-otherapp:v2", 1, evt)
+	c.Assert(err, check.IsNil)
+	err = s.p.PromoteVersion(a, "tsuru/app-otherapp:v2", evt)
+	c.Assert(err, check.IsNil)
+	containers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 1)
+	c.Assert(containers[0].Image, check.Equals, "tsuru/app-otherapp:v2")
+	currentImg, err := image.AppCurrentImageName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(currentImg, check.Equals, "tsuru/app-otherapp:v2")
+}
+
+func (s *S) TestDeployWithCanaryStrategy(c *check.C) {
+	a, evt := s.newVersionedApp(c)
+	oldContainers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(oldContainers, check.HasLen, 1)
+	w := safe.NewBuffer(make([]byte, 2048))
+	imgID, err := app.Deploy(app.DeployOptions{
+		App:          a,
+		OutputStream: w,
+		Image:        "tsuru/app-otherapp:v2",
+		Rollback:     true,
+		Event:        evt,
+		Strategy:     app.DeployStrategy{Kind: app.DeployStrategyCanary, Percent: 50},
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(imgID, check.Equals, "tsuru/app-otherapp:v2")
+	containers, err := s.p.ListContainers(bson.M{"appname": a.Name})
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 1)
+	c.Assert(containers[0].Image, check.Equals, "tsuru/app-otherapp:v2")
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, containers[0].Address().String()), check.Equals, true)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, oldContainers[0].Address().String()), check.Equals, false)
+}
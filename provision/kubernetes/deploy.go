@@ -1,6 +1,8 @@
 package kubernetes
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -23,6 +25,7 @@ import (
 	"k8s.io/client-go/rest"
 	"k8s.io/kubernetes/pkg/client/unversioned/remotecommand"
 	remotecommandserver "k8s.io/kubernetes/pkg/kubelet/server/remotecommand"
+	"k8s.io/kubernetes/pkg/util/term"
 )
 
 func doAttach(podName, namespace, containerName string, stdin io.Reader) error {
@@ -62,6 +65,84 @@ func doAttach(podName, namespace, containerName string, stdin io.Reader) error {
 	return nil
 }
 
+type execOpts struct {
+	podName       string
+	containerName string
+	cmds          []string
+	stdin         io.Reader
+	stdout        io.Writer
+	stderr        io.Writer
+	tty           bool
+	termSize      *term.Size
+}
+
+// initialSizeQueue reports a single terminal size, the one received when the
+// remote command was started.
+type initialSizeQueue struct {
+	size *term.Size
+}
+
+func (q *initialSizeQueue) Next() *term.Size {
+	size := q.size
+	q.size = nil
+	return size
+}
+
+func doExec(opts execOpts) error {
+	return doStream("exec", opts)
+}
+
+// doAttachStreams attaches to the running container of the pod, streaming its
+// output the same way doExec does. The cmds in opts are ignored.
+func doAttachStreams(opts execOpts) error {
+	opts.cmds = nil
+	return doStream("attach", opts)
+}
+
+func doStream(subResource string, opts execOpts) error {
+	cfg, err := getClusterRestConfig()
+	if err != nil {
+		return err
+	}
+	cli, err := rest.RESTClientFor(cfg)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req := cli.Post().
+		Resource("pods").
+		Name(opts.podName).
+		Namespace(tsuruNamespace).
+		SubResource(subResource)
+	req.Param("container", opts.containerName)
+	for _, cmd := range opts.cmds {
+		req.Param("command", cmd)
+	}
+	req.Param("stdin", strconv.FormatBool(opts.stdin != nil))
+	req.Param("stdout", strconv.FormatBool(opts.stdout != nil))
+	req.Param("stderr", strconv.FormatBool(opts.stderr != nil))
+	req.Param("tty", strconv.FormatBool(opts.tty))
+	exec, err := remotecommand.NewExecutor(cfg, "POST", req.URL())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	var sizeQueue term.TerminalSizeQueue
+	if opts.termSize != nil {
+		sizeQueue = &initialSizeQueue{size: opts.termSize}
+	}
+	err = exec.Stream(remotecommand.StreamOptions{
+		SupportedProtocols: remotecommandserver.SupportedStreamingProtocols,
+		Stdin:              opts.stdin,
+		Stdout:             opts.stdout,
+		Stderr:             opts.stderr,
+		Tty:                opts.tty,
+		TerminalSizeQueue:  sizeQueue,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
 type buildJobParams struct {
 	client           kubernetes.Interface
 	app              provision.App
@@ -69,12 +150,17 @@ type buildJobParams struct {
 	sourceImage      string
 	destinationImage string
 	attachInput      io.Reader
+	inspectSource    bool
 }
 
 func createBuildJob(params buildJobParams) (string, error) {
 	parallelism := int32(1)
 	dockerSockPath := "/var/run/docker.sock"
 	baseName := deployJobNameForApp(params.app)
+	var inspectCmd string
+	if params.inspectSource {
+		inspectCmd = fmt.Sprintf("docker inspect -f '{{json .Config}}' %s && ", params.sourceImage)
+	}
 	job := &batch.Job{
 		ObjectMeta: v1.ObjectMeta{
 			Name:      baseName,
@@ -127,8 +213,8 @@ func createBuildJob(params buildJobParams) (string, error) {
 									while id=$(docker ps -aq -f 'label=io.kubernetes.container.name=%s' -f "label=io.kubernetes.pod.name=$(hostname)") && [ -z $id ]; do
 										sleep 1;
 									done;
-									docker wait $id && docker commit $id %s && docker push %s
-								`, baseName, params.destinationImage, params.destinationImage),
+									%sdocker wait $id && docker commit $id %s && docker push %s
+								`, baseName, inspectCmd, params.destinationImage, params.destinationImage),
 							},
 						},
 					},
@@ -150,6 +236,65 @@ func createBuildJob(params buildJobParams) (string, error) {
 	return podName, nil
 }
 
+// runBuildJob creates a build job, streaming the output of the build command
+// to w and waiting for the resulting image to be committed and pushed. It
+// returns the name of the pod that ran the job.
+func runBuildJob(params buildJobParams, w io.Writer) (string, error) {
+	deployJobName := deployJobNameForApp(params.app)
+	podName, err := createBuildJob(params)
+	if err != nil {
+		return "", err
+	}
+	req := params.client.Core().Pods(tsuruNamespace).GetLogs(podName, &v1.PodLogOptions{
+		Follow:    true,
+		Container: deployJobName,
+	})
+	reader, err := req.Stream()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer reader.Close()
+	_, err = io.Copy(w, reader)
+	if err != nil && err != io.EOF {
+		return "", errors.WithStack(err)
+	}
+	err = waitForJob(params.client, deployJobName, defaultBuildJobTimeout, false)
+	if err != nil {
+		return "", err
+	}
+	return podName, nil
+}
+
+type sourceImageConfig struct {
+	Entrypoint   []string
+	Cmd          []string
+	ExposedPorts map[string]struct{}
+}
+
+// inspectedSourceConfig reads the config of the source image of a build job
+// created with inspectSource, which is the first line written by the
+// committer container.
+func inspectedSourceConfig(client kubernetes.Interface, podName string) (*sourceImageConfig, error) {
+	req := client.Core().Pods(tsuruNamespace).GetLogs(podName, &v1.PodLogOptions{
+		Container: "committer-cont",
+	})
+	reader, err := req.Stream()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer reader.Close()
+	line, err := bufio.NewReader(reader).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, errors.WithStack(err)
+	}
+	var imgConfig sourceImageConfig
+	err = json.Unmarshal(line, &imgConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse source image config %q", line)
+	}
+	return &imgConfig, nil
+}
+
 func extraRegisterCmds(a provision.App) string {
 	host, _ := config.GetString("host")
 	if !strings.HasPrefix(host, "http") {
@@ -162,10 +307,45 @@ func extraRegisterCmds(a provision.App) string {
 	return fmt.Sprintf(`curl -fsSL -m15 -XPOST -d"hostname=$(hostname)" -o/dev/null -H"Content-Type:application/x-www-form-urlencoded" -H"Authorization:bearer %s" %sapps/%s/units/register`, token, host, a.GetName())
 }
 
-func createAppDeployment(client kubernetes.Interface, oldDeployment *extensions.Deployment, a provision.App, process string, image string) error {
-	replicas := int32(1)
-	if oldDeployment != nil && oldDeployment.Spec.Replicas != nil {
-		replicas = *oldDeployment.Spec.Replicas
+func envsForApp(a provision.App) []v1.EnvVar {
+	var envs []v1.EnvVar
+	for _, envData := range a.Envs() {
+		envs = append(envs, v1.EnvVar{Name: envData.Name, Value: envData.Value})
+	}
+	host, _ := config.GetString("host")
+	port := dockercommon.WebProcessDefaultPort()
+	return append(envs, []v1.EnvVar{
+		{Name: "TSURU_HOST", Value: host},
+		{Name: "port", Value: port},
+		{Name: "PORT", Value: port},
+	}...)
+}
+
+func createAppDeployment(client kubernetes.Interface, oldDeployment *extensions.Deployment, a provision.App, process string, image string, pState servicecommon.ProcessState) error {
+	restartCount := 0
+	replicas := 0
+	if oldDeployment != nil {
+		var err error
+		replicas, err = strconv.Atoi(oldDeployment.Labels["tsuru.app.process.replicas"])
+		if err != nil && oldDeployment.Spec.Replicas != nil {
+			replicas = int(*oldDeployment.Spec.Replicas)
+		}
+		restartCount, _ = strconv.Atoi(oldDeployment.Spec.Template.Labels["tsuru.app.restart"])
+	}
+	if pState.Increment != 0 {
+		replicas += pState.Increment
+		if replicas < 0 {
+			return errors.New("cannot have less than 0 units")
+		}
+	} else if replicas == 0 && pState.Start {
+		replicas = 1
+	}
+	deployReplicas := int32(replicas)
+	if pState.Stop {
+		deployReplicas = 0
+	}
+	if pState.Restart {
+		restartCount++
 	}
 	routerName, err := a.GetRouterName()
 	if err != nil {
@@ -180,37 +360,32 @@ func createAppDeployment(client kubernetes.Interface, oldDeployment *extensions.
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	var envs []v1.EnvVar
-	for _, envData := range a.Envs() {
-		envs = append(envs, v1.EnvVar{Name: envData.Name, Value: envData.Value})
-	}
-	host, _ := config.GetString("host")
-	port := dockercommon.WebProcessDefaultPort()
-	envs = append(envs, []v1.EnvVar{
-		{Name: "TSURU_HOST", Value: host},
-		{Name: "port", Value: port},
-		{Name: "PORT", Value: port},
-	}...)
+	envs := envsForApp(a)
 	depName := deploymentNameForApp(a, process)
 	deployment := extensions.Deployment{
 		ObjectMeta: v1.ObjectMeta{
 			Name:      depName,
 			Namespace: tsuruNamespace,
+			Labels: map[string]string{
+				"tsuru.app.name":             a.GetName(),
+				"tsuru.app.process":          process,
+				"tsuru.app.process.replicas": strconv.Itoa(replicas),
+			},
 		},
 		Spec: extensions.DeploymentSpec{
-			Replicas: &replicas,
+			Replicas: &deployReplicas,
 			Template: v1.PodTemplateSpec{
 				ObjectMeta: v1.ObjectMeta{
 					Labels: map[string]string{
-						"tsuru.pod":                  strconv.FormatBool(true),
-						"tsuru.pod.build":            strconv.FormatBool(false),
-						"tsuru.app.name":             a.GetName(),
-						"tsuru.app.process":          process,
-						"tsuru.app.process.replicas": strconv.Itoa(int(replicas)),
-						"tsuru.app.platform":         a.GetPlatform(),
-						"tsuru.node.pool":            a.GetPool(),
-						"tsuru.router.name":          routerName,
-						"tsuru.router.type":          routerType,
+						"tsuru.pod":          strconv.FormatBool(true),
+						"tsuru.pod.build":    strconv.FormatBool(false),
+						"tsuru.app.name":     a.GetName(),
+						"tsuru.app.process":  process,
+						"tsuru.app.platform": a.GetPlatform(),
+						"tsuru.app.restart":  strconv.Itoa(restartCount),
+						"tsuru.node.pool":    a.GetPool(),
+						"tsuru.router.name":  routerName,
+						"tsuru.router.type":  routerType,
 					},
 				},
 				Spec: v1.PodSpec{
@@ -269,7 +444,7 @@ func (m *serviceManager) DeployService(a provision.App, process string, pState s
 		}
 		dep = nil
 	}
-	err = createAppDeployment(m.client, dep, a, process, image)
+	err = createAppDeployment(m.client, dep, a, process, image, pState)
 	if err != nil {
 		return err
 	}
@@ -9,6 +9,7 @@ import (
 	"k8s.io/client-go/kubernetes"
 	k8sErrors "k8s.io/client-go/pkg/api/errors"
 	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/util/rand"
 )
 
 func deploymentNameForApp(a provision.App, process string) string {
@@ -19,6 +20,10 @@ func deployJobNameForApp(a provision.App) string {
 	return fmt.Sprintf("%s-deploy", a.GetName())
 }
 
+func isolatedRunPodNameForApp(a provision.App) string {
+	return fmt.Sprintf("%s-isolated-run-%s", a.GetName(), rand.String(5))
+}
+
 func waitFor(timeout time.Duration, fn func() (bool, error)) error {
 	timeoutCh := time.After(timeout)
 	for {
@@ -125,3 +130,34 @@ func cleanupJob(client kubernetes.Interface, jobName string) error {
 	}
 	return nil
 }
+
+func waitForPod(client kubernetes.Interface, podName string, returnOnRunning bool, timeout time.Duration) error {
+	return waitFor(timeout, func() (bool, error) {
+		pod, err := client.Core().Pods(tsuruNamespace).Get(podName)
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+		switch pod.Status.Phase {
+		case v1.PodSucceeded:
+			return true, nil
+		case v1.PodFailed:
+			for _, contStatus := range pod.Status.ContainerStatuses {
+				if contStatus.State.Terminated != nil {
+					return false, errors.Errorf("pod %s failed: exit code %d", podName, contStatus.State.Terminated.ExitCode)
+				}
+			}
+			return false, errors.Errorf("pod %s failed: %s", podName, pod.Status.Message)
+		case v1.PodRunning:
+			return returnOnRunning, nil
+		}
+		return false, nil
+	})
+}
+
+func cleanupPod(client kubernetes.Interface, podName string) error {
+	err := client.Core().Pods(tsuruNamespace).Delete(podName, &v1.DeleteOptions{})
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -5,10 +5,12 @@
 package kubernetes
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -24,6 +26,7 @@ import (
 	"k8s.io/client-go/pkg/api/v1"
 	policy "k8s.io/client-go/pkg/apis/policy/v1beta1"
 	"k8s.io/client-go/pkg/fields"
+	"k8s.io/kubernetes/pkg/util/term"
 )
 
 const (
@@ -31,6 +34,7 @@ const (
 	tsuruNamespace         = "default"
 	dockerImageName        = "docker:1.11.2"
 	defaultBuildJobTimeout = 30 * time.Minute
+	defaultRunPodTimeout   = time.Hour
 )
 
 var errNotImplemented = errors.New("not implemented")
@@ -80,24 +84,80 @@ func (p *kubernetesProvisioner) Destroy(a provision.App) error {
 	return nil
 }
 
-func (p *kubernetesProvisioner) AddUnits(provision.App, uint, string, io.Writer) error {
-	return errNotImplemented
+func changeUnits(a provision.App, units int, processName string, w io.Writer) error {
+	if a.GetDeploys() == 0 {
+		return errors.New("units can only be modified after the first deploy")
+	}
+	if units == 0 {
+		return errors.New("cannot change 0 units")
+	}
+	imgID, err := image.AppCurrentImageName(a.GetName())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if processName == "" {
+		_, processName, err = dockercommon.ProcessCmdForImage(processName, imgID)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return deployProcesses(a, imgID, servicecommon.ProcessSpec{processName: servicecommon.ProcessState{Increment: units}})
 }
 
-func (p *kubernetesProvisioner) RemoveUnits(provision.App, uint, string, io.Writer) error {
-	return errNotImplemented
+func (p *kubernetesProvisioner) AddUnits(a provision.App, units uint, processName string, w io.Writer) error {
+	return changeUnits(a, int(units), processName, w)
 }
 
-func (p *kubernetesProvisioner) Restart(provision.App, string, io.Writer) error {
-	return errNotImplemented
+func (p *kubernetesProvisioner) RemoveUnits(a provision.App, units uint, processName string, w io.Writer) error {
+	return changeUnits(a, -int(units), processName, w)
 }
 
-func (p *kubernetesProvisioner) Start(provision.App, string) error {
-	return errNotImplemented
+func changeAppState(a provision.App, process string, state servicecommon.ProcessState) error {
+	imgID, err := image.AppCurrentImageName(a.GetName())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	var processes []string
+	if process == "" {
+		var data image.ImageMetadata
+		data, err = image.GetImageCustomData(imgID)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for procName := range data.Processes {
+			processes = append(processes, procName)
+		}
+	} else {
+		processes = []string{process}
+	}
+	spec := servicecommon.ProcessSpec{}
+	for _, procName := range processes {
+		spec[procName] = state
+	}
+	return deployProcesses(a, imgID, spec)
 }
 
-func (p *kubernetesProvisioner) Stop(provision.App, string) error {
-	return errNotImplemented
+func (p *kubernetesProvisioner) Restart(a provision.App, process string, w io.Writer) error {
+	return changeAppState(a, process, servicecommon.ProcessState{Start: true, Restart: true})
+}
+
+func (p *kubernetesProvisioner) Start(a provision.App, process string) error {
+	return changeAppState(a, process, servicecommon.ProcessState{Start: true})
+}
+
+func (p *kubernetesProvisioner) Stop(a provision.App, process string) error {
+	return changeAppState(a, process, servicecommon.ProcessState{Stop: true})
+}
+
+func deployProcesses(a provision.App, newImg string, updateSpec servicecommon.ProcessSpec) error {
+	client, err := getClusterClient()
+	if err != nil {
+		return err
+	}
+	manager := &serviceManager{
+		client: client,
+	}
+	return servicecommon.RunServicePipeline(manager, a, newImg, updateSpec)
 }
 
 var stateMap = map[v1.PodPhase]provision.Status{
@@ -146,6 +206,209 @@ func (p *kubernetesProvisioner) Units(a provision.App) ([]provision.Unit, error)
 	return units, nil
 }
 
+func runningPodsForApp(client kubernetes.Interface, a provision.App, unit string) ([]v1.Pod, error) {
+	pods, err := client.Core().Pods(tsuruNamespace).List(v1.ListOptions{
+		LabelSelector: fmt.Sprintf("tsuru.app.name=%s,tsuru.pod.build=false,tsuru.pod.isolated.run!=true", a.GetName()),
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var running []v1.Pod
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != v1.PodRunning {
+			continue
+		}
+		if unit != "" && pod.Name != unit {
+			continue
+		}
+		running = append(running, pod)
+	}
+	return running, nil
+}
+
+func (p *kubernetesProvisioner) Shell(opts provision.ShellOptions) error {
+	client, err := getClusterClient()
+	if err != nil {
+		return err
+	}
+	pods, err := runningPodsForApp(client, opts.App, opts.Unit)
+	if err != nil {
+		return err
+	}
+	if len(pods) == 0 {
+		if opts.Unit != "" {
+			return &provision.UnitNotFoundError{ID: opts.Unit}
+		}
+		return provision.ErrEmptyApp
+	}
+	var size *term.Size
+	if opts.Width != 0 && opts.Height != 0 {
+		size = &term.Size{Width: uint16(opts.Width), Height: uint16(opts.Height)}
+	}
+	return doExec(execOpts{
+		podName:       pods[0].Name,
+		containerName: pods[0].Spec.Containers[0].Name,
+		cmds:          []string{"/usr/bin/env", "TERM=" + opts.Term, "bash", "-l"},
+		stdin:         opts.Conn,
+		stdout:        opts.Conn,
+		tty:           true,
+		termSize:      size,
+	})
+}
+
+func execInPod(pod *v1.Pod, stdout, stderr io.Writer, cmd string, args ...string) error {
+	cmds := []string{"/bin/bash", "-lc", cmd}
+	cmds = append(cmds, args...)
+	return doExec(execOpts{
+		podName:       pod.Name,
+		containerName: pod.Spec.Containers[0].Name,
+		cmds:          cmds,
+		stdout:        stdout,
+		stderr:        stderr,
+	})
+}
+
+func (p *kubernetesProvisioner) ExecuteCommand(stdout, stderr io.Writer, a provision.App, cmd string, args ...string) error {
+	client, err := getClusterClient()
+	if err != nil {
+		return err
+	}
+	pods, err := runningPodsForApp(client, a, "")
+	if err != nil {
+		return err
+	}
+	if len(pods) == 0 {
+		return provision.ErrEmptyApp
+	}
+	for i := range pods {
+		err = execInPod(&pods[i], stdout, stderr, cmd, args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *kubernetesProvisioner) ExecuteCommandOnce(stdout, stderr io.Writer, a provision.App, cmd string, args ...string) error {
+	client, err := getClusterClient()
+	if err != nil {
+		return err
+	}
+	pods, err := runningPodsForApp(client, a, "")
+	if err != nil {
+		return err
+	}
+	if len(pods) == 0 {
+		return provision.ErrEmptyApp
+	}
+	return execInPod(&pods[0], stdout, stderr, cmd, args...)
+}
+
+func (p *kubernetesProvisioner) ExecuteCommandIsolated(stdout, stderr io.Writer, a provision.App, cmd string, args ...string) error {
+	if a.GetDeploys() == 0 {
+		return errors.New("commands can only be executed after the first deploy")
+	}
+	client, err := getClusterClient()
+	if err != nil {
+		return err
+	}
+	cmds := []string{"/bin/bash", "-lc", cmd}
+	cmds = append(cmds, args...)
+	return runIsolatedCmdPod(client, a, execOpts{
+		cmds:   cmds,
+		stdout: stdout,
+		stderr: stderr,
+	})
+}
+
+// runIsolatedCmdPod runs the cmds of opts in an ephemeral pod with the app
+// image and envs. Its output is streamed like in doExec while the pod is
+// running, falling back to the pod logs when it finishes before being
+// attached. The pod is always removed.
+func runIsolatedCmdPod(client kubernetes.Interface, a provision.App, opts execOpts) error {
+	img, err := image.AppCurrentImageName(a.GetName())
+	if err != nil {
+		return err
+	}
+	podName := isolatedRunPodNameForApp(a)
+	pod := &v1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      podName,
+			Namespace: tsuruNamespace,
+			Labels: map[string]string{
+				"tsuru.pod":              strconv.FormatBool(true),
+				"tsuru.pod.build":        strconv.FormatBool(false),
+				"tsuru.pod.isolated.run": strconv.FormatBool(true),
+				"tsuru.app.name":         a.GetName(),
+				"tsuru.app.platform":     a.GetPlatform(),
+				"tsuru.node.pool":        a.GetPool(),
+			},
+		},
+		Spec: v1.PodSpec{
+			RestartPolicy: v1.RestartPolicyNever,
+			Containers: []v1.Container{
+				{
+					Name:      podName,
+					Image:     img,
+					Command:   opts.cmds,
+					Env:       envsForApp(a),
+					Stdin:     opts.stdin != nil,
+					StdinOnce: opts.stdin != nil,
+					TTY:       opts.tty,
+				},
+			},
+		},
+	}
+	defer func() {
+		if cleanupErr := cleanupPod(client, podName); cleanupErr != nil {
+			log.Errorf("unable to remove isolated run pod %s: %v", podName, cleanupErr)
+		}
+	}()
+	_, err = client.Core().Pods(tsuruNamespace).Create(pod)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = waitForPod(client, podName, true, defaultRunPodTimeout)
+	if err != nil {
+		return err
+	}
+	pod, err = client.Core().Pods(tsuruNamespace).Get(podName)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if pod.Status.Phase == v1.PodRunning {
+		opts.podName = podName
+		opts.containerName = podName
+		if opts.tty {
+			opts.stderr = nil
+		}
+		err = doAttachStreams(opts)
+	} else {
+		err = copyPodLogs(client, podName, opts.stdout)
+	}
+	if err != nil {
+		return err
+	}
+	return waitForPod(client, podName, false, defaultRunPodTimeout)
+}
+
+func copyPodLogs(client kubernetes.Interface, podName string, w io.Writer) error {
+	req := client.Core().Pods(tsuruNamespace).GetLogs(podName, &v1.PodLogOptions{
+		Follow:    true,
+		Container: podName,
+	})
+	reader, err := req.Stream()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer reader.Close()
+	_, err = io.Copy(w, reader)
+	if err != nil && err != io.EOF {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
 func (p *kubernetesProvisioner) RoutableAddresses(a provision.App) ([]url.URL, error) {
 	client, err := getClusterClient()
 	if err != nil {
@@ -408,33 +671,137 @@ func (p *kubernetesProvisioner) UploadDeploy(a provision.App, archiveFile io.Rea
 		sourceImage:      buildingImage,
 		destinationImage: buildingImage,
 	}
-	podName, err := createBuildJob(params)
+	_, err = runBuildJob(params, evt)
 	if err != nil {
 		return "", err
 	}
-	req := client.Core().Pods(tsuruNamespace).GetLogs(podName, &v1.PodLogOptions{
-		Follow:    true,
-		Container: deployJobName,
-	})
-	reader, err := req.Stream()
+	err = deployProcesses(a, buildingImage, nil)
 	if err != nil {
 		return "", errors.WithStack(err)
 	}
-	defer reader.Close()
-	_, err = io.Copy(evt, reader)
-	if err != nil && err != io.EOF {
+	return buildingImage, nil
+}
+
+func (p *kubernetesProvisioner) ArchiveDeploy(a provision.App, archiveURL string, evt *event.Event) (string, error) {
+	return p.buildAndDeploy(a, image.GetBuildImage(a), archiveURL, evt)
+}
+
+func (p *kubernetesProvisioner) Rebuild(a provision.App, evt *event.Event) (string, error) {
+	currentImage, err := image.AppCurrentImageName(a.GetName())
+	if err != nil {
+		return "", errors.Errorf("App %s image not found", a.GetName())
+	}
+	return p.buildAndDeploy(a, currentImage, "file:///home/application/archive.tar.gz", evt)
+}
+
+func (p *kubernetesProvisioner) buildAndDeploy(a provision.App, sourceImage, archiveURL string, evt *event.Event) (string, error) {
+	buildingImage, err := image.AppNewImageName(a.GetName())
+	if err != nil {
 		return "", errors.WithStack(err)
 	}
-	err = waitForJob(client, deployJobName, defaultBuildJobTimeout, false)
+	client, err := getClusterClient()
 	if err != nil {
 		return "", err
 	}
-	manager := &serviceManager{
-		client: client,
+	defer cleanupJob(client, deployJobNameForApp(a))
+	params := buildJobParams{
+		app:              a,
+		client:           client,
+		buildCmd:         dockercommon.ArchiveDeployCmds(a, archiveURL),
+		sourceImage:      sourceImage,
+		destinationImage: buildingImage,
 	}
-	err = servicecommon.RunServicePipeline(manager, a, buildingImage, nil)
+	_, err = runBuildJob(params, evt)
+	if err != nil {
+		return "", err
+	}
+	err = deployProcesses(a, buildingImage, nil)
 	if err != nil {
 		return "", errors.WithStack(err)
 	}
 	return buildingImage, nil
 }
+
+func (p *kubernetesProvisioner) ImageDeploy(a provision.App, imgID string, evt *event.Event) (string, error) {
+	if !strings.Contains(imgID, ":") {
+		imgID = fmt.Sprintf("%s:latest", imgID)
+	}
+	newImage, err := image.AppNewImageName(a.GetName())
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	client, err := getClusterClient()
+	if err != nil {
+		return "", err
+	}
+	defer cleanupJob(client, deployJobNameForApp(a))
+	fmt.Fprintln(evt, "---- Pulling image to tsuru ----")
+	var procfileBuf bytes.Buffer
+	params := buildJobParams{
+		app:              a,
+		client:           client,
+		buildCmd:         []string{"/bin/sh", "-c", "cat /home/application/current/Procfile || cat /app/user/Procfile || cat /Procfile || true"},
+		sourceImage:      imgID,
+		destinationImage: newImage,
+		inspectSource:    true,
+	}
+	podName, err := runBuildJob(params, &procfileBuf)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintln(evt, "---- Getting process from image ----")
+	imgConfig, err := inspectedSourceConfig(client, podName)
+	if err != nil {
+		return "", err
+	}
+	procfile := image.GetProcessesFromProcfile(procfileBuf.String())
+	if len(procfile) == 0 {
+		fmt.Fprintln(evt, "  ---> Procfile not found, using entrypoint and cmd")
+		procfile["web"] = append(imgConfig.Entrypoint, imgConfig.Cmd...)
+	}
+	for k, v := range procfile {
+		fmt.Fprintf(evt, "  ---> Process %q found with commands: %q\n", k, v)
+	}
+	if len(imgConfig.ExposedPorts) > 1 {
+		return "", errors.New("Too many ports. You should especify which one you want to.")
+	}
+	imageData := image.ImageMetadata{
+		Name:      newImage,
+		Processes: procfile,
+	}
+	for k := range imgConfig.ExposedPorts {
+		imageData.ExposedPort = k
+	}
+	err = imageData.Save()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	a.SetUpdatePlatform(true)
+	err = deployProcesses(a, newImage, nil)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return newImage, nil
+}
+
+func (p *kubernetesProvisioner) Rollback(a provision.App, imgID string, evt *event.Event) (string, error) {
+	validImgs, err := image.ListValidAppImages(a.GetName())
+	if err != nil {
+		return "", err
+	}
+	valid := false
+	for _, img := range validImgs {
+		if img == imgID {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return "", errors.Errorf("Image %q not found in app", imgID)
+	}
+	err = deployProcesses(a, imgID, nil)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return imgID, nil
+}
@@ -6,15 +6,23 @@ package kubernetes
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"sort"
+	"strings"
 
 	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/app/image"
 	"github.com/tsuru/tsuru/event"
 	"github.com/tsuru/tsuru/permission"
 	"github.com/tsuru/tsuru/provision"
 	"gopkg.in/check.v1"
 	"k8s.io/client-go/pkg/api/v1"
+	batch "k8s.io/client-go/pkg/apis/batch/v1"
 	"k8s.io/client-go/pkg/runtime"
 	ktesting "k8s.io/client-go/testing"
 )
@@ -153,3 +161,433 @@ func (s *S) TestUploadDeploy(c *check.C) {
 	sort.Strings(depNames)
 	c.Assert(depNames, check.DeepEquals, []string{"myapp-web", "myapp-worker"})
 }
+
+func (s *S) prepareDeployedApp(c *check.C, urls ...string) *app.App {
+	s.mockfakeNodes(c, urls...)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name, Deploys: 1}
+	err := app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	imgName := "myapp:v1"
+	err = image.SaveImageCustomData(imgName, map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web":    "python myapp.py",
+			"worker": "python myworker.py",
+		},
+	})
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName(a.GetName(), imgName)
+	c.Assert(err, check.IsNil)
+	return a
+}
+
+func (s *S) deploymentReplicas(c *check.C, a provision.App, process string) (int32, string) {
+	dep, err := s.client.Extensions().Deployments(tsuruNamespace).Get(deploymentNameForApp(a, process))
+	c.Assert(err, check.IsNil)
+	c.Assert(dep.Spec.Replicas, check.NotNil)
+	return *dep.Spec.Replicas, dep.Spec.Template.Labels["tsuru.app.restart"]
+}
+
+func (s *S) TestAddUnits(c *check.C) {
+	a := s.prepareDeployedApp(c)
+	err := s.p.AddUnits(a, 3, "worker", nil)
+	c.Assert(err, check.IsNil)
+	replicas, _ := s.deploymentReplicas(c, a, "worker")
+	c.Assert(replicas, check.Equals, int32(3))
+	err = s.p.AddUnits(a, 2, "worker", nil)
+	c.Assert(err, check.IsNil)
+	replicas, _ = s.deploymentReplicas(c, a, "worker")
+	c.Assert(replicas, check.Equals, int32(5))
+	_, err = s.client.Extensions().Deployments(tsuruNamespace).Get(deploymentNameForApp(a, "web"))
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestAddUnitsDefaultProcess(c *check.C) {
+	a := s.prepareDeployedApp(c)
+	err := s.p.AddUnits(a, 2, "", nil)
+	c.Assert(err, check.IsNil)
+	replicas, _ := s.deploymentReplicas(c, a, "web")
+	c.Assert(replicas, check.Equals, int32(2))
+}
+
+func (s *S) TestAddUnitsNotDeployed(c *check.C) {
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err := app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	err = s.p.AddUnits(a, 1, "web", nil)
+	c.Assert(err, check.ErrorMatches, "units can only be modified after the first deploy")
+}
+
+func (s *S) TestRemoveUnits(c *check.C) {
+	a := s.prepareDeployedApp(c)
+	err := s.p.AddUnits(a, 3, "web", nil)
+	c.Assert(err, check.IsNil)
+	err = s.p.RemoveUnits(a, 2, "web", nil)
+	c.Assert(err, check.IsNil)
+	replicas, _ := s.deploymentReplicas(c, a, "web")
+	c.Assert(replicas, check.Equals, int32(1))
+	err = s.p.RemoveUnits(a, 2, "web", nil)
+	c.Assert(err, check.ErrorMatches, "cannot have less than 0 units")
+	replicas, _ = s.deploymentReplicas(c, a, "web")
+	c.Assert(replicas, check.Equals, int32(1))
+}
+
+func (s *S) TestRestart(c *check.C) {
+	a := s.prepareDeployedApp(c)
+	err := s.p.AddUnits(a, 2, "web", nil)
+	c.Assert(err, check.IsNil)
+	err = s.p.Restart(a, "", nil)
+	c.Assert(err, check.IsNil)
+	replicas, restarts := s.deploymentReplicas(c, a, "web")
+	c.Assert(replicas, check.Equals, int32(2))
+	c.Assert(restarts, check.Equals, "1")
+	replicas, restarts = s.deploymentReplicas(c, a, "worker")
+	c.Assert(replicas, check.Equals, int32(1))
+	c.Assert(restarts, check.Equals, "1")
+	err = s.p.Restart(a, "web", nil)
+	c.Assert(err, check.IsNil)
+	_, restarts = s.deploymentReplicas(c, a, "web")
+	c.Assert(restarts, check.Equals, "2")
+	_, restarts = s.deploymentReplicas(c, a, "worker")
+	c.Assert(restarts, check.Equals, "1")
+}
+
+func (s *S) TestStopStart(c *check.C) {
+	a := s.prepareDeployedApp(c)
+	err := s.p.AddUnits(a, 2, "web", nil)
+	c.Assert(err, check.IsNil)
+	err = s.p.Stop(a, "")
+	c.Assert(err, check.IsNil)
+	replicas, _ := s.deploymentReplicas(c, a, "web")
+	c.Assert(replicas, check.Equals, int32(0))
+	replicas, _ = s.deploymentReplicas(c, a, "worker")
+	c.Assert(replicas, check.Equals, int32(0))
+	err = s.p.Start(a, "web")
+	c.Assert(err, check.IsNil)
+	replicas, _ = s.deploymentReplicas(c, a, "web")
+	c.Assert(replicas, check.Equals, int32(2))
+	replicas, _ = s.deploymentReplicas(c, a, "worker")
+	c.Assert(replicas, check.Equals, int32(0))
+	err = s.p.Start(a, "")
+	c.Assert(err, check.IsNil)
+	replicas, _ = s.deploymentReplicas(c, a, "worker")
+	c.Assert(replicas, check.Equals, int32(1))
+}
+
+func (s *S) newDeployEvent(c *check.C, a provision.App) *event.Event {
+	evt, err := event.New(&event.Opts{
+		Target:  event.Target{Type: event.TargetTypeApp, Value: a.GetName()},
+		Kind:    permission.PermAppDeploy,
+		Owner:   s.token,
+		Allowed: event.Allowed(permission.PermAppDeploy),
+	})
+	c.Assert(err, check.IsNil)
+	return evt
+}
+
+func (s *S) TestArchiveDeploy(c *check.C) {
+	srv := s.createDeployReadyServer(c)
+	defer srv.Close()
+	s.mockfakeNodes(c, srv.URL)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err := app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	var buildCmd string
+	reaction := s.jobWithPodReaction(a, c)
+	s.client.PrependReactor("create", "jobs", func(action ktesting.Action) (bool, runtime.Object, error) {
+		job := action.(ktesting.CreateAction).GetObject().(*batch.Job)
+		buildCmd = strings.Join(job.Spec.Template.Spec.Containers[0].Command, " ")
+		return reaction(action)
+	})
+	img, err := s.p.ArchiveDeploy(a, "http://server/myfile.tgz", s.newDeployEvent(c, a))
+	c.Assert(err, check.IsNil, check.Commentf("%+v", err))
+	c.Assert(img, check.Equals, "tsuru/app-myapp:v1")
+	c.Assert(buildCmd, check.Matches, `.*archive http://server/myfile.tgz.*`)
+	deps, err := s.client.Extensions().Deployments(tsuruNamespace).List(v1.ListOptions{})
+	c.Assert(err, check.IsNil)
+	c.Assert(deps.Items, check.HasLen, 2)
+	imgs, err := image.ListAppImages(a.GetName())
+	c.Assert(err, check.IsNil)
+	c.Assert(imgs, check.DeepEquals, []string{"tsuru/app-myapp:v1"})
+}
+
+func (s *S) TestRebuild(c *check.C) {
+	srv := s.createDeployReadyServer(c)
+	defer srv.Close()
+	s.mockfakeNodes(c, srv.URL)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err := app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	s.client.PrependReactor("create", "jobs", s.jobWithPodReaction(a, c))
+	_, err = s.p.ArchiveDeploy(a, "http://server/myfile.tgz", s.newDeployEvent(c, a))
+	c.Assert(err, check.IsNil, check.Commentf("%+v", err))
+	var sourceImage, buildCmd string
+	s.client.PrependReactor("create", "jobs", func(action ktesting.Action) (bool, runtime.Object, error) {
+		job := action.(ktesting.CreateAction).GetObject().(*batch.Job)
+		sourceImage = job.Spec.Template.Spec.Containers[0].Image
+		buildCmd = strings.Join(job.Spec.Template.Spec.Containers[0].Command, " ")
+		return false, nil, nil
+	})
+	img, err := s.p.Rebuild(a, s.newDeployEvent(c, a))
+	c.Assert(err, check.IsNil, check.Commentf("%+v", err))
+	c.Assert(img, check.Equals, "tsuru/app-myapp:v2")
+	c.Assert(sourceImage, check.Equals, "tsuru/app-myapp:v1")
+	c.Assert(buildCmd, check.Matches, `.*archive file:///home/application/archive.tar.gz.*`)
+	current, err := image.AppCurrentImageName(a.GetName())
+	c.Assert(err, check.IsNil)
+	c.Assert(current, check.Equals, "tsuru/app-myapp:v2")
+}
+
+func (s *S) TestImageDeploy(c *check.C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/log") {
+			return
+		}
+		if r.URL.Query().Get("container") == "committer-cont" {
+			fmt.Fprintln(w, `{"Entrypoint":["/bin/sh"],"Cmd":["-c","python myapp.py"],"ExposedPorts":{"8000/tcp":{}}}`)
+			fmt.Fprintln(w, "pushing image")
+			return
+		}
+		fmt.Fprint(w, "web: python myapp.py\nworker: python myworker.py\n")
+	}))
+	defer srv.Close()
+	s.mockfakeNodes(c, srv.URL)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err := app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	var job *batch.Job
+	reaction := s.jobWithPodReaction(a, c)
+	s.client.PrependReactor("create", "jobs", func(action ktesting.Action) (bool, runtime.Object, error) {
+		job = action.(ktesting.CreateAction).GetObject().(*batch.Job)
+		return reaction(action)
+	})
+	img, err := s.p.ImageDeploy(a, "myregistry/someimage", s.newDeployEvent(c, a))
+	c.Assert(err, check.IsNil, check.Commentf("%+v", err))
+	c.Assert(img, check.Equals, "tsuru/app-myapp:v1")
+	c.Assert(job.Spec.Template.Spec.Containers[0].Image, check.Equals, "myregistry/someimage:latest")
+	c.Assert(strings.Join(job.Spec.Template.Spec.Containers[1].Command, " "), check.Matches, `(?s).*docker inspect .* myregistry/someimage:latest.*`)
+	data, err := image.GetImageCustomData(img)
+	c.Assert(err, check.IsNil)
+	c.Assert(data.Processes, check.DeepEquals, map[string][]string{
+		"web":    {"python myapp.py"},
+		"worker": {"python myworker.py"},
+	})
+	c.Assert(data.ExposedPort, check.Equals, "8000/tcp")
+	deps, err := s.client.Extensions().Deployments(tsuruNamespace).List(v1.ListOptions{})
+	c.Assert(err, check.IsNil)
+	c.Assert(deps.Items, check.HasLen, 2)
+}
+
+func (s *S) TestRollback(c *check.C) {
+	a := s.prepareDeployedApp(c)
+	err := image.SaveImageCustomData("myapp:v2", map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python myapp.py",
+		},
+	})
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName(a.GetName(), "myapp:v2")
+	c.Assert(err, check.IsNil)
+	img, err := s.p.Rollback(a, "myapp:v1", s.newDeployEvent(c, a))
+	c.Assert(err, check.IsNil)
+	c.Assert(img, check.Equals, "myapp:v1")
+	current, err := image.AppCurrentImageName(a.GetName())
+	c.Assert(err, check.IsNil)
+	c.Assert(current, check.Equals, "myapp:v1")
+	dep, err := s.client.Extensions().Deployments(tsuruNamespace).Get(deploymentNameForApp(a, "worker"))
+	c.Assert(err, check.IsNil)
+	c.Assert(dep.Spec.Template.Spec.Containers[0].Image, check.Equals, "myapp:v1")
+}
+
+func (s *S) TestRollbackInvalidImage(c *check.C) {
+	a := s.prepareDeployedApp(c)
+	_, err := s.p.Rollback(a, "myapp:v9", s.newDeployEvent(c, a))
+	c.Assert(err, check.ErrorMatches, `Image "myapp:v9" not found in app`)
+}
+
+type bufferConn struct {
+	bytes.Buffer
+}
+
+func (c *bufferConn) Close() error {
+	return nil
+}
+
+func (s *S) TestShell(c *check.C) {
+	var calls []execCall
+	srv := s.createExecServer(c, "shell output", &calls)
+	defer srv.Close()
+	s.mockfakeNodes(c, srv.URL)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err := app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	s.createRunningPod(c, a, "myapp-web-pod-1")
+	s.createRunningPod(c, a, "myapp-web-pod-2")
+	conn := &bufferConn{}
+	err = s.p.Shell(provision.ShellOptions{App: a, Conn: conn, Width: 80, Height: 24, Term: "xterm", Unit: "myapp-web-pod-2"})
+	c.Assert(err, check.IsNil)
+	c.Assert(conn.String(), check.Equals, "shell output")
+	c.Assert(calls, check.DeepEquals, []execCall{
+		{pod: "myapp-web-pod-2", container: "myapp-web", cmds: []string{"/usr/bin/env", "TERM=xterm", "bash", "-l"}, tty: true},
+	})
+}
+
+func (s *S) TestShellUnitNotFound(c *check.C) {
+	s.mockfakeNodes(c)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err := app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	err = s.p.Shell(provision.ShellOptions{App: a, Conn: &bufferConn{}})
+	c.Assert(err, check.Equals, provision.ErrEmptyApp)
+	s.createRunningPod(c, a, "myapp-web-pod-1")
+	err = s.p.Shell(provision.ShellOptions{App: a, Conn: &bufferConn{}, Unit: "otherpod"})
+	c.Assert(err, check.DeepEquals, &provision.UnitNotFoundError{ID: "otherpod"})
+}
+
+func (s *S) TestExecuteCommand(c *check.C) {
+	var calls []execCall
+	srv := s.createExecServer(c, "out\n", &calls)
+	defer srv.Close()
+	s.mockfakeNodes(c, srv.URL)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err := app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	s.createRunningPod(c, a, "myapp-web-pod-1")
+	s.createRunningPod(c, a, "myapp-web-pod-2")
+	var stdout, stderr bytes.Buffer
+	err = s.p.ExecuteCommand(&stdout, &stderr, a, "ls", "-l")
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "out\nout\n")
+	c.Assert(calls, check.HasLen, 2)
+	c.Assert(calls[0].cmds, check.DeepEquals, []string{"/bin/bash", "-lc", "ls", "-l"})
+	c.Assert(calls[0].tty, check.Equals, false)
+	stdout.Reset()
+	err = s.p.ExecuteCommandOnce(&stdout, &stderr, a, "ls", "-l")
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "out\n")
+	c.Assert(calls, check.HasLen, 3)
+}
+
+func (s *S) TestExecuteCommandNoUnits(c *check.C) {
+	s.mockfakeNodes(c)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err := app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	err = s.p.ExecuteCommand(ioutil.Discard, ioutil.Discard, a, "ls")
+	c.Assert(err, check.Equals, provision.ErrEmptyApp)
+	err = s.p.ExecuteCommandOnce(ioutil.Discard, ioutil.Discard, a, "ls")
+	c.Assert(err, check.Equals, provision.ErrEmptyApp)
+}
+
+func (s *S) TestExecuteCommandIsolated(c *check.C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/log") {
+			fmt.Fprint(w, "isolated output")
+		}
+	}))
+	defer srv.Close()
+	a := s.prepareDeployedApp(c, srv.URL)
+	err := a.SetEnvs(bind.SetEnvApp{Envs: []bind.EnvVar{{Name: "MY_ENV", Value: "myvalue", Public: true}}}, nil)
+	c.Assert(err, check.IsNil)
+	var pod *v1.Pod
+	s.client.PrependReactor("create", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		pod = action.(ktesting.CreateAction).GetObject().(*v1.Pod)
+		pod.Status.Phase = v1.PodSucceeded
+		return false, nil, nil
+	})
+	var stdout, stderr bytes.Buffer
+	err = s.p.ExecuteCommandIsolated(&stdout, &stderr, a, "ls", "-l")
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "isolated output")
+	c.Assert(pod, check.NotNil)
+	c.Assert(pod.Name, check.Matches, "myapp-isolated-run-[a-z0-9]{5}")
+	c.Assert(pod.Spec.Containers[0].Image, check.Equals, "myapp:v1")
+	c.Assert(pod.Spec.Containers[0].Command, check.DeepEquals, []string{"/bin/bash", "-lc", "ls", "-l"})
+	c.Assert(pod.Spec.Containers[0].Env, check.HasLen, len(envsForApp(a)))
+	var found bool
+	for _, env := range pod.Spec.Containers[0].Env {
+		if env.Name == "MY_ENV" {
+			found = true
+			c.Assert(env.Value, check.Equals, "myvalue")
+		}
+	}
+	c.Assert(found, check.Equals, true)
+	_, err = s.client.Core().Pods(tsuruNamespace).Get(pod.Name)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestExecuteCommandIsolatedRunning(c *check.C) {
+	var calls []execCall
+	srv := s.createStreamServer(c, "isolated output", "isolated error", &calls)
+	defer srv.Close()
+	a := s.prepareDeployedApp(c, srv.URL)
+	var pod *v1.Pod
+	s.client.PrependReactor("create", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		pod = action.(ktesting.CreateAction).GetObject().(*v1.Pod)
+		pod.Status.Phase = v1.PodRunning
+		return false, nil, nil
+	})
+	gets := 0
+	s.client.PrependReactor("get", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		gets++
+		if gets <= 2 {
+			return false, nil, nil
+		}
+		finished := *pod
+		finished.Status.Phase = v1.PodSucceeded
+		return true, &finished, nil
+	})
+	var stdout, stderr bytes.Buffer
+	err := s.p.ExecuteCommandIsolated(&stdout, &stderr, a, "ls", "-l")
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "isolated output")
+	c.Assert(stderr.String(), check.Equals, "isolated error")
+	c.Assert(calls, check.DeepEquals, []execCall{
+		{pod: pod.Name, container: pod.Name},
+	})
+	c.Assert(pod.Spec.Containers[0].TTY, check.Equals, false)
+	c.Assert(pod.Spec.Containers[0].Stdin, check.Equals, false)
+	pods, err := s.client.Core().Pods(tsuruNamespace).List(v1.ListOptions{})
+	c.Assert(err, check.IsNil)
+	c.Assert(pods.Items, check.HasLen, 0)
+}
+
+func (s *S) TestExecuteCommandIsolatedUniquePodNames(c *check.C) {
+	a := s.prepareDeployedApp(c)
+	var names []string
+	s.client.PrependReactor("create", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		pod := action.(ktesting.CreateAction).GetObject().(*v1.Pod)
+		names = append(names, pod.Name)
+		return true, nil, errors.New("create failed")
+	})
+	for i := 0; i < 2; i++ {
+		err := s.p.ExecuteCommandIsolated(ioutil.Discard, ioutil.Discard, a, "ls")
+		c.Assert(err, check.ErrorMatches, "create failed")
+	}
+	c.Assert(names, check.HasLen, 2)
+	c.Assert(names[0], check.Not(check.Equals), names[1])
+}
+
+func (s *S) TestExecuteCommandIsolatedFailure(c *check.C) {
+	a := s.prepareDeployedApp(c)
+	s.client.PrependReactor("create", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+		pod := action.(ktesting.CreateAction).GetObject().(*v1.Pod)
+		pod.Status.Phase = v1.PodFailed
+		pod.Status.ContainerStatuses = []v1.ContainerStatus{
+			{State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 2}}},
+		}
+		return false, nil, nil
+	})
+	err := s.p.ExecuteCommandIsolated(ioutil.Discard, ioutil.Discard, a, "ls")
+	c.Assert(err, check.ErrorMatches, "pod myapp-isolated-run-[a-z0-9]{5} failed: exit code 2")
+	pods, err := s.client.Core().Pods(tsuruNamespace).List(v1.ListOptions{})
+	c.Assert(err, check.IsNil)
+	c.Assert(pods.Items, check.HasLen, 0)
+}
+
+func (s *S) TestExecuteCommandIsolatedNotDeployed(c *check.C) {
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err := app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	err = s.p.ExecuteCommandIsolated(ioutil.Discard, ioutil.Discard, a, "ls")
+	c.Assert(err, check.ErrorMatches, "commands can only be executed after the first deploy")
+}
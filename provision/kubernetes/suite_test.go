@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/tsuru/config"
@@ -194,6 +195,97 @@ func (s *S) createDeployReadyServer(c *check.C) *httptest.Server {
 	return srv
 }
 
+type execCall struct {
+	pod       string
+	container string
+	cmds      []string
+	tty       bool
+}
+
+// createExecServer returns a server answering pod exec and attach requests
+// by writing output to the stdout stream of the command, recording each call.
+func (s *S) createExecServer(c *check.C, output string, calls *[]execCall) *httptest.Server {
+	return s.createStreamServer(c, output, "", calls)
+}
+
+// createStreamServer is like createExecServer, also writing errOutput to the
+// stderr stream when it's requested.
+func (s *S) createStreamServer(c *check.C, output, errOutput string, calls *[]execCall) *httptest.Server {
+	var mu sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/exec") && !strings.HasSuffix(r.URL.Path, "/attach") {
+			return
+		}
+		query := r.URL.Query()
+		parts := strings.Split(r.URL.Path, "/")
+		tty := query.Get("tty") == "true"
+		mu.Lock()
+		*calls = append(*calls, execCall{
+			pod:       parts[len(parts)-2],
+			container: query.Get("container"),
+			cmds:      query["command"],
+			tty:       tty,
+		})
+		mu.Unlock()
+		expected := 1
+		for _, param := range []string{"stdin", "stdout"} {
+			if query.Get(param) == "true" {
+				expected++
+			}
+		}
+		if query.Get("stderr") == "true" && !tty {
+			expected++
+		}
+		if tty {
+			expected++
+		}
+		_, streamErr := httpstream.Handshake(r, w, []string{"v4.channel.k8s.io"})
+		c.Assert(streamErr, check.IsNil)
+		upgrader := spdy.NewResponseUpgrader()
+		streams := make(chan httpstream.Stream, expected)
+		conn := upgrader.UpgradeResponse(w, r, func(stream httpstream.Stream, replySent <-chan struct{}) error {
+			streams <- stream
+			return nil
+		})
+		defer conn.Close()
+		var received []httpstream.Stream
+		for len(received) < expected {
+			received = append(received, <-streams)
+		}
+		for _, stream := range received {
+			switch stream.Headers().Get("streamType") {
+			case "stdout":
+				stream.Write([]byte(output))
+			case "stderr":
+				stream.Write([]byte(errOutput))
+			}
+		}
+		for _, stream := range received {
+			stream.Close()
+		}
+	}))
+}
+
+func (s *S) createRunningPod(c *check.C, a provision.App, name string) {
+	_, err := s.client.Core().Pods(tsuruNamespace).Create(&v1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      name,
+			Namespace: tsuruNamespace,
+			Labels: map[string]string{
+				"tsuru.pod":         "true",
+				"tsuru.pod.build":   "false",
+				"tsuru.app.name":    a.GetName(),
+				"tsuru.app.process": "web",
+			},
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: a.GetName() + "-web"}},
+		},
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+	})
+	c.Assert(err, check.IsNil)
+}
+
 func (s *S) jobWithPodReaction(a provision.App, c *check.C) ktesting.ReactionFunc {
 	return func(action ktesting.Action) (bool, runtime.Object, error) {
 		job := action.(ktesting.CreateAction).GetObject().(*batch.Job)
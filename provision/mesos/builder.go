@@ -0,0 +1,227 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mesos
+
+import (
+	"io"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/net"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/safe"
+)
+
+const (
+	labelBuildContainer = "tsuru.build"
+	labelBuildImage     = "tsuru.build.image"
+)
+
+var errNoBuildNode = errors.New("mesos:docker-endpoint must be set to build images in the mesos provisioner")
+
+// buildClient returns a client to the docker daemon used to build images of
+// apps running in the mesos provisioner, set in the mesos:docker-endpoint
+// config.
+func buildClient() (*docker.Client, error) {
+	endpoint, _ := config.GetString("mesos:docker-endpoint")
+	if endpoint == "" {
+		return nil, errNoBuildNode
+	}
+	client, err := docker.NewClient(endpoint)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return client, nil
+}
+
+func registryAuthConfig() docker.AuthConfiguration {
+	var authConfig docker.AuthConfiguration
+	authConfig.Email, _ = config.GetString("docker:registry-auth:email")
+	authConfig.Username, _ = config.GetString("docker:registry-auth:username")
+	authConfig.Password, _ = config.GetString("docker:registry-auth:password")
+	authConfig.ServerAddress, _ = config.GetString("docker:registry")
+	return authConfig
+}
+
+func splitImageName(img string) (string, string) {
+	parts := strings.Split(img, ":")
+	if len(parts) < 2 || strings.Contains(parts[len(parts)-1], "/") {
+		return img, "latest"
+	}
+	return strings.Join(parts[:len(parts)-1], ":"), parts[len(parts)-1]
+}
+
+func pullImage(client *docker.Client, img string, w io.Writer) error {
+	repo, tag := splitImageName(img)
+	err := client.PullImage(docker.PullImageOptions{
+		Repository:        repo,
+		Tag:               tag,
+		OutputStream:      w,
+		InactivityTimeout: net.StreamInactivityTimeout,
+	}, registryAuthConfig())
+	return errors.WithStack(err)
+}
+
+func pushImage(client *docker.Client, img string) error {
+	if _, err := config.GetString("docker:registry"); err != nil {
+		return nil
+	}
+	repo, tag := splitImageName(img)
+	var buf safe.Buffer
+	err := client.PushImage(docker.PushImageOptions{
+		Name:              repo,
+		Tag:               tag,
+		OutputStream:      &buf,
+		InactivityTimeout: net.StreamInactivityTimeout,
+		RawJSONStream:     true,
+	}, registryAuthConfig())
+	return errors.WithStack(err)
+}
+
+func removeContainer(client *docker.Client, contID string) error {
+	err := client.RemoveContainer(docker.RemoveContainerOptions{ID: contID, Force: true})
+	if _, ok := err.(*docker.NoSuchContainer); ok {
+		return nil
+	}
+	return errors.WithStack(err)
+}
+
+type buildContainerOpts struct {
+	app           provision.App
+	image         string
+	cmds          []string
+	buildingImage string
+}
+
+func createBuildContainer(client *docker.Client, opts buildContainerOpts) (string, error) {
+	cont, err := client.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{
+			Image:        opts.image,
+			Cmd:          opts.cmds,
+			AttachStdout: true,
+			AttachStderr: true,
+			Labels: map[string]string{
+				labelBuildContainer: "true",
+				labelBuildImage:     opts.buildingImage,
+				labelAppName:        opts.app.GetName(),
+			},
+		},
+	})
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return cont.ID, nil
+}
+
+// uploadToBuildContainer creates an image based on baseImage containing the
+// archive file, returning the ID of the image and the URI of the file inside
+// the image.
+func uploadToBuildContainer(client *docker.Client, a provision.App, baseImage string, archive io.Reader) (string, string, error) {
+	err := pullImage(client, baseImage, &safe.Buffer{})
+	if err != nil {
+		return "", "", err
+	}
+	contID, err := createBuildContainer(client, buildContainerOpts{
+		app:   a,
+		image: baseImage,
+		cmds:  []string{"/usr/bin/tail", "-f", "/dev/null"},
+	})
+	if err != nil {
+		return "", "", err
+	}
+	defer removeContainer(client, contID)
+	err = client.StartContainer(contID, nil)
+	if err != nil {
+		return "", "", errors.WithStack(err)
+	}
+	dirPath := "/home/application/"
+	err = client.UploadToContainer(contID, docker.UploadToContainerOptions{
+		InputStream: archive,
+		Path:        dirPath,
+	})
+	if err != nil {
+		return "", "", errors.WithStack(err)
+	}
+	img, err := client.CommitContainer(docker.CommitContainerOptions{Container: contID})
+	if err != nil {
+		return "", "", errors.WithStack(err)
+	}
+	return img.ID, "file://" + dirPath + "archive.tar.gz", nil
+}
+
+// runBuildContainer runs the commands in a container based on imgID,
+// streaming its output to w. When buildingImage is not empty, the container
+// is committed and pushed as buildingImage after the commands finish
+// successfully.
+func runBuildContainer(client *docker.Client, a provision.App, imgID string, cmds []string, buildingImage string, w io.Writer) error {
+	contID, err := createBuildContainer(client, buildContainerOpts{
+		app:           a,
+		image:         imgID,
+		cmds:          cmds,
+		buildingImage: buildingImage,
+	})
+	if err != nil {
+		return err
+	}
+	defer removeContainer(client, contID)
+	waiter, err := client.AttachToContainerNonBlocking(docker.AttachToContainerOptions{
+		Container:    contID,
+		OutputStream: w,
+		ErrorStream:  w,
+		Stream:       true,
+		Stdout:       true,
+		Stderr:       true,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer waiter.Close()
+	err = client.StartContainer(contID, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	exitCode, err := client.WaitContainer(contID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	waiter.Wait()
+	if exitCode != 0 {
+		return errors.Errorf("build container exited with status %d", exitCode)
+	}
+	if buildingImage == "" {
+		return nil
+	}
+	repo, tag := splitImageName(buildingImage)
+	_, err = client.CommitContainer(docker.CommitContainerOptions{
+		Container:  contID,
+		Repository: repo,
+		Tag:        tag,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return pushImage(client, buildingImage)
+}
+
+// buildImageForUnit returns the image being built by the build container
+// with the given ID, as reported by tsuru_unit_agent during deploys, or an
+// empty string if the unit is not a build container.
+func buildImageForUnit(client *docker.Client, a provision.App, unitID string) (string, error) {
+	containers, err := client.ListContainers(docker.ListContainersOptions{All: true})
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	for _, c := range containers {
+		if c.Labels[labelAppName] != a.GetName() || c.Labels[labelBuildContainer] != "true" {
+			continue
+		}
+		if strings.HasPrefix(c.ID, unitID) {
+			return c.Labels[labelBuildImage], nil
+		}
+	}
+	return "", nil
+}
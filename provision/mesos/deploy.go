@@ -0,0 +1,195 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mesos
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gambol99/go-marathon"
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app/image"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/dockercommon"
+	"github.com/tsuru/tsuru/provision/servicecommon"
+	"github.com/tsuru/tsuru/router"
+)
+
+const (
+	labelAppName         = "tsuru.app.name"
+	labelAppProcess      = "tsuru.app.process"
+	labelAppPlatform     = "tsuru.app.platform"
+	labelProcessReplicas = "tsuru.app.process.replicas"
+	labelAppRestart      = "tsuru.app.restart"
+	labelPoolName        = "tsuru.node.pool"
+	labelRouterName      = "tsuru.router.name"
+	labelRouterType      = "tsuru.router.type"
+
+	defaultCPUs     = 0.1
+	defaultMemoryMB = 128
+)
+
+var errNoMarathonNode = errors.New("no marathon node available")
+
+func getMarathonClient() (marathon.Marathon, error) {
+	coll, err := nodeAddrCollection()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	var data mesosNodeWrapper
+	err = coll.FindId(uniqueDocumentID).One(&data)
+	if err != nil || len(data.Addresses) == 0 {
+		return nil, errNoMarathonNode
+	}
+	cfg := marathon.NewDefaultConfig()
+	cfg.URL = data.Address()
+	client, err := marathon.NewClient(cfg)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return client, nil
+}
+
+func isNotFound(err error) bool {
+	apiErr, ok := errors.Cause(err).(*marathon.APIError)
+	return ok && apiErr.ErrCode == marathon.ErrCodeNotFound
+}
+
+func marathonAppID(a provision.App, process string) string {
+	return fmt.Sprintf("/%s-%s", a.GetName(), process)
+}
+
+func deployProcesses(a provision.App, newImg string, updateSpec servicecommon.ProcessSpec) error {
+	client, err := getMarathonClient()
+	if err != nil {
+		return err
+	}
+	manager := &serviceManager{
+		client: client,
+	}
+	return servicecommon.RunServicePipeline(manager, a, newImg, updateSpec)
+}
+
+type serviceManager struct {
+	client marathon.Marathon
+}
+
+func (m *serviceManager) RemoveService(a provision.App, process string) error {
+	_, err := m.client.DeleteApplication(marathonAppID(a, process), true)
+	if err != nil && !isNotFound(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (m *serviceManager) DeployService(a provision.App, process string, pState servicecommon.ProcessState, imgID string) error {
+	appID := marathonAppID(a, process)
+	old, err := m.client.Application(appID)
+	if err != nil {
+		if !isNotFound(err) {
+			return errors.WithStack(err)
+		}
+		old = nil
+	}
+	app, err := marathonAppForProcess(a, process, imgID, old, pState)
+	if err != nil {
+		return err
+	}
+	if old == nil {
+		_, err = m.client.CreateApplication(app)
+	} else {
+		_, err = m.client.UpdateApplication(app, true)
+	}
+	return errors.WithStack(err)
+}
+
+func marathonAppForProcess(a provision.App, process, imgID string, old *marathon.Application, pState servicecommon.ProcessState) (*marathon.Application, error) {
+	restartCount := 0
+	replicas := 0
+	if old != nil && old.Labels != nil {
+		oldLabels := *old.Labels
+		var err error
+		replicas, err = strconv.Atoi(oldLabels[labelProcessReplicas])
+		if err != nil && old.Instances != nil {
+			replicas = *old.Instances
+		}
+		restartCount, _ = strconv.Atoi(oldLabels[labelAppRestart])
+	}
+	if pState.Increment != 0 {
+		replicas += pState.Increment
+		if replicas < 0 {
+			return nil, errors.New("cannot have less than 0 units")
+		}
+	} else if replicas == 0 && pState.Start {
+		replicas = 1
+	}
+	instances := replicas
+	if pState.Stop {
+		instances = 0
+	}
+	if pState.Restart {
+		restartCount++
+	}
+	routerName, err := a.GetRouterName()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	routerType, _, err := router.Type(routerName)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	cmds, _, err := dockercommon.LeanContainerCmds(process, imgID, a)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	webProcessName, err := image.GetImageWebProcessName(imgID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	port := dockercommon.WebProcessDefaultPort()
+	portInt, _ := strconv.Atoi(port)
+	host, _ := config.GetString("host")
+	app := marathon.NewDockerApplication().
+		Name(marathonAppID(a, process)).
+		CPU(cpusForApp(a)).
+		Memory(memoryForApp(a)).
+		Count(instances).
+		AddArgs(cmds...)
+	for _, envData := range a.Envs() {
+		app.AddEnv(envData.Name, envData.Value)
+	}
+	app.AddEnv("TSURU_HOST", host)
+	app.AddEnv("port", port)
+	app.AddEnv("PORT", port)
+	app.AddLabel(labelAppName, a.GetName())
+	app.AddLabel(labelAppProcess, process)
+	app.AddLabel(labelAppPlatform, a.GetPlatform())
+	app.AddLabel(labelProcessReplicas, strconv.Itoa(replicas))
+	app.AddLabel(labelAppRestart, strconv.Itoa(restartCount))
+	app.AddLabel(labelPoolName, a.GetPool())
+	app.AddLabel(labelRouterName, routerName)
+	app.AddLabel(labelRouterType, routerType)
+	docker := app.Container.Docker.Container(imgID).Bridged()
+	if process == webProcessName {
+		docker.Expose(portInt)
+	}
+	return app, nil
+}
+
+func cpusForApp(a provision.App) float64 {
+	if a.GetCpuShare() <= 0 {
+		return defaultCPUs
+	}
+	return float64(a.GetCpuShare()) / 1024
+}
+
+func memoryForApp(a provision.App) float64 {
+	if a.GetMemory() <= 0 {
+		return defaultMemoryMB
+	}
+	return float64(a.GetMemory()) / (1024 * 1024)
+}
@@ -0,0 +1,266 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package marathontest provides a fake implementation of the Marathon REST
+// API, to be used in tests of the mesos provisioner.
+package marathontest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gambol99/go-marathon"
+)
+
+// MarathonServer is a fake Marathon server. Every application has its tasks
+// started as soon as it's created or updated. Tasks are replaced whenever
+// the configuration of the application changes, and added or removed when
+// only the number of instances changes.
+type MarathonServer struct {
+	// TaskHost is the host assigned to every task started by the server.
+	TaskHost string
+
+	listener net.Listener
+	mu       sync.Mutex
+	apps     map[string]*marathon.Application
+	taskSeq  int
+	portSeq  int
+}
+
+// NewServer starts a new fake Marathon server, listening on the given
+// address.
+func NewServer(bind string) (*MarathonServer, error) {
+	listener, err := net.Listen("tcp", bind)
+	if err != nil {
+		return nil, err
+	}
+	server := &MarathonServer{
+		TaskHost: "127.0.0.1",
+		listener: listener,
+		apps:     make(map[string]*marathon.Application),
+		portSeq:  31000,
+	}
+	go http.Serve(listener, server)
+	return server, nil
+}
+
+// URL returns the URL of the server.
+func (s *MarathonServer) URL() string {
+	return "http://" + s.listener.Addr().String()
+}
+
+// Stop stops the server.
+func (s *MarathonServer) Stop() {
+	s.listener.Close()
+}
+
+// Apps returns a copy of every application in the server.
+func (s *MarathonServer) Apps() []marathon.Application {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	apps := make([]marathon.Application, 0, len(s.apps))
+	for _, app := range s.apps {
+		apps = append(apps, *app)
+	}
+	return apps
+}
+
+// App returns a copy of the application with the given id, or nil when it
+// doesn't exist.
+func (s *MarathonServer) App(id string) *marathon.Application {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	app, ok := s.apps[normalizeID(id)]
+	if !ok {
+		return nil
+	}
+	appCopy := *app
+	return &appCopy
+}
+
+func (s *MarathonServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := r.URL.Path
+	switch {
+	case path == "/ping":
+		fmt.Fprint(w, "pong")
+	case path == "/v2/apps" && r.Method == "GET":
+		s.listApps(w, r)
+	case path == "/v2/apps" && r.Method == "POST":
+		s.createApp(w, r)
+	case strings.HasPrefix(path, "/v2/apps/") && strings.HasSuffix(path, "/tasks") && r.Method == "GET":
+		s.appTasks(w, strings.TrimSuffix(strings.TrimPrefix(path, "/v2/apps"), "/tasks"))
+	case strings.HasPrefix(path, "/v2/apps/") && r.Method == "GET":
+		s.getApp(w, strings.TrimPrefix(path, "/v2/apps"))
+	case strings.HasPrefix(path, "/v2/apps/") && r.Method == "PUT":
+		s.updateApp(w, r, strings.TrimPrefix(path, "/v2/apps"))
+	case strings.HasPrefix(path, "/v2/apps/") && r.Method == "DELETE":
+		s.deleteApp(w, strings.TrimPrefix(path, "/v2/apps"))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown path %s %s", r.Method, path))
+	}
+}
+
+func normalizeID(id string) string {
+	return "/" + strings.Trim(id, "/")
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+func writeJSON(w http.ResponseWriter, code int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(data)
+}
+
+func deploymentResult() map[string]string {
+	now := time.Now().UTC().Format(time.RFC3339)
+	return map[string]string{"deploymentId": "deployment-" + now, "version": now}
+}
+
+// matchLabels checks the app labels against a selector in the format
+// accepted by the label query parameter of Marathon, supporting only
+// comma separated key==value pairs.
+func matchLabels(app *marathon.Application, selector string) bool {
+	if selector == "" {
+		return true
+	}
+	var labels map[string]string
+	if app.Labels != nil {
+		labels = *app.Labels
+	}
+	for _, part := range strings.Split(selector, ",") {
+		kv := strings.SplitN(part, "==", 2)
+		if len(kv) != 2 {
+			return false
+		}
+		if labels[strings.TrimSpace(kv[0])] != strings.TrimSpace(kv[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *MarathonServer) listApps(w http.ResponseWriter, r *http.Request) {
+	apps := []marathon.Application{}
+	for _, app := range s.apps {
+		if matchLabels(app, r.URL.Query().Get("label")) {
+			apps = append(apps, *app)
+		}
+	}
+	writeJSON(w, http.StatusOK, marathon.Applications{Apps: apps})
+}
+
+func (s *MarathonServer) createApp(w http.ResponseWriter, r *http.Request) {
+	var app marathon.Application
+	err := json.NewDecoder(r.Body).Decode(&app)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	app.ID = normalizeID(app.ID)
+	if _, ok := s.apps[app.ID]; ok {
+		writeError(w, http.StatusConflict, fmt.Sprintf("An app with id [%s] already exists.", app.ID))
+		return
+	}
+	s.syncTasks(&app, nil)
+	s.apps[app.ID] = &app
+	writeJSON(w, http.StatusCreated, app)
+}
+
+func (s *MarathonServer) getApp(w http.ResponseWriter, id string) {
+	app, ok := s.apps[normalizeID(id)]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("App '%s' does not exist", normalizeID(id)))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"app": app})
+}
+
+func (s *MarathonServer) appTasks(w http.ResponseWriter, id string) {
+	app, ok := s.apps[normalizeID(id)]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("App '%s' does not exist", normalizeID(id)))
+		return
+	}
+	tasks := make([]marathon.Task, len(app.Tasks))
+	for i, t := range app.Tasks {
+		tasks[i] = *t
+	}
+	writeJSON(w, http.StatusOK, marathon.Tasks{Tasks: tasks})
+}
+
+func (s *MarathonServer) updateApp(w http.ResponseWriter, r *http.Request, id string) {
+	var app marathon.Application
+	err := json.NewDecoder(r.Body).Decode(&app)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	app.ID = normalizeID(id)
+	old := s.apps[app.ID]
+	s.syncTasks(&app, old)
+	s.apps[app.ID] = &app
+	writeJSON(w, http.StatusOK, deploymentResult())
+}
+
+func (s *MarathonServer) deleteApp(w http.ResponseWriter, id string) {
+	if _, ok := s.apps[normalizeID(id)]; !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("App '%s' does not exist", normalizeID(id)))
+		return
+	}
+	delete(s.apps, normalizeID(id))
+	writeJSON(w, http.StatusOK, deploymentResult())
+}
+
+func sameConfig(a, b *marathon.Application) bool {
+	aCopy, bCopy := *a, *b
+	aCopy.Instances, bCopy.Instances = nil, nil
+	aCopy.Tasks, bCopy.Tasks = nil, nil
+	aCopy.TasksRunning, bCopy.TasksRunning = 0, 0
+	aCopy.Version, bCopy.Version = "", ""
+	return reflect.DeepEqual(aCopy, bCopy)
+}
+
+func (s *MarathonServer) syncTasks(app, old *marathon.Application) {
+	instances := 1
+	if app.Instances != nil {
+		instances = *app.Instances
+	}
+	app.Instances = &instances
+	var tasks []*marathon.Task
+	if old != nil && sameConfig(app, old) {
+		tasks = old.Tasks
+	}
+	if len(tasks) > instances {
+		tasks = tasks[:instances]
+	}
+	for len(tasks) < instances {
+		s.taskSeq++
+		s.portSeq++
+		now := time.Now().UTC().Format(time.RFC3339)
+		tasks = append(tasks, &marathon.Task{
+			ID:        fmt.Sprintf("%s.task-%d", strings.Trim(app.ID, "/"), s.taskSeq),
+			AppID:     app.ID,
+			Host:      s.TaskHost,
+			Ports:     []int{s.portSeq},
+			StagedAt:  now,
+			StartedAt: now,
+		})
+	}
+	app.Tasks = tasks
+	app.TasksRunning = len(tasks)
+	app.Version = time.Now().UTC().Format(time.RFC3339)
+}
@@ -5,15 +5,22 @@
 package mesos
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"net/url"
 	"strings"
 
+	"github.com/fsouza/go-dockerclient"
 	"github.com/gambol99/go-marathon"
 	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/app/image"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
 	"github.com/tsuru/tsuru/event"
 	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/dockercommon"
+	"github.com/tsuru/tsuru/provision/servicecommon"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -39,40 +46,207 @@ func (p *mesosProvisioner) Provision(provision.App) error {
 	return nil
 }
 
-func (p *mesosProvisioner) Destroy(provision.App) error {
-	return errNotImplemented
+func (p *mesosProvisioner) Destroy(a provision.App) error {
+	imgID, err := image.AppCurrentImageName(a.GetName())
+	if err != nil {
+		if err == image.ErrNoImagesAvailable {
+			return nil
+		}
+		return errors.WithStack(err)
+	}
+	data, err := image.GetImageCustomData(imgID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	client, err := getMarathonClient()
+	if err != nil {
+		return err
+	}
+	manager := &serviceManager{
+		client: client,
+	}
+	multiErrors := tsuruErrors.NewMultiError()
+	for process := range data.Processes {
+		err = manager.RemoveService(a, process)
+		if err != nil {
+			multiErrors.Add(err)
+		}
+	}
+	if multiErrors.Len() > 0 {
+		return multiErrors
+	}
+	return nil
 }
 
-func (p *mesosProvisioner) AddUnits(provision.App, uint, string, io.Writer) error {
-	return errNotImplemented
+func changeUnits(a provision.App, units int, processName string, w io.Writer) error {
+	if a.GetDeploys() == 0 {
+		return errors.New("units can only be modified after the first deploy")
+	}
+	if units == 0 {
+		return errors.New("cannot change 0 units")
+	}
+	imgID, err := image.AppCurrentImageName(a.GetName())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if processName == "" {
+		_, processName, err = dockercommon.ProcessCmdForImage(processName, imgID)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return deployProcesses(a, imgID, servicecommon.ProcessSpec{processName: servicecommon.ProcessState{Increment: units}})
 }
 
-func (p *mesosProvisioner) RemoveUnits(provision.App, uint, string, io.Writer) error {
-	return errNotImplemented
+func (p *mesosProvisioner) AddUnits(a provision.App, units uint, processName string, w io.Writer) error {
+	return changeUnits(a, int(units), processName, w)
 }
 
-func (p *mesosProvisioner) Restart(provision.App, string, io.Writer) error {
-	return errNotImplemented
+func (p *mesosProvisioner) RemoveUnits(a provision.App, units uint, processName string, w io.Writer) error {
+	return changeUnits(a, -int(units), processName, w)
 }
 
-func (p *mesosProvisioner) Start(provision.App, string) error {
-	return errNotImplemented
+func changeAppState(a provision.App, process string, state servicecommon.ProcessState) error {
+	imgID, err := image.AppCurrentImageName(a.GetName())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	var processes []string
+	if process == "" {
+		var data image.ImageMetadata
+		data, err = image.GetImageCustomData(imgID)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for procName := range data.Processes {
+			processes = append(processes, procName)
+		}
+	} else {
+		processes = []string{process}
+	}
+	spec := servicecommon.ProcessSpec{}
+	for _, procName := range processes {
+		spec[procName] = state
+	}
+	return deployProcesses(a, imgID, spec)
 }
 
-func (p *mesosProvisioner) Stop(provision.App, string) error {
-	return errNotImplemented
+func (p *mesosProvisioner) Restart(a provision.App, process string, w io.Writer) error {
+	return changeAppState(a, process, servicecommon.ProcessState{Start: true, Restart: true})
 }
 
-func (p *mesosProvisioner) Units(app provision.App) ([]provision.Unit, error) {
-	return nil, nil
+func (p *mesosProvisioner) Start(a provision.App, process string) error {
+	return changeAppState(a, process, servicecommon.ProcessState{Start: true})
 }
 
-func (p *mesosProvisioner) RoutableAddresses(app provision.App) ([]url.URL, error) {
-	return nil, errNotImplemented
+func (p *mesosProvisioner) Stop(a provision.App, process string) error {
+	return changeAppState(a, process, servicecommon.ProcessState{Stop: true})
 }
 
-func (p *mesosProvisioner) RegisterUnit(a provision.App, unitId string, customData map[string]interface{}) error {
-	return errNotImplemented
+func taskToUnit(task *marathon.Task, mApp *marathon.Application, a provision.App) provision.Unit {
+	var labels map[string]string
+	if mApp.Labels != nil {
+		labels = *mApp.Labels
+	}
+	status := provision.StatusStarting
+	if task.StartedAt != "" {
+		status = provision.StatusStarted
+	}
+	addr := &url.URL{Scheme: "http", Host: task.Host}
+	if len(task.Ports) > 0 {
+		addr.Host = fmt.Sprintf("%s:%d", task.Host, task.Ports[0])
+	}
+	return provision.Unit{
+		ID:          task.ID,
+		AppName:     a.GetName(),
+		ProcessName: labels[labelAppProcess],
+		Type:        a.GetPlatform(),
+		Ip:          task.Host,
+		Status:      status,
+		Address:     addr,
+	}
+}
+
+func (p *mesosProvisioner) Units(a provision.App) ([]provision.Unit, error) {
+	client, err := getMarathonClient()
+	if err != nil {
+		if err == errNoMarathonNode {
+			return nil, nil
+		}
+		return nil, err
+	}
+	apps, err := client.Applications(url.Values{
+		"label": []string{fmt.Sprintf("%s==%s", labelAppName, a.GetName())},
+		"embed": []string{"apps.tasks"},
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	units := []provision.Unit{}
+	for i := range apps.Apps {
+		mApp := &apps.Apps[i]
+		for _, task := range mApp.Tasks {
+			units = append(units, taskToUnit(task, mApp, a))
+		}
+	}
+	return units, nil
+}
+
+func (p *mesosProvisioner) RoutableAddresses(a provision.App) ([]url.URL, error) {
+	imgID, err := image.AppCurrentImageName(a.GetName())
+	if err != nil {
+		if err != image.ErrNoImagesAvailable {
+			return nil, err
+		}
+		return nil, nil
+	}
+	webProcessName, err := image.GetImageWebProcessName(imgID)
+	if err != nil {
+		return nil, err
+	}
+	if webProcessName == "" {
+		return nil, nil
+	}
+	client, err := getMarathonClient()
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := client.Tasks(marathonAppID(a, webProcessName))
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+	var addrs []url.URL
+	for _, task := range tasks.Tasks {
+		if len(task.Ports) == 0 {
+			continue
+		}
+		addrs = append(addrs, url.URL{
+			Scheme: "http",
+			Host:   fmt.Sprintf("%s:%d", task.Host, task.Ports[0]),
+		})
+	}
+	return addrs, nil
+}
+
+func (p *mesosProvisioner) RegisterUnit(a provision.App, unitID string, customData map[string]interface{}) error {
+	if customData == nil {
+		return nil
+	}
+	client, err := buildClient()
+	if err != nil {
+		return err
+	}
+	buildingImage, err := buildImageForUnit(client, a, unitID)
+	if err != nil {
+		return err
+	}
+	if buildingImage == "" {
+		return nil
+	}
+	return image.SaveImageCustomData(buildingImage, customData)
 }
 
 func (p *mesosProvisioner) ListNodes(addressFilter []string) ([]provision.Node, error) {
@@ -125,7 +299,22 @@ func (p *mesosProvisioner) AddNode(opts provision.AddNodeOptions) error {
 }
 
 func (p *mesosProvisioner) RemoveNode(opts provision.RemoveNodeOptions) error {
-	return errNotImplemented
+	if opts.Address != "" {
+		_, err := p.GetNode(opts.Address)
+		if err != nil {
+			return err
+		}
+	}
+	coll, err := nodeAddrCollection()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	err = coll.RemoveId(uniqueDocumentID)
+	if err != nil && err != mgo.ErrNotFound {
+		return errors.WithStack(err)
+	}
+	return nil
 }
 
 func (p *mesosProvisioner) UpdateNode(provision.UpdateNodeOptions) error {
@@ -136,27 +325,89 @@ func (p *mesosProvisioner) NodeForNodeData(nodeData provision.NodeStatusData) (p
 	return provision.FindNodeByAddrs(p, nodeData.Addrs)
 }
 
-func (p *mesosProvisioner) ArchiveDeploy(app provision.App, archiveURL string, evt *event.Event) (imgID string, err error) {
-	return "", errNotImplemented
+func (p *mesosProvisioner) ArchiveDeploy(a provision.App, archiveURL string, evt *event.Event) (string, error) {
+	client, err := buildClient()
+	if err != nil {
+		return "", err
+	}
+	baseImage := image.GetBuildImage(a)
+	err = pullImage(client, baseImage, evt)
+	if err != nil {
+		return "", err
+	}
+	return buildAndDeploy(client, a, baseImage, archiveURL, evt)
 }
 
-func (p *mesosProvisioner) ImageDeploy(a provision.App, imgID string, evt *event.Event) (string, error) {
-	hosts, err := p.ListNodes(nil)
+func (p *mesosProvisioner) UploadDeploy(a provision.App, archiveFile io.ReadCloser, fileSize int64, build bool, evt *event.Event) (string, error) {
+	defer archiveFile.Close()
+	if build {
+		return "", errors.New("running UploadDeploy with build=true is not yet supported")
+	}
+	client, err := buildClient()
+	if err != nil {
+		return "", err
+	}
+	tarFile := dockercommon.AddDeployTarFile(archiveFile, fileSize, "archive.tar.gz")
+	defer tarFile.Close()
+	imgID, fileURI, err := uploadToBuildContainer(client, a, image.GetBuildImage(a), tarFile)
+	if err != nil {
+		return "", err
+	}
+	return buildAndDeploy(client, a, imgID, fileURI, evt)
+}
+
+func buildAndDeploy(client *docker.Client, a provision.App, imgID, archiveURL string, evt *event.Event) (string, error) {
+	buildingImage, err := image.AppNewImageName(a.GetName())
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	cmds := dockercommon.ArchiveDeployCmds(a, archiveURL)
+	err = runBuildContainer(client, a, imgID, cmds, buildingImage, evt)
 	if err != nil {
 		return "", err
 	}
-	marathonURL := hosts[0].Address()
-	config := marathon.NewDefaultConfig()
-	config.URL = marathonURL
-	client, err := marathon.NewClient(config)
+	err = deployProcesses(a, buildingImage, nil)
+	if err != nil {
+		return "", err
+	}
+	return buildingImage, nil
+}
+
+func (p *mesosProvisioner) ImageDeploy(a provision.App, imgID string, evt *event.Event) (string, error) {
+	client, err := buildClient()
 	if err != nil {
 		return "", err
 	}
-	application := marathon.NewDockerApplication().Name(a.GetName()).CPU(0.1).Memory(64).Count(1)
 	if !strings.Contains(imgID, ":") {
 		imgID = fmt.Sprintf("%s:latest", imgID)
 	}
-	application.Container.Docker.Container(imgID)
-	_, err = client.CreateApplication(application)
-	return "", err
+	fmt.Fprintln(evt, "---- Pulling image to tsuru ----")
+	err = pullImage(client, imgID, evt)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintln(evt, "---- Getting process from image ----")
+	var buf bytes.Buffer
+	cmds := []string{"/bin/sh", "-c", "cat /home/application/current/Procfile || cat /app/user/Procfile || cat /Procfile || true"}
+	err = runBuildContainer(client, a, imgID, cmds, "", &buf)
+	if err != nil {
+		return "", err
+	}
+	newImage, err := dockercommon.PrepareImageForDeploy(dockercommon.PrepareImageArgs{
+		Client:      client,
+		App:         a,
+		ProcfileRaw: buf.String(),
+		ImageId:     imgID,
+		AuthConfig:  registryAuthConfig(),
+		Out:         evt,
+	})
+	if err != nil {
+		return "", err
+	}
+	a.SetUpdatePlatform(true)
+	err = deployProcesses(a, newImage, nil)
+	if err != nil {
+		return "", err
+	}
+	return newImage, nil
 }
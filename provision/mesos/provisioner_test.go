@@ -5,7 +5,15 @@
 package mesos
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+
+	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/app/image"
 	"github.com/tsuru/tsuru/event"
 	"github.com/tsuru/tsuru/permission"
 	"github.com/tsuru/tsuru/provision"
@@ -76,17 +84,18 @@ func (s *S) TestGetNode(c *check.C) {
 	c.Assert(node, check.IsNil)
 }
 
-func (s *S) TestImageDeploy(c *check.C) {
-	url := "https://192.168.99.100:8443"
-	opts := provision.AddNodeOptions{
-		Address: url,
-	}
-	err := s.p.AddNode(opts)
+func (s *S) TestRemoveNode(c *check.C) {
+	s.addMarathonNode(c)
+	err := s.p.RemoveNode(provision.RemoveNodeOptions{Address: "http://doesnotexist.com"})
+	c.Assert(err, check.Equals, provision.ErrNodeNotFound)
+	err = s.p.RemoveNode(provision.RemoveNodeOptions{Address: s.marathon.URL()})
 	c.Assert(err, check.IsNil)
-	defer s.p.RemoveNode(provision.RemoveNodeOptions{})
-	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
-	err = app.CreateApp(a, s.user)
+	nodes, err := s.p.ListNodes(nil)
 	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 0)
+}
+
+func (s *S) newDeployEvent(c *check.C, a provision.App) *event.Event {
 	evt, err := event.New(&event.Opts{
 		Target:  event.Target{Type: event.TargetTypeApp, Value: a.GetName()},
 		Kind:    permission.PermAppDeploy,
@@ -94,6 +103,257 @@ func (s *S) TestImageDeploy(c *check.C) {
 		Allowed: event.Allowed(permission.PermAppDeploy),
 	})
 	c.Assert(err, check.IsNil)
-	s.p.ImageDeploy(a, "imageName", evt)
+	return evt
+}
+
+func (s *S) prepareDeployedApp(c *check.C) *app.App {
+	s.addMarathonNode(c)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name, Deploys: 1}
+	err := app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	imgName := "myapp:v1"
+	err = image.SaveImageCustomData(imgName, map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web":    "python myapp.py",
+			"worker": "python myworker.py",
+		},
+	})
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName(a.GetName(), imgName)
+	c.Assert(err, check.IsNil)
+	return a
+}
+
+func (s *S) appInstances(c *check.C, a provision.App, process string) (int, string) {
+	mApp := s.marathon.App(marathonAppID(a, process))
+	c.Assert(mApp, check.NotNil)
+	c.Assert(mApp.Instances, check.NotNil)
+	return *mApp.Instances, (*mApp.Labels)[labelAppRestart]
+}
+
+func (s *S) TestAddUnits(c *check.C) {
+	a := s.prepareDeployedApp(c)
+	err := s.p.AddUnits(a, 3, "worker", nil)
+	c.Assert(err, check.IsNil)
+	instances, _ := s.appInstances(c, a, "worker")
+	c.Assert(instances, check.Equals, 3)
+	err = s.p.AddUnits(a, 2, "worker", nil)
+	c.Assert(err, check.IsNil)
+	instances, _ = s.appInstances(c, a, "worker")
+	c.Assert(instances, check.Equals, 5)
+	c.Assert(s.marathon.App(marathonAppID(a, "web")), check.IsNil)
+}
+
+func (s *S) TestAddUnitsDefaultProcess(c *check.C) {
+	a := s.prepareDeployedApp(c)
+	err := s.p.AddUnits(a, 2, "", nil)
+	c.Assert(err, check.IsNil)
+	instances, _ := s.appInstances(c, a, "web")
+	c.Assert(instances, check.Equals, 2)
+	mApp := s.marathon.App(marathonAppID(a, "web"))
+	c.Assert(mApp.Container.Docker.Image, check.Equals, "myapp:v1")
+	c.Assert(*mApp.Container.Docker.PortMappings, check.HasLen, 1)
+	c.Assert((*mApp.Container.Docker.PortMappings)[0].ContainerPort, check.Equals, 8888)
+	c.Assert((*mApp.Labels)[labelAppName], check.Equals, "myapp")
+	c.Assert((*mApp.Labels)[labelAppProcess], check.Equals, "web")
+}
+
+func (s *S) TestAddUnitsNotDeployed(c *check.C) {
+	s.addMarathonNode(c)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err := app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	err = s.p.AddUnits(a, 1, "web", nil)
+	c.Assert(err, check.ErrorMatches, "units can only be modified after the first deploy")
+}
+
+func (s *S) TestRemoveUnits(c *check.C) {
+	a := s.prepareDeployedApp(c)
+	err := s.p.AddUnits(a, 3, "worker", nil)
+	c.Assert(err, check.IsNil)
+	err = s.p.RemoveUnits(a, 2, "worker", nil)
+	c.Assert(err, check.IsNil)
+	instances, _ := s.appInstances(c, a, "worker")
+	c.Assert(instances, check.Equals, 1)
+	err = s.p.RemoveUnits(a, 2, "worker", nil)
+	c.Assert(err, check.ErrorMatches, "cannot have less than 0 units")
+}
+
+func (s *S) TestRestart(c *check.C) {
+	a := s.prepareDeployedApp(c)
+	err := s.p.AddUnits(a, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	units, err := s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 1)
+	err = s.p.Restart(a, "web", nil)
+	c.Assert(err, check.IsNil)
+	instances, restarts := s.appInstances(c, a, "web")
+	c.Assert(instances, check.Equals, 1)
+	c.Assert(restarts, check.Equals, "1")
+	newUnits, err := s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(newUnits, check.HasLen, 1)
+	c.Assert(newUnits[0].ID, check.Not(check.Equals), units[0].ID)
+}
+
+func (s *S) TestStopStart(c *check.C) {
+	a := s.prepareDeployedApp(c)
+	err := s.p.AddUnits(a, 2, "web", nil)
+	c.Assert(err, check.IsNil)
+	err = s.p.Stop(a, "")
+	c.Assert(err, check.IsNil)
+	instances, _ := s.appInstances(c, a, "web")
+	c.Assert(instances, check.Equals, 0)
+	instances, _ = s.appInstances(c, a, "worker")
+	c.Assert(instances, check.Equals, 0)
+	err = s.p.Start(a, "")
+	c.Assert(err, check.IsNil)
+	instances, _ = s.appInstances(c, a, "web")
+	c.Assert(instances, check.Equals, 2)
+	instances, _ = s.appInstances(c, a, "worker")
+	c.Assert(instances, check.Equals, 1)
+}
+
+func (s *S) TestDestroy(c *check.C) {
+	a := s.prepareDeployedApp(c)
+	err := s.p.Start(a, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(s.marathon.Apps(), check.HasLen, 2)
+	err = s.p.Destroy(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.marathon.Apps(), check.HasLen, 0)
+}
+
+func (s *S) TestDestroyNotDeployed(c *check.C) {
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err := app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	err = s.p.Destroy(a)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestUnits(c *check.C) {
+	a := s.prepareDeployedApp(c)
+	s.marathon.TaskHost = "10.0.0.1"
+	err := s.p.AddUnits(a, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	err = s.p.AddUnits(a, 1, "worker", nil)
+	c.Assert(err, check.IsNil)
+	units, err := s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 2)
+	var processes []string
+	for _, u := range units {
+		processes = append(processes, u.ProcessName)
+		c.Assert(u.AppName, check.Equals, "myapp")
+		c.Assert(u.Ip, check.Equals, "10.0.0.1")
+		c.Assert(u.Status, check.Equals, provision.StatusStarted)
+		c.Assert(u.Address.Host, check.Matches, `10\.0\.0\.1:\d+`)
+	}
+	sort.Strings(processes)
+	c.Assert(processes, check.DeepEquals, []string{"web", "worker"})
+}
+
+func (s *S) TestUnitsNoNode(c *check.C) {
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err := app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	units, err := s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 0)
+}
+
+func (s *S) TestRoutableAddresses(c *check.C) {
+	a := s.prepareDeployedApp(c)
+	err := s.p.Start(a, "")
+	c.Assert(err, check.IsNil)
+	addrs, err := s.p.RoutableAddresses(a)
+	c.Assert(err, check.IsNil)
+	mApp := s.marathon.App(marathonAppID(a, "web"))
+	c.Assert(mApp.Tasks, check.HasLen, 1)
+	task := mApp.Tasks[0]
+	c.Assert(addrs, check.DeepEquals, []url.URL{
+		{Scheme: "http", Host: fmt.Sprintf("%s:%d", task.Host, task.Ports[0])},
+	})
+}
+
+func (s *S) TestArchiveDeploy(c *check.C) {
+	s.addMarathonNode(c)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err := app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	img, err := s.p.ArchiveDeploy(a, "http://server/myfile.tgz", s.newDeployEvent(c, a))
+	c.Assert(err, check.IsNil, check.Commentf("%+v", err))
+	c.Assert(img, check.Equals, "tsuru/app-myapp:v1")
+	c.Assert(s.marathon.Apps(), check.HasLen, 2)
+	mApp := s.marathon.App(marathonAppID(a, "web"))
+	c.Assert(mApp, check.NotNil)
+	c.Assert(mApp.Container.Docker.Image, check.Equals, "tsuru/app-myapp:v1")
+	imgs, err := image.ListAppImages(a.GetName())
+	c.Assert(err, check.IsNil)
+	c.Assert(imgs, check.DeepEquals, []string{"tsuru/app-myapp:v1"})
+}
+
+func (s *S) TestArchiveDeployNoBuildNode(c *check.C) {
+	config.Unset("mesos:docker-endpoint")
+	s.addMarathonNode(c)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err := app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	_, err = s.p.ArchiveDeploy(a, "http://server/myfile.tgz", s.newDeployEvent(c, a))
+	c.Assert(err, check.Equals, errNoBuildNode)
+}
+
+func (s *S) TestUploadDeploy(c *check.C) {
+	s.addMarathonNode(c)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err := app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	archive := ioutil.NopCloser(bytes.NewBufferString("my archive data"))
+	img, err := s.p.UploadDeploy(a, archive, int64(len("my archive data")), false, s.newDeployEvent(c, a))
+	c.Assert(err, check.IsNil, check.Commentf("%+v", err))
+	c.Assert(img, check.Equals, "tsuru/app-myapp:v1")
+	c.Assert(s.marathon.Apps(), check.HasLen, 2)
+}
+
+func (s *S) TestImageDeploy(c *check.C) {
+	config.Set("docker:registry", "localhost:3030")
+	defer config.Unset("docker:registry")
+	s.addMarathonNode(c)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err := app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	img, err := s.p.ImageDeploy(a, "myimage", s.newDeployEvent(c, a))
+	c.Assert(err, check.IsNil, check.Commentf("%+v", err))
+	c.Assert(img, check.Equals, "localhost:3030/tsuru/app-myapp:v1")
+	mApp := s.marathon.App(marathonAppID(a, "web"))
+	c.Assert(mApp, check.NotNil)
+	c.Assert(mApp.Container.Docker.Image, check.Equals, img)
+}
+
+func (s *S) TestRegisterUnit(c *check.C) {
+	a := s.prepareDeployedApp(c)
+	client, err := buildClient()
+	c.Assert(err, check.IsNil)
+	err = pullImage(client, "myapp:v1", ioutil.Discard)
+	c.Assert(err, check.IsNil)
+	contID, err := createBuildContainer(client, buildContainerOpts{
+		app:           a,
+		image:         "myapp:v1",
+		buildingImage: "myapp:v2",
+	})
+	c.Assert(err, check.IsNil)
+	customData := map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python web.py",
+		},
+	}
+	err = s.p.RegisterUnit(a, contID[:12], customData)
+	c.Assert(err, check.IsNil)
+	data, err := image.GetImageCustomData("myapp:v2")
+	c.Assert(err, check.IsNil)
+	c.Assert(data.Processes, check.DeepEquals, map[string][]string{"web": {"python web.py"}})
+	err = s.p.RegisterUnit(a, "unknown-unit", customData)
 	c.Assert(err, check.IsNil)
 }
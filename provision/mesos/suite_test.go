@@ -6,15 +6,22 @@ package mesos
 
 import (
 	"math/rand"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/fsouza/go-dockerclient"
+	dtesting "github.com/fsouza/go-dockerclient/testing"
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/app/image"
 	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/auth/native"
 	"github.com/tsuru/tsuru/db"
 	"github.com/tsuru/tsuru/db/dbtest"
 	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/mesos/marathontest"
 	"github.com/tsuru/tsuru/quota"
 	"github.com/tsuru/tsuru/router/routertest"
 	"golang.org/x/crypto/bcrypt"
@@ -22,11 +29,13 @@ import (
 )
 
 type S struct {
-	p     *mesosProvisioner
-	conn  *db.Storage
-	user  *auth.User
-	team  *auth.Team
-	token auth.Token
+	p        *mesosProvisioner
+	conn     *db.Storage
+	user     *auth.User
+	team     *auth.Team
+	token    auth.Token
+	marathon *marathontest.MarathonServer
+	server   *dtesting.DockerServer
 }
 
 var _ = check.Suite(&S{})
@@ -81,4 +90,52 @@ func (s *S) SetUpTest(c *check.C) {
 	c.Assert(err, check.IsNil)
 	s.token, err = nativeScheme.Login(map[string]string{"email": s.user.Email, "password": "123456"})
 	c.Assert(err, check.IsNil)
+	s.marathon, err = marathontest.NewServer("127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	s.server, err = dtesting.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	s.server.SetHook(s.buildContainerHook(c))
+	config.Set("mesos:docker-endpoint", s.server.URL())
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	s.marathon.Stop()
+	s.server.Stop()
+	config.Unset("mesos:docker-endpoint")
+}
+
+// buildContainerHook emulates the execution of build containers in the fake
+// docker server, stopping them right after they're started and registering
+// the processes of the image being built, as tsuru_unit_agent would do.
+func (s *S) buildContainerHook(c *check.C) func(*http.Request) {
+	return func(r *http.Request) {
+		if r.Method != "POST" || !strings.HasSuffix(r.URL.Path, "/start") {
+			return
+		}
+		parts := strings.Split(r.URL.Path, "/")
+		contID := parts[len(parts)-2]
+		client, err := docker.NewClient(s.server.URL())
+		c.Assert(err, check.IsNil)
+		cont, err := client.InspectContainer(contID)
+		c.Assert(err, check.IsNil)
+		if len(cont.Config.Cmd) > 0 && cont.Config.Cmd[0] == "/usr/bin/tail" {
+			return
+		}
+		if buildingImage := cont.Config.Labels[labelBuildImage]; buildingImage != "" {
+			err = image.SaveImageCustomData(buildingImage, map[string]interface{}{
+				"processes": map[string]interface{}{
+					"web":    "python myapp.py",
+					"worker": "python myworker.py",
+				},
+			})
+			c.Assert(err, check.IsNil)
+		}
+		err = s.server.MutateContainer(cont.ID, docker.State{StartedAt: time.Now(), Running: false})
+		c.Assert(err, check.IsNil)
+	}
+}
+
+func (s *S) addMarathonNode(c *check.C) {
+	err := s.p.AddNode(provision.AddNodeOptions{Address: s.marathon.URL()})
+	c.Assert(err, check.IsNil)
 }
@@ -294,12 +294,42 @@ type RollbackableDeployer interface {
 	Rollback(App, string, *event.Event) (string, error)
 }
 
+// UnitsDestroyer is a provisioner that can remove every unit of an app, along
+// with their routes, while keeping the app images and image names, which are
+// shared with other provisioners.
+type UnitsDestroyer interface {
+	DestroyUnits(App) error
+}
+
 // RebuildableDeployer is a provisioner that allows rebuild the last
 // deployed image.
 type RebuildableDeployer interface {
 	Rebuild(App, *event.Event) (string, error)
 }
 
+// VersionedDeployer is a provisioner able to run units of a given image
+// alongside the units of the image currently serving the app. It's used by
+// gradual deploy strategies, like canary and blue/green deploys.
+type VersionedDeployer interface {
+	// PrepareVersion makes the image available to the app, returning the
+	// name of the image that must be used in the other calls.
+	PrepareVersion(app App, image string, w io.Writer) (string, error)
+
+	// AddVersionUnits starts the given number of web units running the
+	// image, without touching existing units and without adding them to the
+	// router. Units are only returned after passing the app healthcheck.
+	AddVersionUnits(app App, image string, units uint, w io.Writer) ([]Unit, error)
+
+	// RemoveVersionUnits removes all units running the image that were
+	// started by AddVersionUnits.
+	RemoveVersionUnits(app App, image string, w io.Writer) error
+
+	// PromoteVersion turns the image into the current app image, replacing
+	// every unit that is not running it. Routes to the web units are managed
+	// by the caller.
+	PromoteVersion(app App, image string, w io.Writer) error
+}
+
 // Provisioner is the basic interface of this package.
 //
 // Any tsuru provisioner must implement this interface in order to provision
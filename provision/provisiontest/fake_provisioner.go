@@ -33,7 +33,8 @@ var (
 	errNotProvisioned         = &provision.Error{Reason: "App is not provisioned."}
 	uniqueIpCounter     int32 = 0
 
-	_ provision.NodeProvisioner = &FakeProvisioner{}
+	_ provision.NodeProvisioner   = &FakeProvisioner{}
+	_ provision.VersionedDeployer = &FakeProvisioner{}
 )
 
 const fakeAppImage = "app-image"
@@ -884,6 +885,108 @@ func (p *FakeProvisioner) Rebuild(app provision.App, evt *event.Event) (string,
 	return fakeAppImage, nil
 }
 
+func (p *FakeProvisioner) PrepareVersion(app provision.App, img string, w io.Writer) (string, error) {
+	if err := p.getError("PrepareVersion"); err != nil {
+		return "", err
+	}
+	if !p.Provisioned(app) {
+		return "", errNotProvisioned
+	}
+	return img, nil
+}
+
+func (p *FakeProvisioner) AddVersionUnits(app provision.App, img string, n uint, w io.Writer) ([]provision.Unit, error) {
+	if err := p.getError("AddVersionUnits"); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errors.New("Cannot add 0 units.")
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	pApp, ok := p.apps[app.GetName()]
+	if !ok {
+		return nil, errNotProvisioned
+	}
+	if pApp.versionUnits == nil {
+		pApp.versionUnits = make(map[string][]provision.Unit)
+	}
+	name := app.GetName()
+	var added []provision.Unit
+	for i := uint(0); i < n; i++ {
+		val := atomic.AddInt32(&uniqueIpCounter, 1)
+		hostAddr := fmt.Sprintf("10.10.10.%d", val)
+		unit := provision.Unit{
+			ID:      fmt.Sprintf("%s-%d", name, pApp.unitLen),
+			AppName: name,
+			Type:    app.GetPlatform(),
+			Status:  provision.StatusStarted,
+			Ip:      hostAddr,
+			Address: &url.URL{
+				Scheme: "http",
+				Host:   fmt.Sprintf("%s:%d", hostAddr, val),
+			},
+		}
+		added = append(added, unit)
+		pApp.unitLen++
+	}
+	pApp.versionUnits[img] = append(pApp.versionUnits[img], added...)
+	p.apps[name] = pApp
+	if w != nil {
+		fmt.Fprintf(w, "added %d units for version %s", n, img)
+	}
+	return added, nil
+}
+
+func (p *FakeProvisioner) RemoveVersionUnits(app provision.App, img string, w io.Writer) error {
+	if err := p.getError("RemoveVersionUnits"); err != nil {
+		return err
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	pApp, ok := p.apps[app.GetName()]
+	if !ok {
+		return errNotProvisioned
+	}
+	delete(pApp.versionUnits, img)
+	p.apps[app.GetName()] = pApp
+	if w != nil {
+		fmt.Fprintf(w, "removed units for version %s", img)
+	}
+	return nil
+}
+
+func (p *FakeProvisioner) PromoteVersion(app provision.App, img string, w io.Writer) error {
+	if err := p.getError("PromoteVersion"); err != nil {
+		return err
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	pApp, ok := p.apps[app.GetName()]
+	if !ok {
+		return errNotProvisioned
+	}
+	for _, u := range pApp.units {
+		routertest.FakeRouter.RemoveRoute(app.GetName(), u.Address)
+	}
+	pApp.units = pApp.versionUnits[img]
+	pApp.image = img
+	delete(pApp.versionUnits, img)
+	p.apps[app.GetName()] = pApp
+	if w != nil {
+		fmt.Fprintf(w, "promoted version %s", img)
+	}
+	return nil
+}
+
+// VersionUnits returns the units started by AddVersionUnits for the given
+// image that were not promoted yet.
+func (p *FakeProvisioner) VersionUnits(app provision.App, img string) []provision.Unit {
+	p.mut.RLock()
+	defer p.mut.RUnlock()
+	return p.apps[app.GetName()].versionUnits[img]
+}
+
 func (p *FakeProvisioner) Provision(app provision.App) error {
 	if err := p.getError("Provision"); err != nil {
 		return err
@@ -1551,18 +1654,19 @@ func (p *ExtensibleFakeProvisioner) PlatformRemove(name string) error {
 }
 
 type provisionedApp struct {
-	units       []provision.Unit
-	app         provision.App
-	restarts    map[string]int
-	starts      map[string]int
-	stops       map[string]int
-	sleeps      map[string]int
-	lastArchive string
-	lastFile    io.ReadCloser
-	cnames      []string
-	unitLen     int
-	lastData    map[string]interface{}
-	image       string
+	units        []provision.Unit
+	app          provision.App
+	restarts     map[string]int
+	starts       map[string]int
+	stops        map[string]int
+	sleeps       map[string]int
+	lastArchive  string
+	lastFile     io.ReadCloser
+	cnames       []string
+	unitLen      int
+	lastData     map[string]interface{}
+	image        string
+	versionUnits map[string][]provision.Unit
 }
 
 type provisionedPlatform struct {
@@ -18,11 +18,17 @@ func (q *Quota) Unlimited() bool {
 	return q.Limit == -1
 }
 
+// QuotaExceededError is returned when a request would use more than what is
+// available in a quota. Resource optionally describes the exhausted quota.
 type QuotaExceededError struct {
 	Requested uint
 	Available uint
+	Resource  string
 }
 
 func (err *QuotaExceededError) Error() string {
+	if err.Resource != "" {
+		return fmt.Sprintf("Quota exceeded for %s. Available: %d. Requested: %d.", err.Resource, err.Available, err.Requested)
+	}
 	return fmt.Sprintf("Quota exceeded. Available: %d. Requested: %d.", err.Available, err.Requested)
 }
@@ -19,6 +19,8 @@ type Suite struct{}
 func (Suite) TestQuotaExceededError(c *check.C) {
 	err := QuotaExceededError{Requested: 10, Available: 9}
 	c.Assert(err.Error(), check.Equals, "Quota exceeded. Available: 9. Requested: 10.")
+	err.Resource = `units of team "myteam"`
+	c.Assert(err.Error(), check.Equals, `Quota exceeded for units of team "myteam". Available: 9. Requested: 10.`)
 }
 
 func (Suite) TestQuotaUnlimited(c *check.C) {
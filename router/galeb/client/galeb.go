@@ -11,6 +11,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -176,6 +177,51 @@ func (c *GalebClient) AddVirtualHost(addr string) (string, error) {
 	return resource, c.waitStatusOK(resource)
 }
 
+func (c *GalebClient) UpdateVirtualHostProperties(virtualHostName string, properties VirtualHostProperties) error {
+	virtualHostID, err := c.findItemByName("virtualhost", virtualHostName)
+	if err != nil {
+		return err
+	}
+	path := strings.TrimPrefix(virtualHostID, c.ApiUrl)
+	var params VirtualHost
+	c.fillDefaultVirtualHostValues(&params)
+	params.Name = virtualHostName
+	params.Properties = properties
+	rsp, err := c.doRequest("PATCH", path, params)
+	if err != nil {
+		return err
+	}
+	if rsp.StatusCode != http.StatusNoContent {
+		responseData, _ := ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		return errors.Errorf("PATCH %s: invalid response code: %d: %s", path, rsp.StatusCode, string(responseData))
+	}
+	return c.waitStatusOK(virtualHostID)
+}
+
+func (c *GalebClient) FindVirtualHostByName(virtualHostName string) (*VirtualHost, error) {
+	virtualHostID, err := c.findItemByName("virtualhost", virtualHostName)
+	if err != nil {
+		return nil, err
+	}
+	path := strings.TrimPrefix(virtualHostID, c.ApiUrl)
+	rsp, err := c.doRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	responseData, _ := ioutil.ReadAll(rsp.Body)
+	if rsp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("GET %s: invalid response code: %d: %s", path, rsp.StatusCode, string(responseData))
+	}
+	var virtualHost VirtualHost
+	err = json.Unmarshal(responseData, &virtualHost)
+	if err != nil {
+		return nil, errors.Wrapf(err, "GET %s: unable to parse: %s", path, string(responseData))
+	}
+	return &virtualHost, nil
+}
+
 func (c *GalebClient) AddBackendPool(name string) (string, error) {
 	var params Pool
 	c.fillDefaultPoolValues(&params)
@@ -209,6 +255,23 @@ func (c *GalebClient) UpdatePoolProperties(poolName string, properties BackendPo
 	return c.waitStatusOK(poolID)
 }
 
+func (c *GalebClient) UpdateTargetWeight(targetID string, weight int) error {
+	path := strings.TrimPrefix(targetID, c.ApiUrl)
+	params := map[string]interface{}{
+		"properties": TargetProperties{Weight: strconv.Itoa(weight)},
+	}
+	rsp, err := c.doRequest("PATCH", path, params)
+	if err != nil {
+		return err
+	}
+	if rsp.StatusCode != http.StatusNoContent {
+		responseData, _ := ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		return errors.Errorf("PATCH %s: invalid response code: %d: %s", path, rsp.StatusCode, string(responseData))
+	}
+	return c.waitStatusOK(targetID)
+}
+
 func (c *GalebClient) AddBackend(backend *url.URL, poolName string) (string, error) {
 	var params Target
 	c.fillDefaultTargetValues(&params)
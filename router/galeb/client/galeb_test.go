@@ -161,6 +161,12 @@ func (s *S) TestGalebAddBackend(c *check.C) {
 	c.Assert(fullId, check.Equals, fmt.Sprintf("%s/target/10", s.client.ApiUrl))
 }
 
+func (s *S) TestGalebUpdateTargetWeightInvalidStatusCode(c *check.C) {
+	s.handler.RspCode = http.StatusBadRequest
+	err := s.client.UpdateTargetWeight(s.client.ApiUrl+"/target/10", 5)
+	c.Assert(err, check.ErrorMatches, "PATCH /target/10: invalid response code: 400: .*")
+}
+
 func (s *S) TestGalebAddVirtualHost(c *check.C) {
 	s.handler.ConditionalContent["/api/virtualhost/999"] = []string{
 		"200", `{"_status": "OK"}`,
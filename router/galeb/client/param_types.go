@@ -39,11 +39,16 @@ type BackendPoolProperties struct {
 	HcStatusCode string `json:"hcStatusCode"`
 }
 
+type TargetProperties struct {
+	Weight string `json:"weight,omitempty"`
+}
+
 type Target struct {
 	commonPostResponse
-	Project     string `json:"project"`
-	Environment string `json:"environment"`
-	BackendPool string `json:"parent,omitempty"`
+	Project     string           `json:"project"`
+	Environment string           `json:"environment"`
+	BackendPool string           `json:"parent,omitempty"`
+	Properties  TargetProperties `json:"properties,omitempty"`
 }
 
 type Pool struct {
@@ -67,8 +72,14 @@ type Rule struct {
 	Properties  RuleProperties `json:"properties,omitempty"`
 }
 
+type VirtualHostProperties struct {
+	FullChain  string `json:"fullchain,omitempty"`
+	PrivateKey string `json:"privatekey,omitempty"`
+}
+
 type VirtualHost struct {
 	commonPostResponse
-	Environment string `json:"environment,omitempty"`
-	Project     string `json:"project,omitempty"`
+	Environment string                `json:"environment,omitempty"`
+	Project     string                `json:"project,omitempty"`
+	Properties  VirtualHostProperties `json:"properties,omitempty"`
 }
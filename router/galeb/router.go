@@ -7,6 +7,7 @@ package galeb
 import (
 	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -318,6 +319,62 @@ func (r *galebRouter) Routes(name string) (urls []*url.URL, err error) {
 	return urls, nil
 }
 
+func (r *galebRouter) SetRouteWeight(name string, address *url.URL, weight int) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	if err = router.ValidateRouteWeight(weight); err != nil {
+		return err
+	}
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	targets, err := r.client.FindTargetsByParent(r.poolName(backendName))
+	if err != nil {
+		return err
+	}
+	for _, target := range targets {
+		parsedAddr, err := url.Parse(target.Name)
+		if err != nil {
+			return err
+		}
+		if parsedAddr.Host == address.Host {
+			return r.client.UpdateTargetWeight(target.FullId(), weight)
+		}
+	}
+	return router.ErrRouteNotFound
+}
+
+func (r *galebRouter) RouteWeights(name string) (weights map[string]int, err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := r.client.FindTargetsByParent(r.poolName(backendName))
+	if err != nil {
+		return nil, err
+	}
+	weights = make(map[string]int, len(targets))
+	for _, target := range targets {
+		parsedAddr, err := url.Parse(target.Name)
+		if err != nil {
+			return nil, err
+		}
+		weight, err := strconv.Atoi(target.Properties.Weight)
+		if err != nil {
+			weight = router.DefaultRouteWeight
+		}
+		weights[parsedAddr.Host] = weight
+	}
+	return weights, nil
+}
+
 func (r *galebRouter) StartupMessage() (string, error) {
 	return fmt.Sprintf("galeb router %q with API URL %q.", r.domain, r.client.ApiUrl), nil
 }
@@ -395,3 +452,48 @@ func (r *galebRouter) SetHealthcheck(name string, data router.HealthcheckData) (
 	}
 	return r.client.UpdatePoolProperties(r.poolName(backendName), poolProperties)
 }
+
+func (r *galebRouter) AddCertificate(cname, certificate, key string) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	err = r.client.UpdateVirtualHostProperties(cname, galebClient.VirtualHostProperties{
+		FullChain:  certificate,
+		PrivateKey: key,
+	})
+	if _, ok := errors.Cause(err).(galebClient.ErrItemNotFound); ok {
+		return router.ErrCNameNotFound
+	}
+	return err
+}
+
+func (r *galebRouter) RemoveCertificate(cname string) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	err = r.client.UpdateVirtualHostProperties(cname, galebClient.VirtualHostProperties{})
+	if _, ok := errors.Cause(err).(galebClient.ErrItemNotFound); ok {
+		return nil
+	}
+	return err
+}
+
+func (r *galebRouter) GetCertificate(cname string) (certificate string, err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	virtualHost, err := r.client.FindVirtualHostByName(cname)
+	if _, ok := errors.Cause(err).(galebClient.ErrItemNotFound); ok {
+		return "", router.ErrCertificateNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if virtualHost.Properties.FullChain == "" {
+		return "", router.ErrCertificateNotFound
+	}
+	return virtualHost.Properties.FullChain, nil
+}
@@ -581,17 +581,117 @@ func (r *hipacheRouter) Routes(name string) (urls []*url.URL, err error) {
 	if len(routes) == 0 {
 		return nil, router.ErrBackendNotFound
 	}
-	routes = routes[1:]
-	urls = make([]*url.URL, len(routes))
-	for i, route := range routes {
-		urls[i], err = url.Parse(route)
+	// Weighted routes are stored multiple times in the frontend list, so
+	// repeated entries are ignored.
+	seen := make(map[string]bool, len(routes)-1)
+	urls = make([]*url.URL, 0, len(routes)-1)
+	for _, route := range routes[1:] {
+		if seen[route] {
+			continue
+		}
+		seen[route] = true
+		u, err := url.Parse(route)
 		if err != nil {
 			return nil, err
 		}
+		urls = append(urls, u)
 	}
 	return urls, nil
 }
 
+// SetRouteWeight changes the weight of a route by repeating it in the
+// frontend list, as Hipache picks one of the entries randomly for each
+// request.
+func (r *hipacheRouter) SetRouteWeight(name string, address *url.URL, weight int) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	if err = router.ValidateRouteWeight(weight); err != nil {
+		return err
+	}
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	domain, err := config.GetString(r.prefix + ":domain")
+	if err != nil {
+		return &router.RouterError{Op: "setRouteWeight", Err: err}
+	}
+	routes, err := r.Routes(name)
+	if err != nil {
+		return err
+	}
+	var route string
+	for _, u := range routes {
+		if u.Host == address.Host {
+			route = u.String()
+			break
+		}
+	}
+	if route == "" {
+		return router.ErrRouteNotFound
+	}
+	entries := make([]string, weight)
+	for i := range entries {
+		entries[i] = route
+	}
+	frontends := []string{"frontend:" + backendName + "." + domain}
+	cnames, err := r.getCNames(backendName)
+	if err != nil {
+		return err
+	}
+	for _, cname := range cnames {
+		frontends = append(frontends, "frontend:"+cname)
+	}
+	for _, frontend := range frontends {
+		_, err = r.removeElement(frontend, route)
+		if err != nil {
+			return err
+		}
+		err = r.addRoutes(frontend, entries)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *hipacheRouter) RouteWeights(name string) (weights map[string]int, err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return nil, err
+	}
+	domain, err := config.GetString(r.prefix + ":domain")
+	if err != nil {
+		return nil, &router.RouterError{Op: "routeWeights", Err: err}
+	}
+	conn, err := r.connect()
+	if err != nil {
+		return nil, &router.RouterError{Op: "routeWeights", Err: err}
+	}
+	routes, err := conn.LRange("frontend:"+backendName+"."+domain, 0, -1).Result()
+	if err != nil {
+		return nil, &router.RouterError{Op: "routeWeights", Err: err}
+	}
+	if len(routes) == 0 {
+		return nil, router.ErrBackendNotFound
+	}
+	weights = make(map[string]int, len(routes)-1)
+	for _, route := range routes[1:] {
+		u, err := url.Parse(route)
+		if err != nil {
+			return nil, err
+		}
+		weights[u.Host]++
+	}
+	return weights, nil
+}
+
 func (r *hipacheRouter) removeElement(name, address string) (int, error) {
 	conn, err := r.connect()
 	if err != nil {
@@ -0,0 +1,319 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nginx
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/exec"
+	"github.com/tsuru/tsuru/router"
+)
+
+// renderMu serializes the rendering and reloading of configuration files,
+// as every router instance of the same configuration shares the same file.
+var renderMu sync.Mutex
+
+const defaultTemplate = `# This file is generated by tsuru, do not edit it manually.
+{{range $backend := .Backends}}
+{{- if .Routes}}
+upstream {{.Upstream}} {
+{{- range .Routes}}
+    server {{.Host}} weight={{.Weight}} max_fails=3 fail_timeout=10s;
+{{- end}}
+}
+{{end}}
+{{- range .Hosts}}
+server {
+    listen 80;
+{{- if .Certificate}}
+    listen 443 ssl;
+    ssl_certificate {{.Certificate}};
+    ssl_certificate_key {{.Key}};
+{{- end}}
+    server_name {{.Name}};
+{{- if .Challenge}}
+    location {{$.ChallengePath}} {
+        proxy_pass {{.Challenge}};
+    }
+{{- end}}
+    location / {
+{{- if $backend.Routes}}
+        proxy_pass http://{{$backend.Upstream}};
+{{- if $backend.Healthcheck.Path}}
+        proxy_next_upstream error timeout http_500 http_502 http_503 http_504;
+{{- end}}
+        proxy_set_header Host $host;
+        proxy_set_header X-Real-IP $remote_addr;
+        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+        proxy_set_header X-Forwarded-Proto $scheme;
+{{- else}}
+        return 503;
+{{- end}}
+    }
+}
+{{end}}
+{{- end}}
+{{- range .ChallengeHosts}}
+server {
+    listen 80;
+    server_name {{.Name}};
+    location {{$.ChallengePath}} {
+        proxy_pass {{.Challenge}};
+    }
+}
+{{end}}`
+
+// configData is the data available to the template used to render the
+// configuration file.
+type configData struct {
+	Backends []backendConfig
+	// ChallengeHosts holds the hosts that have ACME challenge routes but do
+	// not belong to any backend.
+	ChallengeHosts []hostConfig
+	ChallengePath  string
+
+	certificates []certificate
+}
+
+type backendConfig struct {
+	Name        string
+	Upstream    string
+	Routes      []route
+	Healthcheck router.HealthcheckData
+	Hosts       []hostConfig
+}
+
+type hostConfig struct {
+	Name        string
+	Certificate string
+	Key         string
+	Challenge   string
+}
+
+func (r *nginxRouter) certificatePaths(cname string) (string, string) {
+	return filepath.Join(r.certsDir, cname+".crt"), filepath.Join(r.certsDir, cname+".key")
+}
+
+// writeCertificates writes the files of the certificates stored in the
+// database, so any API instance rendering the configuration file has them.
+func (r *nginxRouter) writeCertificates(certs []certificate) error {
+	if len(certs) == 0 {
+		return nil
+	}
+	err := os.MkdirAll(r.certsDir, 0700)
+	if err != nil {
+		return err
+	}
+	for _, cert := range certs {
+		certPath, keyPath := r.certificatePaths(cert.CName)
+		err = writeFileIfChanged(certPath, []byte(cert.Certificate), 0644)
+		if err != nil {
+			return err
+		}
+		err = writeFileIfChanged(keyPath, []byte(cert.Key), 0600)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeStaleCertificates removes the files of certificates that are no
+// longer stored in the database.
+func (r *nginxRouter) removeStaleCertificates(certs []certificate) error {
+	keep := make(map[string]bool, len(certs)*2)
+	for _, cert := range certs {
+		certPath, keyPath := r.certificatePaths(cert.CName)
+		keep[certPath] = true
+		keep[keyPath] = true
+	}
+	for _, pattern := range []string{"*.crt", "*.key"} {
+		paths, err := filepath.Glob(filepath.Join(r.certsDir, pattern))
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			if keep[path] {
+				continue
+			}
+			err = os.Remove(path)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *nginxRouter) configData() (*configData, error) {
+	backends, err := r.collection("backends")
+	if err != nil {
+		return nil, err
+	}
+	defer backends.Close()
+	var allBackends []backend
+	err = backends.Find(nil).Sort("_id").All(&allBackends)
+	if err != nil {
+		return nil, err
+	}
+	var certs []certificate
+	err = backends.Database.C(r.collectionName("certificates")).Find(nil).All(&certs)
+	if err != nil {
+		return nil, err
+	}
+	var challenges []challenge
+	err = backends.Database.C(r.collectionName("challenges")).Find(nil).Sort("_id").All(&challenges)
+	if err != nil {
+		return nil, err
+	}
+	hasCert := make(map[string]bool, len(certs))
+	for _, c := range certs {
+		hasCert[c.CName] = true
+	}
+	challengeAddrs := make(map[string]string, len(challenges))
+	for _, c := range challenges {
+		challengeAddrs[c.CName] = c.Address
+	}
+	data := configData{ChallengePath: router.ACMEChallengePath, certificates: certs}
+	for _, b := range allBackends {
+		bc := backendConfig{
+			Name:        b.Name,
+			Upstream:    "tsuru_" + b.Name,
+			Routes:      b.Routes,
+			Healthcheck: b.Healthcheck,
+		}
+		for _, name := range append([]string{r.hostname(b.Name)}, b.CNames...) {
+			host := hostConfig{Name: name, Challenge: challengeAddrs[name]}
+			if hasCert[name] {
+				host.Certificate, host.Key = r.certificatePaths(name)
+			}
+			delete(challengeAddrs, name)
+			bc.Hosts = append(bc.Hosts, host)
+		}
+		data.Backends = append(data.Backends, bc)
+	}
+	for _, c := range challenges {
+		if addr, ok := challengeAddrs[c.CName]; ok {
+			data.ChallengeHosts = append(data.ChallengeHosts, hostConfig{Name: c.CName, Challenge: addr})
+		}
+	}
+	return &data, nil
+}
+
+func (r *nginxRouter) collectionName(suffix string) string {
+	return fmt.Sprintf("router_nginx_%s_%s", r.routerName, suffix)
+}
+
+func (r *nginxRouter) template() (*template.Template, error) {
+	text := defaultTemplate
+	if r.templateFile != "" {
+		data, err := ioutil.ReadFile(r.templateFile)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	}
+	return template.New("nginx").Parse(text)
+}
+
+// render writes the configuration file and the certificate files with the
+// current state of the router, as stored in the database.
+func (r *nginxRouter) render() error {
+	tmpl, err := r.template()
+	if err != nil {
+		return err
+	}
+	data, err := r.configData()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return err
+	}
+	err = r.writeCertificates(data.certificates)
+	if err != nil {
+		return err
+	}
+	err = writeFile(r.configFile, buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	return r.removeStaleCertificates(data.certificates)
+}
+
+// reload renders the configuration file and runs the reload command, so the
+// proxy picks up the new configuration.
+func (r *nginxRouter) reload(op string) error {
+	renderMu.Lock()
+	defer renderMu.Unlock()
+	err := r.render()
+	if err != nil {
+		return &router.RouterError{Op: op, Err: err}
+	}
+	if len(r.reloadCommand) == 0 {
+		return nil
+	}
+	var stderr bytes.Buffer
+	err = r.executor.Execute(exec.ExecuteOptions{
+		Cmd:    r.reloadCommand[0],
+		Args:   r.reloadCommand[1:],
+		Stdout: ioutil.Discard,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		msg := fmt.Sprintf("unable to run %q", strings.Join(r.reloadCommand, " "))
+		if output := strings.TrimSpace(stderr.String()); output != "" {
+			msg += ": " + output
+		}
+		err = errors.Wrap(err, msg)
+		return &router.RouterError{Op: op, Err: err}
+	}
+	return nil
+}
+
+// writeFileIfChanged calls writeFile unless the file in path already holds
+// the given data.
+func writeFileIfChanged(path string, data []byte, perm os.FileMode) error {
+	current, err := ioutil.ReadFile(path)
+	if err == nil && bytes.Equal(current, data) {
+		return nil
+	}
+	return writeFile(path, data, perm)
+}
+
+// writeFile atomically replaces the file in path, writing the data to a
+// temporary file in the same directory and renaming it afterwards.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(f.Name(), perm)
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
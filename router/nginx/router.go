@@ -0,0 +1,533 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package nginx provides a router that renders the configuration of a plain
+// nginx reverse proxy and reloads it whenever backends, routes, cnames or
+// certificates change.
+package nginx
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/storage"
+	"github.com/tsuru/tsuru/exec"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const routerType = "nginx"
+
+func init() {
+	router.Register(routerType, createRouter)
+}
+
+type nginxRouter struct {
+	routerName    string
+	domain        string
+	configFile    string
+	certsDir      string
+	templateFile  string
+	reloadCommand []string
+	executor      exec.Executor
+}
+
+type backend struct {
+	Name        string `bson:"_id"`
+	Routes      []route
+	CNames      []string
+	Healthcheck router.HealthcheckData
+}
+
+type route struct {
+	Scheme string
+	Host   string
+	Weight int
+}
+
+type certificate struct {
+	CName       string `bson:"_id"`
+	Certificate string
+	Key         string
+}
+
+type challenge struct {
+	CName   string `bson:"_id"`
+	Address string
+}
+
+func createRouter(routerName, configPrefix string) (router.Router, error) {
+	domain, err := config.GetString(configPrefix + ":domain")
+	if err != nil {
+		return nil, err
+	}
+	configFile, err := config.GetString(configPrefix + ":config-file")
+	if err != nil {
+		return nil, err
+	}
+	certsDir, err := config.GetString(configPrefix + ":certs-dir")
+	if err != nil {
+		certsDir = filepath.Join(filepath.Dir(configFile), "certs")
+	}
+	templateFile, _ := config.GetString(configPrefix + ":template")
+	reloadCommand, _ := config.GetString(configPrefix + ":reload-command")
+	r := &nginxRouter{
+		routerName:    routerName,
+		domain:        domain,
+		configFile:    configFile,
+		certsDir:      certsDir,
+		templateFile:  templateFile,
+		reloadCommand: strings.Fields(reloadCommand),
+		executor:      exec.OsExecutor{},
+	}
+	return r, nil
+}
+
+func (r *nginxRouter) collection(suffix string) (*storage.Collection, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	return conn.Collection(r.collectionName(suffix)), nil
+}
+
+func (r *nginxRouter) hostname(name string) string {
+	return fmt.Sprintf("%s.%s", name, r.domain)
+}
+
+func (r *nginxRouter) findBackend(name string) (*backend, error) {
+	usedName, err := router.Retrieve(name)
+	if err != nil {
+		return nil, err
+	}
+	coll, err := r.collection("backends")
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	var b backend
+	err = coll.FindId(usedName).One(&b)
+	if err == mgo.ErrNotFound {
+		return nil, router.ErrBackendNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// update runs the given update against the backend used by name and
+// renders the configuration file when it succeeds.
+func (r *nginxRouter) update(op, name string, update bson.M) error {
+	usedName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	coll, err := r.collection("backends")
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	err = coll.UpdateId(usedName, update)
+	if err == mgo.ErrNotFound {
+		return router.ErrBackendNotFound
+	}
+	if err != nil {
+		return &router.RouterError{Op: op, Err: err}
+	}
+	return r.reload(op)
+}
+
+func (r *nginxRouter) AddBackend(name string) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	coll, err := r.collection("backends")
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	err = coll.Insert(backend{Name: name, Routes: []route{}, CNames: []string{}})
+	if mgo.IsDup(err) {
+		return router.ErrBackendExists
+	}
+	if err != nil {
+		return &router.RouterError{Op: "add-backend", Err: err}
+	}
+	err = router.Store(name, name, routerType)
+	if err != nil {
+		return err
+	}
+	return r.reload("add-backend")
+}
+
+func (r *nginxRouter) RemoveBackend(name string) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	usedName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	if usedName != name {
+		return router.ErrBackendSwapped
+	}
+	coll, err := r.collection("backends")
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	err = coll.RemoveId(usedName)
+	if err == mgo.ErrNotFound {
+		return router.ErrBackendNotFound
+	}
+	if err != nil {
+		return &router.RouterError{Op: "remove-backend", Err: err}
+	}
+	return r.reload("remove-backend")
+}
+
+func (r *nginxRouter) AddRoute(name string, address *url.URL) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	b, err := r.findBackend(name)
+	if err != nil {
+		return err
+	}
+	for _, rt := range b.Routes {
+		if rt.Host == address.Host {
+			return router.ErrRouteExists
+		}
+	}
+	rt := route{Scheme: address.Scheme, Host: address.Host, Weight: router.DefaultRouteWeight}
+	return r.update("add-route", name, bson.M{"$push": bson.M{"routes": rt}})
+}
+
+func (r *nginxRouter) AddRoutes(name string, addresses []*url.URL) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	b, err := r.findBackend(name)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool, len(b.Routes))
+	for _, rt := range b.Routes {
+		existing[rt.Host] = true
+	}
+	var toAdd []route
+	for _, addr := range addresses {
+		if existing[addr.Host] {
+			continue
+		}
+		existing[addr.Host] = true
+		toAdd = append(toAdd, route{Scheme: addr.Scheme, Host: addr.Host, Weight: router.DefaultRouteWeight})
+	}
+	if len(toAdd) == 0 {
+		return nil
+	}
+	return r.update("add-routes", name, bson.M{"$push": bson.M{"routes": bson.M{"$each": toAdd}}})
+}
+
+func (r *nginxRouter) RemoveRoute(name string, address *url.URL) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	b, err := r.findBackend(name)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, rt := range b.Routes {
+		if rt.Host == address.Host {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return router.ErrRouteNotFound
+	}
+	return r.update("remove-route", name, bson.M{"$pull": bson.M{"routes": bson.M{"host": address.Host}}})
+}
+
+func (r *nginxRouter) RemoveRoutes(name string, addresses []*url.URL) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	if _, err = r.findBackend(name); err != nil {
+		return err
+	}
+	hosts := make([]string, len(addresses))
+	for i, addr := range addresses {
+		hosts[i] = addr.Host
+	}
+	return r.update("remove-routes", name, bson.M{"$pull": bson.M{"routes": bson.M{"host": bson.M{"$in": hosts}}}})
+}
+
+func (r *nginxRouter) Addr(name string) (addr string, err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	b, err := r.findBackend(name)
+	if err != nil {
+		return "", err
+	}
+	return r.hostname(b.Name), nil
+}
+
+func (r *nginxRouter) Swap(backend1, backend2 string, cnameOnly bool) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	return router.Swap(r, backend1, backend2, cnameOnly)
+}
+
+func (r *nginxRouter) Routes(name string) (routes []*url.URL, err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	b, err := r.findBackend(name)
+	if err != nil {
+		return nil, err
+	}
+	routes = make([]*url.URL, len(b.Routes))
+	for i, rt := range b.Routes {
+		routes[i] = &url.URL{Scheme: rt.Scheme, Host: rt.Host}
+	}
+	return routes, nil
+}
+
+func (r *nginxRouter) SetRouteWeight(name string, address *url.URL, weight int) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	b, err := r.findBackend(name)
+	if err != nil {
+		return err
+	}
+	index := -1
+	for i, rt := range b.Routes {
+		if rt.Host == address.Host {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return router.ErrRouteNotFound
+	}
+	if err = router.ValidateRouteWeight(weight); err != nil {
+		return err
+	}
+	field := fmt.Sprintf("routes.%d.weight", index)
+	return r.update("set-route-weight", name, bson.M{"$set": bson.M{field: weight}})
+}
+
+func (r *nginxRouter) RouteWeights(name string) (weights map[string]int, err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	b, err := r.findBackend(name)
+	if err != nil {
+		return nil, err
+	}
+	weights = make(map[string]int, len(b.Routes))
+	for _, rt := range b.Routes {
+		weights[rt.Host] = rt.Weight
+	}
+	return weights, nil
+}
+
+func (r *nginxRouter) SetCName(cname, name string) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	if _, err = r.findBackend(name); err != nil {
+		return err
+	}
+	if !router.ValidCName(cname, r.domain) {
+		return router.ErrCNameNotAllowed
+	}
+	coll, err := r.collection("backends")
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	n, err := coll.Find(bson.M{"cnames": cname}).Count()
+	if err != nil {
+		return &router.RouterError{Op: "set-cname", Err: err}
+	}
+	if n > 0 {
+		return router.ErrCNameExists
+	}
+	return r.update("set-cname", name, bson.M{"$push": bson.M{"cnames": cname}})
+}
+
+func (r *nginxRouter) UnsetCName(cname, name string) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	b, err := r.findBackend(name)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, c := range b.CNames {
+		if c == cname {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return router.ErrCNameNotFound
+	}
+	return r.update("unset-cname", name, bson.M{"$pull": bson.M{"cnames": cname}})
+}
+
+func (r *nginxRouter) CNames(name string) (urls []*url.URL, err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	b, err := r.findBackend(name)
+	if err != nil {
+		return nil, err
+	}
+	urls = make([]*url.URL, len(b.CNames))
+	for i, cname := range b.CNames {
+		urls[i] = &url.URL{Host: cname}
+	}
+	return urls, nil
+}
+
+func (r *nginxRouter) SetHealthcheck(name string, data router.HealthcheckData) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	return r.update("set-healthcheck", name, bson.M{"$set": bson.M{"healthcheck": data}})
+}
+
+func (r *nginxRouter) AddCertificate(cname, certificate, key string) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	_, err = tls.X509KeyPair([]byte(certificate), []byte(key))
+	if err != nil {
+		return &router.RouterError{Op: "add-certificate", Err: err}
+	}
+	coll, err := r.collection("certificates")
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	_, err = coll.UpsertId(cname, bson.M{"$set": bson.M{"certificate": certificate, "key": key}})
+	if err != nil {
+		return &router.RouterError{Op: "add-certificate", Err: err}
+	}
+	return r.reload("add-certificate")
+}
+
+func (r *nginxRouter) RemoveCertificate(cname string) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	coll, err := r.collection("certificates")
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	err = coll.RemoveId(cname)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return &router.RouterError{Op: "remove-certificate", Err: err}
+	}
+	return r.reload("remove-certificate")
+}
+
+func (r *nginxRouter) GetCertificate(cname string) (cert string, err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	coll, err := r.collection("certificates")
+	if err != nil {
+		return "", err
+	}
+	defer coll.Close()
+	var c certificate
+	err = coll.FindId(cname).One(&c)
+	if err == mgo.ErrNotFound {
+		return "", router.ErrCertificateNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return c.Certificate, nil
+}
+
+func (r *nginxRouter) AddChallengeRoute(cname string, address *url.URL) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	coll, err := r.collection("challenges")
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	_, err = coll.UpsertId(cname, bson.M{"$set": bson.M{"address": address.String()}})
+	if err != nil {
+		return &router.RouterError{Op: "add-challenge-route", Err: err}
+	}
+	return r.reload("add-challenge-route")
+}
+
+func (r *nginxRouter) RemoveChallengeRoute(cname string) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	coll, err := r.collection("challenges")
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	err = coll.RemoveId(cname)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return &router.RouterError{Op: "remove-challenge-route", Err: err}
+	}
+	return r.reload("remove-challenge-route")
+}
+
+func (r *nginxRouter) StartupMessage() (string, error) {
+	message := fmt.Sprintf("nginx router %q with config file %q", r.domain, r.configFile)
+	return message, nil
+}
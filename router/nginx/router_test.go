@@ -0,0 +1,252 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package nginx
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/dbtest"
+	"github.com/tsuru/tsuru/exec/exectest"
+	"github.com/tsuru/tsuru/router"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct {
+	conn     *db.Storage
+	dir      string
+	executor *exectest.FakeExecutor
+	router   *nginxRouter
+}
+
+var _ = check.Suite(&S{})
+
+func init() {
+	base := &S{}
+	suite := &routertest.RouterSuite{
+		SetUpSuiteFunc:   base.SetUpSuite,
+		TearDownTestFunc: base.TearDownTest,
+	}
+	suite.SetUpTestFunc = func(c *check.C) {
+		base.SetUpTest(c)
+		suite.Router = base.router
+	}
+	check.Suite(suite)
+}
+
+func (s *S) SetUpSuite(c *check.C) {
+	config.Set("routers:nginx:domain", "nginx.example.com")
+	config.Set("routers:nginx:type", "nginx")
+	config.Set("routers:nginx:reload-command", "nginx -s reload")
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "router_nginx_tests")
+}
+
+func (s *S) SetUpTest(c *check.C) {
+	var err error
+	s.conn, err = db.Conn()
+	c.Assert(err, check.IsNil)
+	dbtest.ClearAllCollections(s.conn.Collection("router_nginx_tests").Database)
+	s.dir = c.MkDir()
+	config.Set("routers:nginx:config-file", filepath.Join(s.dir, "tsuru.conf"))
+	r, err := createRouter("nginx", "routers:nginx")
+	c.Assert(err, check.IsNil)
+	s.executor = &exectest.FakeExecutor{}
+	s.router = r.(*nginxRouter)
+	s.router.executor = s.executor
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	s.conn.Close()
+}
+
+func (s *S) TearDownSuite(c *check.C) {
+	conn, err := db.Conn()
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	conn.Apps().Database.DropDatabase()
+}
+
+func (s *S) readConfig(c *check.C) string {
+	data, err := ioutil.ReadFile(s.router.configFile)
+	c.Assert(err, check.IsNil)
+	return string(data)
+}
+
+func (s *S) TestCreateRouter(c *check.C) {
+	c.Assert(s.router.domain, check.Equals, "nginx.example.com")
+	c.Assert(s.router.certsDir, check.Equals, filepath.Join(s.dir, "certs"))
+	c.Assert(s.router.reloadCommand, check.DeepEquals, []string{"nginx", "-s", "reload"})
+}
+
+func (s *S) TestCreateRouterRequiresConfigFile(c *check.C) {
+	config.Set("routers:other:domain", "other.example.com")
+	defer config.Unset("routers:other")
+	_, err := createRouter("other", "routers:other")
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestAddRouteRendersConfig(c *check.C) {
+	err := s.router.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	conf := s.readConfig(c)
+	c.Assert(conf, check.Matches, `(?s).*server_name myapp\.nginx\.example\.com;.*return 503;.*`)
+	addr, _ := url.Parse("http://10.10.10.10:8080")
+	err = s.router.AddRoute("myapp", addr)
+	c.Assert(err, check.IsNil)
+	err = s.router.SetRouteWeight("myapp", addr, 3)
+	c.Assert(err, check.IsNil)
+	err = s.router.SetCName("myapp.io", "myapp")
+	c.Assert(err, check.IsNil)
+	conf = s.readConfig(c)
+	c.Assert(conf, check.Matches, `(?s).*upstream tsuru_myapp \{\n    server 10\.10\.10\.10:8080 weight=3 .*`)
+	c.Assert(conf, check.Matches, `(?s).*server_name myapp\.io;.*proxy_pass http://tsuru_myapp;.*`)
+	c.Assert(strings.Contains(conf, "return 503;"), check.Equals, false)
+	err = s.router.RemoveBackend("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(strings.Contains(s.readConfig(c), "myapp"), check.Equals, false)
+}
+
+func (s *S) TestReloadCommand(c *check.C) {
+	err := s.router.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(s.executor.ExecutedCmd("nginx", []string{"-s", "reload"}), check.Equals, true)
+}
+
+func (s *S) TestReloadCommandFailure(c *check.C) {
+	s.router.executor = &exectest.ErrorExecutor{Err: errors.New("exit status 1")}
+	err := s.router.AddBackend("myapp")
+	c.Assert(err, check.ErrorMatches, `\[router add-backend\] unable to run "nginx -s reload": exit status 1`)
+}
+
+func (s *S) TestWithoutReloadCommand(c *check.C) {
+	s.router.reloadCommand = nil
+	err := s.router.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(s.executor.GetCommands("nginx"), check.HasLen, 0)
+	c.Assert(s.readConfig(c), check.Matches, `(?s).*server_name myapp\.nginx\.example\.com;.*`)
+}
+
+func (s *S) TestCustomTemplate(c *check.C) {
+	s.router.templateFile = filepath.Join(s.dir, "custom.tmpl")
+	tmpl := "{{range .Backends}}{{.Name}} {{.Healthcheck.Path}}\n{{end}}"
+	err := ioutil.WriteFile(s.router.templateFile, []byte(tmpl), 0644)
+	c.Assert(err, check.IsNil)
+	err = s.router.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	err = s.router.SetHealthcheck("myapp", router.HealthcheckData{Path: "/healthcheck"})
+	c.Assert(err, check.IsNil)
+	c.Assert(s.readConfig(c), check.Equals, "myapp /healthcheck\n")
+}
+
+func (s *S) TestHealthcheckRendersPassiveCheck(c *check.C) {
+	err := s.router.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	addr, _ := url.Parse("http://10.10.10.10:8080")
+	err = s.router.AddRoute("myapp", addr)
+	c.Assert(err, check.IsNil)
+	c.Assert(strings.Contains(s.readConfig(c), "proxy_next_upstream"), check.Equals, false)
+	err = s.router.SetHealthcheck("myapp", router.HealthcheckData{Path: "/healthcheck"})
+	c.Assert(err, check.IsNil)
+	c.Assert(s.readConfig(c), check.Matches, `(?s).*proxy_pass http://tsuru_myapp;\n        proxy_next_upstream error timeout http_500 http_502 http_503 http_504;\n.*`)
+	err = s.router.SetHealthcheck("myapp", router.HealthcheckData{})
+	c.Assert(err, check.IsNil)
+	c.Assert(strings.Contains(s.readConfig(c), "proxy_next_upstream"), check.Equals, false)
+}
+
+func (s *S) TestAddCertificateWritesFiles(c *check.C) {
+	cert, err := ioutil.ReadFile("testdata/certificate.crt")
+	c.Assert(err, check.IsNil)
+	key, err := ioutil.ReadFile("testdata/private.key")
+	c.Assert(err, check.IsNil)
+	err = s.router.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	err = s.router.SetCName("app.io", "myapp")
+	c.Assert(err, check.IsNil)
+	err = s.router.AddCertificate("app.io", string(cert), string(key))
+	c.Assert(err, check.IsNil)
+	certPath, keyPath := s.router.certificatePaths("app.io")
+	data, err := ioutil.ReadFile(certPath)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, string(cert))
+	info, err := os.Stat(keyPath)
+	c.Assert(err, check.IsNil)
+	c.Assert(info.Mode().Perm(), check.Equals, os.FileMode(0600))
+	c.Assert(s.readConfig(c), check.Matches, `(?s).*listen 443 ssl;\n    ssl_certificate `+certPath+`;.*server_name app\.io;.*`)
+	err = s.router.RemoveCertificate("app.io")
+	c.Assert(err, check.IsNil)
+	_, err = os.Stat(certPath)
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+	_, err = os.Stat(keyPath)
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+	c.Assert(strings.Contains(s.readConfig(c), "ssl_certificate"), check.Equals, false)
+}
+
+func (s *S) TestCertificateFilesRenderedByOtherInstance(c *check.C) {
+	cert, err := ioutil.ReadFile("testdata/certificate.crt")
+	c.Assert(err, check.IsNil)
+	key, err := ioutil.ReadFile("testdata/private.key")
+	c.Assert(err, check.IsNil)
+	err = s.router.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	err = s.router.SetCName("app.io", "myapp")
+	c.Assert(err, check.IsNil)
+	err = s.router.AddCertificate("app.io", string(cert), string(key))
+	c.Assert(err, check.IsNil)
+	otherDir := c.MkDir()
+	config.Set("routers:nginx:config-file", filepath.Join(otherDir, "tsuru.conf"))
+	r, err := createRouter("nginx", "routers:nginx")
+	c.Assert(err, check.IsNil)
+	other := r.(*nginxRouter)
+	other.executor = &exectest.FakeExecutor{}
+	addr, _ := url.Parse("http://10.10.10.10:8080")
+	err = other.AddRoute("myapp", addr)
+	c.Assert(err, check.IsNil)
+	certPath, keyPath := other.certificatePaths("app.io")
+	c.Assert(certPath, check.Equals, filepath.Join(otherDir, "certs", "app.io.crt"))
+	data, err := ioutil.ReadFile(certPath)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, string(cert))
+	data, err = ioutil.ReadFile(keyPath)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, string(key))
+	err = s.router.RemoveCertificate("app.io")
+	c.Assert(err, check.IsNil)
+	err = other.RemoveRoute("myapp", addr)
+	c.Assert(err, check.IsNil)
+	_, err = os.Stat(certPath)
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+	_, err = os.Stat(keyPath)
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+}
+
+func (s *S) TestAddCertificateInvalidKeyPair(c *check.C) {
+	err := s.router.AddCertificate("app.io", "invalid-cert", "invalid-key")
+	c.Assert(err, check.ErrorMatches, `\[router add-certificate\] .*`)
+	_, err = s.router.GetCertificate("app.io")
+	c.Assert(err, check.Equals, router.ErrCertificateNotFound)
+}
+
+func (s *S) TestChallengeRouteUnknownHost(c *check.C) {
+	target, _ := url.Parse("http://10.10.10.20:8080")
+	err := s.router.AddChallengeRoute("app.io", target)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.readConfig(c), check.Matches, `(?s).*server_name app\.io;\n    location /\.well-known/acme-challenge/ \{\n        proxy_pass http://10\.10\.10\.20:8080;.*`)
+	err = s.router.RemoveChallengeRoute("app.io")
+	c.Assert(err, check.IsNil)
+	c.Assert(strings.Contains(s.readConfig(c), "app.io"), check.Equals, false)
+}
@@ -34,6 +34,7 @@ var (
 	ErrCNameNotAllowed       = errors.New("CName as router subdomain not allowed")
 	ErrCertificateNotFound   = errors.New("Certificate not found")
 	ErrDefaultRouterNotFound = errors.New("No default router found")
+	ErrInvalidRouteWeight    = errors.Errorf("Route weight must be between %d and %d", MinRouteWeight, MaxRouteWeight)
 )
 
 type ErrRouterNotFound struct {
@@ -46,6 +47,12 @@ func (e *ErrRouterNotFound) Error() string {
 
 const HttpScheme = "http"
 
+const (
+	MinRouteWeight     = 1
+	MaxRouteWeight     = 100
+	DefaultRouteWeight = 1
+)
+
 var routers = make(map[string]routerFactory)
 
 // Register registers a new router.
@@ -153,6 +160,53 @@ type TLSRouter interface {
 	GetCertificate(cname string) (string, error)
 }
 
+// ACMEChallengePath is the path prefix used by ACME certificate authorities
+// to validate HTTP-01 challenges.
+const ACMEChallengePath = "/.well-known/acme-challenge/"
+
+// ChallengeRouter is a router able to forward requests to ACMEChallengePath
+// in a cname to a different address, while the remaining requests keep
+// reaching the backend of the cname. It's used to answer ACME challenges
+// without interrupting the app.
+type ChallengeRouter interface {
+	AddChallengeRoute(cname string, address *url.URL) error
+	RemoveChallengeRoute(cname string) error
+}
+
+// WeightedRouter is a router able to split the traffic of a backend unevenly
+// among its routes. Weights are relative: a route with weight 3 receives
+// three times as many requests as a route with weight 1. Routes start with
+// DefaultRouteWeight when added.
+type WeightedRouter interface {
+	SetRouteWeight(name string, address *url.URL, weight int) error
+	// RouteWeights returns the weight of each route of a backend, keyed by
+	// the route host.
+	RouteWeights(name string) (map[string]int, error)
+}
+
+// SetRoutesWeight sets the same weight to a group of routes in a backend,
+// such as every unit running a version of an app, allowing traffic to be
+// shifted gradually between groups.
+func SetRoutesWeight(r WeightedRouter, name string, addresses []*url.URL, weight int) error {
+	if err := ValidateRouteWeight(weight); err != nil {
+		return err
+	}
+	for _, addr := range addresses {
+		err := r.SetRouteWeight(name, addr, weight)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ValidateRouteWeight(weight int) error {
+	if weight < MinRouteWeight || weight > MaxRouteWeight {
+		return ErrInvalidRouteWeight
+	}
+	return nil
+}
+
 type HealthcheckData struct {
 	Path   string
 	Status int
@@ -232,6 +286,32 @@ func Retrieve(appName string) (string, error) {
 	return data.Router, nil
 }
 
+// StoredApps returns the names of the apps that had backends added to
+// routers of the given kind. Entries are kept after their backends are
+// removed, so callers must check with the router if each backend still
+// exists.
+func StoredApps(kind string) ([]string, error) {
+	coll, err := collection()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	query := bson.M{"kind": kind}
+	if kind == "hipache" {
+		query = bson.M{"kind": bson.M{"$in": []interface{}{kind, "", nil}}}
+	}
+	var entries []routerAppEntry
+	err = coll.Find(query).All(&entries)
+	if err != nil {
+		return nil, err
+	}
+	apps := make([]string, len(entries))
+	for i, e := range entries {
+		apps[i] = e.App
+	}
+	return apps, nil
+}
+
 func Remove(appName string) error {
 	coll, err := collection()
 	if err != nil {
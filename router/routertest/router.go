@@ -45,7 +45,7 @@ func createTLSRouter(name, prefix string) (router.Router, error) {
 }
 
 func newFakeRouter() fakeRouter {
-	return fakeRouter{cnames: make(map[string]string), backends: make(map[string][]string), failuresByIp: make(map[string]bool), healthcheck: make(map[string]router.HealthcheckData), mutex: &sync.Mutex{}}
+	return fakeRouter{cnames: make(map[string]string), backends: make(map[string][]string), failuresByIp: make(map[string]bool), healthcheck: make(map[string]router.HealthcheckData), weights: make(map[string]map[string]int), challenges: make(map[string]string), mutex: &sync.Mutex{}}
 }
 
 type fakeRouter struct {
@@ -53,6 +53,8 @@ type fakeRouter struct {
 	cnames       map[string]string
 	failuresByIp map[string]bool
 	healthcheck  map[string]router.HealthcheckData
+	weights      map[string]map[string]int
+	challenges   map[string]string
 	mutex        *sync.Mutex
 }
 
@@ -169,6 +171,7 @@ func (r *fakeRouter) RemoveBackend(name string) error {
 		}
 	}
 	delete(r.backends, backendName)
+	delete(r.weights, backendName)
 	return nil
 }
 
@@ -224,6 +227,7 @@ func (r *fakeRouter) RemoveRoutes(name string, addresses []*url.URL) error {
 				break
 			}
 		}
+		delete(r.weights[backendName], addr.Host)
 	}
 	r.backends[backendName] = routes
 	return nil
@@ -277,6 +281,7 @@ func (r *fakeRouter) RemoveRoute(name string, address *url.URL) error {
 	}
 	routes[index] = routes[len(routes)-1]
 	r.backends[backendName] = routes[:len(routes)-1]
+	delete(r.weights[backendName], address.Host)
 	return nil
 }
 
@@ -343,6 +348,8 @@ func (r *fakeRouter) Reset() {
 	r.failuresByIp = make(map[string]bool)
 	r.cnames = make(map[string]string)
 	r.healthcheck = make(map[string]router.HealthcheckData)
+	r.weights = make(map[string]map[string]int)
+	r.challenges = make(map[string]string)
 }
 
 func (r *fakeRouter) Routes(name string) ([]*url.URL, error) {
@@ -364,6 +371,51 @@ func (r *fakeRouter) Swap(backend1, backend2 string, cnameOnly bool) error {
 	return router.Swap(r, backend1, backend2, cnameOnly)
 }
 
+func (r *fakeRouter) SetRouteWeight(name string, address *url.URL, weight int) error {
+	if err := router.ValidateRouteWeight(weight); err != nil {
+		return err
+	}
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	if !r.HasRoute(backendName, address.Host) {
+		return router.ErrRouteNotFound
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.failuresByIp[address.Host] {
+		return ErrForcedFailure
+	}
+	if r.weights[backendName] == nil {
+		r.weights[backendName] = make(map[string]int)
+	}
+	r.weights[backendName][address.Host] = weight
+	return nil
+}
+
+func (r *fakeRouter) RouteWeights(name string) (map[string]int, error) {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return nil, err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	routes, ok := r.backends[backendName]
+	if !ok {
+		return nil, router.ErrBackendNotFound
+	}
+	weights := make(map[string]int, len(routes))
+	for _, route := range routes {
+		weight, ok := r.weights[backendName][route]
+		if !ok {
+			weight = router.DefaultRouteWeight
+		}
+		weights[route] = weight
+	}
+	return weights, nil
+}
+
 type hcRouter struct {
 	fakeRouter
 	err error
@@ -396,6 +448,29 @@ func (r *fakeRouter) SetHealthcheck(name string, data router.HealthcheckData) er
 	return nil
 }
 
+func (r *fakeRouter) AddChallengeRoute(cname string, address *url.URL) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.challenges[cname] = address.String()
+	return nil
+}
+
+func (r *fakeRouter) RemoveChallengeRoute(cname string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.challenges, cname)
+	return nil
+}
+
+// ChallengeRoute returns the address requests to router.ACMEChallengePath in
+// the given cname are being forwarded to.
+func (r *fakeRouter) ChallengeRoute(cname string) (string, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	addr, ok := r.challenges[cname]
+	return addr, ok
+}
+
 type tlsRouter struct {
 	fakeRouter
 	Certs map[string]string
@@ -401,3 +401,132 @@ func (r *vulcandRouter) HealthCheck() (err error) {
 	}()
 	return r.client.GetStatus()
 }
+
+func (r *vulcandRouter) AddCertificate(cname, certificate, key string) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	keyPair, err := engine.NewKeyPair([]byte(certificate), []byte(key))
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "add-certificate"}
+	}
+	host, err := engine.NewHost(cname, engine.HostSettings{KeyPair: keyPair})
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "add-certificate"}
+	}
+	err = r.client.UpsertHost(*host)
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "add-certificate"}
+	}
+	return nil
+}
+
+func (r *vulcandRouter) RemoveCertificate(cname string) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	err = r.client.DeleteHost(engine.HostKey{Name: cname})
+	if err != nil {
+		if _, ok := err.(*engine.NotFoundError); ok {
+			return nil
+		}
+		return &router.RouterError{Err: err, Op: "remove-certificate"}
+	}
+	return nil
+}
+
+func (r *vulcandRouter) GetCertificate(cname string) (certificate string, err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	host, err := r.client.GetHost(engine.HostKey{Name: cname})
+	if err != nil {
+		if _, ok := err.(*engine.NotFoundError); ok {
+			return "", router.ErrCertificateNotFound
+		}
+		return "", &router.RouterError{Err: err, Op: "get-certificate"}
+	}
+	if host.Settings.KeyPair == nil {
+		return "", router.ErrCertificateNotFound
+	}
+	return string(host.Settings.KeyPair.Cert), nil
+}
+
+func (r *vulcandRouter) challengeFrontendName(cname string) string {
+	return fmt.Sprintf("tsuru_acme_%s", cname)
+}
+
+func (r *vulcandRouter) AddChallengeRoute(cname string, address *url.URL) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	backendName := r.challengeFrontendName(cname)
+	backend, err := engine.NewHTTPBackend(backendName, engine.HTTPBackendSettings{})
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "add-challenge-route"}
+	}
+	err = r.client.UpsertBackend(*backend)
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "add-challenge-route"}
+	}
+	server, err := engine.NewServer(r.serverName(address.Host), address.String())
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "add-challenge-route"}
+	}
+	err = r.client.UpsertServer(engine.BackendKey{Id: backendName}, *server, engine.NoTTL)
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "add-challenge-route"}
+	}
+	frontend, err := engine.NewHTTPFrontend(
+		route.NewMux(),
+		r.challengeFrontendName(cname),
+		backendName,
+		fmt.Sprintf(`Host(%q) && PathRegexp(%q)`, cname, router.ACMEChallengePath+".*"),
+		engine.HTTPFrontendSettings{},
+	)
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "add-challenge-route"}
+	}
+	err = r.client.UpsertFrontend(*frontend, engine.NoTTL)
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "add-challenge-route"}
+	}
+	return nil
+}
+
+func (r *vulcandRouter) RemoveChallengeRoute(cname string) (err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	name := r.challengeFrontendName(cname)
+	err = r.client.DeleteFrontend(engine.FrontendKey{Id: name})
+	if err != nil {
+		if _, ok := err.(*engine.NotFoundError); ok {
+			return nil
+		}
+		return &router.RouterError{Err: err, Op: "remove-challenge-route"}
+	}
+	backendKey := engine.BackendKey{Id: name}
+	servers, err := r.client.GetServers(backendKey)
+	if err != nil {
+		return &router.RouterError{Err: err, Op: "remove-challenge-route"}
+	}
+	for _, server := range servers {
+		err = r.client.DeleteServer(engine.ServerKey{Id: server.Id, BackendKey: backendKey})
+		if err != nil {
+			return &router.RouterError{Err: err, Op: "remove-challenge-route"}
+		}
+	}
+	err = r.client.DeleteBackend(backendKey)
+	if err != nil {
+		if _, ok := err.(*engine.NotFoundError); !ok {
+			return &router.RouterError{Err: err, Op: "remove-challenge-route"}
+		}
+	}
+	return nil
+}
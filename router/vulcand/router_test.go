@@ -368,6 +368,29 @@ func (s *S) TestUnsetCNameNotExist(c *check.C) {
 	c.Assert(err, check.Equals, router.ErrCNameNotFound)
 }
 
+func (s *S) TestAddCertificateInvalidKeyPair(c *check.C) {
+	vRouter, err := router.Get("vulcand")
+	c.Assert(err, check.IsNil)
+	tlsRouter, ok := vRouter.(router.TLSRouter)
+	c.Assert(ok, check.Equals, true)
+	err = tlsRouter.AddCertificate("myapp.vulcand.example.com", "cert", "key")
+	c.Assert(err, check.NotNil)
+	hosts, err := s.engine.GetHosts()
+	c.Assert(err, check.IsNil)
+	c.Assert(hosts, check.HasLen, 0)
+}
+
+func (s *S) TestGetCertificateNotExist(c *check.C) {
+	vRouter, err := router.Get("vulcand")
+	c.Assert(err, check.IsNil)
+	tlsRouter, ok := vRouter.(router.TLSRouter)
+	c.Assert(ok, check.Equals, true)
+	_, err = tlsRouter.GetCertificate("myapp.vulcand.example.com")
+	c.Assert(err, check.Equals, router.ErrCertificateNotFound)
+	err = tlsRouter.RemoveCertificate("myapp.vulcand.example.com")
+	c.Assert(err, check.IsNil)
+}
+
 func (s *S) TestAddr(c *check.C) {
 	vRouter, err := router.Get("vulcand")
 	c.Assert(err, check.IsNil)
@@ -60,7 +60,11 @@ func DeleteInstance(si *ServiceInstance, requestID string) error {
 		return err
 	}
 	defer conn.Close()
-	return conn.ServiceInstances().Remove(bson.M{"name": si.Name, "service_name": si.ServiceName})
+	err = conn.ServiceInstances().Remove(bson.M{"name": si.Name, "service_name": si.ServiceName})
+	if err != nil {
+		return err
+	}
+	return auth.ReleaseTeamQuota(si.TeamOwner, auth.TeamQuota{ServiceInstances: 1})
 }
 
 func (si *ServiceInstance) GetIdentifier() string {
@@ -314,10 +318,21 @@ func CreateServiceInstance(instance ServiceInstance, service *Service, user *aut
 	if instance.TeamOwner == "" {
 		return ErrTeamMandatory
 	}
+	err = auth.ReserveTeamQuota(instance.TeamOwner, auth.TeamQuota{ServiceInstances: 1})
+	if err != nil {
+		return err
+	}
 	instance.Teams = []string{instance.TeamOwner}
 	actions := []*action.Action{&createServiceInstance, &insertServiceInstance}
 	pipeline := action.NewPipeline(actions...)
-	return pipeline.Execute(*service, instance, user.Email, requestID)
+	err = pipeline.Execute(*service, instance, user.Email, requestID)
+	if err != nil {
+		if releaseErr := auth.ReleaseTeamQuota(instance.TeamOwner, auth.TeamQuota{ServiceInstances: 1}); releaseErr != nil {
+			log.Errorf("Unable to release quota of team %q: %s", instance.TeamOwner, releaseErr)
+		}
+		return err
+	}
+	return nil
 }
 
 func UpdateService(si *ServiceInstance) error {
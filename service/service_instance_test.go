@@ -23,6 +23,7 @@ import (
 	"github.com/tsuru/tsuru/db"
 	"github.com/tsuru/tsuru/db/dbtest"
 	"github.com/tsuru/tsuru/provision/provisiontest"
+	"github.com/tsuru/tsuru/quota"
 	"gopkg.in/check.v1"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -371,6 +372,28 @@ func (s *InstanceSuite) TestCreateServiceInstance(c *check.C) {
 	c.Assert(si.Teams, check.DeepEquals, []string{s.team.Name})
 }
 
+func (s *InstanceSuite) TestCreateServiceInstanceTeamQuotaExceeded(c *check.C) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer ts.Close()
+	srv := Service{Name: "mongodb", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Services().RemoveId(srv.Name)
+	err = auth.ChangeTeamQuota(s.team, auth.TeamQuota{Apps: -1, Units: -1, Memory: -1, ServiceInstances: 0})
+	c.Assert(err, check.IsNil)
+	instance := ServiceInstance{Name: "instance", PlanName: "small", TeamOwner: s.team.Name}
+	err = CreateServiceInstance(instance, &srv, s.user, "")
+	c.Assert(err, check.FitsTypeOf, &quota.QuotaExceededError{})
+	c.Assert(err, check.ErrorMatches, `Quota exceeded for service instances of team "Raul". Available: 0. Requested: 1.`)
+	c.Assert(atomic.LoadInt32(&requests), check.Equals, int32(0))
+	_, err = GetServiceInstance("mongodb", "instance")
+	c.Assert(err, check.Equals, ErrServiceInstanceNotFound)
+}
+
 func (s *InstanceSuite) TestCreateServiceInstanceWithSameInstanceName(c *check.C) {
 	var requests int32
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {